@@ -1,19 +1,21 @@
 package api
 
 import (
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/metrics"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const URL_BASE = "https://music.youtube.com/youtubei/v1/"
@@ -23,9 +25,15 @@ const DEBUG = false
 var companionBaseURL string
 var companionAPIKey string
 
-func init() {
-	companionBaseURL = os.Getenv("COMPANION_URL")
-	companionAPIKey = os.Getenv("COMPANION_SECRET_KEY")
+// ConfigureCompanion sets the invidious companion used for player requests.
+func ConfigureCompanion(baseURL, apiKey string) {
+	companionBaseURL = strings.TrimSuffix(baseURL, "/")
+	companionAPIKey = apiKey
+}
+
+// CompanionURL returns the configured invidious companion base URL.
+func CompanionURL() string {
+	return companionBaseURL
 }
 
 func Browse(browseId string, pageType PageType, params string,
@@ -306,11 +314,20 @@ func doRequest(clientInfo ClientInfo, req *http.Request, requestPayload *innertu
 	req.Header.Set("accept-encoding", "gzip, deflate")
 	req.Header.Set("referer", "https://music.youtube.com")
 
+	endpoint := endpointLabel(req.URL)
+	clientName := clientInfo.ClientName
+	if clientName == "" {
+		clientName = "none"
+	}
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.ObserveSince(metrics.InnertubeRequestDuration.WithLabelValues(endpoint, clientName), start)
 
 	if err != nil {
+		metrics.InnertubeRequests.WithLabelValues(endpoint, clientName, "error").Inc()
 		return nil, err
 	}
+	metrics.InnertubeRequests.WithLabelValues(endpoint, clientName, strconv.Itoa(resp.StatusCode)).Inc()
 
 	defer resp.Body.Close()
 	// Check that the server actually sent compressed data
@@ -326,16 +343,27 @@ func doRequest(clientInfo ClientInfo, req *http.Request, requestPayload *innertu
 	respBytes, err := io.ReadAll(reader)
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("API call failed with status %d \n  %s", resp.StatusCode, string(respBytes))
-		dump, _ := httputil.DumpRequestOut(req, true)
-		log.Println(string(dump))
-		log.Println(string(respBytes))
+		logging.Log.Error().Int("status", resp.StatusCode).Str("endpoint", endpointLabel(req.URL)).Msg("API call failed")
+		if logging.Log.Debug().Enabled() {
+			dump, _ := httputil.DumpRequestOut(req, true)
+			logging.Log.Debug().Bytes("request", dump).Bytes("response", respBytes).Msg("API call failed")
+		}
 		return nil, errors.New(resp.Status)
 	}
 
 	return respBytes, nil
 }
 
+// endpointLabel maps a request URL to a low-cardinality metrics label,
+// e.g. "browse" or "music/get_search_suggestions".
+func endpointLabel(u *url.URL) string {
+	const prefix = "/youtubei/v1/"
+	if i := strings.Index(u.Path, prefix); i >= 0 {
+		return strings.Trim(u.Path[i+len(prefix):], "/")
+	}
+	return "webpage"
+}
+
 func getHttpClient() http.Client {
 
 	myDialer := net.Dialer{}
@@ -51,19 +51,37 @@ func ArtistEndpointHandler(c echo.Context) error {
 
 }
 
-func parseArtist(homeResponse _youtube.HomeResponse) interface{} {
-	var carouselResponse []Carousel = make([]Carousel, 0)
-	var response = make(map[string]interface{}, 0)
+// ArtistHeader is the header of an artist page.
+type ArtistHeader struct {
+	Name                 string                       `json:"name"`
+	Thumbnails           []Thumbnail                  `json:"thumbnails"`
+	ForegroundThumbnails []Thumbnail                  `json:"foregroundThumbnails"`
+	Description          string                       `json:"description"`
+	Buttons              map[string]map[string]string `json:"buttons"`
+}
+
+// GetArtistHeader fetches the header of the artist page of a channel.
+func GetArtistHeader(browseId string) (*ArtistHeader, error) {
+	responseBytes, err := api.Browse(browseId, api.PageType_MusicPageTypeArtist, "", nil, nil, nil, api.WebMusic)
+	if err != nil {
+		return nil, err
+	}
+	var homeResponse _youtube.HomeResponse
+	if err := json.Unmarshal(responseBytes, &homeResponse); err != nil {
+		return nil, err
+	}
+	header := parseArtistHeader(homeResponse)
+	if header == nil {
+		return nil, fmt.Errorf("artist %s has no header", browseId)
+	}
+	return header, nil
+}
 
+// parseArtistHeader returns nil for pages without a header.
+func parseArtistHeader(homeResponse _youtube.HomeResponse) *ArtistHeader {
 	renderer := homeResponse.Header.MusicImmersiveHeaderRenderer
 	if len(homeResponse.Header.MusicHeaderRenderer.Title.Runs) != 0 {
-		response["header"] = struct {
-			Name                 string                       `json:"name"`
-			Thumbnails           []Thumbnail                  `json:"thumbnails"`
-			ForegroundThumbnails []Thumbnail                  `json:"foregroundThumbnails"`
-			Description          string                       `json:"description"`
-			Buttons              map[string]map[string]string `json:"buttons"`
-		}{
+		return &ArtistHeader{
 			Name:        homeResponse.Header.MusicHeaderRenderer.Title.Runs[0].Text,
 			Thumbnails:  []Thumbnail{},
 			Description: "",
@@ -97,13 +115,7 @@ func parseArtist(homeResponse _youtube.HomeResponse) interface{} {
 			"videoId":    renderer.StartRadioButton.ButtonRenderer.NavigationEndpoint.WatchEndpoint.VideoID,
 		}
 
-		response["header"] = struct {
-			Name                 string                       `json:"name"`
-			Thumbnails           []Thumbnail                  `json:"thumbnails"`
-			ForegroundThumbnails []Thumbnail                  `json:"foregroundThumbnails"`
-			Description          string                       `json:"description"`
-			Buttons              map[string]map[string]string `json:"buttons"`
-		}{
+		return &ArtistHeader{
 			Name:        renderer.Title.Runs[0].Text,
 			Thumbnails:  itemThumbnails,
 			Description: description,
@@ -111,6 +123,16 @@ func parseArtist(homeResponse _youtube.HomeResponse) interface{} {
 			//  Buttons:
 		}
 	}
+	return nil
+}
+
+func parseArtist(homeResponse _youtube.HomeResponse) interface{} {
+	var carouselResponse []Carousel = make([]Carousel, 0)
+	var response = make(map[string]interface{}, 0)
+
+	if header := parseArtistHeader(homeResponse); header != nil {
+		response["header"] = header
+	}
 	//var description Description = Description{}
 	if len(homeResponse.Contents.SingleColumnBrowseResultsRenderer.Tabs[0].TabRenderer.Content.SectionListRenderer.Contents) != 0 {
 		for _, section := range homeResponse.Contents.SingleColumnBrowseResultsRenderer.Tabs[0].TabRenderer.Content.SectionListRenderer.Contents {
@@ -2,13 +2,22 @@ package api
 
 import (
 	"beatbump-server/backend/db"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/metadata"
+	"beatbump-server/backend/playlist"
+	"beatbump-server/backend/schedule"
+	"beatbump-server/backend/storage"
 	"beatbump-server/backend/utils"
+	"errors"
 	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 type DownloadPlaylistRequest struct {
@@ -18,6 +27,19 @@ type DownloadPlaylistRequest struct {
 type SettingsRequest struct {
 	DownloadPath            string `json:"downloadPath"`
 	OngoingListeningEnabled string `json:"ongoingListeningEnabled"`
+	ServerLibraryEnabled    string `json:"serverLibraryEnabled"`
+	HistoryRetentionDays    string `json:"historyRetentionDays"`
+	HistoryMaxEvents        string `json:"historyMaxEvents"`
+	MetadataProviders       string `json:"metadataProviders"`
+	OutputProfile           string `json:"outputProfile"`
+	ArtworkMaxResolution    string `json:"artworkMaxResolution"`
+	ArtworkSquareCrop       string `json:"artworkSquareCrop"`
+	FolderCover             string `json:"folderCover"`
+	PlaylistFormats         string `json:"playlistFormats"`
+	PlaylistPaths           string `json:"playlistPaths"`
+	ScheduleTimezone        string `json:"scheduleTimezone"`
+	QuietHours              string `json:"quietHours"`
+	QuietHoursMode          string `json:"quietHoursMode"`
 }
 
 func DownloadPlaylistHandler(c echo.Context) error {
@@ -31,7 +53,7 @@ func DownloadPlaylistHandler(c echo.Context) error {
 	err := db.AddGroupTask(db.TaskTypePlaylistDownload, playlistID, playlistName, "user", -1)
 	if err != nil {
 		c.Logger().Errorf("Failed to add group task: %v", err)
-		if len(err.Error()) > 24 && err.Error()[:24] == "UNIQUE constraint failed" {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			return c.JSON(http.StatusConflict, map[string]string{"status": "already_queued", "message": "Task already queued"})
 		}
 		return c.String(http.StatusInternalServerError, "Failed to create task")
@@ -70,7 +92,7 @@ func DownloadSongMixHandler(c echo.Context) error {
 	err := db.AddGroupTask(db.TaskTypeSongMixDownload, referenceID, "Song mix "+title+" ("+limitStr+" songs)", "user", limit)
 	if err != nil {
 		c.Logger().Errorf("Failed to add song mix task: %v", err)
-		if len(err.Error()) > 24 && err.Error()[:24] == "UNIQUE constraint failed" {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			return c.JSON(http.StatusConflict, map[string]string{"status": "already_queued", "message": "Task already queued"})
 		}
 		return c.String(http.StatusInternalServerError, "Failed to create task")
@@ -114,9 +136,35 @@ func GetTaskTracksHandler(c echo.Context) error {
 func GetSettingsHandler(c echo.Context) error {
 	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
 	ongoingListeningEnabled, _ := db.GetSetting(db.OngoingListeningEnabledSetting)
+	serverLibraryEnabled, _ := db.GetSetting(db.ServerLibraryEnabledSetting)
+	historyRetentionDays, _ := db.GetSetting(db.HistoryRetentionDaysSetting)
+	historyMaxEvents, _ := db.GetSetting(db.HistoryMaxEventsSetting)
+	metadataProviders, _ := db.GetSetting(db.MetadataProvidersSetting)
+	outputProfile, _ := db.GetSetting(db.OutputProfileSetting)
+	artworkMaxResolution, _ := db.GetSetting(db.ArtworkMaxResolutionSetting)
+	artworkSquareCrop, _ := db.GetSetting(db.ArtworkSquareCropSetting)
+	folderCover, _ := db.GetSetting(db.FolderCoverSetting)
+	playlistFormats, _ := db.GetSetting(db.PlaylistFormatsSetting)
+	playlistPaths, _ := db.GetSetting(db.PlaylistPathsSetting)
+	scheduleTimezone, _ := db.GetSetting(db.ScheduleTimezoneSetting)
+	quietHours, _ := db.GetSetting(db.QuietHoursSetting)
+	quietHoursMode, _ := db.GetSetting(db.QuietHoursModeSetting)
 	return c.JSON(http.StatusOK, map[string]string{
 		"downloadPath":            downloadPath,
 		"ongoingListeningEnabled": ongoingListeningEnabled,
+		"serverLibraryEnabled":    serverLibraryEnabled,
+		"historyRetentionDays":    historyRetentionDays,
+		"historyMaxEvents":        historyMaxEvents,
+		"metadataProviders":       metadataProviders,
+		"outputProfile":           outputProfile,
+		"artworkMaxResolution":    artworkMaxResolution,
+		"artworkSquareCrop":       artworkSquareCrop,
+		"folderCover":             folderCover,
+		"playlistFormats":         playlistFormats,
+		"playlistPaths":           playlistPaths,
+		"scheduleTimezone":        scheduleTimezone,
+		"quietHours":              quietHours,
+		"quietHoursMode":          quietHoursMode,
 	})
 }
 
@@ -128,19 +176,22 @@ func UpdateSettingsHandler(c echo.Context) error {
 	}
 
 	if req.DownloadPath != "" {
-		// Validate that the path exists and is a directory
-		info, err := os.Stat(req.DownloadPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Directory does not exist"})
+		// Validate that the path exists and is a directory. Remote storage
+		// backends do not keep tracks in the download path.
+		if storage.IsLocal() {
+			info, err := os.Stat(req.DownloadPath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return c.JSON(http.StatusBadRequest, map[string]string{"error": "Directory does not exist"})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to validate directory"})
+			}
+			if !info.IsDir() {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Path is not a directory"})
 			}
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to validate directory"})
-		}
-		if !info.IsDir() {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Path is not a directory"})
 		}
 
-		err = db.SetSetting(db.DownloadPathSetting, req.DownloadPath)
+		err := db.SetSetting(db.DownloadPathSetting, req.DownloadPath)
 		if err != nil {
 			return c.String(http.StatusInternalServerError, "Failed to update download path")
 		}
@@ -159,9 +210,11 @@ func UpdateSettingsHandler(c echo.Context) error {
 				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Download path must be set first"})
 			}
 
-			info, err := os.Stat(currentPath)
-			if err != nil || !info.IsDir() {
-				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Valid download path required"})
+			if storage.IsLocal() {
+				info, err := os.Stat(currentPath)
+				if err != nil || !info.IsDir() {
+					return c.JSON(http.StatusBadRequest, map[string]string{"error": "Valid download path required"})
+				}
 			}
 		}
 
@@ -171,6 +224,115 @@ func UpdateSettingsHandler(c echo.Context) error {
 		}
 	}
 
+	if req.ServerLibraryEnabled != "" {
+		err := db.SetSetting(db.ServerLibraryEnabledSetting, req.ServerLibraryEnabled)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update server library setting")
+		}
+	}
+
+	// History retention limits, "0" disables a limit
+	historyLimits := map[string]string{
+		db.HistoryRetentionDaysSetting: req.HistoryRetentionDays,
+		db.HistoryMaxEventsSetting:     req.HistoryMaxEvents,
+	}
+	for key, value := range historyLimits {
+		if value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "History limits must be non-negative numbers"})
+		}
+		if err := db.SetSetting(key, value); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update history settings")
+		}
+	}
+
+	// Ordered metadata provider chain, "none" disables enrichment
+	if req.MetadataProviders != "" && req.MetadataProviders != metadata.DisabledSetting {
+		names := metadata.ParseProviders(req.MetadataProviders)
+		for _, name := range names {
+			if _, err := metadata.NewProvider(name); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+		}
+		req.MetadataProviders = strings.Join(names, ",")
+	}
+	if req.MetadataProviders != "" {
+		if err := db.SetSetting(db.MetadataProvidersSetting, req.MetadataProviders); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update metadata providers")
+		}
+	}
+
+	if req.OutputProfile != "" {
+		if !slices.Contains(utils.OutputProfiles, req.OutputProfile) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown output profile"})
+		}
+		if err := db.SetSetting(db.OutputProfileSetting, req.OutputProfile); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update output profile")
+		}
+	}
+
+	artworkOptions := map[string]string{
+		db.ArtworkMaxResolutionSetting: req.ArtworkMaxResolution,
+		db.ArtworkSquareCropSetting:    req.ArtworkSquareCrop,
+		db.FolderCoverSetting:          req.FolderCover,
+	}
+	for key, value := range artworkOptions {
+		if value == "" {
+			continue
+		}
+		if value != "true" && value != "false" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Artwork options must be true or false"})
+		}
+		if err := db.SetSetting(key, value); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update artwork settings")
+		}
+	}
+
+	if req.PlaylistFormats != "" {
+		formats, err := playlist.ParseFormats(req.PlaylistFormats)
+		if err != nil || len(formats) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Playlist formats must be a list of " + strings.Join(playlist.Formats, ", ")})
+		}
+		if err := db.SetSetting(db.PlaylistFormatsSetting, strings.Join(formats, ",")); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update playlist formats")
+		}
+	}
+	if req.PlaylistPaths != "" {
+		if req.PlaylistPaths != playlist.PathsRelative && req.PlaylistPaths != playlist.PathsAbsolute {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Playlist paths must be relative or absolute"})
+		}
+		if err := db.SetSetting(db.PlaylistPathsSetting, req.PlaylistPaths); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update playlist paths")
+		}
+	}
+
+	if req.ScheduleTimezone != "" {
+		if _, err := time.LoadLocation(req.ScheduleTimezone); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown timezone " + req.ScheduleTimezone})
+		}
+		if err := db.SetSetting(db.ScheduleTimezoneSetting, req.ScheduleTimezone); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update schedule timezone")
+		}
+	}
+	if req.QuietHours != "" {
+		if _, err := schedule.ParseWindows(req.QuietHours); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := db.SetSetting(db.QuietHoursSetting, req.QuietHours); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update quiet hours")
+		}
+	}
+	if req.QuietHoursMode != "" {
+		if !slices.Contains(schedule.Modes, req.QuietHoursMode) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Quiet hours mode must be one of " + strings.Join(schedule.Modes, ", ")})
+		}
+		if err := db.SetSetting(db.QuietHoursModeSetting, req.QuietHoursMode); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update quiet hours mode")
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
 }
 
@@ -230,7 +392,11 @@ func DeleteTaskHandler(c echo.Context) error {
 
 	// 2. Resolve Download Directory
 	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
-	fullDownloadPath, _, _ := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	_, playlistFolder, _ := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	songs, err := db.GetSongTasks(taskID)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to fetch task tracks")
+	}
 
 	// 3. Delete from DB first (to stop worker from picking it up)
 	err = db.DeleteGroupTask(taskID)
@@ -238,14 +404,23 @@ func DeleteTaskHandler(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "Failed to delete task from DB")
 	}
 
-	// 4. Delete directory (best effort)
-	if fullDownloadPath != "" {
-		err = os.RemoveAll(fullDownloadPath)
+	// 4. Delete folder and the shared tracks no other task uses (best effort)
+	ctx := c.Request().Context()
+	store := storage.Resolve(downloadPath)
+	if prefix := storage.Dir(playlistFolder); prefix != "" {
+		err = storage.DeleteAll(ctx, store, prefix)
 		if err != nil {
-			c.Logger().Errorf("Failed to delete directory %s: %v", fullDownloadPath, err)
+			c.Logger().Errorf("Failed to delete folder %s: %v", prefix, err)
 			// We don't fail the request if file deletion fails, as DB is already updated
 		}
 	}
+	trackKeys := make([]string, 0, len(songs))
+	for _, song := range songs {
+		trackKeys = append(trackKeys, song.TrackKey)
+	}
+	if err := library.ReleaseTracks(ctx, store, trackKeys...); err != nil {
+		c.Logger().Errorf("Failed to delete shared tracks: %v", err)
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
 }
@@ -275,15 +450,22 @@ func DeleteTrackHandler(c echo.Context) error {
 	}
 
 	// 3. Delete file (best effort)
-	// If FilePath is set, use it.
-	if songTask.FilePath != "" {
-		downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
-		fullPath := filepath.Join(downloadPath, songTask.FilePath)
-		err = os.Remove(fullPath)
+	// A shared track is only deleted once no other task links to it.
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	store := storage.Resolve(downloadPath)
+	key := storage.Key(songTask.FilePath)
+	if songTask.FilePath != "" && key != songTask.TrackKey {
+		err = store.Delete(c.Request().Context(), key)
 		if err != nil {
-			c.Logger().Errorf("Failed to delete file %s: %v", fullPath, err)
+			c.Logger().Errorf("Failed to delete file %s: %v", key, err)
 		}
 	}
+	if err := library.ReleaseTracks(c.Request().Context(), store, songTask.TrackKey); err != nil {
+		c.Logger().Errorf("Failed to delete shared track %s: %v", songTask.TrackKey, err)
+	}
+	if err := playlist.Update(c.Request().Context(), taskID); err != nil {
+		c.Logger().Errorf("Failed to update playlist files: %v", err)
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
 }
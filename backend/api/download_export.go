@@ -0,0 +1,117 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/schedule"
+	"beatbump-server/backend/storage"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// maxTaskImportSize limits the size of an imported download queue
+const maxTaskImportSize = 32 << 20
+
+// ExportDownloadsHandler writes the download queue as JSON or, with
+// format=yaml, as YAML. tracks=true includes the tracks and their status.
+func ExportDownloadsHandler(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" {
+		return c.String(http.StatusBadRequest, "Invalid format")
+	}
+
+	export, err := db.ExportTasks(c.QueryParam("tracks") == "true")
+	if err != nil {
+		c.Logger().Errorf("Failed to export downloads: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to export downloads")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="beatbump-downloads.`+format+`"`)
+	if format == "json" {
+		return c.JSON(http.StatusOK, export)
+	}
+	data, err := yaml.Marshal(export)
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/yaml", data)
+}
+
+// ImportDownloadsHandler queues the tasks of an export. The body is read as
+// YAML when format=yaml or the content type names YAML, as JSON otherwise.
+// mode picks how tasks that are already queued are handled: skip (the
+// default), merge or replace.
+func ImportDownloadsHandler(c echo.Context) error {
+	mode := c.QueryParam("mode")
+	if mode == "" {
+		mode = db.ImportSkip
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxTaskImportSize))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	var data db.TaskExport
+	if c.QueryParam("format") == "yaml" || strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "yaml") {
+		err = yaml.Unmarshal(body, &data)
+	} else {
+		err = json.Unmarshal(body, &data)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid download export: " + err.Error()})
+	}
+	if data.Version > db.TaskExportVersion {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported download export version"})
+	}
+	for _, task := range data.Tasks {
+		if task.Recurrence == "" {
+			continue
+		}
+		if _, err := schedule.ParseRecurrence(task.Recurrence); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": task.Reference + ": " + err.Error()})
+		}
+	}
+
+	// Shared tracks only the replaced tasks used are deleted afterwards
+	var trackKeys []string
+	if mode == db.ImportReplace {
+		for _, task := range data.Tasks {
+			existing, err := db.GetGroupTaskByReferenceID(task.Reference)
+			if err != nil {
+				continue
+			}
+			songs, err := db.GetSongTasks(int(existing.ID))
+			if err != nil {
+				return c.String(http.StatusInternalServerError, "Failed to fetch task tracks")
+			}
+			for _, song := range songs {
+				trackKeys = append(trackKeys, song.TrackKey)
+			}
+		}
+	}
+
+	results, err := db.ImportTasks(&data, mode)
+	if errors.Is(err, db.ErrUnknownImportMode) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "mode must be one of " + strings.Join(db.ImportModes, ", ")})
+	} else if err != nil {
+		c.Logger().Errorf("Failed to import downloads: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to import downloads")
+	}
+
+	if len(trackKeys) > 0 {
+		downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+		if err := library.ReleaseTracks(c.Request().Context(), storage.Resolve(downloadPath), trackKeys...); err != nil {
+			c.Logger().Errorf("Failed to delete shared tracks: %v", err)
+		}
+	}
+	return c.JSON(http.StatusOK, results)
+}
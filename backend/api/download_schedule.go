@@ -0,0 +1,83 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/schedule"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// TaskScheduleRequest is the body of PUT /api/v1/downloads/:taskId/schedule.
+type TaskScheduleRequest struct {
+	// RFC 3339 or "2006-01-02 15:04" in the schedule timezone, empty starts
+	// the task right away
+	StartAfter string `json:"startAfter"`
+	// "daily 03:00", "weekly sun 03:00" or "every 6h", empty runs the task
+	// once
+	Recurrence string `json:"recurrence"`
+}
+
+// recurringTaskTypes are the task types a refresh can find new tracks for
+var recurringTaskTypes = []string{db.TaskTypePlaylistDownload, db.TaskTypeLibraryPlaylistDownload}
+
+// ScheduleTaskHandler sets when a task starts and how often a playlist
+// download looks for new tracks. The whole schedule is replaced, empty
+// fields clear it.
+func ScheduleTaskHandler(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("taskId"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid task ID")
+	}
+	var req TaskScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+
+	task, err := db.GetGroupTask(taskID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Task not found"})
+	} else if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to fetch task")
+	}
+
+	var startAfter *time.Time
+	if req.StartAfter != "" {
+		start, err := schedule.ParseTime(req.StartAfter)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		startAfter = &start
+	}
+
+	var nextRunAt *time.Time
+	if req.Recurrence != "" {
+		if !slices.Contains(recurringTaskTypes, task.Type) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Only playlist downloads can recur"})
+		}
+		recurrence, err := schedule.ParseRecurrence(req.Recurrence)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		from := time.Now()
+		if startAfter != nil && startAfter.After(from) {
+			from = *startAfter
+		}
+		next := recurrence.Next(from.In(schedule.Location()))
+		nextRunAt = &next
+	}
+
+	if err := db.SetGroupTaskSchedule(taskID, startAfter, req.Recurrence, nextRunAt); err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to update task schedule")
+	}
+	task, err = db.GetGroupTask(taskID)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to fetch task")
+	}
+	return c.JSON(http.StatusOK, task)
+}
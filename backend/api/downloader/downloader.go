@@ -4,71 +4,119 @@ import (
 	"beatbump-server/backend/_youtube"
 	yt_api "beatbump-server/backend/_youtube/api"
 	"beatbump-server/backend/api"
+	"beatbump-server/backend/artwork"
 	"beatbump-server/backend/db"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/metadata"
+	"beatbump-server/backend/metrics"
+	"beatbump-server/backend/nfo"
+	"beatbump-server/backend/playlist"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
 	"beatbump-server/backend/utils"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
 )
 
 type TrackInfo struct {
 	VideoID      string
 	Title        string
 	Artist       string
+	ArtistID     string // YouTube Music channel of the artist, empty when unknown
 	Album        string
 	ThumbnailURL string
+	// Length of the downloaded stream, set by downloadTrack
+	DurationMs int
+	// Loudness of the stream above the YouTube reference, set by
+	// downloadTrack when YouTube reports it
+	LoudnessDb *float64
+	// Position in the playlist
+	Track, TrackTotal int
 }
 
-func PopulatePlaylistTask(playlistID string, groupTaskID int) {
-	log.Printf("Populating songs for group task %d (Playlist %s)", groupTaskID, playlistID)
+// PopulatePlaylistTask adds the tracks of a playlist to its group task.
+// With refresh set, as for recurring tasks, a task that already has tracks
+// fetches the playlist again and adds the new ones. It stops when ctx is
+// done, the worker lost the task then.
+func PopulatePlaylistTask(ctx context.Context, playlistID string, groupTaskID int, refresh bool) {
+	logger := logging.ForTask(groupTaskID).With().Str("playlist_id", playlistID).Logger()
+	logger.Info().Msg("Populating songs for group task")
 
-	// Phase 1: Populate Tracks if not already populated
+	// Phase 1: Populate Tracks if not already populated, or look for new ones
 	existingSongs, err := db.GetSongTasks(groupTaskID)
 	if err != nil {
-		log.Printf("Failed to get song tasks: %v", err)
+		logger.Error().Err(err).Msg("Failed to get song tasks")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 		return
 	}
 
-	if len(existingSongs) == 0 {
-		tracks, err := fetchPlaylistTracks(playlistID)
+	if len(existingSongs) == 0 || refresh {
+		tracks, err := fetchPlaylistTracks(ctx, logger, playlistID)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			log.Printf("Failed to fetch playlist tracks: %v", err)
+			logger.Error().Err(err).Msg("Failed to fetch playlist tracks")
 			db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 			return
 		}
 
+		queued := make(map[string]bool, len(existingSongs))
+		for _, song := range existingSongs {
+			queued[song.VideoID] = true
+		}
+		added := 0
 		for _, track := range tracks {
-			if track.VideoID == "" {
+			if ctx.Err() != nil {
+				return
+			}
+			if track.VideoID == "" || queued[track.VideoID] {
 				continue
 			}
+			queued[track.VideoID] = true
+			added++
 			err := db.AddSongTask(groupTaskID, track.VideoID, track.Title, track.Artist, track.Album, track.ThumbnailURL)
 			if err != nil {
-				log.Printf("Failed to add song %s to task: %v", track.Title, err)
+				logger.Error().Err(err).Str("video_id", track.VideoID).Msg("Failed to add song to task")
+			} else {
+				if track.DurationMs > 0 {
+					// Until the download reports the exact length
+					db.SetSongTaskDuration(groupTaskID, track.VideoID, track.DurationMs)
+				}
+				if track.ArtistID != "" {
+					db.SetSongTaskArtistID(groupTaskID, track.VideoID, track.ArtistID)
+				}
 			}
 		}
-		log.Printf("Populated %d songs for group task %d", len(tracks), groupTaskID)
+		logger.Info().Int("songs", added).Msg("Populated songs for group task")
 	} else {
-		log.Printf("Group task %d already has %d songs", groupTaskID, len(existingSongs))
+		logger.Info().Int("songs", len(existingSongs)).Msg("Group task already has songs")
 	}
 }
 
-func PopulateSongMixTask(groupTaskID int) {
-	log.Printf("Populating song mix for group task %d", groupTaskID)
+func PopulateSongMixTask(ctx context.Context, groupTaskID int) {
+	logger := logging.ForTask(groupTaskID)
+	logger.Info().Msg("Populating song mix for group task")
 
 	// Get the group task to extract videoId from ReferenceID
 	groupTask, err := db.GetGroupTask(groupTaskID)
 	if err != nil {
-		log.Printf("Failed to get group task: %v", err)
+		logger.Error().Err(err).Msg("Failed to get group task")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 		return
 	}
@@ -76,13 +124,13 @@ func PopulateSongMixTask(groupTaskID int) {
 	// Check if already populated
 	existingSongs, err := db.GetSongTasks(groupTaskID)
 	if err != nil {
-		log.Printf("Failed to get song tasks: %v", err)
+		logger.Error().Err(err).Msg("Failed to get song tasks")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 		return
 	}
 
 	if len(existingSongs) == groupTask.MaxTracks {
-		log.Printf("Group task %d already has %d songs", groupTaskID, len(existingSongs))
+		logger.Info().Int("songs", len(existingSongs)).Msg("Group task already has songs")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusCompleted)
 		return
 	}
@@ -98,9 +146,12 @@ func PopulateSongMixTask(groupTaskID int) {
 	}
 	tracksAdded := map[string]api.Item{}
 	for len(tracksAdded) < groupTask.MaxTracks {
-		tracks, err := songMixNext(seedVideoID, groupTaskID)
+		if ctx.Err() != nil {
+			return
+		}
+		tracks, err := songMixNext(logger, seedVideoID, groupTaskID)
 		if err != nil {
-			log.Printf("Failed to get mix songs: %v", err)
+			logger.Error().Err(err).Msg("Failed to get mix songs")
 			break
 		}
 
@@ -111,9 +162,10 @@ func PopulateSongMixTask(groupTaskID int) {
 
 			title := track.Title
 			seedVideoID = track.VideoID
-			artist := ""
+			artist, artistID := "", ""
 			if len(track.ArtistInfo.Artist) > 0 {
 				artist = track.ArtistInfo.Artist[0].Text
+				artistID = track.ArtistInfo.Artist[0].BrowseId
 			}
 			thumbnail := ""
 			if len(track.Thumbnails) > 0 {
@@ -122,21 +174,70 @@ func PopulateSongMixTask(groupTaskID int) {
 
 			err = db.AddSongTask(groupTaskID, track.VideoID, title, artist, "", thumbnail)
 			if err != nil {
-				log.Printf("Failed to add mix song %s to task: %v", title, err)
-			}else{
-				log.Printf("Added song to mix: %s - %s", artist, title)
+				logger.Error().Err(err).Str("video_id", track.VideoID).Msg("Failed to add mix song to task")
+			} else {
+				logger.Info().Str("video_id", track.VideoID).Msgf("Added song to mix: %s - %s", artist, title)
 				tracksAdded[track.VideoID] = track
+				if durationMs := parseLength(track.Length); durationMs > 0 {
+					db.SetSongTaskDuration(groupTaskID, track.VideoID, durationMs)
+				}
+				if artistID != "" {
+					db.SetSongTaskArtistID(groupTaskID, track.VideoID, artistID)
+				}
 			}
 		}
 	}
-	log.Printf("Populated %d songs for group task %d", len(tracksAdded), groupTaskID)
+	logger.Info().Int("songs", len(tracksAdded)).Msg("Populated songs for group task")
 }
 
-func songMixNext(videoID string, groupTaskID int) ([]api.Item, error) {
+// PopulateLibraryPlaylistTask adds the entries of a server-side library playlist
+// referenced as "library:<playlistId>" to the group task.
+func PopulateLibraryPlaylistTask(ctx context.Context, groupTaskID int) {
+	logger := logging.ForTask(groupTaskID)
+	logger.Info().Msg("Populating library playlist for group task")
+
+	groupTask, err := db.GetGroupTask(groupTaskID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get group task")
+		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
+		return
+	}
+
+	playlistID, err := strconv.Atoi(strings.TrimPrefix(groupTask.ReferenceID, "library:"))
+	if err != nil {
+		logger.Error().Err(err).Str("reference_id", groupTask.ReferenceID).Msg("Invalid library playlist reference")
+		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
+		return
+	}
+
+	entries, err := db.GetPlaylistEntries(playlistID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get library playlist entries")
+		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
+		return
+	}
+
+	// Entries added to the playlist after the first run are picked up on retry
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.VideoID == "" {
+			continue
+		}
+		err := db.AddSongTask(groupTaskID, entry.VideoID, entry.Title, entry.Artist, entry.Album, entry.ThumbnailURL)
+		if err != nil {
+			logger.Error().Err(err).Str("video_id", entry.VideoID).Msg("Failed to add song to task")
+		}
+	}
+	logger.Info().Int("songs", len(entries)).Msg("Populated songs for group task")
+}
+
+func songMixNext(logger zerolog.Logger, videoID string, groupTaskID int) ([]api.Item, error) {
 	params := map[string]string{}
 	responseBytes, err := yt_api.Next(videoID, "RDAMVM"+videoID, yt_api.IOS_MUSIC, params)
 	if err != nil {
-		log.Printf("Failed to fetch next for %s: %v", videoID, err)
+		logger.Error().Err(err).Str("video_id", videoID).Msg("Failed to fetch next")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 		return nil, err
 	}
@@ -144,7 +245,7 @@ func songMixNext(videoID string, groupTaskID int) ([]api.Item, error) {
 	var nextResponse _youtube.NextResponse
 	err = json.Unmarshal(responseBytes, &nextResponse)
 	if err != nil {
-		log.Printf("Failed to unmarshal next response: %v", err)
+		logger.Error().Err(err).Str("video_id", videoID).Msg("Failed to unmarshal next response")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 		return nil, err
 	}
@@ -152,7 +253,7 @@ func songMixNext(videoID string, groupTaskID int) ([]api.Item, error) {
 	parsedResponse := api.ParseNextBody(nextResponse)
 
 	if len(parsedResponse.Results) == 0 {
-		log.Printf("No results returned from next API for %s", videoID)
+		logger.Warn().Str("video_id", videoID).Msg("No results returned from next API")
 		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusFailed)
 		return nil, err
 	}
@@ -160,13 +261,16 @@ func songMixNext(videoID string, groupTaskID int) ([]api.Item, error) {
 	return parsedResponse.Results, nil
 }
 
-func fetchPlaylistTracks(playlistID string) ([]TrackInfo, error) {
+func fetchPlaylistTracks(ctx context.Context, logger zerolog.Logger, playlistID string) ([]TrackInfo, error) {
 	var tracks []TrackInfo
 	ctoken := ""
 	itct := ""
 	maxIterations := 10
 	for {
-		log.Printf("Fetching playlist %s (ctoken: %s)", playlistID, ctoken)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		logger.Debug().Str("ctoken", ctoken).Msg("Fetching playlist page")
 
 		playlistResponse, err := api.GetPlaylist(playlistID, ctoken, itct)
 		if err != nil {
@@ -174,7 +278,7 @@ func fetchPlaylistTracks(playlistID string) ([]TrackInfo, error) {
 		}
 
 		if len(tracks) > 0 && len(playlistResponse.Tracks) > 0 && *playlistResponse.Tracks[0].VideoId == tracks[0].VideoID {
-			log.Printf("Detected song repetition, breaking")
+			logger.Debug().Msg("Detected song repetition, breaking")
 			break
 		}
 
@@ -186,9 +290,10 @@ func fetchPlaylistTracks(playlistID string) ([]TrackInfo, error) {
 				videoId = *item.VideoId
 			}
 
-			var artist string
+			var artist, artistID string
 			if len(item.ArtistInfo.Artist) > 0 {
 				artist = item.ArtistInfo.Artist[0].Text
+				artistID = item.ArtistInfo.Artist[0].BrowseId
 			}
 
 			var album string
@@ -207,8 +312,10 @@ func fetchPlaylistTracks(playlistID string) ([]TrackInfo, error) {
 					VideoID:      videoId,
 					Title:        title,
 					Artist:       artist,
+					ArtistID:     artistID,
 					Album:        album,
 					ThumbnailURL: thumbnailURL,
+					DurationMs:   parseLength(item.Length),
 				})
 			}
 		}
@@ -234,13 +341,13 @@ func fetchPlaylistTracks(playlistID string) ([]TrackInfo, error) {
 
 		dataBytes, err := json.Marshal(playlistResponse.Continuations)
 		if err != nil {
-			log.Printf("Failed to marshal continuations: %v", err)
+			logger.Error().Err(err).Msg("Failed to marshal continuations")
 			break
 		}
 
 		var contData ContinuationData
 		if err := json.Unmarshal(dataBytes, &contData); err != nil {
-			log.Printf("Failed to unmarshal continuations: %v", err)
+			logger.Error().Err(err).Msg("Failed to unmarshal continuations")
 			break
 		}
 
@@ -258,18 +365,39 @@ func fetchPlaylistTracks(playlistID string) ([]TrackInfo, error) {
 			break
 		}
 	}
-	log.Printf("Fetched %d tracks for playlist %s", len(tracks), playlistID)
+	logger.Info().Int("tracks", len(tracks)).Msg("Fetched playlist tracks")
 	return tracks, nil
 }
 
-func downloadTrack(track TrackInfo, downloadPath, playlistFolder string) (string, error) {
-	log.Printf("Downloading %s - %s", track.Artist, track.Title)
+// parseLength reads a track length such as "3:45" or "1:02:03" in
+// milliseconds, 0 when it is malformed.
+func parseLength(length string) int {
+	if length == "" {
+		return 0
+	}
+	seconds := 0
+	for _, part := range strings.Split(length, ":") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds * 1000
+}
+
+func downloadTrack(ctx context.Context, logger zerolog.Logger, track *TrackInfo, downloadPath, playlistFolder string) (string, error) {
+	logger.Info().Msgf("Downloading %s - %s", track.Artist, track.Title)
 
 	// 1. Get Stream Info
-	streamUrl, contentLength, err := getStreamInfo(track.VideoID)
+	stream, err := getStreamInfo(track.VideoID)
 	if err != nil {
 		return "", err
 	}
+	if stream.DurationMs > 0 {
+		track.DurationMs = stream.DurationMs
+	}
+	track.LoudnessDb = stream.LoudnessDb
 
 	// 2. Build filename and path (always .m4a)
 	baseFilename := fmt.Sprintf("%s - %s", track.Artist, track.Title)
@@ -283,7 +411,7 @@ func downloadTrack(track TrackInfo, downloadPath, playlistFolder string) (string
 	}
 
 	// 4. Perform Download
-	err = performDownload(streamUrl, downloadTarget, contentLength)
+	err = performDownload(ctx, stream.URL, downloadTarget, stream.ContentLength)
 	downloadTarget.Close() // Close immediately after download
 
 	if err != nil {
@@ -291,51 +419,69 @@ func downloadTrack(track TrackInfo, downloadPath, playlistFolder string) (string
 		return "", err
 	}
 
-	log.Printf("Finished downloading %s - %s", track.Artist, track.Title)
+	logger.Info().Str("path", finalFilePath).Msg("Finished downloading")
 
 	// Return absolute path to the downloaded file
 	return finalFilePath, nil
 }
 
-func getStreamInfo(videoID string) (string, int64, error) {
+type streamInfo struct {
+	URL           string
+	ContentLength int64
+	DurationMs    int
+	// Loudness above the YouTube reference of -14 LUFS, nil when unknown
+	LoudnessDb *float64
+}
+
+// getStreamInfo returns the best audio stream.
+func getStreamInfo(videoID string) (*streamInfo, error) {
 	responseBytes, err := yt_api.Player(videoID, "", yt_api.IOS_MUSIC, nil)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
 	var playerResponse _youtube.PlayerResponse
 	err = json.Unmarshal(responseBytes, &playerResponse)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
 	if playerResponse.PlayabilityStatus.Status != "OK" {
-		return "", 0, fmt.Errorf("not playable: %s", playerResponse.PlayabilityStatus.Status)
+		return nil, fmt.Errorf("not playable: %s", playerResponse.PlayabilityStatus.Status)
 	}
 
-	var streamUrl string
-	var contentLength int64
+	stream := &streamInfo{}
+	var formatLoudness float64
 	bestBitrate := 0
 
 	for _, format := range playerResponse.StreamingData.AdaptiveFormats {
 		if strings.HasPrefix(format.MimeType, "audio") {
 			if format.Bitrate > bestBitrate {
 				bestBitrate = format.Bitrate
-				streamUrl = format.URL
-				contentLength, _ = strconv.ParseInt(format.ContentLength, 10, 64)
+				stream.URL = format.URL
+				stream.ContentLength, _ = strconv.ParseInt(format.ContentLength, 10, 64)
+				stream.DurationMs, _ = strconv.Atoi(format.ApproxDurationMs)
+				formatLoudness = format.LoudnessDb
 			}
 		}
 	}
 
-	if streamUrl == "" {
-		return "", 0, fmt.Errorf("no audio stream found")
+	if stream.URL == "" {
+		return nil, fmt.Errorf("no audio stream found")
+	}
+
+	// Zero is what YouTube leaves out, the per format value is preferred
+	if formatLoudness != 0 {
+		stream.LoudnessDb = &formatLoudness
+	} else if loudness := playerResponse.PlayerConfig.AudioConfig.LoudnessDb; loudness != 0 {
+		stream.LoudnessDb = &loudness
 	}
 
-	return streamUrl, contentLength, nil
+	return stream, nil
 }
 
-func performDownload(streamUrl string, output *os.File, contentLength int64) error {
-	req, err := http.NewRequest(http.MethodGet, streamUrl, nil)
+func performDownload(ctx context.Context, streamUrl string, output *os.File, contentLength int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamUrl, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -343,7 +489,7 @@ func performDownload(streamUrl string, output *os.File, contentLength int64) err
 	r, w := io.Pipe()
 
 	if contentLength == 0 {
-		resp, err := http.Get(streamUrl)
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			return fmt.Errorf("failed to download stream: %v", err)
 		}
@@ -362,71 +508,150 @@ func performDownload(streamUrl string, output *os.File, contentLength int64) err
 	}
 	defer r.Close()
 
-	_, err = io.Copy(output, r)
+	_, err = io.Copy(&countingWriter{w: output}, r)
 	if err != nil {
-		return fmt.Errorf("failed to save stream: %v", err)
+		return fmt.Errorf("failed to save stream: %w", err)
 	}
 	return nil
 }
 
-func convertTrack(track TrackInfo, inputM4aPath, downloadPath string) (string, error) {
-	log.Printf("Converting %s - %s to MP3", track.Artist, track.Title)
+// countingWriter reports written bytes to the downloaded bytes metric.
+type countingWriter struct {
+	w io.Writer
+}
 
-	baseFilename := fmt.Sprintf("%s - %s", track.Artist, track.Title)
-	mp3Filename := utils.SanitizeFilename(baseFilename + ".mp3")
-	mp3FilePath := filepath.Join(downloadPath, mp3Filename)
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	metrics.DownloadedBytes.Add(float64(n))
+	return n, err
+}
 
-	// 1. Fetch Enhanced Metadata (Best Effort)
-	enhancedMeta, err := utils.FetchMetadata(track.Artist, track.Title)
+// enrichTrack looks up the metadata of a downloaded track, a weak match
+// keeps the YouTube metadata and is queued for review after the track is
+// stored. The error is only set when ctx is done.
+func enrichTrack(ctx context.Context, logger zerolog.Logger, track TrackInfo) (utils.AudioMetadata, *metadata.Result, error) {
+	meta := utils.AudioMetadata{
+		Title:  track.Title,
+		Artist: track.Artist,
+		Album:  track.Album,
+	}
+	lookup, err := metadata.Lookup(ctx, metadata.Query{
+		Title:      track.Title,
+		Artist:     track.Artist,
+		Album:      track.Album,
+		DurationMs: track.DurationMs,
+	})
 	if err != nil {
-		log.Printf("Metadata fetch failed for %s - %s: %v", track.Artist, track.Title, err)
-		// Fallback to basic metadata
-		enhancedMeta = &utils.AudioMetadata{
-			Title:  track.Title,
-			Artist: track.Artist,
-			Album:  track.Album,
+		if ctx.Err() != nil {
+			return meta, nil, ctx.Err()
 		}
+		// Keep the basic metadata, a weak match is queued for review
+		logger.Warn().Err(err).Int("candidates", len(lookup.Candidates)).Msg("Metadata fetch failed")
+		metrics.RecordFailure("metadata", err)
 	} else {
-		log.Printf("Enhanced metadata found: %s - %s (%s)", enhancedMeta.Artist, enhancedMeta.Title, enhancedMeta.Album)
+		match := lookup.Match
+		meta = match.AudioMetadata()
+		logger.Info().Str("provider", match.Provider).Float64("score", match.Score).
+			Msgf("Enhanced metadata found: %s - %s (%s)", meta.Artist, meta.Title, meta.Album)
 	}
 
-	// 2. Determine Cover Art
-	var coverPath string
-
-	// Priority: iTunes Artwork > YouTube Thumbnail
-	artworkURL := enhancedMeta.ArtworkURL
-	if artworkURL == "" {
-		artworkURL = track.ThumbnailURL
+	meta.VideoID = track.VideoID
+	meta.Track, meta.TrackTotal = track.Track, track.TrackTotal
+	// Priority: provider artwork > YouTube Thumbnail
+	if meta.ArtworkURL == "" {
+		meta.ArtworkURL = track.ThumbnailURL
 	}
+	return meta, lookup, nil
+}
 
-	if artworkURL != "" {
-		cf, err := os.CreateTemp("", "beatbump_cover_*.jpg")
-		if err == nil {
-			coverPath = cf.Name()
-			cf.Close()
-			if err := downloadFile(artworkURL, coverPath); err != nil {
-				os.Remove(coverPath)
-				coverPath = ""
-			} else {
-				defer os.Remove(coverPath)
-			}
-		}
+// downloadCover fetches the artwork of meta into dir with the current
+// artwork options, it returns an empty path when there is none.
+func downloadCover(logger zerolog.Logger, meta utils.AudioMetadata, dir string) string {
+	if meta.ArtworkURL == "" {
+		return ""
+	}
+	coverPath := filepath.Join(dir, "cover.jpg")
+	if err := artwork.Fetch(meta.ArtworkURL, coverPath, artwork.CurrentOptions()); err != nil {
+		logger.Warn().Err(err).Msg("Failed to download cover art")
+		os.Remove(coverPath)
+		return ""
 	}
+	return coverPath
+}
+
+func convertTrack(ctx context.Context, logger zerolog.Logger, track TrackInfo, inputM4aPath, downloadPath, coverPath string, meta utils.AudioMetadata) (string, error) {
+	logger.Info().Msg("Converting to MP3")
+
+	baseFilename := fmt.Sprintf("%s - %s", track.Artist, track.Title)
+	mp3Filename := utils.SanitizeFilename(baseFilename + ".mp3")
+	mp3FilePath := filepath.Join(downloadPath, mp3Filename)
 
-	// 3. Convert
-	err = utils.ConvertToMp3(inputM4aPath, mp3FilePath, coverPath, *enhancedMeta)
+	start := time.Now()
+	err := utils.ConvertToMp3(ctx, inputM4aPath, mp3FilePath, coverPath, meta)
 	if err != nil {
-		log.Printf("Conversion failed for %s: %v", track.Title, err)
-		return "", fmt.Errorf("conversion failed: %v", err)
+		metrics.ObserveSince(metrics.ConversionDuration.WithLabelValues("failed"), start)
+		metrics.RecordFailure("conversion", err)
+		logger.Error().Err(err).Msg("Conversion failed")
+		return "", fmt.Errorf("conversion failed: %w", err)
 	}
+	metrics.ObserveSince(metrics.ConversionDuration.WithLabelValues("success"), start)
 
-	// 4. Remove original .m4a file after successful conversion
+	// Remove original .m4a file after successful conversion
 	os.Remove(inputM4aPath)
-	log.Printf("Conversion complete: %s", mp3Filename)
+	logger.Info().Str("path", mp3FilePath).Msg("Conversion complete")
 
 	return mp3FilePath, nil
 }
 
+// tagTrack writes the tags and cover of a track kept as .m4a, without
+// ffmpeg.
+func tagTrack(path, coverPath string, meta utils.AudioMetadata) error {
+	t := &tags.Tags{
+		Title:       meta.Title,
+		Artist:      meta.Artist,
+		Album:       meta.Album,
+		Year:        meta.Year,
+		Genre:       meta.Genre,
+		Track:       meta.Track,
+		TrackTotal:  meta.TrackTotal,
+		VideoID:     meta.VideoID,
+		MusicBrainz: meta.MusicBrainz,
+	}
+	if coverPath != "" {
+		data, err := os.ReadFile(coverPath)
+		if err != nil {
+			return err
+		}
+		t.Cover = &tags.Picture{MIMEType: tags.DetectImageType(data), Data: data}
+	}
+	return tags.WriteFile(path, t)
+}
+
+// youtubeReferenceLoudness is the loudness in LUFS YouTube reports the
+// loudness of streams against.
+const youtubeReferenceLoudness = -14.0
+
+// measureLoudness returns the EBU R128 loudness of the file at path,
+// measured with ffmpeg or, without it, derived from the loudness YouTube
+// reports for the stream. It returns nil when neither is known.
+func measureLoudness(ctx context.Context, logger zerolog.Logger, path string, track TrackInfo) *utils.Loudness {
+	if utils.IsFFmpegAvailable() {
+		loudness, err := utils.MeasureLoudness(ctx, path)
+		if err == nil {
+			return loudness
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Warn().Err(err).Msg("Failed to measure loudness")
+	}
+	if track.LoudnessDb != nil {
+		// The peak is not reported
+		return &utils.Loudness{Integrated: youtubeReferenceLoudness + *track.LoudnessDb}
+	}
+	return nil
+}
+
 const (
 	Size1Kb  = 1024
 	Size1Mb  = Size1Kb * 1024
@@ -448,7 +673,7 @@ func downloadChunked(req *http.Request, w *io.PipeWriter, contentLength int64) {
 				}
 
 				chunk := &chunks[chunkIndex]
-				err := downloadChunk(req.Clone(context.Background()), chunk)
+				err := downloadChunk(req.Clone(req.Context()), chunk)
 				close(chunk.data)
 
 				if err != nil {
@@ -479,7 +704,7 @@ func downloadChunk(req *http.Request, chunk *chunk) error {
 	q.Set("range", fmt.Sprintf("%d-%d", chunk.start, chunk.end))
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.Get(req.URL.String())
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -527,90 +752,118 @@ func getChunks(totalSize, chunkSize int64) []chunk {
 	return chunks
 }
 
-func generateM3U(playlistName string, tracks []db.SongTask, outputDir string) error {
-	filename := filepath.Join(outputDir, "playlist.m3u8")
-	f, err := os.Create(filename)
-	if err != nil {
+// writeNFOs writes the album.nfo of a completed group task and, when its
+// album artist has a YouTube Music channel, artist.nfo from the artist page.
+func writeNFOs(ctx context.Context, store storage.Storage, groupTaskID int, folder string) error {
+	album, err := library.WriteAlbumNFO(ctx, store, groupTaskID, folder)
+	if err != nil || album.Compilation {
 		return err
 	}
-	defer f.Close()
-
-	// Write header
-	if _, err := f.WriteString("#EXTM3U\n"); err != nil {
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
 		return err
 	}
-
-	for _, track := range tracks {
-		if track.Status == "completed" && track.FilePath != "" {
-			// FilePath in DB is relative to downloads root (e.g. "Playlist/Song.m4a")
-			// We need just the filename for the M3U since it's in the same folder
-			baseName := filepath.Base(track.FilePath)
-
-			// Write EXTINF
-			duration := -1 // Unknown duration
-			title := fmt.Sprintf("%s - %s", track.Artist, track.Title)
-			if _, err := f.WriteString(fmt.Sprintf("#EXTINF:%d,%s\n", duration, title)); err != nil {
-				return err
-			}
-			// Write filename
-			if _, err := f.WriteString(baseName + "\n"); err != nil {
-				return err
-			}
+	artistID := ""
+	for _, song := range songs {
+		if song.ArtistID != "" && song.Artist == album.AlbumArtist {
+			artistID = song.ArtistID
+			break
 		}
 	}
-	return nil
-}
+	if artistID == "" {
+		return nil
+	}
 
-func generateNFO(playlistName string, outputDir string) error {
-	filename := filepath.Join(outputDir, "album.nfo")
-	f, err := os.Create(filename)
+	header, err := api.GetArtistHeader(artistID)
 	if err != nil {
-		return err
+		return fmt.Errorf("fetching artist %s: %w", artistID, err)
 	}
-	defer f.Close()
-
-	content := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
-<album>
-  <title>%s</title>
-  <artist>Various Artists</artist>
-  <albumartist>Various Artists</albumartist>
-  <compilation>true</compilation>
-</album>`, playlistName)
-
-	_, err = f.WriteString(content)
-	return err
+	artist := &nfo.Artist{
+		Name:                album.AlbumArtist,
+		MusicBrainzArtistID: album.AlbumArtistCredits.MusicBrainzArtistID,
+		Biography:           header.Description,
+	}
+	// Largest first, Kodi picks the first one
+	for i := len(header.Thumbnails) - 1; i >= 0; i-- {
+		artist.Thumbs = append(artist.Thumbs, nfo.Thumb{Aspect: "thumb", URL: header.Thumbnails[i].URL})
+	}
+	return library.WriteNFO(ctx, store, filepath.Join(folder, nfo.ArtistFile), artist)
 }
 
-func HandleSongTask(track *db.SongTask) {
-	// Update status to in_progress
-	db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusProcessing)
+// HandleSongTask downloads, converts and tags a track claimed with
+// db.ClaimPendingSongTask. When ctx is cancelled (shutdown or lost lease) the
+// partial files are removed and the task is put back into the queue.
+func HandleSongTask(ctx context.Context, track *db.SongTask) {
+	logger := logging.ForTrack(track.GroupTaskID, track.VideoID)
+	start := time.Now()
+	result := db.TaskStatusFailed
+	defer func() {
+		metrics.SongTasksProcessed.WithLabelValues(result).Inc()
+		metrics.ObserveSince(metrics.SongTaskDuration.WithLabelValues(result), start)
+		logger.Info().Str("result", result).Dur("duration", time.Since(start)).Msg("Song task finished")
+	}()
+	logger.Info().Msg("Song task started")
 
 	// Fetch parent group task to get keys and playlist name
 	groupTask, err := db.GetGroupTask(int(track.GroupTaskID))
 	if err != nil {
-		log.Printf("Failed to get group task %d: %v", track.GroupTaskID, err)
+		logger.Error().Err(err).Msg("Failed to get group task")
+		metrics.RecordFailure("group_task", err)
 		db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
 		return
 	}
 
 	// Fetch download path setting
 	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
-	fullDownloadPath, playlistFolder, playlistName := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	_, playlistFolder, _ := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	store := storage.Resolve(downloadPath)
+
+	// Reuse the file of an earlier download of the video
+	dedup := storage.DedupMode() != storage.DedupOff
+	if dedup {
+		if stored, ok := library.LookupTrack(ctx, store, track.VideoID, outputProfile()); ok {
+			logger.Info().Str("track_key", stored.Key).Msg("Track already stored, linking it")
+			name := utils.SanitizeFilename(fmt.Sprintf("%s - %s", track.Artist, track.Title) + path.Ext(stored.Key))
+			if err := linkStoredTrack(ctx, store, track, stored, filepath.Join(playlistFolder, name)); err != nil {
+				logger.Error().Err(err).Msg("Failed to link stored track")
+				metrics.RecordFailure("storage", err)
+				db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
+				return
+			}
+			finalizeTask(logger, store, track, playlistFolder)
+			result = db.TaskStatusCompleted
+			return
+		}
+	}
 
-	// Ensure download directory exists
-	if _, err := os.Stat(fullDownloadPath); os.IsNotExist(err) {
-		os.MkdirAll(fullDownloadPath, 0755)
+	// Download and convert in a staging folder, the finished file is then
+	// put into the storage
+	stagingDir := storage.StagingDir(downloadPath)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		logger.Error().Err(err).Msg("Failed to create staging directory")
+		metrics.RecordFailure("staging", err)
+		db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
+		return
+	}
+	workDir, err := os.MkdirTemp(stagingDir, "track-*")
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create staging directory")
+		metrics.RecordFailure("staging", err)
+		db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
+		return
 	}
+	// Removes partial files of interrupted or failed tracks as well
+	defer os.RemoveAll(workDir)
 
 	// Check for free disk space (require at least 50MB)
-	freeSpace, err := utils.GetFreeDiskSpace(fullDownloadPath)
+	freeSpace, err := utils.GetFreeDiskSpace(workDir)
 	if err != nil {
-		log.Printf("Failed to check disk space: %v", err)
+		logger.Warn().Err(err).Msg("Failed to check disk space")
 		// Proceeding anyway as it might be a permission issue or unsupported OS
 	} else {
-		const minSpace = 50 * 1024 * 1024 // 50MB
-		if freeSpace < minSpace {
-			log.Printf("Insufficient disk space: %d bytes available, %d required", freeSpace, minSpace)
+		if freeSpace < utils.MinFreeDiskSpace {
+			logger.Error().Uint64("available", freeSpace).Uint64("required", utils.MinFreeDiskSpace).Msg("Insufficient disk space")
+			metrics.Failures.WithLabelValues("disk_space", "disk_space").Inc()
 			db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
 			return
 		}
@@ -622,68 +875,225 @@ func HandleSongTask(track *db.SongTask) {
 		Artist:       track.Artist,
 		Album:        track.Album,
 		ThumbnailURL: track.ThumbnailURL,
+		DurationMs:   track.DurationMs,
 	}
+	trackInfo.Track, trackInfo.TrackTotal = trackPosition(track)
 
 	// Step 1: Download the track (always as .m4a)
-	absolutePath, err := downloadTrack(trackInfo, fullDownloadPath, playlistFolder)
+	absolutePath, err := downloadTrack(ctx, logger, &trackInfo, workDir, playlistFolder)
+	if ctx.Err() != nil {
+		result = interruptTask(logger, track)
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to download track %s: %v", track.Title, err)
+		logger.Error().Err(err).Msg("Failed to download track")
+		metrics.RecordFailure("download", err)
 		db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
 		return
 	}
 
-	// Step 2: Convert to MP3 if FFmpeg is available
+	// Step 2: Tag the track, converted to MP3 if FFmpeg is available
+	meta, lookup, _ := enrichTrack(ctx, logger, trackInfo)
+	if ctx.Err() != nil {
+		result = interruptTask(logger, track)
+		return
+	}
+	coverPath := downloadCover(logger, meta, workDir)
 	finalPath := absolutePath
 	if utils.IsFFmpegAvailable() {
-		convertedPath, err := convertTrack(trackInfo, absolutePath, fullDownloadPath)
+		var convertedPath string
+		meta.Normalize = outputProfile() == utils.ProfileMP3Loudnorm
+		convertedPath, err = convertTrack(ctx, logger, trackInfo, absolutePath, workDir, coverPath, meta)
+		if ctx.Err() != nil {
+			result = interruptTask(logger, track)
+			return
+		}
 		if err != nil {
-			log.Printf("Conversion failed for %s: %v. Keeping .m4a file.", track.Title, err)
+			logger.Warn().Err(err).Msg("Conversion failed, keeping .m4a file")
 			// Continue with .m4a file (don't fail the task)
 		} else {
 			finalPath = convertedPath
 		}
 	}
+	if finalPath == absolutePath {
+		if err := tagTrack(finalPath, coverPath, meta); err != nil {
+			// The untagged file still plays
+			logger.Warn().Err(err).Msg("Failed to tag .m4a file")
+		}
+	}
+
+	// Tag the ReplayGain of the track, the album gain follows once the
+	// group is complete
+	loudness := measureLoudness(ctx, logger, finalPath, trackInfo)
+	if ctx.Err() != nil {
+		result = interruptTask(logger, track)
+		return
+	}
+	if loudness != nil {
+		err := tags.UpdateFile(finalPath, func(t *tags.Tags) {
+			t.TrackGain = tags.GainFor(loudness.Integrated, loudness.Peak)
+		})
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to tag track gain")
+		}
+	}
 
-	// Step 3: Finalize the task
+	// Step 3: Store the file
 	relativePath := filepath.Join(playlistFolder, filepath.Base(finalPath))
-	finalizeTask(track, relativePath, playlistName, fullDownloadPath)
-}
+	fileHash, err := library.HashFile(finalPath)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to hash track")
+	}
+	if dedup {
+		var stored *db.StoredTrack
+		// A normalized conversion is not shared with plain ones
+		profile := library.ProfileOf(finalPath)
+		if meta.Normalize && profile == utils.ProfileMP3 {
+			profile = utils.ProfileMP3Loudnorm
+		}
+		stored, err = library.StoreTrack(ctx, store, track.VideoID, profile, finalPath, fileHash)
+		if err == nil {
+			err = linkStoredTrack(ctx, store, track, stored, relativePath)
+		}
+	} else {
+		err = storage.PutFile(ctx, store, storage.Key(relativePath), finalPath)
+		if err == nil {
+			err = db.MarkSongTaskCompleted(int(track.GroupTaskID), track.VideoID, relativePath, fileHash)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			result = interruptTask(logger, track)
+			return
+		}
+		logger.Error().Err(err).Str("backend", storage.Backend()).Msg("Failed to store track")
+		metrics.RecordFailure("storage", err)
+		db.UpdateSongTaskStatus(int(track.GroupTaskID), track.VideoID, db.TaskStatusFailed)
+		return
+	}
 
-func finalizeTask(track *db.SongTask, relativePath, playlistName, fullDownloadPath string) {
-	db.MarkSongTaskCompleted(int(track.GroupTaskID), track.VideoID, relativePath)
+	var integrated *float64
+	var peak float64
+	if loudness != nil {
+		integrated, peak = &loudness.Integrated, loudness.Peak
+	}
+	if err := db.SetSongTaskLoudness(int(track.GroupTaskID), track.VideoID, trackInfo.DurationMs, integrated, peak); err != nil {
+		logger.Warn().Err(err).Msg("Failed to record loudness")
+	}
+	if coverPath != "" {
+		// Lets an artwork refresh tell whether the artwork changed
+		if data, err := os.ReadFile(coverPath); err == nil {
+			err = db.SetSongTaskArtwork(int(track.GroupTaskID), track.VideoID, "", meta.ArtworkURL, artwork.Hash(data))
+			if err != nil {
+				logger.Warn().Err(err).Msg("Failed to record artwork")
+			}
+		}
+	}
 
-	// Check if all songs in the group are completed
-	completed, err := db.CheckGroupCompletion(int(track.GroupTaskID))
-	if err == nil && completed {
-		log.Printf("All songs completed for group task %d. Finalizing...", track.GroupTaskID)
-		db.UpdateGroupTaskStatus(int(track.GroupTaskID), db.TaskStatusCompleted)
+	// Step 4: Record the metadata match, weak ones are queued for review
+	if lookup != nil {
+		if err := metadata.Record(int(track.GroupTaskID), track.VideoID, lookup); err != nil {
+			logger.Warn().Err(err).Msg("Failed to record metadata match")
+		}
+	}
 
-		// Generate Metadata
-		finalSongs, err := db.GetSongTasks(int(track.GroupTaskID))
-		if err == nil {
-			if err := generateM3U(playlistName, finalSongs, fullDownloadPath); err != nil {
-				log.Printf("Failed to generate M3U: %v", err)
-			}
-			if err := generateNFO(playlistName, fullDownloadPath); err != nil {
-				log.Printf("Failed to generate NFO: %v", err)
-			}
+	// Step 5: Finalize the task
+	finalizeTask(logger, store, track, playlistFolder)
+	result = db.TaskStatusCompleted
+}
+
+// trackPosition returns the 1-based position of a track in its group and
+// the number of tracks, zero when unknown.
+func trackPosition(track *db.SongTask) (int, int) {
+	songs, err := db.GetSongTasks(int(track.GroupTaskID))
+	if err != nil {
+		return 0, 0
+	}
+	for i, song := range songs {
+		if song.VideoID == track.VideoID {
+			return i + 1, len(songs)
 		}
 	}
+	return 0, 0
+}
+
+// outputProfile is the profile a new download of a track ends up in.
+func outputProfile() string {
+	if !utils.IsFFmpegAvailable() {
+		return utils.ProfileM4A
+	}
+	if profile, _ := db.GetSetting(db.OutputProfileSetting); profile == utils.ProfileMP3Loudnorm {
+		return profile
+	}
+	return utils.ProfileMP3
 }
 
-func downloadFile(url, path string) error {
-	resp, err := http.Get(url)
+// linkStoredTrack completes a track with a link to the shared file of its
+// video, relativePath is where the file would be without deduplication.
+func linkStoredTrack(ctx context.Context, store storage.Storage, track *db.SongTask, stored *db.StoredTrack, relativePath string) error {
+	key, err := library.LinkTrack(ctx, store, stored, storage.Key(relativePath))
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	if err := db.MarkSongTaskLinked(int(track.GroupTaskID), track.VideoID, filepath.FromSlash(key), stored.FileHash, stored.Key); err != nil {
+		return err
+	}
 
-	out, err := os.Create(path)
+	// The loudness and artwork of the shared file
+	linked, err := db.GetLinkedSongTasks(stored.Key)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	for _, song := range linked {
+		if song.Loudness != nil || song.ArtworkHash != "" {
+			if err := db.SetSongTaskLoudness(int(track.GroupTaskID), track.VideoID, song.DurationMs, song.Loudness, song.Peak); err != nil {
+				return err
+			}
+			return db.SetSongTaskArtwork(int(track.GroupTaskID), track.VideoID, "", song.ArtworkURL, song.ArtworkHash)
+		}
+	}
+	return nil
+}
+
+// interruptTask puts a track cancelled by shutdown or a lost lease back into
+// the queue, unless another worker has claimed it meanwhile.
+func interruptTask(logger zerolog.Logger, track *db.SongTask) string {
+	logger.Warn().Msg("Song task interrupted, returning it to the queue")
+	if err := db.RequeueSongTask(int(track.GroupTaskID), track.VideoID, track.WorkerID); err != nil {
+		logger.Error().Err(err).Msg("Failed to requeue song task")
+	}
+	return "interrupted"
+}
+
+// finalizeTask updates the playlist files of the group after a track is
+// stored and completes the group once its last track is.
+func finalizeTask(logger zerolog.Logger, store storage.Storage, track *db.SongTask, playlistFolder string) {
+	// Not bound to the task context, the track itself is already stored
+	ctx := context.Background()
+	if err := playlist.Update(ctx, int(track.GroupTaskID)); err != nil {
+		logger.Error().Err(err).Msg("Failed to update playlist files")
+	}
+
+	// Check if all songs in the group are completed
+	completed, err := db.CheckGroupCompletion(int(track.GroupTaskID))
+	if err == nil && completed {
+		logger.Info().Msg("All songs completed for group task. Finalizing...")
+		db.UpdateGroupTaskStatus(int(track.GroupTaskID), db.TaskStatusCompleted)
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+		// Generate Metadata
+		if err := writeNFOs(ctx, store, int(track.GroupTaskID), playlistFolder); err != nil {
+			logger.Error().Err(err).Msg("Failed to write NFO files")
+		}
+		if enabled, _ := db.GetSetting(db.FolderCoverSetting); enabled == "true" {
+			if err := library.WriteFolderCover(ctx, store, int(track.GroupTaskID), playlistFolder); err != nil {
+				logger.Error().Err(err).Msg("Failed to write folder cover")
+			}
+		}
+		// Tracks downloaded before loudness was measured have none
+		if err := library.TagAlbumGain(ctx, int(track.GroupTaskID)); errors.Is(err, library.ErrLoudnessUnknown) {
+			logger.Debug().Err(err).Msg("Skipping album gain")
+		} else if err != nil {
+			logger.Warn().Err(err).Msg("Failed to tag album gain")
+		}
+	}
 }
@@ -4,6 +4,8 @@
 package downloader
 
 import (
+	"beatbump-server/backend/logging"
+	"context"
 	"testing"
 )
 
@@ -12,7 +14,7 @@ func TestGetPlaylist(t *testing.T) {
 	playlistID := "VLPLciALUPf8sIJlvH350O8PWh4UbSbOffSJ"
 
 	// Initial call to GetPlaylist
-	response, err := fetchPlaylistTracks(playlistID)
+	response, err := fetchPlaylistTracks(context.Background(), logging.Log, playlistID)
 	if err != nil {
 		t.Errorf("Error fetching playlist: %v", err)
 	}
@@ -1,62 +1,342 @@
 package downloader
 
 import (
+	"beatbump-server/backend/config"
 	"beatbump-server/backend/db"
-	"log"
+	"beatbump-server/backend/health"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/metrics"
+	"beatbump-server/backend/schedule"
+	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
 )
 
-func StartWorker() {
+// workerStaleAfter is how long the worker may go without progress before it
+// is reported as unhealthy. Batches of tracks block the ticker, so progress
+// is also recorded after every song task.
+const workerStaleAfter = 10 * time.Minute
+
+var lastActivity atomic.Int64
+
+func markWorkerActivity() {
+	lastActivity.Store(time.Now().UnixNano())
+	metrics.WorkerLastTick.SetToCurrentTime()
+}
+
+// LastActivity returns when the worker last ticked or finished a song task.
+func LastActivity() time.Time {
+	nanos := lastActivity.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func checkWorker(ctx context.Context) error {
+	last := LastActivity()
+	if last.IsZero() {
+		return fmt.Errorf("worker has not started")
+	}
+	if idle := time.Since(last); idle > workerStaleAfter {
+		return fmt.Errorf("no worker activity for %s", idle.Round(time.Second))
+	}
+	return nil
+}
+
+// shutdownGrace bounds the wait for cancelled tracks to clean up after the
+// drain timeout of StopWorker expired.
+const shutdownGrace = 10 * time.Second
+
+var (
+	stopWorker  = make(chan struct{})
+	stopOnce    sync.Once
+	workerDone  = make(chan struct{})
+	cancelTasks context.CancelFunc
+)
+
+func workerStopping() bool {
+	select {
+	case <-stopWorker:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveWorkerID returns the ID this instance uses in task leases. Only a
+// configured ID is stable, it lets a restarted instance take back its tasks
+// without waiting for the leases to expire. The default is unique to the
+// process, as instances on one host or containers may share a hostname.
+func resolveWorkerID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%08x", host, os.Getpid(), rand.Uint32())
+}
+
+// keepLease calls renew every interval until the returned stop function is
+// called. lost is called once when renew reports the lease was taken over.
+func keepLease(logger zerolog.Logger, interval time.Duration, renew func() error, lost func()) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if err := renew(); errors.Is(err, db.ErrLeaseLost) {
+				logger.Warn().Msg("Task lease lost, another worker owns the task now")
+				lost()
+				return
+			} else if err != nil {
+				logger.Error().Err(err).Msg("Failed to renew task lease")
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func requeueExpiredLeases() {
+	groups, songs, err := db.RequeueExpiredLeases(time.Now())
+	if err != nil {
+		logging.Log.Error().Err(err).Msg("Failed to requeue tasks with expired leases")
+	} else if groups > 0 || songs > 0 {
+		logging.Log.Warn().Int64("group_tasks", groups).Int64("song_tasks", songs).Msg("Requeued tasks with expired leases")
+	}
+}
+
+// requeueRecurringTasks queues the recurring tasks whose next refresh is
+// due and works out the one after.
+func requeueRecurringTasks(now time.Time) {
+	tasks, err := db.DueRecurringGroupTasks(now)
+	if err != nil {
+		logging.Log.Error().Err(err).Msg("Failed to find due recurring tasks")
+		return
+	}
+	for _, task := range tasks {
+		logger := logging.ForTask(int(task.ID))
+		recurrence, err := schedule.ParseRecurrence(task.Recurrence)
+		if err != nil {
+			logger.Error().Err(err).Str("recurrence", task.Recurrence).Msg("Invalid task recurrence")
+			continue
+		}
+		next := recurrence.Next(now.In(schedule.Location()))
+		if requeued, err := db.RequeueRecurringGroupTask(int(task.ID), next); err != nil {
+			logger.Error().Err(err).Msg("Failed to requeue recurring task")
+		} else if requeued {
+			logger.Info().Time("next_run_at", next).Msg("Refreshing recurring task")
+		}
+	}
+}
+
+// completeRefreshedTask completes a recurring task whose refresh found no
+// new tracks, no track download would complete it otherwise.
+func completeRefreshedTask(logger zerolog.Logger, groupTaskID int) {
+	task, err := db.GetGroupTask(groupTaskID)
+	if err != nil || task.Status != db.TaskStatusProcessing {
+		return
+	}
+	if done, err := db.CheckGroupCompletion(groupTaskID); err == nil && done {
+		logger.Info().Msg("No new songs for recurring task")
+		db.UpdateGroupTaskStatus(groupTaskID, db.TaskStatusCompleted)
+	}
+}
+
+func StartWorker(cfg config.WorkerConfig) {
+	metrics.RegisterQueueDepth(db.CountSongTasksByStatus)
+	markWorkerActivity()
+	health.Register("worker", true, checkWorker)
+
+	workerID := resolveWorkerID(cfg.ID)
+	lease := cfg.LeaseTimeout.Duration()
+	heartbeat := lease / 3
+	logging.Log.Info().Str("worker_id", workerID).Dur("lease", lease).Msg("Starting download worker")
+
+	// Tasks this instance held before a restart or crash. A generated ID
+	// is new, the leases of the previous run expire instead.
+	if cfg.ID != "" {
+		if groups, songs, err := db.ReleaseWorkerTasks(workerID); err != nil {
+			logging.Log.Error().Err(err).Msg("Failed to requeue tasks of the previous run")
+		} else if groups > 0 || songs > 0 {
+			logging.Log.Info().Int64("group_tasks", groups).Int64("song_tasks", songs).Msg("Requeued tasks of the previous run")
+		}
+	}
+	requeueExpiredLeases()
+
+	var taskCtx context.Context
+	taskCtx, cancelTasks = context.WithCancel(context.Background())
+
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
+		defer close(workerDone)
+		ticker := time.NewTicker(cfg.PollInterval.Duration())
 		defer ticker.Stop()
+		// Quiet hour mode of the last tick
+		quietMode := ""
+
+		for {
+			select {
+			case <-stopWorker:
+				return
+			case <-ticker.C:
+			}
+
+			metrics.WorkerTicks.Inc()
+			markWorkerActivity()
+			requeueExpiredLeases()
+			requeueRecurringTasks(time.Now())
+
+			// In quiet hours in-flight tracks finish, then the worker pauses
+			// or takes one track at a time
+			quiet := schedule.QuietMode(time.Now())
+			if quiet != quietMode {
+				if quiet == "" {
+					logging.Log.Info().Msg("Quiet hours ended")
+				} else {
+					logging.Log.Info().Str("mode", quiet).Msg("Quiet hours started")
+				}
+				quietMode = quiet
+			}
+			if quiet == schedule.ModePause {
+				continue
+			}
 
-		for range ticker.C {
 			// 1. Prioritize User Group Tasks (Playlists)
 			// We only pick up tasks that are pending and source='user'
-			groupTask, err := db.GetPendingGroupTask()
+			groupTask, err := db.ClaimPendingGroupTask(workerID, lease)
 			if err == nil && groupTask != nil {
-				// Mark as processing
-				db.UpdateGroupTaskStatus(int(groupTask.ID), db.TaskStatusProcessing)
-
-				if groupTask != nil {
-					log.Printf("Processing group task %d: %s", groupTask.ID, groupTask.Type)
-					switch groupTask.Type {
-					case db.TaskTypePlaylistDownload:
-						PopulatePlaylistTask(groupTask.ReferenceID, int(groupTask.ID))
-					case db.TaskTypeSongMixDownload:
-						PopulateSongMixTask(int(groupTask.ID))
-					default:
-						log.Printf("Unknown task type: %s", groupTask.Type)
-						db.UpdateGroupTaskStatus(int(groupTask.ID), db.TaskStatusFailed)
-					}
+				logger := logging.ForTask(int(groupTask.ID))
+				logger.Info().Str("type", groupTask.Type).Msg("Processing group task")
+				// Another worker took the task over when the lease is lost,
+				// so population stops
+				populateCtx, cancelPopulate := context.WithCancel(context.Background())
+				stopLease := keepLease(logger, heartbeat, func() error {
+					return db.RenewGroupTaskLease(int(groupTask.ID), workerID, lease)
+				}, cancelPopulate)
+				switch groupTask.Type {
+				case db.TaskTypePlaylistDownload:
+					PopulatePlaylistTask(populateCtx, groupTask.ReferenceID, int(groupTask.ID), groupTask.Recurrence != "")
+				case db.TaskTypeSongMixDownload:
+					PopulateSongMixTask(populateCtx, int(groupTask.ID))
+				case db.TaskTypeLibraryPlaylistDownload:
+					PopulateLibraryPlaylistTask(populateCtx, int(groupTask.ID))
+				default:
+					logger.Error().Str("type", groupTask.Type).Msg("Unknown task type")
+					db.UpdateGroupTaskStatus(int(groupTask.ID), db.TaskStatusFailed)
+				}
+				stopLease()
+				lost := populateCtx.Err() != nil
+				cancelPopulate()
+				if lost {
+					continue
+				}
+				if groupTask.Recurrence != "" {
+					completeRefreshedTask(logger, int(groupTask.ID))
+				}
+				// The tracks carry their own leases from here on
+				if err := db.ReleaseGroupTaskLease(int(groupTask.ID), workerID); err != nil {
+					logger.Error().Err(err).Msg("Failed to release group task lease")
 				}
 				continue
 			}
 
 			// 2. Process Individual Song Tasks (from any source, prioritized by user)
-			songTasks, err := db.GetPendingSongTasks()
-			if err == nil && len(songTasks) > 0 {
-				// Process concurrent downloads
-				// Limit concurrency to avoid rate limiting or system overload
-				concurrencyLimit := 1
-				sem := make(chan struct{}, concurrencyLimit)
+			// Tracks are claimed one at a time as slots free up, so instances
+			// sharing a database split the queue between them.
+			// Limit concurrency to avoid rate limiting or system overload
+			concurrencyLimit := cfg.Concurrency
+			if quiet == schedule.ModeThrottle {
+				concurrencyLimit = 1
+			}
+			sem := make(chan struct{}, concurrencyLimit)
 
-				for _, songTask := range songTasks {
-					sem <- struct{}{} // Acquire semaphore
-					go func(task *db.SongTask) {
-						defer func() { <-sem }() // Release semaphore
-						HandleSongTask(task)
-						time.Sleep(time.Duration(rand.Intn(8)) * time.Second)
-					}(songTask)
+			for {
+				sem <- struct{}{} // Acquire semaphore
+				if workerStopping() {
+					// Leave the remaining tracks pending for the next start
+					<-sem
+					break
 				}
-
-				// Wait for batch to finish
-				for i := 0; i < concurrencyLimit; i++ {
-					sem <- struct{}{}
+				if schedule.QuietMode(time.Now()) != quiet {
+					// Quiet hours started or ended, the next tick sets the limit
+					<-sem
+					break
+				}
+				songTask, err := db.ClaimPendingSongTask(workerID, lease)
+				if err != nil {
+					if !errors.Is(err, gorm.ErrRecordNotFound) {
+						logging.Log.Error().Err(err).Msg("Failed to claim song task")
+					}
+					<-sem
+					break
 				}
+				go func(task *db.SongTask) {
+					defer func() { <-sem }() // Release semaphore
+					ctx, cancel := context.WithCancel(taskCtx)
+					defer cancel()
+					stopLease := keepLease(logging.ForTrack(task.GroupTaskID, task.VideoID), heartbeat, func() error {
+						return db.RenewSongTaskLease(int(task.GroupTaskID), task.VideoID, workerID, lease)
+					}, cancel)
+					HandleSongTask(ctx, task)
+					stopLease()
+					markWorkerActivity()
+					if cfg.MaxJitter > 0 {
+						select {
+						case <-stopWorker:
+						case <-time.After(time.Duration(rand.Int63n(int64(cfg.MaxJitter)))):
+						}
+					}
+				}(songTask)
+			}
+
+			// Wait for in-flight tracks to finish
+			for i := 0; i < concurrencyLimit; i++ {
+				sem <- struct{}{}
 			}
 		}
 	}()
 }
+
+// StopWorker stops the worker from picking up new tasks and waits for the
+// in-flight tracks to finish. If ctx expires first, the remaining tracks are
+// cancelled, which removes their partial files and puts them back in the queue.
+func StopWorker(ctx context.Context) error {
+	if cancelTasks == nil {
+		return nil
+	}
+	stopOnce.Do(func() { close(stopWorker) })
+
+	select {
+	case <-workerDone:
+		return nil
+	case <-ctx.Done():
+	}
+
+	cancelTasks()
+	select {
+	case <-workerDone:
+	case <-time.After(shutdownGrace):
+		logging.Log.Warn().Msg("Download worker did not stop after cancelling in-flight tracks")
+	}
+	return ctx.Err()
+}
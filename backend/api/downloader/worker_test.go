@@ -0,0 +1,213 @@
+package downloader
+
+import (
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/nfo"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptedSongTaskIsRequeued(t *testing.T) {
+	dbtest.Open(t)
+	require.NoError(t, db.SetSetting(db.DownloadPathSetting, t.TempDir()))
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", "user", -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	require.NoError(t, db.AddSongTask(int(group.ID), "abc", "Song", "Artist", "", ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	track, err := db.ClaimPendingSongTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	HandleSongTask(ctx, track)
+
+	track, err = db.GetSongTask(int(group.ID), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusNotStarted, track.Status)
+	assert.Empty(t, track.WorkerID)
+}
+
+func TestStoredTrackIsLinkedInsteadOfDownloaded(t *testing.T) {
+	dbtest.Open(t)
+	root := t.TempDir()
+	require.NoError(t, db.SetSetting(db.DownloadPathSetting, root))
+	require.NoError(t, storage.Configure(config.StorageConfig{Backend: "local", Dedup: storage.DedupHardlink}))
+	t.Cleanup(func() { storage.Configure(config.StorageConfig{}) })
+
+	// Downloaded earlier by another task
+	local := filepath.Join(t.TempDir(), "abc."+outputProfile())
+	require.NoError(t, os.WriteFile(local, []byte("audio"), 0644))
+	_, err := library.StoreTrack(context.Background(), storage.Resolve(root), "abc", library.ProfileOf(local), local, "hash")
+	require.NoError(t, err)
+
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", "user", -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	require.NoError(t, db.AddSongTask(int(group.ID), "abc", "Song", "Artist", "", ""))
+	track, err := db.ClaimPendingSongTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	HandleSongTask(context.Background(), track)
+
+	track, err = db.GetSongTask(int(group.ID), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusCompleted, track.Status)
+	assert.Equal(t, filepath.Join("Playlist", "Artist - Song."+outputProfile()), track.FilePath)
+	assert.Equal(t, library.TrackKey("abc", outputProfile(), "."+outputProfile()), track.TrackKey)
+	assert.FileExists(t, filepath.Join(root, track.FilePath))
+	assert.FileExists(t, filepath.Join(root, "Playlist", "playlist.m3u8"))
+	assert.FileExists(t, filepath.Join(root, "Playlist", nfo.AlbumFile))
+}
+
+func TestKeepLeaseStopsWhenLost(t *testing.T) {
+	renewals := 0
+	lost := make(chan struct{})
+	stop := keepLease(logging.Log, time.Millisecond, func() error {
+		renewals++
+		if renewals == 3 {
+			return db.ErrLeaseLost
+		}
+		return nil
+	}, func() { close(lost) })
+	defer stop()
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("lost lease not reported")
+	}
+	assert.Equal(t, 3, renewals)
+}
+
+func TestStopWorkerDrains(t *testing.T) {
+	dbtest.Open(t)
+	StartWorker(config.WorkerConfig{
+		PollInterval: config.Duration(10 * time.Millisecond),
+		Concurrency:  1,
+		LeaseTimeout: config.Duration(time.Minute),
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, StopWorker(ctx))
+
+	select {
+	case <-workerDone:
+	default:
+		t.Fatal("worker loop still running")
+	}
+	assert.NotPanics(t, func() {
+		assert.NoError(t, StopWorker(ctx))
+	})
+}
+
+func TestM4aIsTaggedWithoutFFmpeg(t *testing.T) {
+	dbtest.Open(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", "user", -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, db.AddSongTask(int(group.ID), id, "Song "+id, "Artist", "", ""))
+		time.Sleep(time.Millisecond)
+	}
+	track, err := db.GetSongTask(int(group.ID), "b")
+	require.NoError(t, err)
+	number, total := trackPosition(track)
+	assert.Equal(t, 2, number)
+	assert.Equal(t, 3, total)
+
+	// ftyp, moov and mdat as YouTube serves them
+	atom := func(typ string, payload ...byte) []byte {
+		return append(append([]byte{0, 0, 0, byte(8 + len(payload))}, typ...), payload...)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.m4a")
+	file := append(atom("ftyp", []byte("M4A ")...), atom("moov", atom("mvhd", make([]byte, 20)...)...)...)
+	require.NoError(t, os.WriteFile(path, append(file, atom("mdat", []byte("audio")...)...), 0644))
+	coverPath := filepath.Join(dir, "cover.jpg")
+	require.NoError(t, os.WriteFile(coverPath, []byte("\xff\xd8\xff\xe0jpeg"), 0644))
+
+	meta := utils.AudioMetadata{Title: "Song b", Artist: "Artist", Album: "Album", Year: "2020", Genre: "Pop", VideoID: "b", Track: number, TrackTotal: total}
+	require.NoError(t, tagTrack(path, coverPath, meta))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	got, err := tags.Read(f, path)
+	require.NoError(t, err)
+	assert.Equal(t, "Song b", got.Title)
+	assert.Equal(t, "2020", got.Year)
+	assert.Equal(t, "Pop", got.Genre)
+	assert.Equal(t, "b", got.VideoID)
+	assert.Equal(t, 2, got.Track)
+	assert.Equal(t, 3, got.TrackTotal)
+	require.NotNil(t, got.Cover)
+	assert.Equal(t, "image/jpeg", got.Cover.MIMEType)
+}
+
+func TestRecurringTaskIsRequeued(t *testing.T) {
+	dbtest.Open(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", "user", -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	require.NoError(t, db.AddSongTask(groupID, "abc", "Song", "Artist", "", ""))
+	require.NoError(t, db.MarkSongTaskCompleted(groupID, "abc", "Playlist/Song.mp3", ""))
+	require.NoError(t, db.UpdateGroupTaskStatus(groupID, db.TaskStatusCompleted))
+	due := time.Now().Add(-time.Minute)
+	require.NoError(t, db.SetGroupTaskSchedule(groupID, nil, "every 1h", &due))
+
+	now := time.Now()
+	requeueRecurringTasks(now)
+	task, err := db.GetGroupTask(groupID)
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusPending, task.Status)
+	require.NotNil(t, task.NextRunAt)
+	assert.WithinDuration(t, now.Add(time.Hour), *task.NextRunAt, time.Second)
+
+	// The refresh found nothing new
+	claimed, err := db.ClaimPendingGroupTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	completeRefreshedTask(logging.ForTask(groupID), int(claimed.ID))
+	task, err = db.GetGroupTask(groupID)
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusCompleted, task.Status)
+}
+
+func TestPopulationStopsWhenLeaseIsLost(t *testing.T) {
+	dbtest.Open(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypeLibraryPlaylistDownload, "library:1", "Mine", "user", -1))
+	group, err := db.GetGroupTaskByReferenceID("library:1")
+	require.NoError(t, err)
+	require.NoError(t, db.DB.Create(&db.PlaylistEntry{PlaylistID: 1, VideoID: "abc", Title: "Song"}).Error)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	PopulateLibraryPlaylistTask(ctx, int(group.ID))
+	songs, err := db.GetSongTasks(int(group.ID))
+	require.NoError(t, err)
+	assert.Empty(t, songs)
+
+	PopulateLibraryPlaylistTask(context.Background(), int(group.ID))
+	songs, err = db.GetSongTasks(int(group.ID))
+	require.NoError(t, err)
+	assert.Len(t, songs, 1)
+}
+
+func TestGeneratedWorkerIDsDiffer(t *testing.T) {
+	assert.Equal(t, "node-1", resolveWorkerID("node-1"))
+	assert.NotEqual(t, resolveWorkerID(""), resolveWorkerID(""), "instances sharing a hostname")
+}
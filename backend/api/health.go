@@ -0,0 +1,28 @@
+package api
+
+import (
+	"beatbump-server/backend/health"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const readinessCheckTimeout = 5 * time.Second
+
+// HealthzHandler reports that the process is up and serving requests.
+func HealthzHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": health.StatusOK})
+}
+
+// ReadyzHandler runs the dependency checks and returns 503 when a critical
+// one fails. Non-critical failures are reported as degraded with a 200.
+func ReadyzHandler(c echo.Context) error {
+	report := health.Run(c.Request().Context(), readinessCheckTimeout)
+
+	status := http.StatusOK
+	if report.Status == health.StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, report)
+}
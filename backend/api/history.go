@@ -0,0 +1,193 @@
+package api
+
+import (
+	"beatbump-server/backend/_youtube"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/scrobbler"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+)
+
+type HistoryResponse struct {
+	Total  int64          `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+	Events []db.PlayEvent `json:"events"`
+}
+
+// recordPlayEvent stores a play for the listening history and feeds the scrobbler.
+func recordPlayEvent(logger zerolog.Logger, userID, playlistId, album string, playerResponse _youtube.PlayerResponse) {
+	go func() {
+		if playlistId == "undefined" {
+			playlistId = ""
+		}
+		details := playerResponse.VideoDetails
+		duration, _ := strconv.Atoi(details.LengthSeconds)
+
+		event := &db.PlayEvent{
+			UserID:          userID,
+			VideoID:         details.VideoID,
+			Title:           details.Title,
+			Artist:          details.Author,
+			Album:           album,
+			PlaylistID:      playlistId,
+			DurationSeconds: duration,
+		}
+		if err := db.RecordPlayEvent(event); err != nil {
+			logger.Error().Err(err).Str("video_id", details.VideoID).Msg("Failed to record play event")
+		}
+
+		scrobbler.HandlePlay(userID, scrobbler.Track{
+			VideoID:         event.VideoID,
+			Artist:          event.Artist,
+			Title:           event.Title,
+			Album:           event.Album,
+			DurationSeconds: event.DurationSeconds,
+			PlayedAt:        event.PlayedAt,
+		})
+	}()
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or YYYY-MM-DD dates.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func intQueryParam(c echo.Context, name string, def int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+func GetHistoryHandler(c echo.Context) error {
+	limit, err := intQueryParam(c, "limit", 50)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid limit")
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset, err := intQueryParam(c, "offset", 0)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid offset")
+	}
+	since, err := parseTimeParam(c.QueryParam("since"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid since")
+	}
+	until, err := parseTimeParam(c.QueryParam("until"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid until")
+	}
+
+	events, total, err := db.GetPlayEvents(requestUser(c), since, until, limit, offset)
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch history: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch history")
+	}
+	if events == nil {
+		events = []db.PlayEvent{}
+	}
+
+	return c.JSON(http.StatusOK, HistoryResponse{
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Events: events,
+	})
+}
+
+func ClearHistoryHandler(c echo.Context) error {
+	if err := db.ClearPlayEvents(requestUser(c)); err != nil {
+		c.Logger().Errorf("Failed to clear history: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to clear history")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func GetHistoryStatsHandler(c echo.Context) error {
+	period := c.QueryParam("period")
+	switch period {
+	case "":
+		period = "all"
+	case "all", "day", "week", "month", "year":
+	default:
+		return c.String(http.StatusBadRequest, "Invalid period")
+	}
+
+	limit, err := intQueryParam(c, "limit", 10)
+	if err != nil || limit == 0 {
+		return c.String(http.StatusBadRequest, "Invalid limit")
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	stats, err := db.GetListeningStats(requestUser(c), period, limit)
+	if err != nil {
+		c.Logger().Errorf("Failed to compute listening stats: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to compute listening stats")
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// ExportHistoryHandler streams the whole history as JSON or CSV.
+func ExportHistoryHandler(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		return c.String(http.StatusBadRequest, "Invalid format")
+	}
+
+	events, _, err := db.GetPlayEvents(requestUser(c), time.Time{}, time.Time{}, 0, 0)
+	if err != nil {
+		c.Logger().Errorf("Failed to export history: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to export history")
+	}
+	if events == nil {
+		events = []db.PlayEvent{}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="beatbump-history.`+format+`"`)
+	if format == "json" {
+		return c.JSON(http.StatusOK, events)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"played_at", "video_id", "title", "artist", "album", "playlist_id", "duration_seconds"})
+	for _, e := range events {
+		w.Write([]string{
+			e.PlayedAt.Format(time.RFC3339),
+			e.VideoID,
+			e.Title,
+			e.Artist,
+			e.Album,
+			e.PlaylistID,
+			strconv.Itoa(e.DurationSeconds),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,339 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type LibraryPlaylistRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Thumbnail   string            `json:"thumbnail"`
+	Items       []db.LibraryTrack `json:"items"`
+}
+
+type LibraryOrderRequest struct {
+	VideoIDs []string `json:"videoIds"`
+	EntryIDs []uint   `json:"entryIds"`
+}
+
+// requestUser identifies the library owner. Beatbump has no accounts, so
+// devices that want to share a library send the same X-Beatbump-User value.
+func requestUser(c echo.Context) string {
+	user := strings.TrimSpace(c.Request().Header.Get("X-Beatbump-User"))
+	if user == "" {
+		user = c.QueryParam("user")
+	}
+	if user == "" {
+		return db.DefaultUserID
+	}
+	return user
+}
+
+// RequireServerLibrary rejects library requests unless the server-side store
+// has been enabled in the settings.
+func RequireServerLibrary(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		enabled, _ := db.GetSetting(db.ServerLibraryEnabledSetting)
+		if enabled != "true" {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Server library is disabled"})
+		}
+		return next(c)
+	}
+}
+
+func playlistIDParam(c echo.Context) (int, error) {
+	return strconv.Atoi(c.Param("playlistId"))
+}
+
+// Favorites
+
+func GetFavoritesHandler(c echo.Context) error {
+	favorites, err := db.GetFavorites(requestUser(c))
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch favorites: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch favorites")
+	}
+	if favorites == nil {
+		favorites = []db.Favorite{}
+	}
+	return c.JSON(http.StatusOK, favorites)
+}
+
+func AddFavoriteHandler(c echo.Context) error {
+	var track db.LibraryTrack
+	if err := c.Bind(&track); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	if track.VideoID == "" {
+		return c.String(http.StatusBadRequest, "Video ID is required")
+	}
+
+	if err := db.AddFavorite(requestUser(c), track); err != nil {
+		c.Logger().Errorf("Failed to add favorite: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to add favorite")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "added"})
+}
+
+func DeleteFavoriteHandler(c echo.Context) error {
+	videoID := c.Param("videoId")
+	if videoID == "" {
+		return c.String(http.StatusBadRequest, "Video ID is required")
+	}
+
+	if err := db.DeleteFavorite(requestUser(c), videoID); err != nil {
+		c.Logger().Errorf("Failed to delete favorite: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to delete favorite")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func ReorderFavoritesHandler(c echo.Context) error {
+	var req LibraryOrderRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := db.ReorderFavorites(requestUser(c), req.VideoIDs); err != nil {
+		c.Logger().Errorf("Failed to reorder favorites: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to reorder favorites")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// Playlists
+
+func GetLibraryPlaylistsHandler(c echo.Context) error {
+	playlists, err := db.GetUserPlaylists(requestUser(c))
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch playlists: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch playlists")
+	}
+	if playlists == nil {
+		playlists = []db.UserPlaylist{}
+	}
+	return c.JSON(http.StatusOK, playlists)
+}
+
+func GetLibraryPlaylistHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	playlist, err := db.GetUserPlaylist(requestUser(c), playlistID)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch playlist: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch playlist")
+	}
+	if playlist.Entries == nil {
+		playlist.Entries = []db.PlaylistEntry{}
+	}
+	return c.JSON(http.StatusOK, playlist)
+}
+
+func CreateLibraryPlaylistHandler(c echo.Context) error {
+	var req LibraryPlaylistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	if req.Name == "" {
+		return c.String(http.StatusBadRequest, "Playlist name is required")
+	}
+
+	user := requestUser(c)
+	playlist, err := db.CreateUserPlaylist(user, req.Name, req.Description, req.Thumbnail)
+	if err != nil {
+		c.Logger().Errorf("Failed to create playlist: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to create playlist")
+	}
+
+	if len(req.Items) > 0 {
+		if err := db.AddPlaylistEntries(user, int(playlist.ID), req.Items); err != nil {
+			c.Logger().Errorf("Failed to add playlist entries: %v", err)
+			return c.String(http.StatusInternalServerError, "Failed to add tracks to playlist")
+		}
+	}
+
+	return c.JSON(http.StatusOK, playlist)
+}
+
+func UpdateLibraryPlaylistHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	var req LibraryPlaylistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+
+	err = db.UpdateUserPlaylist(requestUser(c), playlistID, req.Name, req.Description, req.Thumbnail)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to update playlist: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to update playlist")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
+}
+
+func DeleteLibraryPlaylistHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	err = db.DeleteUserPlaylist(requestUser(c), playlistID)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to delete playlist: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to delete playlist")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func AddLibraryPlaylistTracksHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	var req LibraryPlaylistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	for _, item := range req.Items {
+		if item.VideoID == "" {
+			return c.String(http.StatusBadRequest, "Video ID is required")
+		}
+	}
+
+	err = db.AddPlaylistEntries(requestUser(c), playlistID, req.Items)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to add playlist entries: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to add tracks to playlist")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "added"})
+}
+
+func DeleteLibraryPlaylistTrackHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+	entryID, err := strconv.Atoi(c.Param("entryId"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid entry ID")
+	}
+
+	err = db.DeletePlaylistEntry(requestUser(c), playlistID, entryID)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if errors.Is(err, db.ErrPlaylistEntryNotFound) {
+		return c.String(http.StatusNotFound, "Playlist entry not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to delete playlist entry: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to delete track from playlist")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func ReorderLibraryPlaylistHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	var req LibraryOrderRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+
+	err = db.ReorderPlaylistEntries(requestUser(c), playlistID, req.EntryIDs)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to reorder playlist: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to reorder playlist")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// DownloadLibraryPlaylistHandler queues a server-side playlist for download.
+func DownloadLibraryPlaylistHandler(c echo.Context) error {
+	playlistID, err := playlistIDParam(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	playlist, err := db.GetUserPlaylist(requestUser(c), playlistID)
+	if errors.Is(err, db.ErrPlaylistNotFound) {
+		return c.String(http.StatusNotFound, "Playlist not found")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch playlist: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch playlist")
+	}
+
+	referenceID := fmt.Sprintf("library:%d", playlist.ID)
+	err = db.AddGroupTask(db.TaskTypeLibraryPlaylistDownload, referenceID, playlist.Name, db.TaskSourceUser, -1)
+	if err != nil {
+		c.Logger().Errorf("Failed to add library playlist task: %v", err)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.JSON(http.StatusConflict, map[string]string{"status": "already_queued", "message": "Task already queued"})
+		}
+		return c.String(http.StatusInternalServerError, "Failed to create task")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "queued"})
+}
+
+// Import / Export
+
+func ExportLibraryHandler(c echo.Context) error {
+	export, err := db.ExportLibrary(requestUser(c))
+	if err != nil {
+		c.Logger().Errorf("Failed to export library: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to export library")
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="beatbump-library.json"`)
+	return c.JSON(http.StatusOK, export)
+}
+
+func ImportLibraryHandler(c echo.Context) error {
+	var data db.LibraryExport
+	if err := c.Bind(&data); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	if data.Version > db.LibraryExportVersion {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported library export version"})
+	}
+
+	if err := db.ImportLibrary(requestUser(c), &data); err != nil {
+		c.Logger().Errorf("Failed to import library: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to import library")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "imported"})
+}
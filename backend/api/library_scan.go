@@ -0,0 +1,53 @@
+package api
+
+import (
+	"beatbump-server/backend/library"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StartLibraryScanHandler reconciles the downloaded files with the tasks.
+// Query parameters: missing=mark|requeue, dryRun=true, and wait=true to run
+// the scan in the request and answer with its report.
+func StartLibraryScanHandler(c echo.Context) error {
+	opts := library.ScanOptions{
+		MissingAction: c.QueryParam("missing"),
+		DryRun:        c.QueryParam("dryRun") == "true",
+	}
+	switch opts.MissingAction {
+	case "":
+		opts.MissingAction = library.MissingMark
+	case library.MissingMark, library.MissingRequeue:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing must be mark or requeue"})
+	}
+
+	if c.QueryParam("wait") == "true" {
+		report, err := library.RunScan(c.Request().Context(), opts)
+		if errors.Is(err, library.ErrScanRunning) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		} else if errors.Is(err, library.ErrNoDownloadPath) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Download path must be set first"})
+		} else if err != nil {
+			c.Logger().Errorf("Library scan failed: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Library scan failed"})
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+
+	if err := library.StartScan(opts); errors.Is(err, library.ErrScanRunning) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+// GetLibraryScanHandler reports a running scan and the last report.
+func GetLibraryScanHandler(c echo.Context) error {
+	running, report := library.ScanStatus()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"running": running,
+		"report":  report,
+	})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type LibrarySearchResponse struct {
+	Total   int64             `json:"total"`
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+	Results []db.SearchResult `json:"results"`
+}
+
+// SearchLibraryHandler searches the downloaded tracks. q matches any field,
+// title, artist, album, playlist and lyrics restrict words to one field.
+// Every word matches as a prefix.
+func SearchLibraryHandler(c echo.Context) error {
+	limit, err := intQueryParam(c, "limit", 50)
+	if err != nil || limit == 0 {
+		return c.String(http.StatusBadRequest, "Invalid limit")
+	}
+	if limit > 200 {
+		limit = 200
+	}
+	offset, err := intQueryParam(c, "offset", 0)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid offset")
+	}
+
+	query := db.SearchQuery{
+		Text:   c.QueryParam("q"),
+		Fields: make(map[string]string),
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, field := range db.SearchFields {
+		query.Fields[field] = c.QueryParam(field)
+	}
+
+	results, total, err := db.SearchSongTasks(query)
+	if errors.Is(err, db.ErrEmptySearch) {
+		return c.String(http.StatusBadRequest, "Search needs at least one word")
+	} else if err != nil {
+		c.Logger().Errorf("Failed to search library: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to search library")
+	}
+	if results == nil {
+		results = []db.SearchResult{}
+	}
+
+	return c.JSON(http.StatusOK, LibrarySearchResponse{
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Results: results,
+	})
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/metadata"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ReviewChoiceRequest struct {
+	Candidate int `json:"candidate"`
+}
+
+// GetMetadataReviewsHandler lists the reviews with ?status=, pending ones by
+// default, all of them with status=all.
+func GetMetadataReviewsHandler(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "":
+		status = db.ReviewStatusPending
+	case "all":
+		status = ""
+	case db.ReviewStatusPending, db.ReviewStatusAccepted, db.ReviewStatusRejected:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be pending, accepted, rejected or all"})
+	}
+
+	reviews, err := db.GetMetadataReviews(status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get reviews"})
+	}
+	if reviews == nil {
+		reviews = []db.MetadataReview{}
+	}
+	return c.JSON(http.StatusOK, reviews)
+}
+
+func GetMetadataReviewHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid review ID"})
+	}
+	review, err := db.GetMetadataReview(id)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, review)
+}
+
+// AcceptMetadataReviewHandler applies the best candidate of a review.
+func AcceptMetadataReviewHandler(c echo.Context) error {
+	return resolveReview(c, 0)
+}
+
+// PickMetadataReviewHandler applies the candidate at the index in the body.
+func PickMetadataReviewHandler(c echo.Context) error {
+	var req ReviewChoiceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	return resolveReview(c, req.Candidate)
+}
+
+func RejectMetadataReviewHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid review ID"})
+	}
+	review, err := metadata.Reject(id)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, review)
+}
+
+func resolveReview(c echo.Context, choice int) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid review ID"})
+	}
+	review, err := metadata.Accept(c.Request().Context(), id, choice)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, review)
+}
+
+func reviewError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Review not found"})
+	case errors.Is(err, db.ErrReviewResolved), errors.Is(err, library.ErrNotStored):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, metadata.ErrInvalidChoice):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, library.ErrNoDownloadPath):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Download path must be set first"})
+	default:
+		c.Logger().Errorf("Metadata review failed: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Metadata review failed"})
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"beatbump-server/backend/_youtube"
 	"beatbump-server/backend/_youtube/api"
 	"beatbump-server/backend/db"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/metrics"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
 )
 
 type PlayerAPIResponse struct {
@@ -63,13 +65,17 @@ func PlayerEndpointHandler(c echo.Context) error {
 		format.URL = strings.Clone(streamUrl)
 	}
 
+	// Listening History
+	logger := logging.Ctx(c.Request().Context())
+	recordPlayEvent(*logger, requestUser(c), playlistId, query.Get("album"), playerResponse)
+
 	// Ongoing Listening Logic
-	handleTrackdownloadTask(playlistId, playerResponse, videoId)
+	handleTrackdownloadTask(*logger, playlistId, playerResponse, videoId)
 
 	return c.JSON(http.StatusOK, playerResponse)
 }
 
-func handleTrackdownloadTask(playlistId string, playerResponse _youtube.PlayerResponse, videoId string) {
+func handleTrackdownloadTask(logger zerolog.Logger, playlistId string, playerResponse _youtube.PlayerResponse, videoId string) {
 	go func() {
 		enabled, _ := db.GetSetting(db.OngoingListeningEnabledSetting)
 		if enabled == "true" {
@@ -86,8 +92,10 @@ func handleTrackdownloadTask(playlistId string, playerResponse _youtube.PlayerRe
 				// Try to get task to see if we already have the name
 				t, err := db.GetGroupTaskByReferenceID(refID)
 				if err == nil && t != nil {
+					metrics.OngoingPlaylistLookups.WithLabelValues("found").Inc()
 					task = t
 				} else {
+					metrics.OngoingPlaylistLookups.WithLabelValues("created").Inc()
 					if (strings.HasPrefix(playlistId, "R") || strings.HasPrefix(playlistId, "PL")) && !strings.HasPrefix(playlistId, "VL") {
 						playlistId = "VL" + playlistId
 					}
@@ -105,23 +113,23 @@ func handleTrackdownloadTask(playlistId string, playerResponse _youtube.PlayerRe
 				}
 			} else {
 				// Try to find an active session task (updated within last 30 mins)
-				log.Println("Checking for active session task...")
+				logger.Debug().Msg("Checking for active session task...")
 				activeTask, err := db.GetActiveSessionGroupTask(30 * time.Minute)
 				if err == nil && activeTask != nil {
-					log.Printf("Found active session task: %d (Ref: %s)", activeTask.ID, activeTask.ReferenceID)
+					logger.Debug().Uint("task_id", activeTask.ID).Str("reference_id", activeTask.ReferenceID).Msg("Found active session task")
 					task = activeTask
 					refID = task.ReferenceID
 				} else {
 					if err != nil {
-						log.Printf("Error getting active session task: %v", err)
+						logger.Debug().Err(err).Msg("Error getting active session task")
 					} else {
-						log.Println("No active session task found.")
+						logger.Debug().Msg("No active session task found.")
 					}
 					// Create new session
 					timestamp := time.Now().Format("2006-01-02 15:04")
 					refID = fmt.Sprintf("ongoing:songs:%d", time.Now().Unix())
 					playlistName = fmt.Sprintf("Listening Session %s", timestamp)
-					log.Printf("Creating new session: %s (Ref: %s)", playlistName, refID)
+					logger.Info().Str("reference_id", refID).Msgf("Creating new session: %s", playlistName)
 				}
 			}
 
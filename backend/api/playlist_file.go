@@ -0,0 +1,52 @@
+package api
+
+import (
+	"beatbump-server/backend/playlist"
+	"bytes"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// GetTaskPlaylistHandler renders the completed tracks of a task as a
+// playlist file. ?format= picks the format (m3u8 by default) and ?paths=
+// relative or absolute overrides the playlist paths setting.
+func GetTaskPlaylistHandler(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("taskId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid task ID"})
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = playlist.FormatM3U8
+	}
+	if !slices.Contains(playlist.Formats, format) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown playlist format"})
+	}
+	paths := c.QueryParam("paths")
+	switch paths {
+	case "":
+		paths = playlist.ConfiguredPaths()
+	case playlist.PathsRelative, playlist.PathsAbsolute:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "paths must be relative or absolute"})
+	}
+
+	p, _, err := playlist.ForTask(taskID, paths)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Task not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build playlist"})
+	}
+	var buf bytes.Buffer
+	if err := playlist.Encode(&buf, p, format); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build playlist"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+playlist.FileName(format)+`"`)
+	return c.Blob(http.StatusOK, playlist.ContentType(format), buf.Bytes())
+}
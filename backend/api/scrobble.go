@@ -0,0 +1,189 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/scrobbler"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ScrobbleRequest struct {
+	Event           string `json:"event"`
+	VideoID         string `json:"videoId"`
+	Artist          string `json:"artist"`
+	Title           string `json:"title"`
+	Album           string `json:"album"`
+	Duration        int    `json:"duration"`
+	Timestamp       int64  `json:"timestamp"`
+	ListenedSeconds int    `json:"listenedSeconds"`
+}
+
+type ScrobbleSettingsRequest struct {
+	ListenBrainzToken string  `json:"listenBrainzToken"`
+	ListenBrainzURL   *string `json:"listenBrainzUrl"`
+	LastFmAPIKey      string  `json:"lastFmApiKey"`
+	LastFmSecret      string  `json:"lastFmSecret"`
+	LastFmSessionKey  string  `json:"lastFmSessionKey"`
+	LastFmURL         *string `json:"lastFmUrl"`
+}
+
+type LastFmSessionRequest struct {
+	Token string `json:"token"`
+}
+
+// ScrobbleHandler accepts now-playing and completion reports from the client.
+func ScrobbleHandler(c echo.Context) error {
+	var req ScrobbleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	if req.VideoID == "" || req.Artist == "" || req.Title == "" {
+		return c.String(http.StatusBadRequest, "videoId, artist and title are required")
+	}
+
+	track := scrobbler.Track{
+		VideoID:         req.VideoID,
+		Artist:          req.Artist,
+		Title:           req.Title,
+		Album:           req.Album,
+		DurationSeconds: req.Duration,
+	}
+	if req.Timestamp > 0 {
+		track.PlayedAt = time.Unix(req.Timestamp, 0)
+	}
+
+	switch req.Event {
+	case "now_playing":
+		scrobbler.HandlePlay(requestUser(c), track)
+		return c.JSON(http.StatusOK, map[string]string{"status": "now_playing"})
+	case "complete":
+		listened := time.Duration(req.ListenedSeconds) * time.Second
+		if !scrobbler.Complete(requestUser(c), track, listened) {
+			return c.JSON(http.StatusOK, map[string]string{"status": "skipped"})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"status": "scrobbled"})
+	default:
+		return c.String(http.StatusBadRequest, "event must be now_playing or complete")
+	}
+}
+
+func GetScrobbleQueueHandler(c echo.Context) error {
+	items, err := db.GetScrobbleQueue()
+	if err != nil {
+		c.Logger().Errorf("Failed to fetch scrobble queue: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch scrobble queue")
+	}
+	if items == nil {
+		items = []db.ScrobbleQueueItem{}
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// GetScrobbleSettingsHandler reports which providers are configured without
+// returning the secrets themselves.
+func GetScrobbleSettingsHandler(c echo.Context) error {
+	listenBrainzToken, _ := db.GetSetting(db.ScrobbleListenBrainzTokenSetting)
+	listenBrainzURL, _ := db.GetSetting(db.ScrobbleListenBrainzURLSetting)
+	lastFmAPIKey, _ := db.GetSetting(db.ScrobbleLastFmAPIKeySetting)
+	lastFmSessionKey, _ := db.GetSetting(db.ScrobbleLastFmSessionKeySetting)
+	lastFmURL, _ := db.GetSetting(db.ScrobbleLastFmURLSetting)
+
+	if listenBrainzURL == "" {
+		listenBrainzURL = scrobbler.DefaultListenBrainzURL
+	}
+	if lastFmURL == "" {
+		lastFmURL = scrobbler.DefaultLastFmURL
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"listenBrainzEnabled": listenBrainzToken != "",
+		"listenBrainzUrl":     listenBrainzURL,
+		"lastFmApiKey":        lastFmAPIKey,
+		"lastFmEnabled":       lastFmSessionKey != "",
+		"lastFmUrl":           lastFmURL,
+	})
+}
+
+func UpdateScrobbleSettingsHandler(c echo.Context) error {
+	var req ScrobbleSettingsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+
+	// Empty secrets keep the stored value; base URLs can be reset with ""
+	updates := map[string]string{}
+	if req.ListenBrainzToken != "" {
+		updates[db.ScrobbleListenBrainzTokenSetting] = req.ListenBrainzToken
+	}
+	if req.LastFmAPIKey != "" {
+		updates[db.ScrobbleLastFmAPIKeySetting] = req.LastFmAPIKey
+	}
+	if req.LastFmSecret != "" {
+		updates[db.ScrobbleLastFmSecretSetting] = req.LastFmSecret
+	}
+	if req.LastFmSessionKey != "" {
+		updates[db.ScrobbleLastFmSessionKeySetting] = req.LastFmSessionKey
+	}
+	if req.ListenBrainzURL != nil {
+		updates[db.ScrobbleListenBrainzURLSetting] = *req.ListenBrainzURL
+	}
+	if req.LastFmURL != nil {
+		updates[db.ScrobbleLastFmURLSetting] = *req.LastFmURL
+	}
+
+	for key, value := range updates {
+		if err := db.SetSetting(key, value); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update scrobble settings")
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// DisconnectScrobblerHandler removes the credentials of a provider.
+func DisconnectScrobblerHandler(c echo.Context) error {
+	var keys []string
+	switch c.Param("provider") {
+	case "listenbrainz":
+		keys = []string{db.ScrobbleListenBrainzTokenSetting}
+	case "lastfm":
+		keys = []string{db.ScrobbleLastFmSessionKeySetting}
+	default:
+		return c.String(http.StatusNotFound, "Unknown provider")
+	}
+
+	for _, key := range keys {
+		if err := db.SetSetting(key, ""); err != nil {
+			return c.String(http.StatusInternalServerError, "Failed to update scrobble settings")
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "disconnected"})
+}
+
+// LastFmSessionHandler completes the Last.fm web auth flow by exchanging the
+// token the user authorized for a session key.
+func LastFmSessionHandler(c echo.Context) error {
+	var req LastFmSessionRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.String(http.StatusBadRequest, "Token is required")
+	}
+
+	apiKey, _ := db.GetSetting(db.ScrobbleLastFmAPIKeySetting)
+	secret, _ := db.GetSetting(db.ScrobbleLastFmSecretSetting)
+	baseURL, _ := db.GetSetting(db.ScrobbleLastFmURLSetting)
+	if apiKey == "" || secret == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Last.fm API key and secret must be set first"})
+	}
+
+	sessionKey, err := scrobbler.LastFmSession(c.Request().Context(), baseURL, apiKey, secret, req.Token)
+	if err != nil {
+		c.Logger().Errorf("Failed to get Last.fm session: %v", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to get Last.fm session"})
+	}
+
+	if err := db.SetSetting(db.ScrobbleLastFmSessionKeySetting, sessionKey); err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to update scrobble settings")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "connected"})
+}
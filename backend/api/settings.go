@@ -0,0 +1,49 @@
+package api
+
+import (
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/metadata"
+	"beatbump-server/backend/playlist"
+	"beatbump-server/backend/schedule"
+	"beatbump-server/backend/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SettingSchema describes a setting editable through POST /api/v1/settings.
+type SettingSchema struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Default     string   `json:"default"`
+	Options     []string `json:"options,omitempty"`
+}
+
+var settingsSchema = []SettingSchema{
+	{Key: "downloadPath", Type: "path", Description: "Directory where downloaded music is saved; downloads are disabled while empty"},
+	{Key: "ongoingListeningEnabled", Type: "boolean", Description: "Download songs automatically while listening", Default: "false", Options: []string{"true", "false"}},
+	{Key: "serverLibraryEnabled", Type: "boolean", Description: "Store favorites and playlists on the server", Default: "false", Options: []string{"true", "false"}},
+	{Key: "historyRetentionDays", Type: "integer", Description: "Delete play events older than this many days (0 keeps all)", Default: "0"},
+	{Key: "historyMaxEvents", Type: "integer", Description: "Keep at most this many play events per user (0 keeps all)", Default: "0"},
+	{Key: "outputProfile", Type: "string", Description: "Format of new downloads when ffmpeg is available, mp3-loudnorm normalizes the loudness to -14 LUFS", Default: utils.ProfileMP3, Options: utils.OutputProfiles},
+	{Key: "artworkMaxResolution", Type: "boolean", Description: "Fetch the largest variant of the artwork the image host serves", Default: "false", Options: []string{"true", "false"}},
+	{Key: "artworkSquareCrop", Type: "boolean", Description: "Crop 16:9 video thumbnails used as artwork to a centered square", Default: "false", Options: []string{"true", "false"}},
+	{Key: "folderCover", Type: "boolean", Description: "Write the artwork of completed downloads into their folder as cover.jpg", Default: "false", Options: []string{"true", "false"}},
+	{Key: "playlistFormats", Type: "list", Description: "Playlist files written into the folder of every task, comma separated", Default: playlist.FormatM3U8, Options: playlist.Formats},
+	{Key: "playlistPaths", Type: "string", Description: "Whether playlist files point at tracks with relative or absolute paths", Default: playlist.PathsRelative, Options: []string{playlist.PathsRelative, playlist.PathsAbsolute}},
+	{Key: "scheduleTimezone", Type: "string", Description: "IANA timezone of quiet hours, recurrences and start times, such as Europe/Berlin", Default: "Local"},
+	{Key: "quietHours", Type: "list", Description: `Windows in which downloads pause or slow down, comma separated, such as "23:00-07:00" or "mon-fri 09:00-17:00"; none disables them`, Default: schedule.Disabled},
+	{Key: "quietHoursMode", Type: "string", Description: "Whether no downloads start in quiet hours or only one at a time", Default: schedule.ModePause, Options: schedule.Modes},
+	{Key: "metadataProviders", Type: "list", Description: "Metadata providers asked in order, comma separated; none disables enrichment, metadata.providers applies until set", Options: append(config.KnownMetadataProviders, metadata.DisabledSetting)},
+}
+
+// SettingsSchemaHandler describes the runtime settings the UI can edit and
+// the read-only server configuration, so the settings page can be rendered
+// without hard-coding every option.
+func SettingsSchemaHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"settings": settingsSchema,
+		"config":   config.Describe(config.Get()),
+	})
+}
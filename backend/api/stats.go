@@ -1,12 +1,25 @@
 package api
 
 import (
-	"github.com/labstack/echo/v4"
+	"beatbump-server/backend/db"
 	"net/http"
 	"time"
+
+	"github.com/labstack/echo/v4"
 )
 
+var startedAt = time.Now()
+
 func StatsEndpointHandler(c echo.Context) error {
+	queue, err := db.CountSongTasksByStatus()
+	if err != nil {
+		c.Logger().Errorf("Failed to count song tasks: %v", err)
+		return c.String(http.StatusInternalServerError, "Failed to fetch stats")
+	}
 
-	return c.JSON(http.StatusOK, ([]byte)("version:"+time.Now().String()))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"startedAt":     startedAt,
+		"uptimeSeconds": int64(time.Since(startedAt).Seconds()),
+		"songTasks":     queue,
+	})
 }
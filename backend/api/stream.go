@@ -2,9 +2,10 @@ package api
 
 import (
 	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"errors"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
@@ -31,24 +32,21 @@ func StreamTrackHandler(c echo.Context) error {
 
 	// Get Download Path Setting
 	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	store := storage.Resolve(downloadPath)
+	ctx := c.Request().Context()
 
-	// Construct full path
-	fullPath := filepath.Join(downloadPath, songTask.FilePath)
-
-	// Open the file
-	file, err := os.Open(fullPath)
-	if err != nil {
-		return c.String(http.StatusNotFound, "File not found on disk")
-	}
-	defer file.Close()
-
-	// Get file info for ServeContent
-	fileInfo, err := file.Stat()
-	if err != nil {
+	object, err := store.Stat(ctx, storage.Key(songTask.FilePath))
+	if errors.Is(err, storage.ErrNotExist) {
+		return c.String(http.StatusNotFound, "File not found in storage")
+	} else if err != nil {
 		return c.String(http.StatusInternalServerError, "Could not get file info")
 	}
 
+	file := storage.NewReadSeeker(ctx, store, object)
+	defer file.Close()
+
 	// ServeContent handles Range requests automatically
-	http.ServeContent(c.Response(), c.Request(), fileInfo.Name(), fileInfo.ModTime(), file)
+	c.Response().Header().Set(echo.HeaderContentType, storage.ContentType(object.Key))
+	http.ServeContent(c.Response(), c.Request(), path.Base(object.Key), object.ModTime, file)
 	return nil
 }
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/tags"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type TrackTagsResponse struct {
+	*tags.Tags
+	HasCover bool `json:"hasCover"`
+}
+
+func newTrackTagsResponse(t *tags.Tags) TrackTagsResponse {
+	return TrackTagsResponse{Tags: t, HasCover: t.Cover != nil}
+}
+
+// GetTrackTagsHandler reads the tags embedded in the stored file of a
+// track.
+func GetTrackTagsHandler(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("taskId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid task ID"})
+	}
+	song, err := db.GetSongTask(taskID, c.Param("videoId"))
+	if err != nil {
+		return tagsError(c, err)
+	}
+	t, err := library.ReadTags(c.Request().Context(), song)
+	if err != nil {
+		return tagsError(c, err)
+	}
+	return c.JSON(http.StatusOK, newTrackTagsResponse(t))
+}
+
+// UpdateTrackTagsHandler rewrites the tags of the stored file of a track.
+// Fields missing from the body are kept.
+func UpdateTrackTagsHandler(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("taskId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid task ID"})
+	}
+	song, err := db.GetSongTask(taskID, c.Param("videoId"))
+	if err != nil {
+		return tagsError(c, err)
+	}
+	var edit library.TagEdit
+	if err := c.Bind(&edit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	t, err := library.EditTags(c.Request().Context(), song, &edit)
+	if err != nil {
+		return tagsError(c, err)
+	}
+	return c.JSON(http.StatusOK, newTrackTagsResponse(t))
+}
+
+// UpdateGroupTagsHandler applies the same edit to every completed track of
+// a task, such as the album or the cover of a whole playlist.
+func UpdateGroupTagsHandler(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("taskId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid task ID"})
+	}
+	if _, err := db.GetGroupTask(taskID); err != nil {
+		return tagsError(c, err)
+	}
+	var edit library.TagEdit
+	if err := c.Bind(&edit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if edit.PerTrack() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "title, track, lyrics and videoId can only be set per track"})
+	}
+
+	results, err := library.EditGroupTags(c.Request().Context(), taskID, &edit)
+	if err != nil {
+		return tagsError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"tracks": results})
+}
+
+// RefreshArtworkHandler fetches the artwork of every completed track of a
+// task again, with the current artwork options, and embeds it where it
+// changed.
+func RefreshArtworkHandler(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("taskId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid task ID"})
+	}
+	if _, err := db.GetGroupTask(taskID); err != nil {
+		return tagsError(c, err)
+	}
+
+	results, err := library.RefreshArtwork(c.Request().Context(), taskID)
+	if err != nil {
+		return tagsError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"tracks": results})
+}
+
+func tagsError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Task not found"})
+	case errors.Is(err, library.ErrNotStored), errors.Is(err, library.ErrSharedTrackNumber):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, library.ErrNoDownloadPath):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Download path must be set first"})
+	case errors.Is(err, tags.ErrUnsupported), errors.Is(err, library.ErrInvalidCover):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		c.Logger().Errorf("Tag edit failed: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Tag edit failed"})
+	}
+}
@@ -0,0 +1,120 @@
+// Package artwork fetches the cover art of downloaded tracks, in the largest
+// size the image host serves and cropped to a square when asked to.
+package artwork
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"regexp"
+)
+
+// Options change how artwork is fetched, see CurrentOptions.
+type Options struct {
+	// Fetch the largest variant the image host serves
+	MaxResolution bool
+	// Crop 16:9 video thumbnails to a centered square
+	SquareCrop bool
+}
+
+// CurrentOptions reads the artwork settings.
+func CurrentOptions() Options {
+	maxResolution, _ := db.GetSetting(db.ArtworkMaxResolutionSetting)
+	squareCrop, _ := db.GetSetting(db.ArtworkSquareCropSetting)
+	return Options{
+		MaxResolution: maxResolution == "true",
+		SquareCrop:    squareCrop == "true",
+	}
+}
+
+// Size suffixes of the image hosts used by YouTube Music and the metadata
+// providers, with the largest variant each of them serves
+var variants = []struct {
+	size    *regexp.Regexp
+	largest string
+}{
+	// lh3.googleusercontent.com/...=w544-h544-l90-rj
+	{regexp.MustCompile(`=w\d+-h\d+[^/=]*$`), "=w3000-h3000-l90-rj"},
+	// is1-ssl.mzstatic.com/.../600x600bb.jpg
+	{regexp.MustCompile(`/\d+x\d+bb\.jpg$`), "/3000x3000bb.jpg"},
+	// e-cdns-images.dzcdn.net/images/cover/.../1000x1000-000000-80-0-0.jpg
+	{regexp.MustCompile(`/\d+x\d+-000000-80-0-0\.jpg$`), "/1800x1800-000000-80-0-0.jpg"},
+	// i.ytimg.com/vi/<id>/hqdefault.jpg?sqp=...
+	{regexp.MustCompile(`/(default|mqdefault|hqdefault|sddefault)\.jpg(\?.*)?$`), "/maxresdefault.jpg"},
+}
+
+// LargestURL returns the URL of the largest variant of an image, url itself
+// for unknown hosts.
+func LargestURL(url string) string {
+	for _, v := range variants {
+		if v.size.MatchString(url) {
+			return v.size.ReplaceAllLiteralString(url, v.largest)
+		}
+	}
+	return url
+}
+
+// Fetch downloads the artwork at url to path. Without the largest variant,
+// which not every image has, the image at url is used.
+func Fetch(url, path string, opts Options) error {
+	err := os.ErrNotExist
+	if large := LargestURL(url); opts.MaxResolution && large != url {
+		err = utils.DownloadFile(large, path)
+	}
+	if err != nil {
+		if err := utils.DownloadFile(url, path); err != nil {
+			os.Remove(path)
+			return err
+		}
+	}
+	if !opts.SquareCrop {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if cropped, ok := CropSquare(data); ok {
+		return os.WriteFile(path, cropped, 0644)
+	}
+	return nil
+}
+
+// CropSquare crops a 16:9 image to its centered square, encoded as JPEG. It
+// reports false for other images and data it cannot decode.
+func CropSquare(data []byte) ([]byte, bool) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if height == 0 || math.Abs(float64(width)/float64(height)-16.0/9.0) > 0.02 {
+		return nil, false
+	}
+
+	left := bounds.Min.X + (width-height)/2
+	square := image.NewRGBA(image.Rect(0, 0, height, height))
+	draw.Draw(square, square.Bounds(), img, image.Pt(left, bounds.Min.Y), draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, square, &jpeg.Options{Quality: 95}); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// Hash identifies image data, it tells whether the embedded artwork of a
+// track changed.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
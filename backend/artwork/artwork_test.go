@@ -0,0 +1,94 @@
+package artwork
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLargestURL(t *testing.T) {
+	tests := map[string]string{
+		"https://lh3.googleusercontent.com/abc=w544-h544-l90-rj":                       "https://lh3.googleusercontent.com/abc=w3000-h3000-l90-rj",
+		"https://is1-ssl.mzstatic.com/image/thumb/a/b/600x600bb.jpg":                   "https://is1-ssl.mzstatic.com/image/thumb/a/b/3000x3000bb.jpg",
+		"https://e-cdns-images.dzcdn.net/images/cover/f00/1000x1000-000000-80-0-0.jpg": "https://e-cdns-images.dzcdn.net/images/cover/f00/1800x1800-000000-80-0-0.jpg",
+		"https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg?sqp=abc&rs=def":              "https://i.ytimg.com/vi/dQw4w9WgXcQ/maxresdefault.jpg",
+		"https://example.com/cover.jpg":                                                "https://example.com/cover.jpg",
+	}
+	for url, want := range tests {
+		assert.Equal(t, want, LargestURL(url), url)
+	}
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	// Black bars left and right of a white square
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if x >= (width-height)/2 && x < (width+height)/2 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestCropSquare(t *testing.T) {
+	cropped, ok := CropSquare(encodePNG(t, 160, 90))
+	require.True(t, ok)
+	img, err := jpeg.Decode(bytes.NewReader(cropped))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 90, 90), img.Bounds())
+	r, _, _, _ := img.At(2, 45).RGBA()
+	assert.Greater(t, r, uint32(0xf000), "the bars are cut off")
+
+	_, ok = CropSquare(encodePNG(t, 90, 90))
+	assert.False(t, ok)
+	_, ok = CropSquare([]byte("not an image"))
+	assert.False(t, ok)
+}
+
+func TestFetch(t *testing.T) {
+	wide := encodePNG(t, 160, 90)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vi/a/hqdefault.jpg":
+			w.Write(wide)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cover.jpg")
+
+	require.NoError(t, Fetch(server.URL+"/vi/a/hqdefault.jpg", path, Options{}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, wide, data)
+
+	// No maxresdefault for a, the thumbnail is cropped
+	require.NoError(t, Fetch(server.URL+"/vi/a/hqdefault.jpg", path, Options{MaxResolution: true, SquareCrop: true}))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 90, config.Width)
+
+	assert.Error(t, Fetch(server.URL+"/missing.jpg", path, Options{MaxResolution: true}))
+	assert.NoFileExists(t, path)
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.Server.Listen)
+	assert.Equal(t, "./build", cfg.Server.StaticRoot)
+	assert.Equal(t, 5*time.Second, cfg.Worker.PollInterval.Duration())
+	assert.Equal(t, []string{"itunes"}, cfg.Metadata.Providers)
+}
+
+func TestLoadYAMLFile(t *testing.T) {
+	path := writeFile(t, "beatbump.yaml", `
+server:
+  listen: "127.0.0.1:9090"
+database:
+  path: /data
+worker:
+  pollInterval: 10s
+  concurrency: 2
+metadata:
+  providers: []
+`)
+	cfg, err := Load([]string{"--config", path})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", cfg.Server.Listen)
+	assert.Equal(t, "/data", cfg.Database.Path)
+	assert.Equal(t, 10*time.Second, cfg.Worker.PollInterval.Duration())
+	assert.Equal(t, 2, cfg.Worker.Concurrency)
+	assert.Empty(t, cfg.Metadata.Providers)
+	// Untouched values keep their defaults
+	assert.Equal(t, "./build", cfg.Server.StaticRoot)
+}
+
+func TestLoadTOMLFile(t *testing.T) {
+	path := writeFile(t, "beatbump.toml", `
+[companion]
+url = "http://companion:8282"
+
+[worker]
+maxJitter = "0s"
+`)
+	cfg, err := Load([]string{"-config=" + path})
+	require.NoError(t, err)
+	assert.Equal(t, "http://companion:8282", cfg.Companion.URL)
+	assert.Equal(t, time.Duration(0), cfg.Worker.MaxJitter.Duration())
+}
+
+func TestPrecedenceFileEnvFlags(t *testing.T) {
+	path := writeFile(t, "beatbump.yaml", "server:\n  listen: \":7000\"\ndatabase:\n  path: /from-file\n")
+	t.Setenv(ConfigFileEnv, path)
+	t.Setenv("BEATBUMP_LISTEN_ADDR", ":7001")
+	t.Setenv("COMPANION_URL", "http://legacy:8282")
+	t.Setenv("COMPANION_SECRET_KEY", "secret")
+
+	cfg, err := Load([]string{"--listen", ":7002"})
+	require.NoError(t, err)
+	assert.Equal(t, ":7002", cfg.Server.Listen, "flags override env")
+	assert.Equal(t, "/from-file", cfg.Database.Path)
+	assert.Equal(t, "http://legacy:8282", cfg.Companion.URL, "legacy env names are still read")
+	assert.Equal(t, "secret", cfg.Companion.SecretKey)
+
+	t.Setenv("BEATBUMP_COMPANION_URL", "http://new:8282")
+	cfg, err = Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "http://new:8282", cfg.Companion.URL, "prefixed env names win over legacy ones")
+	assert.Equal(t, ":7001", cfg.Server.Listen, "env overrides the file")
+}
+
+func TestValidation(t *testing.T) {
+	_, err := Load([]string{"--listen", "8080", "--worker-concurrency", "0", "--metadata-providers", "itunes,spotify"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.listen")
+	assert.Contains(t, err.Error(), "worker.concurrency")
+	assert.Contains(t, err.Error(), `unknown provider "spotify"`)
+
+	cfg, err := Load([]string{"--metadata-providers", "deezer,musicbrainz,itunes"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deezer", "musicbrainz", "itunes"}, cfg.Metadata.Providers)
+	_, err = Load([]string{"--musicbrainz-url", "musicbrainz.org"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "metadata.musicbrainzUrl")
+
+	_, err = Load([]string{"--tls-cert", "/nonexistent.pem"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certFile and keyFile must be set together")
+
+	_, err = Load([]string{"--storage-backend", "s3", "--s3-endpoint", "minio:9000"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "endpoint and bucket are required")
+
+	_, err = Load([]string{"--storage-backend", "webdav", "--webdav-url", "cloud.example.com"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "storage.webdav.url")
+
+	_, err = Load([]string{"--storage-backend", "webdav", "--webdav-url", "https://cloud.example.com", "--storage-dedup", "hardlink"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hardlink needs the local backend")
+
+	_, err = Load([]string{"--worker-poll-interval", "soon"})
+	assert.Error(t, err)
+
+	_, err = Load([]string{"--config", writeFile(t, "beatbump.json", "{}")})
+	assert.Error(t, err)
+}
+
+func TestDescribeHidesSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Companion.SecretKey = "hunter2"
+	cfg.Server.Listen = ":9000"
+
+	byKey := map[string]FieldSchema{}
+	for _, f := range Describe(cfg) {
+		byKey[f.Key] = f
+	}
+
+	secret := byKey["companion.secretKey"]
+	assert.True(t, secret.Secret)
+	assert.True(t, secret.IsSet)
+	assert.Nil(t, secret.Value)
+
+	listen := byKey["server.listen"]
+	assert.Equal(t, ":9000", listen.Value)
+	assert.Equal(t, ":8080", listen.Default)
+	assert.Equal(t, "listen", listen.Flag)
+
+	assert.Equal(t, "duration", byKey["worker.pollInterval"].Type)
+	assert.Equal(t, "5s", byKey["worker.pollInterval"].Default)
+	assert.Equal(t, []string{"BEATBUMP_TLS_CERT"}, byKey["server.tls.certFile"].Env)
+}
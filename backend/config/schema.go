@@ -0,0 +1,192 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes strings such as "5s".
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// field is a leaf of Config addressed by its dotted key, e.g. "server.listen".
+type field struct {
+	Key         string
+	Env         []string
+	Flag        string
+	Description string
+	Secret      bool
+	value       reflect.Value
+}
+
+func fieldsOf(cfg *Config) []field {
+	var fields []field
+	collectFields(reflect.ValueOf(cfg).Elem(), "", &fields)
+	return fields
+}
+
+func collectFields(v reflect.Value, prefix string, fields *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			collectFields(v.Field(i), key, fields)
+			continue
+		}
+
+		f := field{
+			Key:         key,
+			Flag:        sf.Tag.Get("flag"),
+			Description: sf.Tag.Get("desc"),
+			Secret:      sf.Tag.Get("secret") == "true",
+			value:       v.Field(i),
+		}
+		if env := sf.Tag.Get("env"); env != "" {
+			f.Env = strings.Split(env, ",")
+		}
+		*fields = append(*fields, f)
+	}
+}
+
+func (f field) set(raw string) error {
+	v := f.value
+	if v.Type() == durationType {
+		var d Duration
+		if err := d.UnmarshalText([]byte(raw)); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+func (f field) typeName() string {
+	if f.value.Type() == durationType {
+		return "duration"
+	}
+	switch f.value.Kind() {
+	case reflect.Int:
+		return "integer"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice:
+		return "list"
+	default:
+		return "string"
+	}
+}
+
+func (f field) interfaceValue() interface{} {
+	if f.value.Type() == durationType {
+		return f.value.Interface().(Duration).String()
+	}
+	return f.value.Interface()
+}
+
+// FieldSchema describes a config option for the settings UI.
+type FieldSchema struct {
+	Key         string      `json:"key"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Default     interface{} `json:"default"`
+	Value       interface{} `json:"value,omitempty"`
+	Env         []string    `json:"env,omitempty"`
+	Flag        string      `json:"flag,omitempty"`
+	Secret      bool        `json:"secret,omitempty"`
+	IsSet       bool        `json:"isSet"`
+	Options     []string    `json:"options,omitempty"`
+}
+
+var fieldOptions = map[string][]string{
+	"database.driver":    KnownDatabaseDrivers,
+	"storage.backend":    KnownStorageBackends,
+	"storage.dedup":      KnownDedupModes,
+	"metadata.providers": KnownMetadataProviders,
+	"logging.format":     {"console", "json"},
+	"logging.level":      {"debug", "info", "warn", "error"},
+}
+
+// Describe lists every option with its default and the value in cfg.
+// Secret values are never returned, only whether they are set.
+func Describe(cfg *Config) []FieldSchema {
+	defaults := fieldsOf(Default())
+	fields := fieldsOf(cfg)
+
+	schema := make([]FieldSchema, 0, len(fields))
+	for i, f := range fields {
+		entry := FieldSchema{
+			Key:         f.Key,
+			Type:        f.typeName(),
+			Description: f.Description,
+			Default:     defaults[i].interfaceValue(),
+			Env:         f.Env,
+			Flag:        f.Flag,
+			Secret:      f.Secret,
+			IsSet:       !f.value.IsZero(),
+			Options:     fieldOptions[f.Key],
+		}
+		if !f.Secret {
+			entry.Value = f.interfaceValue()
+		}
+		schema = append(schema, entry)
+	}
+	return schema
+}
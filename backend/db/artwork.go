@@ -0,0 +1,25 @@
+package db
+
+// SetSongTaskArtwork records the source and hash of the artwork embedded in
+// the file of a track, for every task linked to the canonical file when
+// trackKey is set. Empty values mean the file has no artwork.
+func SetSongTaskArtwork(groupTaskID int, videoID, trackKey, url, hash string) error {
+	query := DB.Model(&SongTask{})
+	if trackKey == "" {
+		query = query.Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID)
+	} else {
+		query = query.Where("track_key = ?", trackKey)
+	}
+	return query.Updates(map[string]interface{}{
+		"artwork_url":  url,
+		"artwork_hash": hash,
+	}).Error
+}
+
+// SetSongTaskArtistID records the YouTube Music channel of the artist of a
+// track, artist.nfo is written from it.
+func SetSongTaskArtistID(groupTaskID int, videoID, artistID string) error {
+	return DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+		Update("artist_id", artistID).Error
+}
@@ -1,5 +1,11 @@
 package db
 
+// Database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
 // Task Statuses
 const (
 	TaskStatusPending    = "pending"
@@ -8,13 +14,18 @@ const (
 	TaskStatusFailed     = "failed"
 	TaskStatusNotStarted = "not_started"
 	TaskStatusPaused     = "paused"
+	// A completed track whose file disappeared from the library
+	TaskStatusMissing = "missing"
 )
 
 // Task Types
 const (
-	TaskTypePlaylistDownload = "playlist_download"
-	TaskTypeSongMixDownload  = "song_mix_download"
-	TaskTypeOngoingDownload  = "ongoing_download"
+	TaskTypePlaylistDownload        = "playlist_download"
+	TaskTypeSongMixDownload         = "song_mix_download"
+	TaskTypeOngoingDownload         = "ongoing_download"
+	TaskTypeLibraryPlaylistDownload = "library_playlist_download"
+	// Holds files found in the library that no download created
+	TaskTypeLibraryImport = "library_import"
 )
 
 // Task Sources
@@ -27,4 +38,32 @@ const (
 const (
 	OngoingListeningEnabledSetting = "ongoing_listening_enabled"
 	DownloadPathSetting            = "download_path"
+	ServerLibraryEnabledSetting    = "server_library_enabled"
+	HistoryRetentionDaysSetting    = "history_retention_days"
+	HistoryMaxEventsSetting        = "history_max_events"
+	// Comma separated metadata provider chain, overrides metadata.providers
+	MetadataProvidersSetting = "metadata_providers"
+	// One of utils.OutputProfiles, mp3 when unset
+	OutputProfileSetting = "output_profile"
+	// Artwork options, "true" or "false", see artwork.CurrentOptions
+	ArtworkMaxResolutionSetting = "artwork_max_resolution"
+	ArtworkSquareCropSetting    = "artwork_square_crop"
+	// Write a cover image into the folder of completed tasks
+	FolderCoverSetting = "folder_cover"
+	// Comma separated playlist formats written into task folders and
+	// whether their track paths are "relative" or "absolute"
+	PlaylistFormatsSetting = "playlist_formats"
+	PlaylistPathsSetting   = "playlist_paths"
+	// Schedule options, see the schedule package: an IANA timezone name,
+	// comma separated quiet hour windows and "pause" or "throttle"
+	ScheduleTimezoneSetting = "schedule_timezone"
+	QuietHoursSetting       = "quiet_hours"
+	QuietHoursModeSetting   = "quiet_hours_mode"
+
+	ScrobbleListenBrainzTokenSetting = "scrobble_listenbrainz_token"
+	ScrobbleListenBrainzURLSetting   = "scrobble_listenbrainz_url"
+	ScrobbleLastFmAPIKeySetting      = "scrobble_lastfm_api_key"
+	ScrobbleLastFmSecretSetting      = "scrobble_lastfm_secret"
+	ScrobbleLastFmSessionKeySetting  = "scrobble_lastfm_session_key"
+	ScrobbleLastFmURLSetting         = "scrobble_lastfm_url"
 )
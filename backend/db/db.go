@@ -1,12 +1,17 @@
 package db
 
 import (
-	"log"
-	"os"
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/logging"
+	"context"
+	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
@@ -16,15 +21,26 @@ import (
 
 var DB *gorm.DB
 
+// dbFile is the path of the open beatbump.db, used for backups. It is empty
+// for PostgreSQL.
+var dbFile string
+
+// models lists every table the migrations create, CheckSchema verifies them.
+var models = []interface{}{
+	&GroupTask{}, &SongTask{}, &Setting{},
+	&Favorite{}, &UserPlaylist{}, &PlaylistEntry{}, &PlayEvent{}, &ScrobbleQueueItem{},
+	&StoredTrack{}, &MetadataReview{},
+}
+
 type GroupTask struct {
-	ID               uint `gorm:"primaryKey"`
-	Type             string
-	ReferenceID      string `gorm:"uniqueIndex"`
-	Status           string
-	PlaylistName     string
-	CreatedAt        time.Time
-	UpdatedAt        time.Time
-	Source           string `gorm:"default:user"`
+	ID           uint `gorm:"primaryKey"`
+	Type         string
+	ReferenceID  string `gorm:"uniqueIndex"`
+	Status       string
+	PlaylistName string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Source       string `gorm:"default:user"`
 
 	// Ignored fields for GORM, used for UI
 	TotalTracks int `gorm:"-"`
@@ -33,6 +49,15 @@ type GroupTask struct {
 
 	// New field for song download limit
 	MaxTracks int `gorm:"default:0"`
+
+	// Lease of the worker populating the task, see ClaimPendingGroupTask
+	WorkerID       string
+	LeaseExpiresAt *time.Time `gorm:"index"`
+
+	// Schedule, see schedule.go
+	StartAfter *time.Time
+	Recurrence string
+	NextRunAt  *time.Time
 }
 
 type SongTask struct {
@@ -44,8 +69,32 @@ type SongTask struct {
 	Album        string
 	ThumbnailURL string
 	FilePath     string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	// SHA-256 of the stored file, lets the library scanner find moved files
+	FileHash string `gorm:"index"`
+	// Key of the shared StoredTrack the file links to, empty without dedup
+	TrackKey string `gorm:"index"`
+	// Plain lyrics, indexed for the library search
+	Lyrics string
+	// Provider and score of the metadata match, see metadata.Lookup. The
+	// provider is empty when the match was too weak to be applied.
+	MatchProvider string
+	MatchScore    float64
+	// Length of the stream and EBU R128 loudness of the stored file, nil
+	// when unknown, see SetSongTaskLoudness
+	DurationMs int
+	Loudness   *float64
+	Peak       float64
+	// Source and SHA-256 of the embedded artwork, see SetSongTaskArtwork
+	ArtworkURL  string
+	ArtworkHash string
+	// YouTube Music channel of the artist, empty when unknown
+	ArtistID  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Lease of the worker downloading the track, see ClaimPendingSongTask
+	WorkerID       string
+	LeaseExpiresAt *time.Time `gorm:"index"`
 }
 
 type Setting struct {
@@ -53,28 +102,102 @@ type Setting struct {
 	Value string
 }
 
-func InitDB() {
-	var err error
-	dbPath := os.Getenv("BEATBUMP_DB_PATH")
-	dsn := "file:"+filepath.Join(dbPath, "beatbump.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+// Open connects to the configured database without migrating it. SQLite
+// uses beatbump.db inside cfg.Path, PostgreSQL connects to cfg.DSN.
+func Open(cfg config.DatabaseConfig) error {
+	var dialector gorm.Dialector
+	file := ""
+	switch cfg.Driver {
+	case "", DriverSQLite:
+		file = filepath.Join(cfg.Path, "beatbump.db")
+		dialector = sqlite.Open("file:" + file + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	case DriverPostgres:
+		dialector = postgres.Open(cfg.DSN)
+	default:
+		return fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
 
-	DB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{
+	conn, err := gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
+		// Constraint errors read the same on every driver, as
+		// gorm.ErrDuplicatedKey
+		TranslateError: true,
 	})
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return err
+	}
+	DB, dbFile = conn, file
+	return nil
+}
+
+// InitDB connects to the configured database and applies pending
+// migrations, backing the database up first.
+func InitDB(cfg config.DatabaseConfig) {
+	if err := Open(cfg); err != nil {
+		logging.Log.Fatal().Err(err).Str("driver", cfg.Driver).Msg("Failed to connect to database")
+	}
+
+	applied, backup, err := MigrateWithBackup()
+	if backup != "" {
+		logging.Log.Info().Str("backup", backup).Msg("Backed up database before migrating")
+	}
+	for _, m := range applied {
+		logging.Log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Applied database migration")
+	}
+	if err != nil {
+		logging.Log.Fatal().Err(err).Msg("Failed to migrate database")
+	}
+}
+
+// Close closes the database, checkpointing the WAL.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.Close()
+}
 
-	// Auto Migrate
-	err = DB.AutoMigrate(&GroupTask{}, &SongTask{}, &Setting{})
+// Ping verifies the database connection is usable.
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database not initialized")
+	}
+	sqlDB, err := DB.DB()
 	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
+		return err
 	}
+	return sqlDB.PingContext(ctx)
+}
 
-	err = ResetStuckTasks()
+// CheckSchema reports pending migrations and tables that are missing from
+// the database.
+func CheckSchema() error {
+	pending, err := PendingMigrations()
 	if err != nil {
-		log.Printf("Failed to reset stuck tasks: %v", err)
+		return err
 	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, run beat-server migrate", len(pending))
+	}
+
+	var missing []string
+	for _, model := range models {
+		if !DB.Migrator().HasTable(model) {
+			stmt := &gorm.Statement{DB: DB}
+			if err := stmt.Parse(model); err != nil {
+				return err
+			}
+			missing = append(missing, stmt.Schema.Table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
 
 // Group Task Functions
@@ -111,17 +234,18 @@ func GetActiveSessionGroupTask(timeout time.Duration) (*GroupTask, error) {
 		First(&task).Error
 
 	if err != nil {
-		log.Printf("GetActiveSessionGroupTask: No recent session found (Error: %v)", err)
+		logging.Log.Debug().Err(err).Msg("GetActiveSessionGroupTask: No recent session found")
 		return nil, err
 	}
 
 	// Check if it's within the timeout window
 	if time.Since(task.UpdatedAt) > timeout {
-		log.Printf("GetActiveSessionGroupTask: Found task %d but it's too old (Updated: %v, Threshold: %v)", task.ID, task.UpdatedAt, timeout)
+		logging.Log.Debug().Uint("task_id", task.ID).Time("updated_at", task.UpdatedAt).Dur("threshold", timeout).
+			Msg("GetActiveSessionGroupTask: Found task but it's too old")
 		return nil, gorm.ErrRecordNotFound
 	}
 
-	log.Printf("GetActiveSessionGroupTask: Found active task %d (Updated: %v)", task.ID, task.UpdatedAt)
+	logging.Log.Debug().Uint("task_id", task.ID).Time("updated_at", task.UpdatedAt).Msg("GetActiveSessionGroupTask: Found active task")
 	return &task, nil
 }
 
@@ -181,10 +305,7 @@ func GetAllGroupTasks() ([]GroupTask, error) {
 }
 
 func UpdateGroupTaskStatus(id int, status string) error {
-	return DB.Model(&GroupTask{}).Where("id = ?", id).Updates(map[string]interface{}{
-		"status":     status,
-		"updated_at": time.Now(),
-	}).Error
+	return DB.Model(&GroupTask{}).Where("id = ?", id).Updates(statusUpdate(status)).Error
 }
 
 func RetryGroupTask(id int) error {
@@ -250,50 +371,86 @@ func GetSongTask(groupTaskID int, videoID string) (*SongTask, error) {
 
 func GetPendingSongTasks() ([]*SongTask, error) {
 	var tasks []*SongTask
+	err := pendingSongTasks(DB).Find(&tasks).Error
+	return tasks, err
+}
 
-	// Join with GroupTask to prioritize user tasks
-	// GORM Joins
-	err := DB.Table("song_tasks").
-		Select("song_tasks.*, group_tasks.source").
+// pendingSongTasks selects queued tracks of groups that are not paused, user
+// tasks first.
+func pendingSongTasks(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&SongTask{}).
+		Select("song_tasks.*").
 		Joins("JOIN group_tasks ON song_tasks.group_task_id = group_tasks.id").
 		Where("song_tasks.status = ?", TaskStatusNotStarted).
 		Where("group_tasks.status != ?", TaskStatusPaused).
-		Order("CASE WHEN group_tasks.source = '" + TaskSourceUser + "' THEN 0 ELSE 1 END").
-		Order("song_tasks.created_at ASC").
-		Scan(&tasks).Error
-
-	return tasks, err
+		Where(startedGroupTasks("group_tasks."), time.Now()).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "CASE WHEN group_tasks.source = ? THEN 0 ELSE 1 END, song_tasks.created_at ASC",
+			Vars:               []interface{}{TaskSourceUser},
+			WithoutParentheses: true,
+		}})
 }
 
 func UpdateSongTaskStatus(groupTaskID int, videoID, status string) error {
-	return DB.Model(&SongTask{}).
+	if err := DB.Model(&SongTask{}).
 		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
-		Updates(map[string]interface{}{
-			"status":     status,
-			"updated_at": time.Now(),
-		}).Error
+		Updates(statusUpdate(status)).Error; err != nil {
+		return err
+	}
+	indexSongTask(groupTaskID, videoID)
+	return nil
 }
 
-func MarkSongTaskCompleted(groupTaskID int, videoID, filePath string) error {
-	return DB.Model(&SongTask{}).
+func MarkSongTaskCompleted(groupTaskID int, videoID, filePath, fileHash string) error {
+	update := statusUpdate(TaskStatusCompleted)
+	update["file_path"] = filePath
+	update["file_hash"] = fileHash
+	if err := DB.Model(&SongTask{}).
 		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
-		Updates(map[string]interface{}{
-			"status":     TaskStatusCompleted,
-			"file_path":  filePath,
-			"updated_at": time.Now(),
-		}).Error
+		Updates(update).Error; err != nil {
+		return err
+	}
+	indexSongTask(groupTaskID, videoID)
+	return nil
+}
+
+// CountSongTasksByStatus returns the number of song tasks per status.
+func CountSongTasksByStatus() (map[string]int64, error) {
+	type StatusCount struct {
+		Status string
+		Total  int64
+	}
+
+	var rows []StatusCount
+	err := DB.Model(&SongTask{}).
+		Select("status, COUNT(*) as total").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		counts[r.Status] = r.Total
+	}
+	return counts, nil
 }
 
 func CheckGroupCompletion(groupTaskID int) (bool, error) {
+	return checkGroupCompletion(DB, groupTaskID)
+}
+
+func checkGroupCompletion(tx *gorm.DB, groupTaskID int) (bool, error) {
 	var total int64
 	var completed int64
 
-	err := DB.Model(&SongTask{}).Where("group_task_id = ?", groupTaskID).Count(&total).Error
+	err := tx.Model(&SongTask{}).Where("group_task_id = ?", groupTaskID).Count(&total).Error
 	if err != nil {
 		return false, err
 	}
 
-	err = DB.Model(&SongTask{}).Where("group_task_id = ? AND status = ?", groupTaskID, TaskStatusCompleted).Count(&completed).Error
+	err = tx.Model(&SongTask{}).Where("group_task_id = ? AND status = ?", groupTaskID, TaskStatusCompleted).Count(&completed).Error
 	if err != nil {
 		return false, err
 	}
@@ -303,12 +460,6 @@ func CheckGroupCompletion(groupTaskID int) (bool, error) {
 
 // Helpers
 
-func ResetStuckTasks() error {
-	return DB.Model(&GroupTask{}).
-		Where("status = ?", TaskStatusProcessing).
-		Update("status", TaskStatusPending).Error
-}
-
 func GetSetting(key string) (string, error) {
 	var setting Setting
 	err := DB.First(&setting, "key = ?", key).Error
@@ -320,21 +471,39 @@ func SetSetting(key, value string) error {
 	return DB.Save(&setting).Error // Save handles Insert or Update (Upsert)
 }
 func DeleteGroupTask(id int) error {
-	return DB.Transaction(func(tx *gorm.DB) error {
-		// Delete all song tasks associated with this group task
-		if err := tx.Where("group_task_id = ?", id).Delete(&SongTask{}).Error; err != nil {
-			return err
-		}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return deleteGroupTask(tx, id)
+	})
+	if err != nil {
+		return err
+	}
+	unindexGroupTask(id)
+	return nil
+}
 
-		// Delete the group task itself
-		if err := tx.Delete(&GroupTask{}, id).Error; err != nil {
-			return err
-		}
+func deleteGroupTask(tx *gorm.DB, id int) error {
+	// Delete all song tasks associated with this group task
+	if err := tx.Where("group_task_id = ?", id).Delete(&SongTask{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("group_task_id = ?", id).Delete(&MetadataReview{}).Error; err != nil {
+		return err
+	}
 
-		return nil
-	})
+	// Delete the group task itself
+	return tx.Delete(&GroupTask{}, id).Error
 }
 
 func DeleteSongTask(groupTaskID int, videoID string) error {
-	return DB.Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).Delete(&SongTask{}).Error
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).Delete(&SongTask{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).Delete(&MetadataReview{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	unindexSongTask(groupTaskID, videoID)
+	return nil
 }
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestCheckSchema(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, CheckSchema())
+
+	require.NoError(t, DB.Migrator().DropTable(&PlayEvent{}))
+	err := CheckSchema()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "play_events")
+}
+
+func TestDuplicateGroupTask(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+	err := AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1)
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+}
+
+func TestClaimPendingSongTask(t *testing.T) {
+	setupTestDB(t)
+
+	require.NoError(t, AddGroupTask(TaskTypeOngoingDownload, "ongoing", "Ongoing", TaskSourceSystem, -1))
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL2", "Paused", TaskSourceUser, -1))
+	system, _ := GetGroupTaskByReferenceID("ongoing")
+	user, _ := GetGroupTaskByReferenceID("PL1")
+	paused, _ := GetGroupTaskByReferenceID("PL2")
+	require.NoError(t, UpdateGroupTaskStatus(int(paused.ID), TaskStatusPaused))
+
+	require.NoError(t, AddSongTask(int(system.ID), "sys", "System", "", "", ""))
+	require.NoError(t, AddSongTask(int(paused.ID), "paused", "Paused", "", "", ""))
+	require.NoError(t, AddSongTask(int(user.ID), "user", "User", "", "", ""))
+
+	var claimed []string
+	for {
+		task, err := ClaimPendingSongTask("worker-1", time.Minute)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, TaskStatusProcessing, task.Status)
+		assert.Equal(t, "worker-1", task.WorkerID)
+		claimed = append(claimed, task.VideoID)
+	}
+	assert.Equal(t, []string{"user", "sys"}, claimed)
+
+	song, err := GetSongTask(int(user.ID), "user")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusProcessing, song.Status)
+}
+
+func TestClaimPendingGroupTask(t *testing.T) {
+	setupTestDB(t)
+
+	require.NoError(t, AddGroupTask(TaskTypeOngoingDownload, "ongoing", "Ongoing", TaskSourceSystem, -1))
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+
+	task, err := ClaimPendingGroupTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "PL1", task.ReferenceID)
+	assert.Equal(t, TaskStatusProcessing, task.Status)
+
+	_, err = ClaimPendingGroupTask("worker-1", time.Minute)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
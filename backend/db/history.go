@@ -0,0 +1,261 @@
+package db
+
+import (
+	"beatbump-server/backend/logging"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PlayEvent struct {
+	ID              uint   `gorm:"primaryKey"`
+	UserID          string `gorm:"index;default:default"`
+	VideoID         string `gorm:"index"`
+	Title           string
+	Artist          string
+	Album           string
+	PlaylistID      string
+	DurationSeconds int
+	PlayedAt        time.Time `gorm:"index"`
+}
+
+// PlayCount is an aggregate row for the top artists/tracks statistics.
+type PlayCount struct {
+	VideoID          string `json:"videoId,omitempty"`
+	Title            string `json:"title,omitempty"`
+	Artist           string `json:"artist"`
+	Plays            int    `json:"plays"`
+	ListeningSeconds int    `json:"listeningSeconds"`
+}
+
+type ListeningStats struct {
+	Period           string      `json:"period"`
+	Since            *time.Time  `json:"since,omitempty"`
+	TotalPlays       int         `json:"totalPlays"`
+	ListeningSeconds int         `json:"listeningSeconds"`
+	TopArtists       []PlayCount `json:"topArtists"`
+	TopTracks        []PlayCount `json:"topTracks"`
+	CurrentStreak    int         `json:"currentStreak"`
+	LongestStreak    int         `json:"longestStreak"`
+}
+
+// RecordPlayEvent stores a play and applies the configured retention limits.
+func RecordPlayEvent(event *PlayEvent) error {
+	if event.PlayedAt.IsZero() {
+		event.PlayedAt = time.Now()
+	}
+	if event.UserID == "" {
+		event.UserID = DefaultUserID
+	}
+	if err := DB.Create(event).Error; err != nil {
+		return err
+	}
+
+	if err := PrunePlayEvents(); err != nil {
+		logging.Log.Error().Err(err).Msg("Failed to prune play history")
+	}
+	return nil
+}
+
+// PrunePlayEvents removes events older than the retention window and keeps
+// at most the configured number of events per user. A zero setting disables
+// the limit.
+func PrunePlayEvents() error {
+	if days := getIntSetting(HistoryRetentionDaysSetting); days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		if err := DB.Where("played_at < ?", cutoff).Delete(&PlayEvent{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if maxEvents := getIntSetting(HistoryMaxEventsSetting); maxEvents > 0 {
+		var users []string
+		if err := DB.Model(&PlayEvent{}).Distinct("user_id").Pluck("user_id", &users).Error; err != nil {
+			return err
+		}
+		for _, userID := range users {
+			if err := prunePlayEventsOfUser(userID, maxEvents); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prunePlayEventsOfUser keeps the newest maxEvents events of a user.
+func prunePlayEventsOfUser(userID string, maxEvents int) error {
+	var cutoff PlayEvent
+	err := DB.Where("user_id = ?", userID).
+		Order("played_at DESC").Order("id DESC").Offset(maxEvents).Limit(1).Find(&cutoff).Error
+	if err != nil || cutoff.ID == 0 {
+		return err
+	}
+	return DB.Where("user_id = ?", userID).
+		Where("played_at < ? OR (played_at = ? AND id <= ?)", cutoff.PlayedAt, cutoff.PlayedAt, cutoff.ID).
+		Delete(&PlayEvent{}).Error
+}
+
+// GetPlayEvents returns the user's plays, newest first, within [since, until).
+// Zero times leave the range open.
+func GetPlayEvents(userID string, since, until time.Time, limit, offset int) ([]PlayEvent, int64, error) {
+	query := DB.Model(&PlayEvent{}).Where("user_id = ?", userID)
+	if !since.IsZero() {
+		query = query.Where("played_at >= ?", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("played_at < ?", until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []PlayEvent
+	query = query.Order("played_at DESC").Order("id DESC").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&events).Error
+	return events, total, err
+}
+
+func ClearPlayEvents(userID string) error {
+	return DB.Where("user_id = ?", userID).Delete(&PlayEvent{}).Error
+}
+
+// PeriodStart resolves a stats period name to the start of its window.
+// "all" and unknown periods return the zero time.
+func PeriodStart(period string, now time.Time) time.Time {
+	switch period {
+	case "day":
+		return now.AddDate(0, 0, -1)
+	case "week":
+		return now.AddDate(0, 0, -7)
+	case "month":
+		return now.AddDate(0, -1, 0)
+	case "year":
+		return now.AddDate(-1, 0, 0)
+	}
+	return time.Time{}
+}
+
+func GetListeningStats(userID, period string, limit int) (*ListeningStats, error) {
+	now := time.Now()
+	since := PeriodStart(period, now)
+	stats := &ListeningStats{Period: period}
+	if !since.IsZero() {
+		stats.Since = &since
+	}
+
+	base := func() *gorm.DB {
+		q := DB.Model(&PlayEvent{}).Where("user_id = ?", userID)
+		if !since.IsZero() {
+			q = q.Where("played_at >= ?", since)
+		}
+		return q
+	}
+
+	var totals struct {
+		Plays   int
+		Seconds int
+	}
+	if err := base().Select("COUNT(*) as plays, COALESCE(SUM(duration_seconds), 0) as seconds").Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	stats.TotalPlays = totals.Plays
+	stats.ListeningSeconds = totals.Seconds
+
+	if err := base().
+		Select("artist, COUNT(*) as plays, COALESCE(SUM(duration_seconds), 0) as listening_seconds").
+		Where("artist <> ''").
+		Group("artist").
+		Order("plays DESC").Order("artist ASC").
+		Limit(limit).
+		Scan(&stats.TopArtists).Error; err != nil {
+		return nil, err
+	}
+
+	if err := base().
+		Select("video_id, MAX(title) as title, MAX(artist) as artist, COUNT(*) as plays, COALESCE(SUM(duration_seconds), 0) as listening_seconds").
+		Group("video_id").
+		Order("plays DESC").Order("video_id ASC").
+		Limit(limit).
+		Scan(&stats.TopTracks).Error; err != nil {
+		return nil, err
+	}
+
+	// Streaks span the whole history regardless of the period
+	var playedAt []time.Time
+	if err := DB.Model(&PlayEvent{}).Where("user_id = ?", userID).Pluck("played_at", &playedAt).Error; err != nil {
+		return nil, err
+	}
+	stats.CurrentStreak, stats.LongestStreak = computeStreaks(playedAt, now)
+
+	if stats.TopArtists == nil {
+		stats.TopArtists = []PlayCount{}
+	}
+	if stats.TopTracks == nil {
+		stats.TopTracks = []PlayCount{}
+	}
+	return stats, nil
+}
+
+// computeStreaks returns the number of consecutive days with at least one play
+// ending today (or yesterday, so a streak isn't lost before today's first play)
+// and the longest such run overall. Days are counted in now's location.
+func computeStreaks(playedAt []time.Time, now time.Time) (int, int) {
+	if len(playedAt) == 0 {
+		return 0, 0
+	}
+
+	loc := now.Location()
+	dayOf := func(t time.Time) time.Time {
+		y, m, d := t.In(loc).Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+
+	seen := make(map[time.Time]bool)
+	var days []time.Time
+	for _, t := range playedAt {
+		day := dayOf(t)
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	longest, run := 1, 1
+	for i := 1; i < len(days); i++ {
+		if days[i-1].AddDate(0, 0, 1).Equal(days[i]) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+
+	today := dayOf(now)
+	last := days[len(days)-1]
+	if !last.Equal(today) && !last.AddDate(0, 0, 1).Equal(today) {
+		return 0, longest
+	}
+	return run, longest
+}
+
+func getIntSetting(key string) int {
+	value, err := GetSetting(key)
+	if err != nil || value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return n
+}
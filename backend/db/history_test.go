@@ -0,0 +1,90 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeStreaks(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	day := func(offset int) time.Time { return now.AddDate(0, 0, -offset) }
+
+	current, longest := computeStreaks(nil, now)
+	assert.Equal(t, 0, current)
+	assert.Equal(t, 0, longest)
+
+	// Five days in a row ending a week ago, then three days ending yesterday
+	plays := []time.Time{day(1), day(2), day(3), day(7), day(8), day(9), day(10), day(11), day(1)}
+	current, longest = computeStreaks(plays, now)
+	assert.Equal(t, 3, current)
+	assert.Equal(t, 5, longest)
+
+	// A gap before yesterday breaks the current streak
+	current, longest = computeStreaks([]time.Time{day(2), day(3)}, now)
+	assert.Equal(t, 0, current)
+	assert.Equal(t, 2, longest)
+}
+
+func TestListeningStatsAndRetention(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	events := []PlayEvent{
+		{VideoID: "a", Title: "A", Artist: "X", DurationSeconds: 100, PlayedAt: now.Add(-time.Hour)},
+		{VideoID: "a", Title: "A", Artist: "X", DurationSeconds: 100, PlayedAt: now.Add(-2 * time.Hour)},
+		{VideoID: "b", Title: "B", Artist: "Y", DurationSeconds: 200, PlayedAt: now.Add(-3 * time.Hour)},
+		{VideoID: "c", Title: "C", Artist: "Z", DurationSeconds: 300, PlayedAt: now.AddDate(0, 0, -40)},
+	}
+	for i := range events {
+		require.NoError(t, RecordPlayEvent(&events[i]))
+	}
+
+	stats, err := GetListeningStats(DefaultUserID, "week", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.TotalPlays)
+	assert.Equal(t, 400, stats.ListeningSeconds)
+	require.NotEmpty(t, stats.TopArtists)
+	assert.Equal(t, "X", stats.TopArtists[0].Artist)
+	assert.Equal(t, 2, stats.TopArtists[0].Plays)
+	require.NotEmpty(t, stats.TopTracks)
+	assert.Equal(t, "a", stats.TopTracks[0].VideoID)
+
+	all, err := GetListeningStats(DefaultUserID, "all", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, all.TotalPlays)
+
+	require.NoError(t, SetSetting(HistoryRetentionDaysSetting, "30"))
+	require.NoError(t, SetSetting(HistoryMaxEventsSetting, "2"))
+	require.NoError(t, PrunePlayEvents())
+
+	remaining, total, err := GetPlayEvents(DefaultUserID, time.Time{}, time.Time{}, 0, 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, "a", remaining[0].VideoID)
+	assert.Equal(t, "a", remaining[1].VideoID)
+}
+
+func TestMaxEventsIsPerUser(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, SetSetting(HistoryMaxEventsSetting, "2"))
+
+	now := time.Now()
+	// A light listener first, then a heavy one
+	for i := 0; i < 2; i++ {
+		require.NoError(t, RecordPlayEvent(&PlayEvent{UserID: "light", VideoID: "l", PlayedAt: now.Add(-time.Duration(10+i) * time.Hour)}))
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, RecordPlayEvent(&PlayEvent{UserID: "heavy", VideoID: "h", PlayedAt: now.Add(-time.Duration(i) * time.Minute)}))
+	}
+
+	_, light, err := GetPlayEvents("light", time.Time{}, time.Time{}, 0, 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, light)
+	heavy, total, err := GetPlayEvents("heavy", time.Time{}, time.Time{}, 0, 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.WithinDuration(t, now, heavy[0].PlayedAt, time.Second)
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A worker claims a task by moving it to processing together with its worker
+// ID and a lease expiry. It renews the lease while working on the task;
+// tasks whose lease ran out, e.g. after a crash, are queued again by
+// RequeueExpiredLeases.
+
+// ErrLeaseLost is returned when renewing a lease that expired and was taken
+// over by another worker, or released because the task was deleted or reset.
+var ErrLeaseLost = errors.New("task lease lost")
+
+// statusUpdate sets a new task status. Leaving processing ends the lease.
+func statusUpdate(status string) map[string]interface{} {
+	update := map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now(),
+	}
+	if status != TaskStatusProcessing {
+		update["worker_id"] = ""
+		update["lease_expires_at"] = nil
+	}
+	return update
+}
+
+func skipLocked(tx *gorm.DB, table string) *gorm.DB {
+	if tx.Dialector.Name() != DriverPostgres {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{
+		Strength: clause.LockingStrengthUpdate,
+		Table:    clause.Table{Name: table},
+		Options:  clause.LockingOptionsSkipLocked,
+	})
+}
+
+// ClaimPendingGroupTask leases the oldest pending user group task to
+// workerID and returns it, see ClaimPendingSongTask.
+func ClaimPendingGroupTask(workerID string, lease time.Duration) (*GroupTask, error) {
+	var task GroupTask
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("status = ? AND source = ?", TaskStatusPending, TaskSourceUser).
+			Where(startedGroupTasks(""), time.Now()).
+			Order("created_at ASC")
+		if err := skipLocked(query, "group_tasks").First(&task).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		expires := now.Add(lease)
+		result := tx.Model(&GroupTask{}).
+			Where("id = ? AND status = ?", task.ID, TaskStatusPending).
+			Updates(map[string]interface{}{
+				"status":           TaskStatusProcessing,
+				"worker_id":        workerID,
+				"lease_expires_at": expires,
+				"updated_at":       now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		task.Status, task.WorkerID, task.LeaseExpiresAt, task.UpdatedAt = TaskStatusProcessing, workerID, &expires, now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ClaimPendingSongTask leases the next queued track to workerID and returns
+// it, or gorm.ErrRecordNotFound when the queue is empty. Several instances
+// can claim concurrently: PostgreSQL skips rows locked by another claim and
+// the conditional update rejects a track that was claimed in between.
+func ClaimPendingSongTask(workerID string, lease time.Duration) (*SongTask, error) {
+	var task SongTask
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := skipLocked(pendingSongTasks(tx).Limit(1), "song_tasks").Take(&task).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		expires := now.Add(lease)
+		result := tx.Model(&SongTask{}).
+			Where("group_task_id = ? AND video_id = ? AND status = ?", task.GroupTaskID, task.VideoID, TaskStatusNotStarted).
+			Updates(map[string]interface{}{
+				"status":           TaskStatusProcessing,
+				"worker_id":        workerID,
+				"lease_expires_at": expires,
+				"updated_at":       now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		task.Status, task.WorkerID, task.LeaseExpiresAt, task.UpdatedAt = TaskStatusProcessing, workerID, &expires, now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// RenewGroupTaskLease extends the lease workerID holds on a group task.
+func RenewGroupTaskLease(id int, workerID string, lease time.Duration) error {
+	result := DB.Model(&GroupTask{}).
+		Where("id = ? AND status = ? AND worker_id = ?", id, TaskStatusProcessing, workerID).
+		Update("lease_expires_at", time.Now().Add(lease))
+	return leaseResult(result)
+}
+
+// RenewSongTaskLease extends the lease workerID holds on a track.
+func RenewSongTaskLease(groupTaskID int, videoID, workerID string, lease time.Duration) error {
+	result := DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ? AND status = ? AND worker_id = ?",
+			groupTaskID, videoID, TaskStatusProcessing, workerID).
+		Update("lease_expires_at", time.Now().Add(lease))
+	return leaseResult(result)
+}
+
+func leaseResult(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ReleaseGroupTaskLease ends the lease once the group is populated. The group
+// stays processing until all of its tracks are done.
+func ReleaseGroupTaskLease(id int, workerID string) error {
+	return DB.Model(&GroupTask{}).
+		Where("id = ? AND worker_id = ?", id, workerID).
+		Updates(map[string]interface{}{
+			"worker_id":        "",
+			"lease_expires_at": nil,
+		}).Error
+}
+
+// RequeueSongTask puts a track back into the queue if workerID still holds it.
+func RequeueSongTask(groupTaskID int, videoID, workerID string) error {
+	return DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ? AND status = ? AND worker_id = ?",
+			groupTaskID, videoID, TaskStatusProcessing, workerID).
+		Updates(statusUpdate(TaskStatusNotStarted)).Error
+}
+
+// RequeueExpiredLeases queues tasks again whose worker stopped renewing its
+// lease and returns how many group and song tasks were requeued. Tracks in
+// processing without a lease predate leases and are requeued as well.
+func RequeueExpiredLeases(now time.Time) (groups int64, songs int64, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&GroupTask{}).
+			Where("status = ? AND lease_expires_at < ?", TaskStatusProcessing, now).
+			Updates(statusUpdate(TaskStatusPending))
+		if result.Error != nil {
+			return result.Error
+		}
+		groups = result.RowsAffected
+
+		result = tx.Model(&SongTask{}).
+			Where("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", TaskStatusProcessing, now).
+			Updates(statusUpdate(TaskStatusNotStarted))
+		songs = result.RowsAffected
+		return result.Error
+	})
+	return groups, songs, err
+}
+
+// ReleaseWorkerTasks queues again every task held by workerID. A restarted
+// instance calls it to recover its tasks without waiting for the leases.
+func ReleaseWorkerTasks(workerID string) (groups int64, songs int64, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&GroupTask{}).
+			Where("status = ? AND worker_id = ?", TaskStatusProcessing, workerID).
+			Updates(statusUpdate(TaskStatusPending))
+		if result.Error != nil {
+			return result.Error
+		}
+		groups = result.RowsAffected
+
+		result = tx.Model(&SongTask{}).
+			Where("status = ? AND worker_id = ?", TaskStatusProcessing, workerID).
+			Updates(statusUpdate(TaskStatusNotStarted))
+		songs = result.RowsAffected
+		return result.Error
+	})
+	return groups, songs, err
+}
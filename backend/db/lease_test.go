@@ -0,0 +1,117 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addLeaseTestTasks(t *testing.T) *GroupTask {
+	t.Helper()
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+	group, err := GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, AddSongTask(int(group.ID), id, id, "Artist", "", ""))
+	}
+	return group
+}
+
+func TestLeaseRenewAndExpiry(t *testing.T) {
+	setupTestDB(t)
+	group := addLeaseTestTasks(t)
+
+	song, err := ClaimPendingSongTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, RenewSongTaskLease(int(group.ID), song.VideoID, "worker-1", time.Minute))
+	assert.ErrorIs(t, RenewSongTaskLease(int(group.ID), song.VideoID, "worker-2", time.Minute), ErrLeaseLost)
+
+	// Nothing has expired yet
+	_, songs, err := RequeueExpiredLeases(time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, songs)
+
+	_, songs, err = RequeueExpiredLeases(time.Now().Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, songs)
+
+	requeued, err := GetSongTask(int(group.ID), song.VideoID)
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusNotStarted, requeued.Status)
+	assert.Empty(t, requeued.WorkerID)
+	assert.Nil(t, requeued.LeaseExpiresAt)
+
+	// The original worker notices on its next heartbeat
+	assert.ErrorIs(t, RenewSongTaskLease(int(group.ID), song.VideoID, "worker-1", time.Minute), ErrLeaseLost)
+
+	// and must not requeue a track another worker claimed meanwhile
+	_, err = ClaimPendingSongTask("worker-2", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, RequeueSongTask(int(group.ID), song.VideoID, "worker-1"))
+	claimed, err := GetSongTask(int(group.ID), song.VideoID)
+	require.NoError(t, err)
+	assert.Equal(t, "worker-2", claimed.WorkerID)
+}
+
+func TestCompletionEndsLease(t *testing.T) {
+	setupTestDB(t)
+	group := addLeaseTestTasks(t)
+
+	song, err := ClaimPendingSongTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, MarkSongTaskCompleted(int(group.ID), song.VideoID, "Playlist/a.mp3", ""))
+
+	completed, err := GetSongTask(int(group.ID), song.VideoID)
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusCompleted, completed.Status)
+	assert.Empty(t, completed.WorkerID)
+	assert.Nil(t, completed.LeaseExpiresAt)
+
+	_, songs, err := RequeueExpiredLeases(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, songs)
+}
+
+func TestReleaseWorkerTasks(t *testing.T) {
+	setupTestDB(t)
+	group := addLeaseTestTasks(t)
+
+	claimedGroup, err := ClaimPendingGroupTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, group.ID, claimedGroup.ID)
+	_, err = ClaimPendingSongTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	other, err := ClaimPendingSongTask("worker-2", time.Minute)
+	require.NoError(t, err)
+
+	groups, songs, err := ReleaseWorkerTasks("worker-1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, groups)
+	assert.EqualValues(t, 1, songs)
+
+	group, err = GetGroupTask(int(group.ID))
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusPending, group.Status)
+	stillClaimed, err := GetSongTask(int(group.ID), other.VideoID)
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusProcessing, stillClaimed.Status)
+}
+
+func TestPopulatedGroupIsNotRequeued(t *testing.T) {
+	setupTestDB(t)
+	group := addLeaseTestTasks(t)
+
+	_, err := ClaimPendingGroupTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, ReleaseGroupTaskLease(int(group.ID), "worker-1"))
+
+	groups, _, err := RequeueExpiredLeases(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, groups)
+
+	group, err = GetGroupTask(int(group.ID))
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusProcessing, group.Status)
+}
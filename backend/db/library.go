@@ -0,0 +1,483 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultUserID is used when the client does not identify a user.
+const DefaultUserID = "default"
+
+type Favorite struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       string `gorm:"uniqueIndex:idx_favorites_user_video;default:default"`
+	VideoID      string `gorm:"uniqueIndex:idx_favorites_user_video"`
+	Title        string
+	Artist       string
+	Album        string
+	ThumbnailURL string
+	Position     int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type UserPlaylist struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       string `gorm:"index;default:default"`
+	Name         string
+	Description  string
+	ThumbnailURL string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	// Ignored fields for GORM, used for UI
+	Entries    []PlaylistEntry `gorm:"-"`
+	TrackCount int             `gorm:"-"`
+}
+
+type PlaylistEntry struct {
+	ID           uint `gorm:"primaryKey"`
+	PlaylistID   uint `gorm:"index"`
+	VideoID      string
+	Title        string
+	Artist       string
+	Album        string
+	ThumbnailURL string
+	Position     int
+	CreatedAt    time.Time
+}
+
+// LibraryTrack is the portable representation of a track used by import/export.
+type LibraryTrack struct {
+	VideoID      string `json:"videoId"`
+	Title        string `json:"title"`
+	Artist       string `json:"artist,omitempty"`
+	Album        string `json:"album,omitempty"`
+	ThumbnailURL string `json:"thumbnail,omitempty"`
+}
+
+type LibraryPlaylistExport struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Thumbnail   string         `json:"thumbnail,omitempty"`
+	Items       []LibraryTrack `json:"items"`
+}
+
+type LibraryExport struct {
+	Version    int                     `json:"version"`
+	ExportedAt time.Time               `json:"exportedAt"`
+	Favorites  []LibraryTrack          `json:"favorites"`
+	Playlists  []LibraryPlaylistExport `json:"playlists"`
+}
+
+const LibraryExportVersion = 1
+
+var ErrPlaylistNotFound = errors.New("playlist not found")
+
+var ErrPlaylistEntryNotFound = errors.New("playlist entry not found")
+
+// Favorites
+
+func GetFavorites(userID string) ([]Favorite, error) {
+	var favorites []Favorite
+	err := DB.Where("user_id = ?", userID).
+		Order("position ASC").
+		Order("created_at ASC").
+		Find(&favorites).Error
+	return favorites, err
+}
+
+func AddFavorite(userID string, track LibraryTrack) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return addFavorite(tx, userID, track)
+	})
+}
+
+func addFavorite(tx *gorm.DB, userID string, track LibraryTrack) error {
+	var maxPosition int
+	if err := tx.Model(&Favorite{}).
+		Where("user_id = ?", userID).
+		Select("COALESCE(MAX(position), -1)").
+		Scan(&maxPosition).Error; err != nil {
+		return err
+	}
+
+	favorite := Favorite{
+		UserID:       userID,
+		VideoID:      track.VideoID,
+		Title:        track.Title,
+		Artist:       track.Artist,
+		Album:        track.Album,
+		ThumbnailURL: track.ThumbnailURL,
+		Position:     maxPosition + 1,
+	}
+
+	// Favoriting an existing track is a no-op
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&favorite).Error
+}
+
+func DeleteFavorite(userID, videoID string) error {
+	return DB.Where("user_id = ? AND video_id = ?", userID, videoID).Delete(&Favorite{}).Error
+}
+
+// ReorderFavorites assigns positions following the order of videoIDs.
+// Favorites not listed keep their relative order after the listed ones.
+func ReorderFavorites(userID string, videoIDs []string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var favorites []Favorite
+		if err := tx.Where("user_id = ?", userID).Order("position ASC").Find(&favorites).Error; err != nil {
+			return err
+		}
+
+		ordered := orderByKeys(favorites, videoIDs, func(f Favorite) string { return f.VideoID })
+		for i, f := range ordered {
+			if err := tx.Model(&Favorite{}).Where("id = ?", f.ID).Update("position", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Playlists
+
+func GetUserPlaylists(userID string) ([]UserPlaylist, error) {
+	var playlists []UserPlaylist
+	if err := DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&playlists).Error; err != nil {
+		return nil, err
+	}
+
+	type EntryCounts struct {
+		PlaylistID uint
+		Total      int
+	}
+
+	var counts []EntryCounts
+	err := DB.Model(&PlaylistEntry{}).
+		Select("playlist_id, COUNT(*) as total").
+		Group("playlist_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	countsMap := make(map[uint]int)
+	for _, c := range counts {
+		countsMap[c.PlaylistID] = c.Total
+	}
+	for i := range playlists {
+		playlists[i].TrackCount = countsMap[playlists[i].ID]
+	}
+
+	return playlists, nil
+}
+
+// GetUserPlaylist returns the playlist with its entries in order.
+func GetUserPlaylist(userID string, id int) (*UserPlaylist, error) {
+	var playlist UserPlaylist
+	err := DB.Where("id = ? AND user_id = ?", id, userID).First(&playlist).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := GetPlaylistEntries(id)
+	if err != nil {
+		return nil, err
+	}
+	playlist.Entries = entries
+	playlist.TrackCount = len(entries)
+	return &playlist, nil
+}
+
+func GetPlaylistEntries(playlistID int) ([]PlaylistEntry, error) {
+	var entries []PlaylistEntry
+	err := DB.Where("playlist_id = ?", playlistID).
+		Order("position ASC").
+		Order("id ASC").
+		Find(&entries).Error
+	return entries, err
+}
+
+func CreateUserPlaylist(userID, name, description, thumbnailURL string) (*UserPlaylist, error) {
+	playlist := UserPlaylist{
+		UserID:       userID,
+		Name:         name,
+		Description:  description,
+		ThumbnailURL: thumbnailURL,
+	}
+	err := DB.Create(&playlist).Error
+	return &playlist, err
+}
+
+func UpdateUserPlaylist(userID string, id int, name, description, thumbnailURL string) error {
+	updates := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+	if name != "" {
+		updates["name"] = name
+	}
+	if description != "" {
+		updates["description"] = description
+	}
+	if thumbnailURL != "" {
+		updates["thumbnail_url"] = thumbnailURL
+	}
+
+	result := DB.Model(&UserPlaylist{}).Where("id = ? AND user_id = ?", id, userID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPlaylistNotFound
+	}
+	return nil
+}
+
+func DeleteUserPlaylist(userID string, id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&UserPlaylist{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPlaylistNotFound
+		}
+		return tx.Where("playlist_id = ?", id).Delete(&PlaylistEntry{}).Error
+	})
+}
+
+// AddPlaylistEntries appends tracks to the end of the playlist.
+func AddPlaylistEntries(userID string, playlistID int, tracks []LibraryTrack) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&UserPlaylist{}).Where("id = ? AND user_id = ?", playlistID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrPlaylistNotFound
+		}
+		return addPlaylistEntries(tx, uint(playlistID), tracks)
+	})
+}
+
+func addPlaylistEntries(tx *gorm.DB, playlistID uint, tracks []LibraryTrack) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+
+	var maxPosition int
+	if err := tx.Model(&PlaylistEntry{}).
+		Where("playlist_id = ?", playlistID).
+		Select("COALESCE(MAX(position), -1)").
+		Scan(&maxPosition).Error; err != nil {
+		return err
+	}
+
+	entries := make([]PlaylistEntry, 0, len(tracks))
+	for i, track := range tracks {
+		entries = append(entries, PlaylistEntry{
+			PlaylistID:   playlistID,
+			VideoID:      track.VideoID,
+			Title:        track.Title,
+			Artist:       track.Artist,
+			Album:        track.Album,
+			ThumbnailURL: track.ThumbnailURL,
+			Position:     maxPosition + 1 + i,
+		})
+	}
+	if err := tx.Create(&entries).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&UserPlaylist{}).Where("id = ?", playlistID).Update("updated_at", time.Now()).Error
+}
+
+func DeletePlaylistEntry(userID string, playlistID int, entryID int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&UserPlaylist{}).Where("id = ? AND user_id = ?", playlistID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrPlaylistNotFound
+		}
+		result := tx.Where("id = ? AND playlist_id = ?", entryID, playlistID).Delete(&PlaylistEntry{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPlaylistEntryNotFound
+		}
+		return nil
+	})
+}
+
+// ReorderPlaylistEntries assigns positions following the order of entryIDs.
+func ReorderPlaylistEntries(userID string, playlistID int, entryIDs []uint) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&UserPlaylist{}).Where("id = ? AND user_id = ?", playlistID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrPlaylistNotFound
+		}
+
+		var entries []PlaylistEntry
+		if err := tx.Where("playlist_id = ?", playlistID).Order("position ASC").Find(&entries).Error; err != nil {
+			return err
+		}
+
+		ordered := orderByKeys(entries, entryIDs, func(e PlaylistEntry) uint { return e.ID })
+		for i, e := range ordered {
+			if err := tx.Model(&PlaylistEntry{}).Where("id = ?", e.ID).Update("position", i).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&UserPlaylist{}).Where("id = ?", playlistID).Update("updated_at", time.Now()).Error
+	})
+}
+
+// Import / Export
+
+func ExportLibrary(userID string) (*LibraryExport, error) {
+	export := &LibraryExport{
+		Version:    LibraryExportVersion,
+		ExportedAt: time.Now(),
+		Favorites:  []LibraryTrack{},
+		Playlists:  []LibraryPlaylistExport{},
+	}
+
+	favorites, err := GetFavorites(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range favorites {
+		export.Favorites = append(export.Favorites, LibraryTrack{
+			VideoID:      f.VideoID,
+			Title:        f.Title,
+			Artist:       f.Artist,
+			Album:        f.Album,
+			ThumbnailURL: f.ThumbnailURL,
+		})
+	}
+
+	playlists, err := GetUserPlaylists(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range playlists {
+		entries, err := GetPlaylistEntries(int(p.ID))
+		if err != nil {
+			return nil, err
+		}
+		items := make([]LibraryTrack, 0, len(entries))
+		for _, e := range entries {
+			items = append(items, LibraryTrack{
+				VideoID:      e.VideoID,
+				Title:        e.Title,
+				Artist:       e.Artist,
+				Album:        e.Album,
+				ThumbnailURL: e.ThumbnailURL,
+			})
+		}
+		export.Playlists = append(export.Playlists, LibraryPlaylistExport{
+			Name:        p.Name,
+			Description: p.Description,
+			Thumbnail:   p.ThumbnailURL,
+			Items:       items,
+		})
+	}
+
+	return export, nil
+}
+
+// ImportLibrary merges an export into the user's library.
+// Favorites already present are kept; playlists with the same name get the
+// imported tracks appended, otherwise a new playlist is created.
+func ImportLibrary(userID string, data *LibraryExport) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, track := range data.Favorites {
+			if track.VideoID == "" {
+				continue
+			}
+			if err := addFavorite(tx, userID, track); err != nil {
+				return err
+			}
+		}
+
+		for _, p := range data.Playlists {
+			var playlist UserPlaylist
+			err := tx.Where("user_id = ? AND name = ?", userID, p.Name).First(&playlist).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				playlist = UserPlaylist{
+					UserID:       userID,
+					Name:         p.Name,
+					Description:  p.Description,
+					ThumbnailURL: p.Thumbnail,
+				}
+				err = tx.Create(&playlist).Error
+			}
+			if err != nil {
+				return err
+			}
+
+			var existing []string
+			if err := tx.Model(&PlaylistEntry{}).Where("playlist_id = ?", playlist.ID).Pluck("video_id", &existing).Error; err != nil {
+				return err
+			}
+			seen := make(map[string]bool, len(existing))
+			for _, id := range existing {
+				seen[id] = true
+			}
+
+			var tracks []LibraryTrack
+			for _, item := range p.Items {
+				if item.VideoID == "" || seen[item.VideoID] {
+					continue
+				}
+				seen[item.VideoID] = true
+				tracks = append(tracks, item)
+			}
+			if err := addPlaylistEntries(tx, playlist.ID, tracks); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// orderByKeys returns items sorted by the position of their key in keys.
+// Items whose key is not listed keep their current relative order at the end.
+func orderByKeys[T any, K comparable](items []T, keys []K, keyOf func(T) K) []T {
+	rank := make(map[K]int, len(keys))
+	for i, k := range keys {
+		if _, ok := rank[k]; !ok {
+			rank[k] = i
+		}
+	}
+
+	ordered := make([]T, 0, len(items))
+	var rest []T
+	byKey := make(map[K]T, len(items))
+	for _, item := range items {
+		if _, ok := rank[keyOf(item)]; ok {
+			byKey[keyOf(item)] = item
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	for _, k := range keys {
+		if item, ok := byKey[k]; ok {
+			ordered = append(ordered, item)
+			delete(byKey, k)
+		}
+	}
+	return append(ordered, rest...)
+}
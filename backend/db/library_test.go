@@ -0,0 +1,128 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB points DB at a fresh in-memory database for the test.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	var err error
+	DB, err = gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	require.NoError(t, err)
+
+	// Every connection to :memory: is a separate database
+	sqlDB, err := DB.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	_, err = Migrate()
+	require.NoError(t, err)
+}
+
+func TestFavoritesOrdering(t *testing.T) {
+	setupTestDB(t)
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, AddFavorite(DefaultUserID, LibraryTrack{VideoID: id, Title: id}))
+	}
+	// Adding the same favorite twice is ignored
+	require.NoError(t, AddFavorite(DefaultUserID, LibraryTrack{VideoID: "a"}))
+
+	require.NoError(t, ReorderFavorites(DefaultUserID, []string{"c", "a"}))
+
+	favorites, err := GetFavorites(DefaultUserID)
+	require.NoError(t, err)
+	var ids []string
+	for _, f := range favorites {
+		ids = append(ids, f.VideoID)
+	}
+	assert.Equal(t, []string{"c", "a", "b"}, ids)
+
+	other, err := GetFavorites("someone-else")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+}
+
+func TestLibraryImportExport(t *testing.T) {
+	setupTestDB(t)
+
+	playlist, err := CreateUserPlaylist(DefaultUserID, "Road trip", "", "")
+	require.NoError(t, err)
+	require.NoError(t, AddPlaylistEntries(DefaultUserID, int(playlist.ID), []LibraryTrack{
+		{VideoID: "v1", Title: "One"},
+		{VideoID: "v2", Title: "Two"},
+	}))
+	require.NoError(t, AddFavorite(DefaultUserID, LibraryTrack{VideoID: "v1", Title: "One"}))
+
+	export, err := ExportLibrary(DefaultUserID)
+	require.NoError(t, err)
+	require.Len(t, export.Playlists, 1)
+	assert.Len(t, export.Playlists[0].Items, 2)
+
+	// Importing into the same user merges instead of duplicating
+	export.Playlists[0].Items = append(export.Playlists[0].Items, LibraryTrack{VideoID: "v3", Title: "Three"})
+	require.NoError(t, ImportLibrary(DefaultUserID, export))
+
+	playlists, err := GetUserPlaylists(DefaultUserID)
+	require.NoError(t, err)
+	require.Len(t, playlists, 1)
+	assert.Equal(t, 3, playlists[0].TrackCount)
+
+	favorites, err := GetFavorites(DefaultUserID)
+	require.NoError(t, err)
+	assert.Len(t, favorites, 1)
+
+	// Importing into another user creates a copy
+	require.NoError(t, ImportLibrary("phone", export))
+	phonePlaylists, err := GetUserPlaylists("phone")
+	require.NoError(t, err)
+	require.Len(t, phonePlaylists, 1)
+	assert.Equal(t, 3, phonePlaylists[0].TrackCount)
+}
+
+func TestReorderPlaylistEntries(t *testing.T) {
+	setupTestDB(t)
+
+	playlist, err := CreateUserPlaylist(DefaultUserID, "Mix", "", "")
+	require.NoError(t, err)
+	require.NoError(t, AddPlaylistEntries(DefaultUserID, int(playlist.ID), []LibraryTrack{
+		{VideoID: "v1"}, {VideoID: "v2"}, {VideoID: "v3"},
+	}))
+
+	entries, err := GetPlaylistEntries(int(playlist.ID))
+	require.NoError(t, err)
+	require.NoError(t, ReorderPlaylistEntries(DefaultUserID, int(playlist.ID), []uint{entries[2].ID, entries[0].ID, entries[1].ID}))
+
+	entries, err = GetPlaylistEntries(int(playlist.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "v3", entries[0].VideoID)
+	assert.Equal(t, "v1", entries[1].VideoID)
+	assert.Equal(t, "v2", entries[2].VideoID)
+
+	assert.ErrorIs(t, ReorderPlaylistEntries("phone", int(playlist.ID), nil), ErrPlaylistNotFound)
+}
+
+func TestDeletePlaylistEntry(t *testing.T) {
+	setupTestDB(t)
+
+	playlist, err := CreateUserPlaylist(DefaultUserID, "Mix", "", "")
+	require.NoError(t, err)
+	require.NoError(t, AddPlaylistEntries(DefaultUserID, int(playlist.ID), []LibraryTrack{{VideoID: "v1"}}))
+	entries, err := GetPlaylistEntries(int(playlist.ID))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.NoError(t, DeletePlaylistEntry(DefaultUserID, int(playlist.ID), int(entries[0].ID)))
+	assert.ErrorIs(t, DeletePlaylistEntry(DefaultUserID, int(playlist.ID), int(entries[0].ID)), ErrPlaylistEntryNotFound)
+	assert.ErrorIs(t, DeletePlaylistEntry("phone", int(playlist.ID), 1), ErrPlaylistNotFound)
+}
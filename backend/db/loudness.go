@@ -0,0 +1,21 @@
+package db
+
+// SetSongTaskLoudness records the duration and the EBU R128 loudness of the
+// stored file of a track, loudness is nil when it could not be measured.
+func SetSongTaskLoudness(groupTaskID int, videoID string, durationMs int, loudness *float64, peak float64) error {
+	return DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+		Updates(map[string]interface{}{
+			"duration_ms": durationMs,
+			"loudness":    loudness,
+			"peak":        peak,
+		}).Error
+}
+
+// SetSongTaskDuration records the length of a track before it is
+// downloaded, from the length the playlist lists.
+func SetSongTaskDuration(groupTaskID int, videoID string, durationMs int) error {
+	return DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+		Update("duration_ms", durationMs).Error
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"beatbump-server/backend/logging"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one numbered schema change. Up and Down run inside a
+// transaction together with the bookkeeping in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating.
+const migrationLockID = 0x62656174
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationState describes a known migration and whether it was applied.
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+func appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	var rows []SchemaMigration
+	if err := DB.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// checkKnownVersions refuses to touch a database migrated by a newer build,
+// its schema may not work with the code of this one.
+func checkKnownVersions(applied map[int]SchemaMigration) error {
+	latest := migrations[len(migrations)-1].Version
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("database schema version %d is newer than this server (latest %d), roll back with the newer build first", version, latest)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration, 0 for an empty database.
+func SchemaVersion() (int, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// MigrationStatus lists every known migration in order.
+func MigrationStatus() ([]MigrationState, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet.
+func PendingMigrations() ([]Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnownVersions(applied); err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations in order and returns them. It stops
+// at the first failure, the failed migration is rolled back as a whole.
+func Migrate() ([]Migration, error) {
+	pending, err := PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range pending {
+		applied := false
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			// Instances sharing a PostgreSQL database may start together, the
+			// lock serialises them and the loser finds the migration applied.
+			if tx.Dialector.Name() == DriverPostgres {
+				if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+					return err
+				}
+				var count int64
+				if err := tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					return nil
+				}
+			}
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			applied = true
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		if applied {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// Rollback reverts the given number of most recently applied migrations and
+// returns them in the order they were reverted.
+func Rollback(steps int) ([]Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnownVersions(applied); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// BackupDatabase writes a consistent copy of beatbump.db next to it and
+// returns its path. VACUUM INTO is safe while the database is in WAL mode.
+// PostgreSQL is not backed up here, the path is empty; use pg_dump instead.
+func BackupDatabase() (string, error) {
+	if dbFile == "" {
+		logging.Log.Warn().Msg("Skipping database backup for PostgreSQL, back it up with pg_dump")
+		return "", nil
+	}
+	version, err := SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", dbFile, version, time.Now().Format("20060102-150405"))
+	if err := DB.Exec("VACUUM INTO ?", backup).Error; err != nil {
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+	return filepath.Clean(backup), nil
+}
+
+// hasUserTables reports whether the database holds anything worth a backup.
+func hasUserTables() (bool, error) {
+	tables, err := DB.Migrator().GetTables()
+	if err != nil {
+		return false, err
+	}
+	for _, table := range tables {
+		if table != "schema_migrations" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MigrateWithBackup backs up the database when migrations are pending and it
+// already holds data, then migrates it. It returns the applied migrations and
+// the backup path, which is empty when no backup was needed.
+func MigrateWithBackup() ([]Migration, string, error) {
+	pending, err := PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return nil, "", err
+	}
+
+	var backup string
+	if populated, err := hasUserTables(); err != nil {
+		return nil, "", err
+	} else if populated {
+		if backup, err = BackupDatabase(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	done, err := Migrate()
+	return done, backup, err
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"beatbump-server/backend/config"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateAndRollback(t *testing.T) {
+	setupTestDB(t)
+
+	pending, err := PendingMigrations()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.True(t, DB.Migrator().HasIndex("song_tasks", "idx_song_tasks_status"))
+
+	reverted, err := Rollback(1)
+	require.NoError(t, err)
+	require.Len(t, reverted, 1)
+	assert.Equal(t, migrations[len(migrations)-1].Version, reverted[0].Version)
+	assert.True(t, DB.Migrator().HasIndex("song_tasks", "idx_song_tasks_status"), "rollback keeps other indexes")
+	assert.Error(t, CheckSchema())
+
+	states, err := MigrationStatus()
+	require.NoError(t, err)
+	require.Len(t, states, len(migrations))
+	assert.NotNil(t, states[0].AppliedAt)
+	assert.Nil(t, states[len(states)-1].AppliedAt)
+
+	applied, err := Migrate()
+	require.NoError(t, err)
+	assert.Len(t, applied, 1)
+	require.NoError(t, CheckSchema())
+
+	_, err = Rollback(len(migrations))
+	require.NoError(t, err)
+	assert.False(t, DB.Migrator().HasTable(&GroupTask{}))
+	version, err := SchemaVersion()
+	require.NoError(t, err)
+	assert.Zero(t, version)
+}
+
+func TestMigrateAdoptsAutoMigratedDatabase(t *testing.T) {
+	setupTestDB(t)
+	_, err := Rollback(len(migrations))
+	require.NoError(t, err)
+
+	// Databases from before versioned migrations were created by AutoMigrate
+	require.NoError(t, DB.AutoMigrate(models...))
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", "user", -1))
+
+	_, err = Migrate()
+	require.NoError(t, err)
+	require.NoError(t, CheckSchema())
+
+	group, err := GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	assert.Equal(t, "Playlist", group.PlaylistName)
+}
+
+func TestMigrateRequeuesProcessingGroupsWithoutLease(t *testing.T) {
+	setupTestDB(t)
+	_, err := Rollback(len(migrations) - 2)
+	require.NoError(t, err)
+	version, err := SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	require.NoError(t, DB.Exec("INSERT INTO group_tasks (type, reference_id, playlist_name, source, status, max_tracks) VALUES (?, ?, ?, ?, ?, ?)",
+		TaskTypePlaylistDownload, "PL1", "Playlist", "user", TaskStatusProcessing, -1).Error)
+
+	_, err = Migrate()
+	require.NoError(t, err)
+
+	group, err := GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusPending, group.Status)
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, DB.Create(&SchemaMigration{Version: migrations[len(migrations)-1].Version + 1, Name: "future"}).Error)
+
+	_, err := Migrate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than this server")
+}
+
+func TestMigrateWithBackup(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Open(config.DatabaseConfig{Driver: DriverSQLite, Path: dir}))
+	t.Cleanup(func() { Close() })
+
+	// A fresh database has nothing to back up
+	_, backup, err := MigrateWithBackup()
+	require.NoError(t, err)
+	assert.Empty(t, backup)
+
+	require.NoError(t, SetSetting(DownloadPathSetting, "/music"))
+	_, err = Rollback(1)
+	require.NoError(t, err)
+
+	applied, backup, err := MigrateWithBackup()
+	require.NoError(t, err)
+	assert.Len(t, applied, 1)
+	require.NotEmpty(t, backup)
+	_, err = os.Stat(backup)
+	require.NoError(t, err)
+	assert.Contains(t, backup, fmt.Sprintf(".v%d-", migrations[len(migrations)-2].Version))
+}
@@ -0,0 +1,497 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations is the ordered schema history. Never edit a migration once it
+// has been released; append a new one instead. Each migration works on its
+// own snapshot of the tables so later changes to the models do not alter
+// what an old migration creates.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		// Also adopts databases created by AutoMigrate before versioned
+		// migrations existed, AutoMigrate only adds what is missing.
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(v1Models...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(v1Models...)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "task_status_indexes",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"CREATE INDEX IF NOT EXISTS idx_group_tasks_status ON group_tasks (status)",
+				"CREATE INDEX IF NOT EXISTS idx_song_tasks_status ON song_tasks (status)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"DROP INDEX IF EXISTS idx_group_tasks_status",
+				"DROP INDEX IF EXISTS idx_song_tasks_status",
+			)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "task_leases",
+		Up: func(tx *gorm.DB) error {
+			for _, model := range v3LeaseModels {
+				for _, field := range []string{"WorkerID", "LeaseExpiresAt"} {
+					if !tx.Migrator().HasColumn(model, field) {
+						if err := tx.Migrator().AddColumn(model, field); err != nil {
+							return err
+						}
+					}
+				}
+				if !tx.Migrator().HasIndex(model, "LeaseExpiresAt") {
+					if err := tx.Migrator().CreateIndex(model, "LeaseExpiresAt"); err != nil {
+						return err
+					}
+				}
+			}
+			// Groups left processing before leases have no lease to expire,
+			// so they are queued again as the startup reset used to
+			return execAll(tx, "UPDATE group_tasks SET status = 'pending' WHERE status = 'processing'")
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, model := range v3LeaseModels {
+				if err := tx.Migrator().DropIndex(model, "LeaseExpiresAt"); err != nil {
+					return err
+				}
+				table := model.(interface{ TableName() string }).TableName()
+				if err := execAll(tx,
+					"ALTER TABLE "+table+" DROP COLUMN worker_id",
+					"ALTER TABLE "+table+" DROP COLUMN lease_expires_at",
+				); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "song_task_file_hash",
+		Up: func(tx *gorm.DB) error {
+			model := &v4SongTaskFileHash{}
+			if !tx.Migrator().HasColumn(model, "FileHash") {
+				if err := tx.Migrator().AddColumn(model, "FileHash"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasIndex(model, "FileHash") {
+				return tx.Migrator().CreateIndex(model, "FileHash")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&v4SongTaskFileHash{}, "FileHash"); err != nil {
+				return err
+			}
+			// The SQLite migrator rebuilds the table to drop a column, which
+			// loses the other indexes
+			return tx.Exec("ALTER TABLE song_tasks DROP COLUMN file_hash").Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "stored_tracks",
+		Up: func(tx *gorm.DB) error {
+			model := &v5SongTaskTrackKey{}
+			if !tx.Migrator().HasColumn(model, "TrackKey") {
+				if err := tx.Migrator().AddColumn(model, "TrackKey"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasIndex(model, "TrackKey") {
+				if err := tx.Migrator().CreateIndex(model, "TrackKey"); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&v5StoredTrack{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&v5StoredTrack{}); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex(&v5SongTaskTrackKey{}, "TrackKey"); err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE song_tasks DROP COLUMN track_key").Error
+		},
+	},
+	{
+		Version: 6,
+		Name:    "library_search",
+		Up: func(tx *gorm.DB) error {
+			model := &v6SongTaskLyrics{}
+			if !tx.Migrator().HasColumn(model, "Lyrics") {
+				if err := tx.Migrator().AddColumn(model, "Lyrics"); err != nil {
+					return err
+				}
+			}
+			if err := createSearchIndex(tx); err != nil {
+				return err
+			}
+			return rebuildSearchIndex(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"DROP TABLE IF EXISTS song_search",
+				"ALTER TABLE song_tasks DROP COLUMN lyrics",
+			)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "metadata_reviews",
+		Up: func(tx *gorm.DB) error {
+			model := &v7SongTaskMatch{}
+			for _, field := range []string{"MatchProvider", "MatchScore"} {
+				if !tx.Migrator().HasColumn(model, field) {
+					if err := tx.Migrator().AddColumn(model, field); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.AutoMigrate(&v7MetadataReview{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&v7MetadataReview{}); err != nil {
+				return err
+			}
+			return execAll(tx,
+				"ALTER TABLE song_tasks DROP COLUMN match_provider",
+				"ALTER TABLE song_tasks DROP COLUMN match_score",
+			)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "song_task_loudness",
+		Up: func(tx *gorm.DB) error {
+			model := &v8SongTaskLoudness{}
+			for _, field := range []string{"DurationMs", "Loudness", "Peak"} {
+				if !tx.Migrator().HasColumn(model, field) {
+					if err := tx.Migrator().AddColumn(model, field); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE song_tasks DROP COLUMN duration_ms",
+				"ALTER TABLE song_tasks DROP COLUMN loudness",
+				"ALTER TABLE song_tasks DROP COLUMN peak",
+			)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "song_task_artwork",
+		Up: func(tx *gorm.DB) error {
+			model := &v9SongTaskArtwork{}
+			for _, field := range []string{"ArtworkURL", "ArtworkHash"} {
+				if !tx.Migrator().HasColumn(model, field) {
+					if err := tx.Migrator().AddColumn(model, field); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE song_tasks DROP COLUMN artwork_url",
+				"ALTER TABLE song_tasks DROP COLUMN artwork_hash",
+			)
+		},
+	},
+	{
+		Version: 10,
+		Name:    "song_task_artist_id",
+		Up: func(tx *gorm.DB) error {
+			model := &v10SongTaskArtist{}
+			if !tx.Migrator().HasColumn(model, "ArtistID") {
+				return tx.Migrator().AddColumn(model, "ArtistID")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE song_tasks DROP COLUMN artist_id")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "group_task_schedule",
+		Up: func(tx *gorm.DB) error {
+			model := &v11GroupTaskSchedule{}
+			for _, field := range []string{"StartAfter", "Recurrence", "NextRunAt"} {
+				if !tx.Migrator().HasColumn(model, field) {
+					if err := tx.Migrator().AddColumn(model, field); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE group_tasks DROP COLUMN start_after",
+				"ALTER TABLE group_tasks DROP COLUMN recurrence",
+				"ALTER TABLE group_tasks DROP COLUMN next_run_at",
+			)
+		},
+	},
+}
+
+func execAll(tx *gorm.DB, statements ...string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schema snapshot of migration 1
+
+var v1Models = []interface{}{
+	&v1GroupTask{}, &v1SongTask{}, &v1Setting{},
+	&v1Favorite{}, &v1UserPlaylist{}, &v1PlaylistEntry{}, &v1PlayEvent{}, &v1ScrobbleQueueItem{},
+}
+
+type v1GroupTask struct {
+	ID           uint `gorm:"primaryKey"`
+	Type         string
+	ReferenceID  string `gorm:"uniqueIndex"`
+	Status       string
+	PlaylistName string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Source       string `gorm:"default:user"`
+	MaxTracks    int    `gorm:"default:0"`
+}
+
+func (v1GroupTask) TableName() string { return "group_tasks" }
+
+type v1SongTask struct {
+	GroupTaskID  uint   `gorm:"primaryKey;autoIncrement:false"`
+	VideoID      string `gorm:"primaryKey"`
+	Status       string
+	Title        string
+	Artist       string
+	Album        string
+	ThumbnailURL string
+	FilePath     string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (v1SongTask) TableName() string { return "song_tasks" }
+
+type v1Setting struct {
+	Key   string `gorm:"primaryKey"`
+	Value string
+}
+
+func (v1Setting) TableName() string { return "settings" }
+
+type v1Favorite struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       string `gorm:"uniqueIndex:idx_favorites_user_video;default:default"`
+	VideoID      string `gorm:"uniqueIndex:idx_favorites_user_video"`
+	Title        string
+	Artist       string
+	Album        string
+	ThumbnailURL string
+	Position     int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (v1Favorite) TableName() string { return "favorites" }
+
+type v1UserPlaylist struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       string `gorm:"index;default:default"`
+	Name         string
+	Description  string
+	ThumbnailURL string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (v1UserPlaylist) TableName() string { return "user_playlists" }
+
+type v1PlaylistEntry struct {
+	ID           uint `gorm:"primaryKey"`
+	PlaylistID   uint `gorm:"index"`
+	VideoID      string
+	Title        string
+	Artist       string
+	Album        string
+	ThumbnailURL string
+	Position     int
+	CreatedAt    time.Time
+}
+
+func (v1PlaylistEntry) TableName() string { return "playlist_entries" }
+
+type v1PlayEvent struct {
+	ID              uint   `gorm:"primaryKey"`
+	UserID          string `gorm:"index;default:default"`
+	VideoID         string `gorm:"index"`
+	Title           string
+	Artist          string
+	Album           string
+	PlaylistID      string
+	DurationSeconds int
+	PlayedAt        time.Time `gorm:"index"`
+}
+
+func (v1PlayEvent) TableName() string { return "play_events" }
+
+type v1ScrobbleQueueItem struct {
+	ID              uint   `gorm:"primaryKey"`
+	Provider        string `gorm:"index"`
+	VideoID         string
+	Artist          string
+	Title           string
+	Album           string
+	DurationSeconds int
+	PlayedAt        time.Time
+	Attempts        int
+	LastError       string
+	NextAttemptAt   time.Time `gorm:"index"`
+	CreatedAt       time.Time
+}
+
+func (v1ScrobbleQueueItem) TableName() string { return "scrobble_queue_items" }
+
+// Schema snapshot of migration 3
+
+var v3LeaseModels = []interface{}{&v3GroupTaskLease{}, &v3SongTaskLease{}}
+
+type v3GroupTaskLease struct {
+	WorkerID       string
+	LeaseExpiresAt *time.Time `gorm:"index"`
+}
+
+func (v3GroupTaskLease) TableName() string { return "group_tasks" }
+
+type v3SongTaskLease struct {
+	WorkerID       string
+	LeaseExpiresAt *time.Time `gorm:"index"`
+}
+
+func (v3SongTaskLease) TableName() string { return "song_tasks" }
+
+// Schema snapshot of migration 4
+
+type v4SongTaskFileHash struct {
+	FileHash string `gorm:"index"`
+}
+
+func (v4SongTaskFileHash) TableName() string { return "song_tasks" }
+
+// Schema snapshot of migration 5
+
+type v5SongTaskTrackKey struct {
+	TrackKey string `gorm:"index"`
+}
+
+func (v5SongTaskTrackKey) TableName() string { return "song_tasks" }
+
+type v5StoredTrack struct {
+	VideoID   string `gorm:"primaryKey"`
+	Profile   string `gorm:"primaryKey"`
+	Key       string `gorm:"uniqueIndex"`
+	FileHash  string
+	Size      int64
+	CreatedAt time.Time
+}
+
+func (v5StoredTrack) TableName() string { return "stored_tracks" }
+
+// Schema snapshot of migration 6, the song_search index is raw SQL in
+// search.go
+
+type v6SongTaskLyrics struct {
+	Lyrics string
+}
+
+func (v6SongTaskLyrics) TableName() string { return "song_tasks" }
+
+// Schema snapshot of migration 7
+
+type v7SongTaskMatch struct {
+	MatchProvider string
+	MatchScore    float64
+}
+
+func (v7SongTaskMatch) TableName() string { return "song_tasks" }
+
+type v7MetadataReview struct {
+	ID          uint   `gorm:"primaryKey"`
+	GroupTaskID uint   `gorm:"index:idx_metadata_reviews_song"`
+	VideoID     string `gorm:"index:idx_metadata_reviews_song"`
+	Status      string `gorm:"index"`
+	Title       string
+	Artist      string
+	Album       string
+	DurationMs  int
+	Candidates  string // JSON
+	Choice      *int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (v7MetadataReview) TableName() string { return "metadata_reviews" }
+
+// Schema snapshot of migration 8
+
+type v8SongTaskLoudness struct {
+	DurationMs int
+	Loudness   *float64
+	Peak       float64
+}
+
+func (v8SongTaskLoudness) TableName() string { return "song_tasks" }
+
+// Schema snapshot of migration 9
+
+type v9SongTaskArtwork struct {
+	ArtworkURL  string
+	ArtworkHash string
+}
+
+func (v9SongTaskArtwork) TableName() string { return "song_tasks" }
+
+// Schema snapshot of migration 10
+
+type v10SongTaskArtist struct {
+	ArtistID string
+}
+
+func (v10SongTaskArtist) TableName() string { return "song_tasks" }
+
+// Schema snapshot of migration 11
+
+type v11GroupTaskSchedule struct {
+	StartAfter *time.Time
+	Recurrence string
+	NextRunAt  *time.Time
+}
+
+func (v11GroupTaskSchedule) TableName() string { return "group_tasks" }
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"beatbump-server/backend/tags"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusAccepted = "accepted"
+	ReviewStatusRejected = "rejected"
+)
+
+// ErrReviewResolved is returned when a review was already accepted or
+// rejected.
+var ErrReviewResolved = errors.New("review is already resolved")
+
+// MetadataReview holds the candidates of a track whose best metadata match
+// scored too low to be applied. The track keeps its YouTube metadata until a
+// user accepts a candidate.
+type MetadataReview struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	GroupTaskID uint   `gorm:"index:idx_metadata_reviews_song" json:"groupTaskId"`
+	VideoID     string `gorm:"index:idx_metadata_reviews_song" json:"videoId"`
+	Status      string `gorm:"index" json:"status"`
+	// The YouTube metadata the track was tagged with
+	Title      string            `json:"title"`
+	Artist     string            `json:"artist"`
+	Album      string            `json:"album"`
+	DurationMs int               `json:"durationMs"`
+	Candidates []ReviewCandidate `gorm:"serializer:json" json:"candidates"`
+	// Index of the accepted candidate
+	Choice    *int      `json:"choice,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ReviewCandidate is a scored match offered for review, best first.
+type ReviewCandidate struct {
+	Provider   string  `json:"provider"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album,omitempty"`
+	Year       string  `json:"year,omitempty"`
+	Genre      string  `json:"genre,omitempty"`
+	ArtworkURL string  `json:"artworkUrl,omitempty"`
+	DurationMs int     `json:"durationMs,omitempty"`
+	Score      float64 `json:"score"`
+	// Set for MusicBrainz candidates, the track is tagged with them on
+	// accept
+	MusicBrainz tags.MusicBrainzIDs `json:"musicBrainz"`
+}
+
+// AddMetadataReview queues a review, replacing a pending one of the same
+// track.
+func AddMetadataReview(review *MetadataReview) error {
+	review.Status = ReviewStatusPending
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_task_id = ? AND video_id = ? AND status = ?", review.GroupTaskID, review.VideoID, ReviewStatusPending).
+			Delete(&MetadataReview{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(review).Error
+	})
+}
+
+// GetMetadataReviews returns the reviews with status, or all of them when
+// status is empty, newest first.
+func GetMetadataReviews(status string) ([]MetadataReview, error) {
+	var reviews []MetadataReview
+	query := DB.Order("created_at DESC, id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Find(&reviews).Error
+	return reviews, err
+}
+
+func GetMetadataReview(id int) (*MetadataReview, error) {
+	var review MetadataReview
+	err := DB.First(&review, id).Error
+	return &review, err
+}
+
+// ResolveMetadataReview accepts or rejects a pending review. choice is the
+// accepted candidate, nil on rejection.
+func ResolveMetadataReview(id int, status string, choice *int) error {
+	result := DB.Model(&MetadataReview{}).
+		Where("id = ? AND status = ?", id, ReviewStatusPending).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"choice":     choice,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReviewResolved
+	}
+	return nil
+}
+
+// SetSongTaskMatch records how the metadata of a track was matched.
+// provider is empty when no match was applied.
+func SetSongTaskMatch(groupTaskID int, videoID, provider string, score float64) error {
+	return DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+		Updates(map[string]interface{}{
+			"match_provider": provider,
+			"match_score":    score,
+			"updated_at":     time.Now(),
+		}).Error
+}
+
+// UpdateSongTaskTags stores the metadata a track was re-tagged with, for
+// the song task or, when trackKey is set, for every task linked to the
+// canonical file.
+func UpdateSongTaskTags(groupTaskID int, videoID, trackKey, title, artist, album string) error {
+	return updateStoredSongTasks(groupTaskID, videoID, trackKey, map[string]interface{}{
+		"title":      title,
+		"artist":     artist,
+		"album":      album,
+		"updated_at": time.Now(),
+	})
+}
+
+// UpdateSongTaskLyrics stores the lyrics of a track for the library search,
+// like UpdateSongTaskTags.
+func UpdateSongTaskLyrics(groupTaskID int, videoID, trackKey, lyrics string) error {
+	return updateStoredSongTasks(groupTaskID, videoID, trackKey, map[string]interface{}{
+		"lyrics":     lyrics,
+		"updated_at": time.Now(),
+	})
+}
+
+// updateStoredSongTasks updates the tasks sharing a stored file and their
+// search index entries.
+func updateStoredSongTasks(groupTaskID int, videoID, trackKey string, values map[string]interface{}) error {
+	if trackKey == "" {
+		if err := DB.Model(&SongTask{}).
+			Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+			Updates(values).Error; err != nil {
+			return err
+		}
+		indexSongTask(groupTaskID, videoID)
+		return nil
+	}
+	if err := DB.Model(&SongTask{}).Where("track_key = ?", trackKey).Updates(values).Error; err != nil {
+		return err
+	}
+	songs, err := GetLinkedSongTasks(trackKey)
+	if err != nil {
+		return err
+	}
+	for _, song := range songs {
+		indexSongTask(int(song.GroupTaskID), song.VideoID)
+	}
+	return nil
+}
+
+// UpdateFileHash records the hash of a rewritten file, for the song task at
+// filePath or, when trackKey is set, for every task linked to the canonical
+// file.
+func UpdateFileHash(groupTaskID int, videoID, trackKey, hash string) error {
+	if trackKey == "" {
+		return DB.Model(&SongTask{}).
+			Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+			Update("file_hash", hash).Error
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&SongTask{}).Where("track_key = ?", trackKey).Update("file_hash", hash).Error; err != nil {
+			return err
+		}
+		return tx.Model(&StoredTrack{}).Where("key = ?", trackKey).Update("file_hash", hash).Error
+	})
+}
+
+// GetLinkedSongTasks returns the tasks linked to the canonical file at
+// trackKey.
+func GetLinkedSongTasks(trackKey string) ([]SongTask, error) {
+	var songs []SongTask
+	err := DB.Where("track_key = ?", trackKey).Find(&songs).Error
+	return songs, err
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ImportReferencePrefix prefixes the ReferenceID of library_import groups,
+// followed by the slash separated folder they hold.
+const ImportReferencePrefix = "import:"
+
+// GetStoredSongTasks returns the tracks that point at a file in the library,
+// including the ones whose file went missing.
+func GetStoredSongTasks() ([]SongTask, error) {
+	var songs []SongTask
+	err := DB.Where("file_path != '' AND status IN ?", []string{TaskStatusCompleted, TaskStatusMissing}).
+		Order("group_task_id ASC, created_at ASC").
+		Find(&songs).Error
+	return songs, err
+}
+
+// RequeueMissingSongTask queues a track whose file disappeared for download
+// again and reopens its group.
+func RequeueMissingSongTask(groupTaskID int, videoID string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&SongTask{}).
+			Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+			Updates(map[string]interface{}{
+				"status":     TaskStatusNotStarted,
+				"file_path":  "",
+				"file_hash":  "",
+				"track_key":  "",
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+			return err
+		}
+
+		// finalizeTask completes the group again once the track is stored
+		return tx.Model(&GroupTask{}).
+			Where("id = ? AND status = ?", groupTaskID, TaskStatusCompleted).
+			Updates(map[string]interface{}{
+				"status":     TaskStatusProcessing,
+				"updated_at": time.Now(),
+			}).Error
+	})
+	if err != nil {
+		return err
+	}
+	unindexSongTask(groupTaskID, videoID)
+	return nil
+}
+
+// GetOrCreateImportGroupTask returns the group that holds the imported files
+// of folder, a slash separated path relative to the library root.
+func GetOrCreateImportGroupTask(folder string) (*GroupTask, error) {
+	name := folder
+	if i := strings.LastIndex(folder, "/"); i >= 0 {
+		name = folder[i+1:]
+	}
+	if name == "" {
+		name = "Library"
+	}
+
+	task := GroupTask{
+		Type:         TaskTypeLibraryImport,
+		ReferenceID:  ImportReferencePrefix + folder,
+		Status:       TaskStatusCompleted,
+		PlaylistName: name,
+		Source:       TaskSourceSystem,
+	}
+	err := DB.Where("reference_id = ?", task.ReferenceID).FirstOrCreate(&task).Error
+	return &task, err
+}
+
+// ImportSongTask adds a file found in the library as a completed track. A
+// track with the same video ID in the group is pointed at the file instead.
+func ImportSongTask(task SongTask) error {
+	task.Status = TaskStatusCompleted
+	if err := DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group_task_id"}, {Name: "video_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "file_path", "file_hash", "updated_at"}),
+	}).Omit("GroupTask").Create(&task).Error; err != nil {
+		return err
+	}
+	indexSongTask(int(task.GroupTaskID), task.VideoID)
+	return nil
+}
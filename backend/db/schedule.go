@@ -0,0 +1,80 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// startedGroupTasks is the condition on group tasks whose start time has
+// passed, the current time as its argument. prefix qualifies the column
+// in joins.
+func startedGroupTasks(prefix string) string {
+	return prefix + "start_after IS NULL OR " + prefix + "start_after <= ?"
+}
+
+// SetGroupTaskSchedule sets when a group task starts and how often it is
+// refreshed. A nil startAfter starts it right away, an empty recurrence
+// runs it once. nextRunAt is the first refresh of a recurring task.
+func SetGroupTaskSchedule(id int, startAfter *time.Time, recurrence string, nextRunAt *time.Time) error {
+	if recurrence == "" {
+		nextRunAt = nil
+	}
+	result := DB.Model(&GroupTask{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"start_after": startAfter,
+			"recurrence":  recurrence,
+			"next_run_at": nextRunAt,
+			"updated_at":  time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DueRecurringGroupTasks returns the recurring group tasks that finished
+// and whose next refresh is due. A task without a next refresh time, as
+// an imported one, is due once it finishes.
+func DueRecurringGroupTasks(now time.Time) ([]GroupTask, error) {
+	var tasks []GroupTask
+	err := DB.Where("recurrence != ''").
+		Where("status IN ?", []string{TaskStatusCompleted, TaskStatusFailed}).
+		Where("next_run_at IS NULL OR next_run_at <= ?", now).
+		Order("created_at ASC").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+// RequeueRecurringGroupTask queues a finished recurring task again, like
+// RetryGroupTask, and sets its next refresh. It reports whether the task
+// was requeued, false when it changed since DueRecurringGroupTasks.
+func RequeueRecurringGroupTask(id int, nextRunAt time.Time) (bool, error) {
+	requeued := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&GroupTask{}).
+			Where("id = ? AND recurrence != ''", id).
+			Where("status IN ?", []string{TaskStatusCompleted, TaskStatusFailed}).
+			Updates(map[string]interface{}{
+				"status":      TaskStatusPending,
+				"next_run_at": nextRunAt,
+				"updated_at":  now,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		requeued = true
+		return tx.Model(&SongTask{}).
+			Where("group_task_id = ? AND status = ?", id, TaskStatusFailed).
+			Updates(map[string]interface{}{
+				"status":     TaskStatusNotStarted,
+				"updated_at": now,
+			}).Error
+	})
+	return requeued, err
+}
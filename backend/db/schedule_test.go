@@ -0,0 +1,77 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestStartAfterHoldsClaims(t *testing.T) {
+	setupTestDB(t)
+	group := addLeaseTestTasks(t)
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, SetGroupTaskSchedule(int(group.ID), &later, "", nil))
+
+	_, err := ClaimPendingGroupTask("worker-1", time.Minute)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = ClaimPendingSongTask("worker-1", time.Minute)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	earlier := time.Now().Add(-time.Minute)
+	require.NoError(t, SetGroupTaskSchedule(int(group.ID), &earlier, "", nil))
+	claimed, err := ClaimPendingGroupTask("worker-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, group.ID, claimed.ID)
+
+	assert.ErrorIs(t, SetGroupTaskSchedule(999, nil, "", nil), gorm.ErrRecordNotFound)
+}
+
+func TestRequeueRecurringGroupTask(t *testing.T) {
+	setupTestDB(t)
+	group := addLeaseTestTasks(t)
+	groupID := int(group.ID)
+	now := time.Now()
+	next := now.Add(time.Hour)
+	require.NoError(t, SetGroupTaskSchedule(groupID, nil, "every 1h", &next))
+
+	// Not finished yet
+	due, err := DueRecurringGroupTasks(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	require.NoError(t, UpdateSongTaskStatus(groupID, "a", TaskStatusFailed))
+	require.NoError(t, UpdateGroupTaskStatus(groupID, TaskStatusCompleted))
+	due, err = DueRecurringGroupTasks(now)
+	require.NoError(t, err)
+	assert.Empty(t, due, "not due before its next run")
+	due, err = DueRecurringGroupTasks(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+
+	requeued, err := RequeueRecurringGroupTask(groupID, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, requeued)
+	task, err := GetGroupTask(groupID)
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusPending, task.Status)
+	require.NotNil(t, task.NextRunAt)
+	assert.WithinDuration(t, now.Add(3*time.Hour), *task.NextRunAt, time.Second)
+	song, err := GetSongTask(groupID, "a")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusNotStarted, song.Status)
+
+	// Already pending
+	requeued, err = RequeueRecurringGroupTask(groupID, now.Add(4*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, requeued)
+
+	// Clearing the recurrence clears the next run
+	require.NoError(t, SetGroupTaskSchedule(groupID, nil, "", &next))
+	task, err = GetGroupTask(groupID)
+	require.NoError(t, err)
+	assert.Empty(t, task.Recurrence)
+	assert.Nil(t, task.NextRunAt)
+}
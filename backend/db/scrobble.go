@@ -0,0 +1,59 @@
+package db
+
+import (
+	"time"
+)
+
+// ScrobbleQueueItem is a scrobble that could not be submitted to a provider
+// and is waiting to be retried.
+type ScrobbleQueueItem struct {
+	ID              uint   `gorm:"primaryKey"`
+	Provider        string `gorm:"index"`
+	VideoID         string
+	Artist          string
+	Title           string
+	Album           string
+	DurationSeconds int
+	PlayedAt        time.Time
+	Attempts        int
+	LastError       string
+	NextAttemptAt   time.Time `gorm:"index"`
+	CreatedAt       time.Time
+}
+
+func EnqueueScrobble(item *ScrobbleQueueItem) error {
+	if item.NextAttemptAt.IsZero() {
+		item.NextAttemptAt = time.Now()
+	}
+	return DB.Create(item).Error
+}
+
+// GetDueScrobbles returns the queued scrobbles of the given providers whose
+// next attempt is due, oldest plays first.
+func GetDueScrobbles(now time.Time, providers []string, limit int) ([]ScrobbleQueueItem, error) {
+	var items []ScrobbleQueueItem
+	err := DB.Where("next_attempt_at <= ?", now).
+		Where("provider IN ?", providers).
+		Order("played_at ASC").
+		Limit(limit).
+		Find(&items).Error
+	return items, err
+}
+
+func GetScrobbleQueue() ([]ScrobbleQueueItem, error) {
+	var items []ScrobbleQueueItem
+	err := DB.Order("played_at ASC").Find(&items).Error
+	return items, err
+}
+
+func DeleteScrobble(id uint) error {
+	return DB.Delete(&ScrobbleQueueItem{}, id).Error
+}
+
+func RescheduleScrobble(id uint, attempts int, lastError string, next time.Time) error {
+	return DB.Model(&ScrobbleQueueItem{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      lastError,
+		"next_attempt_at": next,
+	}).Error
+}
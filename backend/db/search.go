@@ -0,0 +1,208 @@
+package db
+
+import (
+	"beatbump-server/backend/logging"
+	"errors"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The song_search index holds the completed tracks. It is an FTS5 table on
+// SQLite and a table with a weighted tsvector on PostgreSQL, see migration 6.
+const (
+	sqliteSearchSchema = "CREATE VIRTUAL TABLE IF NOT EXISTS song_search USING fts5(" +
+		"title, artist, album, playlist, lyrics, " +
+		"group_task_id UNINDEXED, video_id UNINDEXED, " +
+		"tokenize = 'unicode61 remove_diacritics 2')"
+	postgresSearchSchema = "CREATE TABLE IF NOT EXISTS song_search (" +
+		"group_task_id bigint NOT NULL, video_id text NOT NULL, " +
+		"title text, artist text, album text, playlist text, lyrics text, " +
+		"document tsvector GENERATED ALWAYS AS (" +
+		"setweight(to_tsvector('simple', coalesce(title, '')), 'A') || " +
+		"setweight(to_tsvector('simple', coalesce(artist, '')), 'A') || " +
+		"setweight(to_tsvector('simple', coalesce(album, '')), 'B') || " +
+		"setweight(to_tsvector('simple', coalesce(playlist, '')), 'C') || " +
+		"setweight(to_tsvector('simple', coalesce(lyrics, '')), 'D')) STORED, " +
+		"PRIMARY KEY (group_task_id, video_id))"
+	postgresSearchIndex = "CREATE INDEX IF NOT EXISTS idx_song_search_document ON song_search USING GIN (document)"
+
+	indexSongsSQL = "INSERT INTO song_search (group_task_id, video_id, title, artist, album, playlist, lyrics) " +
+		"SELECT song_tasks.group_task_id, song_tasks.video_id, song_tasks.title, song_tasks.artist, " +
+		"song_tasks.album, group_tasks.playlist_name, song_tasks.lyrics " +
+		"FROM song_tasks JOIN group_tasks ON group_tasks.id = song_tasks.group_task_id " +
+		"WHERE song_tasks.status = ?"
+)
+
+// SearchFields are the indexed fields a search can be restricted to.
+var SearchFields = []string{"title", "artist", "album", "playlist", "lyrics"}
+
+// ErrEmptySearch is returned for a search without a single word.
+var ErrEmptySearch = errors.New("search has no terms")
+
+// SearchQuery searches the completed tracks. Every word must match, as a
+// prefix, in any field for Text and in the named field for Fields.
+type SearchQuery struct {
+	Text   string
+	Fields map[string]string
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a matching track and the name of its task.
+type SearchResult struct {
+	SongTask
+	PlaylistName string
+}
+
+func createSearchIndex(tx *gorm.DB) error {
+	if tx.Dialector.Name() == DriverPostgres {
+		return execAll(tx, postgresSearchSchema, postgresSearchIndex)
+	}
+	return tx.Exec(sqliteSearchSchema).Error
+}
+
+// rebuildSearchIndex indexes every completed track again.
+func rebuildSearchIndex(tx *gorm.DB) error {
+	if err := tx.Exec("DELETE FROM song_search").Error; err != nil {
+		return err
+	}
+	return tx.Exec(indexSongsSQL, TaskStatusCompleted).Error
+}
+
+// indexSongTask adds a track to the search index when it is completed and
+// removes it otherwise. The index only helps searching, so failures are
+// logged instead of failing the task.
+func indexSongTask(groupTaskID int, videoID string) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM song_search WHERE group_task_id = ? AND video_id = ?", groupTaskID, videoID).Error; err != nil {
+			return err
+		}
+		return tx.Exec(indexSongsSQL+" AND song_tasks.group_task_id = ? AND song_tasks.video_id = ?",
+			TaskStatusCompleted, groupTaskID, videoID).Error
+	})
+	if err != nil {
+		logging.Log.Warn().Err(err).Int("group_task_id", groupTaskID).Str("video_id", videoID).Msg("Failed to update search index")
+	}
+}
+
+// indexGroupTask indexes the tracks of a group task again, like
+// indexSongTask.
+func indexGroupTask(groupTaskID int) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM song_search WHERE group_task_id = ?", groupTaskID).Error; err != nil {
+			return err
+		}
+		return tx.Exec(indexSongsSQL+" AND song_tasks.group_task_id = ?", TaskStatusCompleted, groupTaskID).Error
+	})
+	if err != nil {
+		logging.Log.Warn().Err(err).Int("group_task_id", groupTaskID).Msg("Failed to update search index")
+	}
+}
+
+func unindexSongTask(groupTaskID int, videoID string) {
+	if err := DB.Exec("DELETE FROM song_search WHERE group_task_id = ? AND video_id = ?", groupTaskID, videoID).Error; err != nil {
+		logging.Log.Warn().Err(err).Int("group_task_id", groupTaskID).Str("video_id", videoID).Msg("Failed to update search index")
+	}
+}
+
+func unindexGroupTask(groupTaskID int) {
+	if err := DB.Exec("DELETE FROM song_search WHERE group_task_id = ?", groupTaskID).Error; err != nil {
+		logging.Log.Warn().Err(err).Int("group_task_id", groupTaskID).Msg("Failed to update search index")
+	}
+}
+
+// searchTerms splits text into words the way the index tokenizes it.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// SearchSongTasks returns a page of the completed tracks matching q, best
+// matches first, and the number of matches.
+func SearchSongTasks(q SearchQuery) ([]SearchResult, int64, error) {
+	var results []SearchResult
+	var total int64
+
+	base := DB.Table("song_search").
+		Joins("JOIN song_tasks ON song_tasks.group_task_id = song_search.group_task_id AND song_tasks.video_id = song_search.video_id")
+	var order clause.Expr
+	if DB.Dialector.Name() == DriverPostgres {
+		base, order = postgresSearch(base, q)
+	} else {
+		base, order = sqliteSearch(base, q)
+	}
+	if base == nil {
+		return nil, 0, ErrEmptySearch
+	}
+
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := base.Select("song_tasks.*, song_search.playlist AS playlist_name").
+		Order(clause.OrderBy{Expression: order}).
+		Limit(q.Limit).
+		Offset(q.Offset).
+		Scan(&results).Error
+	return results, total, err
+}
+
+// sqliteSearch builds an FTS5 query where every term is a quoted prefix.
+func sqliteSearch(tx *gorm.DB, q SearchQuery) (*gorm.DB, clause.Expr) {
+	var parts []string
+	add := func(column, text string) {
+		for _, term := range searchTerms(text) {
+			phrase := `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+			if column != "" {
+				phrase = column + " : " + phrase
+			}
+			parts = append(parts, phrase)
+		}
+	}
+	add("", q.Text)
+	for _, field := range SearchFields {
+		add(field, q.Fields[field])
+	}
+	if len(parts) == 0 {
+		return nil, clause.Expr{}
+	}
+	// Title and artist count most, lyrics least
+	return tx.Where("song_search MATCH ?", strings.Join(parts, " AND ")), clause.Expr{
+		SQL:                "bm25(song_search, 10.0, 8.0, 4.0, 2.0, 1.0), song_tasks.created_at ASC",
+		WithoutParentheses: true,
+	}
+}
+
+// postgresSearch matches the weighted document for Text and the single
+// fields for Fields, every term as a prefix.
+func postgresSearch(tx *gorm.DB, q SearchQuery) (*gorm.DB, clause.Expr) {
+	query := func(text string) string {
+		terms := searchTerms(text)
+		for i, term := range terms {
+			terms[i] = term + ":*"
+		}
+		return strings.Join(terms, " & ")
+	}
+
+	matched := false
+	order := clause.Expr{SQL: "song_tasks.created_at ASC", WithoutParentheses: true}
+	if text := query(q.Text); text != "" {
+		tx = tx.Where("song_search.document @@ to_tsquery('simple', ?)", text)
+		order.SQL = "ts_rank(song_search.document, to_tsquery('simple', ?)) DESC, " + order.SQL
+		order.Vars = []interface{}{text}
+		matched = true
+	}
+	for _, field := range SearchFields {
+		if text := query(q.Fields[field]); text != "" {
+			tx = tx.Where("to_tsvector('simple', coalesce(song_search."+field+", '')) @@ to_tsquery('simple', ?)", text)
+			matched = true
+		}
+	}
+	if !matched {
+		return nil, clause.Expr{}
+	}
+	return tx, order
+}
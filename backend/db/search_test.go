@@ -0,0 +1,125 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func searchIDs(t *testing.T, q SearchQuery) ([]string, int64) {
+	t.Helper()
+	if q.Limit == 0 {
+		q.Limit = 10
+	}
+	results, total, err := SearchSongTasks(q)
+	require.NoError(t, err)
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.VideoID)
+	}
+	return ids, total
+}
+
+func TestSearchSongTasks(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Summer Hits", TaskSourceUser, -1))
+	group, err := GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+
+	require.NoError(t, AddSongTask(groupID, "a", "Café del Mar", "Energy 52", "Café del Mar", ""))
+	require.NoError(t, AddSongTask(groupID, "b", "Summertime", "DJ Jazzy Jeff", "Homebase", ""))
+	require.NoError(t, AddSongTask(groupID, "c", "Queued", "Energy 52", "", ""))
+	require.NoError(t, DB.Model(&SongTask{}).Where("video_id = ?", "b").Update("lyrics", "here it is, the groove slightly transformed").Error)
+	require.NoError(t, MarkSongTaskCompleted(groupID, "a", "Summer Hits/a.mp3", ""))
+	require.NoError(t, MarkSongTaskLinked(groupID, "b", "Summer Hits/b.mp3", "", ""))
+
+	// Only completed tracks, accents folded, prefixes match
+	ids, total := searchIDs(t, SearchQuery{Text: "energ"})
+	assert.Equal(t, []string{"a"}, ids)
+	assert.EqualValues(t, 1, total)
+	ids, _ = searchIDs(t, SearchQuery{Text: "cafe"})
+	assert.Equal(t, []string{"a"}, ids)
+	ids, _ = searchIDs(t, SearchQuery{Text: "groove transf"})
+	assert.Equal(t, []string{"b"}, ids)
+
+	// The playlist name matches every track, the title ranks first
+	results, total, err := SearchSongTasks(SearchQuery{Text: "summer", Limit: 10})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	require.Len(t, results, 2)
+	assert.Equal(t, "b", results[0].VideoID)
+	assert.Equal(t, "Summer Hits", results[0].PlaylistName)
+	assert.Equal(t, "Summer Hits/b.mp3", results[0].FilePath)
+
+	// Field filters
+	ids, _ = searchIDs(t, SearchQuery{Fields: map[string]string{"title": "summer"}})
+	assert.Equal(t, []string{"b"}, ids)
+	ids, _ = searchIDs(t, SearchQuery{Text: "summer", Fields: map[string]string{"artist": "jazzy"}})
+	assert.Equal(t, []string{"b"}, ids)
+	ids, _ = searchIDs(t, SearchQuery{Fields: map[string]string{"lyrics": "energy"}})
+	assert.Empty(t, ids)
+
+	// Pagination
+	ids, total = searchIDs(t, SearchQuery{Text: "summer", Limit: 1, Offset: 1})
+	assert.Equal(t, []string{"a"}, ids)
+	assert.EqualValues(t, 2, total)
+
+	_, _, err = SearchSongTasks(SearchQuery{Text: " - ", Limit: 10})
+	assert.ErrorIs(t, err, ErrEmptySearch)
+
+	// Tracks leave the index when they stop being completed or are deleted
+	require.NoError(t, UpdateSongTaskStatus(groupID, "a", TaskStatusMissing))
+	ids, _ = searchIDs(t, SearchQuery{Text: "summer"})
+	assert.Equal(t, []string{"b"}, ids)
+	require.NoError(t, DeleteSongTask(groupID, "b"))
+	ids, _ = searchIDs(t, SearchQuery{Text: "summer"})
+	assert.Empty(t, ids)
+
+	require.NoError(t, MarkSongTaskCompleted(groupID, "c", "Summer Hits/c.mp3", ""))
+	ids, _ = searchIDs(t, SearchQuery{Text: "queued"})
+	assert.Equal(t, []string{"c"}, ids)
+	require.NoError(t, DeleteGroupTask(groupID))
+	var count int64
+	require.NoError(t, DB.Raw("SELECT count(*) FROM song_search").Scan(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestSearchIndexIsBuiltByMigration(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+	require.NoError(t, AddSongTask(1, "a", "Existing Song", "", "", ""))
+	require.NoError(t, MarkSongTaskCompleted(1, "a", "Playlist/a.mp3", ""))
+
+	_, err := Rollback(1)
+	require.NoError(t, err)
+	_, err = Migrate()
+	require.NoError(t, err)
+
+	ids, _ := searchIDs(t, SearchQuery{Text: "existing"})
+	assert.Equal(t, []string{"a"}, ids)
+}
+
+func TestRetaggingUpdatesLinkedTasks(t *testing.T) {
+	setupTestDB(t)
+	key := ".beatbump-tracks/mp3/a.mp3"
+	for i, ref := range []string{"PL1", "PL2"} {
+		require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, ref, ref, TaskSourceUser, -1))
+		require.NoError(t, AddSongTask(i+1, "a", "Old Title", "Artist", "", ""))
+		require.NoError(t, MarkSongTaskLinked(i+1, "a", ref+"/a.mp3", "", key))
+	}
+
+	require.NoError(t, UpdateSongTaskTags(1, "a", key, "New Title", "Artist", "Album"))
+	require.NoError(t, UpdateSongTaskLyrics(1, "a", key, "shared words"))
+	for _, groupID := range []int{1, 2} {
+		song, err := GetSongTask(groupID, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "New Title", song.Title)
+		assert.Equal(t, "shared words", song.Lyrics)
+	}
+	_, total := searchIDs(t, SearchQuery{Text: "new title shared"})
+	assert.EqualValues(t, 2, total)
+	_, total = searchIDs(t, SearchQuery{Text: "old"})
+	assert.Zero(t, total)
+}
@@ -0,0 +1,353 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TaskExport is the download queue in a form another instance can import,
+// see ExportTasks. It is written as JSON or YAML.
+type TaskExport struct {
+	Version    int               `json:"version" yaml:"version"`
+	ExportedAt time.Time         `json:"exportedAt" yaml:"exportedAt"`
+	Tasks      []GroupTaskExport `json:"tasks" yaml:"tasks"`
+}
+
+type GroupTaskExport struct {
+	Type      string `json:"type" yaml:"type"`
+	Reference string `json:"reference" yaml:"reference"`
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"`
+	MaxTracks int    `json:"maxTracks,omitempty" yaml:"maxTracks,omitempty"`
+	Source    string `json:"source,omitempty" yaml:"source,omitempty"`
+	Status    string `json:"status,omitempty" yaml:"status,omitempty"`
+	// See SetGroupTaskSchedule, the next refresh is worked out again
+	StartAfter *time.Time `json:"startAfter,omitempty" yaml:"startAfter,omitempty"`
+	Recurrence string     `json:"recurrence,omitempty" yaml:"recurrence,omitempty"`
+	// Only exported on request, without them the tracks are fetched again
+	Tracks []SongTaskExport `json:"tracks,omitempty" yaml:"tracks,omitempty"`
+}
+
+type SongTaskExport struct {
+	VideoID      string `json:"videoId" yaml:"videoId"`
+	Title        string `json:"title,omitempty" yaml:"title,omitempty"`
+	Artist       string `json:"artist,omitempty" yaml:"artist,omitempty"`
+	Album        string `json:"album,omitempty" yaml:"album,omitempty"`
+	ThumbnailURL string `json:"thumbnail,omitempty" yaml:"thumbnail,omitempty"`
+	Status       string `json:"status,omitempty" yaml:"status,omitempty"`
+	// Relative to the download path
+	FilePath string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+const TaskExportVersion = 1
+
+// How ImportTasks treats a task whose reference is already queued
+const (
+	// Keep the queued task
+	ImportSkip = "skip"
+	// Update the name and track limit of the queued task and add the
+	// tracks it is missing
+	ImportMerge = "merge"
+	// Delete the queued task and its tracks, the files stay. Call
+	// library.ReleaseTracks with the track keys of the replaced tasks
+	// afterwards.
+	ImportReplace = "replace"
+)
+
+// ImportModes lists the modes ImportTasks accepts.
+var ImportModes = []string{ImportSkip, ImportMerge, ImportReplace}
+
+var ErrUnknownImportMode = errors.New("unknown import mode")
+
+// taskTypes are the task types an import may create. Library playlist
+// downloads are left out, their reference is a playlist ID of the instance
+// that exported them.
+var taskTypes = []string{
+	TaskTypePlaylistDownload, TaskTypeSongMixDownload, TaskTypeOngoingDownload,
+	TaskTypeLibraryImport,
+}
+
+// trackOnlyTaskTypes are the task types the worker cannot fetch the tracks
+// of, they are only imported with their tracks.
+var trackOnlyTaskTypes = []string{TaskTypeOngoingDownload, TaskTypeLibraryImport}
+
+// TaskImportResult is the outcome of the import of one task.
+type TaskImportResult struct {
+	Reference string `json:"reference"`
+	// created, merged, replaced or skipped; empty on errors
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExportTasks returns every group task, with its song tasks when
+// withTracks is set.
+func ExportTasks(withTracks bool) (*TaskExport, error) {
+	var tasks []GroupTask
+	if err := DB.Order("created_at").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	export := &TaskExport{
+		Version:    TaskExportVersion,
+		ExportedAt: time.Now(),
+		Tasks:      make([]GroupTaskExport, 0, len(tasks)),
+	}
+	for _, task := range tasks {
+		item := GroupTaskExport{
+			Type:       task.Type,
+			Reference:  task.ReferenceID,
+			Name:       task.PlaylistName,
+			MaxTracks:  task.MaxTracks,
+			Source:     task.Source,
+			Status:     task.Status,
+			StartAfter: task.StartAfter,
+			Recurrence: task.Recurrence,
+		}
+		if withTracks {
+			songs, err := GetSongTasks(int(task.ID))
+			if err != nil {
+				return nil, err
+			}
+			item.Tracks = make([]SongTaskExport, 0, len(songs))
+			for _, song := range songs {
+				item.Tracks = append(item.Tracks, SongTaskExport{
+					VideoID:      song.VideoID,
+					Title:        song.Title,
+					Artist:       song.Artist,
+					Album:        song.Album,
+					ThumbnailURL: song.ThumbnailURL,
+					Status:       song.Status,
+					FilePath:     song.FilePath,
+				})
+			}
+		}
+		export.Tasks = append(export.Tasks, item)
+	}
+	return export, nil
+}
+
+// ImportTasks queues the tasks of an export, a task whose reference is
+// already queued is handled as mode says. A failed task does not stop the
+// others.
+func ImportTasks(data *TaskExport, mode string) ([]TaskImportResult, error) {
+	if !slices.Contains(ImportModes, mode) {
+		return nil, ErrUnknownImportMode
+	}
+	results := make([]TaskImportResult, 0, len(data.Tasks))
+	for i := range data.Tasks {
+		task := &data.Tasks[i]
+		result := TaskImportResult{Reference: task.Reference}
+		action, err := importTask(task, mode)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Action = action
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func importTask(task *GroupTaskExport, mode string) (string, error) {
+	if task.Reference == "" {
+		return "", errors.New("reference is required")
+	}
+	if task.Type == TaskTypeLibraryPlaylistDownload {
+		return "", errors.New(task.Type + " tasks cannot be imported, their playlist only exists on the instance that exported them")
+	}
+	if !slices.Contains(taskTypes, task.Type) {
+		return "", errors.New("unknown task type " + task.Type)
+	}
+	if slices.Contains(trackOnlyTaskTypes, task.Type) && len(task.Tracks) == 0 {
+		return "", errors.New(task.Type + " tasks can only be imported with their tracks")
+	}
+
+	existing, err := GetGroupTaskByReferenceID(task.Reference)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var created int
+		err := DB.Transaction(func(tx *gorm.DB) (err error) {
+			created, err = createImportedTask(tx, task, nil)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		indexGroupTask(created)
+		return "created", nil
+	} else if err != nil {
+		return "", err
+	}
+	switch mode {
+	case ImportMerge:
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			return mergeImportedTask(tx, existing, task)
+		})
+		if err != nil {
+			return "", err
+		}
+		indexGroupTask(int(existing.ID))
+		return "merged", nil
+	case ImportReplace:
+		// The queued task stays when the import fails
+		var created int
+		err := DB.Transaction(func(tx *gorm.DB) (err error) {
+			var songs []SongTask
+			if err := tx.Where("group_task_id = ?", existing.ID).Find(&songs).Error; err != nil {
+				return err
+			}
+			if err := deleteGroupTask(tx, int(existing.ID)); err != nil {
+				return err
+			}
+			previous := make(map[string]SongTask, len(songs))
+			for _, song := range songs {
+				previous[song.VideoID] = song
+			}
+			created, err = createImportedTask(tx, task, previous)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		unindexGroupTask(int(existing.ID))
+		indexGroupTask(created)
+		return "replaced", nil
+	}
+	return "skipped", nil
+}
+
+// createImportedTask queues a task like the download handlers do and
+// returns its ID. Without tracks the worker fetches them. previous holds the
+// tracks of a replaced task, a completed track at the same file stays
+// linked to its deduplicated file.
+func createImportedTask(tx *gorm.DB, task *GroupTaskExport, previous map[string]SongTask) (int, error) {
+	source := task.Source
+	if source == "" {
+		source = TaskSourceUser
+	}
+	if slices.Contains(trackOnlyTaskTypes, task.Type) {
+		// Not for the group worker
+		source = TaskSourceSystem
+	}
+	maxTracks := task.MaxTracks
+	if maxTracks == 0 {
+		maxTracks = -1
+	}
+	created := GroupTask{
+		Type:         task.Type,
+		ReferenceID:  task.Reference,
+		Status:       TaskStatusPending,
+		PlaylistName: task.Name,
+		Source:       source,
+		MaxTracks:    maxTracks,
+		StartAfter:   task.StartAfter,
+		Recurrence:   task.Recurrence,
+	}
+	if err := tx.Create(&created).Error; err != nil {
+		return 0, err
+	}
+	groupTaskID := int(created.ID)
+	if err := addImportedTracks(tx, groupTaskID, task.Tracks, nil, previous); err != nil {
+		return 0, err
+	}
+
+	status := TaskStatusPending
+	switch {
+	case task.Status == TaskStatusPaused:
+		status = TaskStatusPaused
+	case task.Status == TaskStatusCompleted && len(task.Tracks) > 0:
+		// Tracks without their file are downloaded again
+		if done, err := checkGroupCompletion(tx, groupTaskID); err != nil {
+			return 0, err
+		} else if done {
+			status = TaskStatusCompleted
+		}
+	}
+	if status == TaskStatusPending {
+		return groupTaskID, nil
+	}
+	return groupTaskID, tx.Model(&GroupTask{}).Where("id = ?", groupTaskID).Updates(statusUpdate(status)).Error
+}
+
+// mergeImportedTask updates a queued task with an imported one. The
+// tracks it already has keep their status.
+func mergeImportedTask(tx *gorm.DB, existing *GroupTask, task *GroupTaskExport) error {
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if task.Name != "" {
+		updates["playlist_name"] = task.Name
+	}
+	if task.MaxTracks != 0 {
+		updates["max_tracks"] = task.MaxTracks
+	}
+	if task.StartAfter != nil {
+		updates["start_after"] = task.StartAfter
+	}
+	if task.Recurrence != "" && task.Recurrence != existing.Recurrence {
+		updates["recurrence"] = task.Recurrence
+		updates["next_run_at"] = nil
+	}
+	if err := tx.Model(&GroupTask{}).Where("id = ?", existing.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	groupTaskID := int(existing.ID)
+	var songs []SongTask
+	if err := tx.Where("group_task_id = ?", groupTaskID).Find(&songs).Error; err != nil {
+		return err
+	}
+	queued := make(map[string]bool, len(songs))
+	for _, song := range songs {
+		queued[song.VideoID] = true
+	}
+	if err := addImportedTracks(tx, groupTaskID, task.Tracks, queued, nil); err != nil {
+		return err
+	}
+	if existing.Status != TaskStatusCompleted {
+		return nil
+	}
+	// New tracks to download
+	done, err := checkGroupCompletion(tx, groupTaskID)
+	if err != nil || done {
+		return err
+	}
+	return tx.Model(&GroupTask{}).Where("id = ?", groupTaskID).Updates(statusUpdate(TaskStatusPending)).Error
+}
+
+// addImportedTracks adds the tracks missing from queued to a group task.
+// Completed tracks keep their file, tracks that were being downloaded start
+// over. The caller indexes them for the search once the import is
+// committed.
+func addImportedTracks(tx *gorm.DB, groupTaskID int, tracks []SongTaskExport, queued map[string]bool, previous map[string]SongTask) error {
+	for _, track := range tracks {
+		if track.VideoID == "" || queued[track.VideoID] {
+			continue
+		}
+		song := SongTask{
+			GroupTaskID:  uint(groupTaskID),
+			VideoID:      track.VideoID,
+			Status:       TaskStatusNotStarted,
+			Title:        track.Title,
+			Artist:       track.Artist,
+			Album:        track.Album,
+			ThumbnailURL: track.ThumbnailURL,
+		}
+		switch track.Status {
+		case TaskStatusCompleted:
+			if track.FilePath == "" {
+				break
+			}
+			// Unhashed, the library scan still finds it by its video ID tag
+			song.Status, song.FilePath = TaskStatusCompleted, track.FilePath
+			if old, ok := previous[track.VideoID]; ok && old.FilePath == track.FilePath {
+				song.FileHash, song.TrackKey = old.FileHash, old.TrackKey
+			}
+		case TaskStatusFailed, TaskStatusMissing:
+			song.Status = track.Status
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("GroupTask").Create(&song).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
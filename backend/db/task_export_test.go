@@ -0,0 +1,152 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestTaskImportExport(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+	group, err := GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	require.NoError(t, AddSongTask(groupID, "done", "Done", "Artist", "", ""))
+	require.NoError(t, MarkSongTaskCompleted(groupID, "done", "Playlist/Done.mp3", "hash"))
+	require.NoError(t, AddSongTask(groupID, "busy", "Busy", "Artist", "", ""))
+	require.NoError(t, UpdateSongTaskStatus(groupID, "busy", TaskStatusProcessing))
+	require.NoError(t, AddGroupTask(TaskTypeSongMixDownload, "songmix:abc", "Mix", TaskSourceUser, 25))
+
+	export, err := ExportTasks(false)
+	require.NoError(t, err)
+	assert.Equal(t, TaskExportVersion, export.Version)
+	require.Len(t, export.Tasks, 2)
+	assert.Equal(t, GroupTaskExport{
+		Type: TaskTypeSongMixDownload, Reference: "songmix:abc", Name: "Mix", MaxTracks: 25,
+		Source: TaskSourceUser, Status: TaskStatusPending,
+	}, export.Tasks[1])
+	assert.Nil(t, export.Tasks[0].Tracks)
+
+	export, err = ExportTasks(true)
+	require.NoError(t, err)
+	require.Len(t, export.Tasks[0].Tracks, 2)
+
+	// Into an empty queue
+	require.NoError(t, DeleteGroupTask(groupID))
+	results, err := ImportTasks(export, ImportSkip)
+	require.NoError(t, err)
+	assert.Equal(t, []TaskImportResult{
+		{Reference: "PL1", Action: "created"},
+		{Reference: "songmix:abc", Action: "skipped"},
+	}, results)
+	group, err = GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID = int(group.ID)
+	done, err := GetSongTask(groupID, "done")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusCompleted, done.Status)
+	assert.Equal(t, "Playlist/Done.mp3", done.FilePath)
+	busy, err := GetSongTask(groupID, "busy")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusNotStarted, busy.Status, "interrupted downloads start over")
+
+	// Merging adds the missing tracks and keeps the others
+	require.NoError(t, UpdateGroupTaskStatus(groupID, TaskStatusCompleted))
+	export.Tasks[0].Name = "Renamed"
+	export.Tasks[0].Tracks = append(export.Tasks[0].Tracks, SongTaskExport{VideoID: "new", Title: "New"})
+	export.Tasks[0].Tracks[1].Status = TaskStatusFailed
+	results, err = ImportTasks(&TaskExport{Version: 1, Tasks: export.Tasks[:1]}, ImportMerge)
+	require.NoError(t, err)
+	assert.Equal(t, "merged", results[0].Action)
+	group, err = GetGroupTask(groupID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", group.PlaylistName)
+	assert.Equal(t, TaskStatusPending, group.Status)
+	songs, err := GetSongTasks(groupID)
+	require.NoError(t, err)
+	assert.Len(t, songs, 3)
+	busy, err = GetSongTask(groupID, "busy")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStatusNotStarted, busy.Status)
+
+	// Replacing starts from the imported task alone
+	results, err = ImportTasks(&TaskExport{Version: 1, Tasks: []GroupTaskExport{
+		{Type: TaskTypePlaylistDownload, Reference: "PL1", Name: "Fresh", Status: TaskStatusPaused, Recurrence: "daily 03:00"},
+		{Type: "unknown", Reference: "PL2"},
+		{Type: TaskTypeLibraryPlaylistDownload, Reference: "library:1", Name: "Library"},
+	}}, ImportReplace)
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", results[0].Action)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Contains(t, results[2].Error, "cannot be imported")
+	_, err = GetGroupTaskByReferenceID("library:1")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	group, err = GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	assert.Equal(t, "Fresh", group.PlaylistName)
+	assert.Equal(t, TaskStatusPaused, group.Status)
+	assert.Equal(t, -1, group.MaxTracks)
+	assert.Equal(t, "daily 03:00", group.Recurrence)
+	assert.Nil(t, group.NextRunAt, "due once it finishes")
+	songs, err = GetSongTasks(int(group.ID))
+	require.NoError(t, err)
+	assert.Empty(t, songs)
+
+	_, err = ImportTasks(export, "overwrite")
+	assert.ErrorIs(t, err, ErrUnknownImportMode)
+}
+
+func TestTaskImportReplaceIsAtomic(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, AddGroupTask(TaskTypePlaylistDownload, "PL1", "Playlist", TaskSourceUser, -1))
+	require.NoError(t, AddSongTask(1, "a", "Shared", "Artist", "", ""))
+	require.NoError(t, MarkSongTaskLinked(1, "a", "Playlist/a.mp3", "hash", ".beatbump-tracks/mp3/a.mp3"))
+	require.NoError(t, DB.Exec("CREATE TRIGGER broken_import BEFORE INSERT ON group_tasks WHEN NEW.playlist_name = 'Broken' "+
+		"BEGIN SELECT RAISE(ABORT, 'broken'); END").Error)
+
+	// A failed replace keeps the queued task
+	results, err := ImportTasks(&TaskExport{Version: 1, Tasks: []GroupTaskExport{
+		{Type: TaskTypePlaylistDownload, Reference: "PL1", Name: "Broken"},
+	}}, ImportReplace)
+	require.NoError(t, err)
+	assert.NotEmpty(t, results[0].Error)
+	song, err := GetSongTask(1, "a")
+	require.NoError(t, err)
+	assert.Equal(t, ".beatbump-tracks/mp3/a.mp3", song.TrackKey)
+
+	// The same file stays linked to the shared track
+	results, err = ImportTasks(&TaskExport{Version: 1, Tasks: []GroupTaskExport{
+		{Type: TaskTypePlaylistDownload, Reference: "PL1", Name: "Fresh", Tracks: []SongTaskExport{
+			{VideoID: "a", Title: "Shared", Status: TaskStatusCompleted, FilePath: "Playlist/a.mp3"},
+		}},
+	}}, ImportReplace)
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", results[0].Action)
+	group, err := GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	song, err = GetSongTask(int(group.ID), "a")
+	require.NoError(t, err)
+	assert.Equal(t, ".beatbump-tracks/mp3/a.mp3", song.TrackKey)
+	ids, _ := searchIDs(t, SearchQuery{Text: "shared"})
+	assert.Equal(t, []string{"a"}, ids)
+}
+
+func TestTaskImportNeedsTracksOfWorkerlessTypes(t *testing.T) {
+	setupTestDB(t)
+	results, err := ImportTasks(&TaskExport{Version: 1, Tasks: []GroupTaskExport{
+		{Type: TaskTypeOngoingDownload, Reference: "ongoing:songs:1"},
+		{Type: TaskTypeLibraryImport, Reference: ImportReferencePrefix + "Old", Tracks: []SongTaskExport{
+			{VideoID: "a", Status: TaskStatusCompleted, FilePath: "Old/a.mp3"},
+		}},
+	}}, ImportSkip)
+	require.NoError(t, err)
+	assert.NotEmpty(t, results[0].Error)
+	assert.Equal(t, "created", results[1].Action)
+
+	group, err := GetGroupTaskByReferenceID(ImportReferencePrefix + "Old")
+	require.NoError(t, err)
+	assert.Equal(t, TaskSourceSystem, group.Source, "the group worker cannot populate it")
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// StoredTrack is the canonical file of a video in one profile, shared by
+// every song task that downloaded the video when deduplication is enabled.
+type StoredTrack struct {
+	VideoID   string `gorm:"primaryKey"`
+	Profile   string `gorm:"primaryKey"`
+	Key       string `gorm:"uniqueIndex"`
+	FileHash  string
+	Size      int64
+	CreatedAt time.Time
+}
+
+func GetStoredTrack(videoID, profile string) (*StoredTrack, error) {
+	var track StoredTrack
+	err := DB.Where("video_id = ? AND profile = ?", videoID, profile).First(&track).Error
+	return &track, err
+}
+
+// SaveStoredTrack records a canonical file, replacing an older one of the
+// same video and profile.
+func SaveStoredTrack(track *StoredTrack) error {
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "video_id"}, {Name: "profile"}},
+		DoUpdates: clause.AssignmentColumns([]string{"key", "file_hash", "size"}),
+	}).Create(track).Error
+}
+
+func DeleteStoredTrack(key string) error {
+	return DB.Where("key = ?", key).Delete(&StoredTrack{}).Error
+}
+
+// CountTrackReferences returns the number of song tasks linked to the
+// canonical file at key.
+func CountTrackReferences(key string) (int64, error) {
+	var count int64
+	err := DB.Model(&SongTask{}).Where("track_key = ?", key).Count(&count).Error
+	return count, err
+}
+
+// MarkSongTaskLinked completes a track whose file links to the canonical
+// file at trackKey.
+func MarkSongTaskLinked(groupTaskID int, videoID, filePath, fileHash, trackKey string) error {
+	update := statusUpdate(TaskStatusCompleted)
+	update["file_path"] = filePath
+	update["file_hash"] = fileHash
+	update["track_key"] = trackKey
+	if err := DB.Model(&SongTask{}).
+		Where("group_task_id = ? AND video_id = ?", groupTaskID, videoID).
+		Updates(update).Error; err != nil {
+		return err
+	}
+	indexSongTask(groupTaskID, videoID)
+	return nil
+}
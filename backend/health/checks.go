@@ -0,0 +1,124 @@
+package health
+
+import (
+	ytapi "beatbump-server/backend/_youtube/api"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// RegisterDefaultChecks registers the checks for the dependencies every
+// instance has. The download worker registers its own liveness check.
+func RegisterDefaultChecks() {
+	Register("database", true, CheckDatabase)
+	Register("companion", true, CheckCompanion)
+	Register("ffmpeg", false, CheckFFmpeg)
+	Register("download_path", true, CheckDownloadPath)
+	Register("storage", true, CheckStorage)
+}
+
+// CheckDatabase pings the database and verifies all tables were migrated.
+func CheckDatabase(ctx context.Context) error {
+	if err := db.Ping(ctx); err != nil {
+		return err
+	}
+	return db.CheckSchema()
+}
+
+// CheckCompanion verifies the invidious companion answers HTTP requests.
+func CheckCompanion(ctx context.Context) error {
+	baseURL := ytapi.CompanionURL()
+	if baseURL == "" {
+		return errors.New("companion URL is not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("companion returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CheckFFmpeg is non-critical: without ffmpeg tracks are kept as m4a.
+func CheckFFmpeg(ctx context.Context) error {
+	if !utils.IsFFmpegAvailable() {
+		return errors.New("ffmpeg not found in PATH")
+	}
+	return nil
+}
+
+// CheckDownloadPath verifies the download directory is writable and has
+// enough free space for the worker to make progress.
+func CheckDownloadPath(ctx context.Context) error {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if !storage.IsLocal() {
+		return ErrSkipped{Reason: "tracks are stored in " + storage.Backend()}
+	}
+	if downloadPath == "" {
+		return ErrSkipped{Reason: "download path not configured"}
+	}
+
+	info, err := os.Stat(downloadPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", downloadPath)
+	}
+
+	probe, err := os.CreateTemp(downloadPath, ".beatbump-healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("download path is not writable: %w", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	freeSpace, err := utils.GetFreeDiskSpace(downloadPath)
+	if err != nil {
+		return fmt.Errorf("failed to check disk space: %w", err)
+	}
+	if freeSpace < utils.MinFreeDiskSpace {
+		return fmt.Errorf("insufficient disk space: %d bytes available, %d required", freeSpace, utils.MinFreeDiskSpace)
+	}
+	return nil
+}
+
+// CheckStorage verifies a remote storage backend answers requests and the
+// staging folder has room for tracks in progress.
+func CheckStorage(ctx context.Context) error {
+	if storage.IsLocal() {
+		return ErrSkipped{Reason: "local storage is covered by download_path"}
+	}
+
+	_, err := storage.Resolve("").Stat(ctx, ".beatbump-healthcheck")
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("%s storage: %w", storage.Backend(), err)
+	}
+
+	stagingDir := storage.StagingDir("")
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return fmt.Errorf("staging folder: %w", err)
+	}
+	freeSpace, err := utils.GetFreeDiskSpace(stagingDir)
+	if err != nil {
+		return fmt.Errorf("failed to check disk space: %w", err)
+	}
+	if freeSpace < utils.MinFreeDiskSpace {
+		return fmt.Errorf("insufficient disk space in staging folder: %d bytes available, %d required", freeSpace, utils.MinFreeDiskSpace)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+	StatusSkipped  = "skipped"
+)
+
+// ErrSkipped can be returned by a check that does not apply to the current
+// configuration, e.g. the download path check when downloads are disabled.
+type ErrSkipped struct {
+	Reason string
+}
+
+func (e ErrSkipped) Error() string {
+	return e.Reason
+}
+
+// CheckFunc returns nil when the dependency is healthy.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Critical   bool   `json:"critical"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Time   time.Time              `json:"time"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+var (
+	mu     sync.RWMutex
+	checks []check
+)
+
+// Register adds a readiness check. A failing critical check makes the
+// service unready, a failing non-critical check only degrades it.
+func Register(name string, critical bool, fn CheckFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i, c := range checks {
+		if c.name == name {
+			checks[i] = check{name: name, critical: critical, fn: fn}
+			return
+		}
+	}
+	checks = append(checks, check{name: name, critical: critical, fn: fn})
+}
+
+// Run executes all registered checks concurrently, each bounded by timeout.
+func Run(ctx context.Context, timeout time.Duration) Report {
+	mu.RLock()
+	registered := make([]check, len(checks))
+	copy(registered, checks)
+	mu.RUnlock()
+
+	report := Report{
+		Status: StatusOK,
+		Time:   time.Now(),
+		Checks: make(map[string]CheckResult, len(registered)),
+	}
+
+	var wg sync.WaitGroup
+	var resultsMu sync.Mutex
+	for _, c := range registered {
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.fn(checkCtx)
+			result := CheckResult{
+				Status:     StatusOK,
+				Critical:   c.critical,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if skipped, ok := err.(ErrSkipped); ok {
+				result.Status = StatusSkipped
+				result.Message = skipped.Reason
+			} else if err != nil {
+				result.Status = StatusFail
+				result.Message = err.Error()
+			}
+
+			resultsMu.Lock()
+			report.Checks[c.name] = result
+			resultsMu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusFail {
+			continue
+		}
+		if result.Critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
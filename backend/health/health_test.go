@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetChecks() {
+	mu.Lock()
+	checks = nil
+	mu.Unlock()
+}
+
+func TestRunAggregatesStatus(t *testing.T) {
+	resetChecks()
+	Register("ok", true, func(ctx context.Context) error { return nil })
+	Register("optional", false, func(ctx context.Context) error { return errors.New("missing") })
+	Register("skipped", true, func(ctx context.Context) error { return ErrSkipped{Reason: "not configured"} })
+
+	report := Run(context.Background(), time.Second)
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["ok"].Status)
+	assert.Equal(t, StatusFail, report.Checks["optional"].Status)
+	assert.Equal(t, "missing", report.Checks["optional"].Message)
+	assert.Equal(t, StatusSkipped, report.Checks["skipped"].Status)
+
+	Register("critical", true, func(ctx context.Context) error { return errors.New("down") })
+	assert.Equal(t, StatusFail, Run(context.Background(), time.Second).Status)
+}
+
+func TestRunTimesOutSlowChecks(t *testing.T) {
+	resetChecks()
+	Register("slow", true, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := Run(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Contains(t, report.Checks["slow"].Message, "deadline exceeded")
+}
+
+func TestRegisterReplacesExistingCheck(t *testing.T) {
+	resetChecks()
+	Register("db", true, func(ctx context.Context) error { return errors.New("down") })
+	Register("db", true, func(ctx context.Context) error { return nil })
+
+	assert.Equal(t, StatusOK, Run(context.Background(), time.Second).Status)
+}
@@ -0,0 +1,32 @@
+// Package dbtest sets up the database for the tests of other packages.
+package dbtest
+
+import (
+	"beatbump-server/backend/db"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open points db.DB at a new in-memory SQLite database with every migration
+// applied.
+func Open(t testing.TB) {
+	t.Helper()
+	var err error
+	db.DB, err = gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	require.NoError(t, err)
+
+	// Every connection to :memory: is a separate database
+	sqlDB, err := db.DB.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	_, err = db.Migrate()
+	require.NoError(t, err)
+}
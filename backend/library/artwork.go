@@ -0,0 +1,160 @@
+package library
+
+import (
+	"beatbump-server/backend/artwork"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Names of the folder cover, by image type
+const (
+	FolderCoverJPEG = "cover.jpg"
+	FolderCoverPNG  = "cover.png"
+)
+
+// ArtworkResult is the outcome of an artwork refresh for one track.
+type ArtworkResult struct {
+	VideoID string `json:"videoId"`
+	Updated bool   `json:"updated"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RefreshArtwork fetches the artwork of every completed track of a group
+// task again, with the current artwork options, and embeds it into the
+// tracks where it changed. The folder cover follows. A failed track does
+// not stop the others.
+func RefreshArtwork(ctx context.Context, groupTaskID int) ([]ArtworkResult, error) {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return nil, ErrNoDownloadPath
+	}
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
+		return nil, err
+	}
+	opts := artwork.CurrentOptions()
+
+	results := []ArtworkResult{}
+	changed := false
+	for i := range songs {
+		song := &songs[i]
+		if song.Status != db.TaskStatusCompleted {
+			continue
+		}
+		url := song.ArtworkURL
+		if url == "" {
+			// Downloaded before the artwork was recorded
+			url = song.ThumbnailURL
+		}
+		if url == "" {
+			continue
+		}
+		result := ArtworkResult{VideoID: song.VideoID}
+		updated, err := embedArtwork(ctx, song, url, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			result.Error = err.Error()
+		}
+		result.Updated = updated
+		changed = changed || updated
+		results = append(results, result)
+	}
+
+	if changed {
+		if err := UpdateFolderCover(ctx, groupTaskID); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// embedArtwork embeds the artwork at url into the stored file of song
+// unless the file already holds the same image.
+func embedArtwork(ctx context.Context, song *db.SongTask, url string, opts artwork.Options) (bool, error) {
+	f, err := os.CreateTemp("", "beatbump_cover_*")
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := artwork.Fetch(url, f.Name(), opts); err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return false, err
+	}
+	hash := artwork.Hash(data)
+	if hash == song.ArtworkHash {
+		return false, nil
+	}
+
+	cover := &tags.Picture{MIMEType: tags.DetectImageType(data), Data: data}
+	if _, err := updateTags(ctx, song, func(t *tags.Tags) { t.Cover = cover }); err != nil {
+		return false, err
+	}
+	return true, db.SetSongTaskArtwork(int(song.GroupTaskID), song.VideoID, song.TrackKey, url, hash)
+}
+
+// UpdateFolderCover writes the folder cover of a group task when the
+// folder cover setting is on.
+func UpdateFolderCover(ctx context.Context, groupTaskID int) error {
+	if enabled, _ := db.GetSetting(db.FolderCoverSetting); enabled != "true" {
+		return nil
+	}
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return ErrNoDownloadPath
+	}
+	groupTask, err := db.GetGroupTask(groupTaskID)
+	if err != nil {
+		return err
+	}
+	_, folder, _ := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	return WriteFolderCover(ctx, storage.Resolve(downloadPath), groupTaskID, folder)
+}
+
+// WriteFolderCover writes the artwork of the first track of a group task
+// that has one into folder, as cover.jpg or cover.png. An existing cover of
+// the other type is removed.
+func WriteFolderCover(ctx context.Context, store storage.Storage, groupTaskID int, folder string) error {
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
+		return err
+	}
+	for i := range songs {
+		song := &songs[i]
+		if song.Status != db.TaskStatusCompleted {
+			continue
+		}
+		t, err := ReadTags(ctx, song)
+		if errors.Is(err, ErrNotStored) || errors.Is(err, tags.ErrUnsupported) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if t.Cover == nil {
+			continue
+		}
+
+		name, other := FolderCoverJPEG, FolderCoverPNG
+		if t.Cover.MIMEType == "image/png" {
+			name, other = FolderCoverPNG, FolderCoverJPEG
+		}
+		key := storage.Key(filepath.Join(folder, name))
+		if err := store.Put(ctx, key, bytes.NewReader(t.Cover.Data), int64(len(t.Cover.Data))); err != nil {
+			return err
+		}
+		return store.Delete(ctx, storage.Key(filepath.Join(folder, other)))
+	}
+	return nil
+}
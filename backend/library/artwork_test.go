@@ -0,0 +1,60 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshArtwork(t *testing.T) {
+	root := setupTestDB(t)
+	require.NoError(t, db.SetSetting(db.FolderCoverSetting, "true"))
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+
+	image := []byte("\x89PNG artwork")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(image)
+	}))
+	defer server.Close()
+	for _, id := range []string{"a", "b"} {
+		writeFile(t, root, "Playlist/"+id+".mp3", mp3WithVideoID(id, "Title "+id))
+		addStoredTrack(t, groupID, id, filepath.FromSlash("Playlist/"+id+".mp3"), "")
+		require.NoError(t, db.SetSongTaskArtwork(groupID, id, "", server.URL+"/"+id+".jpg", ""))
+	}
+
+	ctx := context.Background()
+	results, err := RefreshArtwork(ctx, groupID)
+	require.NoError(t, err)
+	assert.Equal(t, []ArtworkResult{{VideoID: "a", Updated: true}, {VideoID: "b", Updated: true}}, results)
+	song, err := db.GetSongTask(groupID, "a")
+	require.NoError(t, err)
+	got, err := ReadTags(ctx, song)
+	require.NoError(t, err)
+	require.NotNil(t, got.Cover)
+	assert.Equal(t, image, got.Cover.Data)
+	cover, err := os.ReadFile(filepath.Join(root, "Playlist", FolderCoverPNG))
+	require.NoError(t, err)
+	assert.Equal(t, image, cover)
+
+	// Unchanged artwork is not embedded again
+	results, err = RefreshArtwork(ctx, groupID)
+	require.NoError(t, err)
+	assert.Equal(t, []ArtworkResult{{VideoID: "a"}, {VideoID: "b"}}, results)
+
+	image = []byte("\xff\xd8\xff new artwork")
+	results, err = RefreshArtwork(ctx, groupID)
+	require.NoError(t, err)
+	assert.True(t, results[0].Updated)
+	assert.FileExists(t, filepath.Join(root, "Playlist", FolderCoverJPEG))
+	assert.NoFileExists(t, filepath.Join(root, "Playlist", FolderCoverPNG))
+}
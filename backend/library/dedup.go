@@ -0,0 +1,113 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// TrackStoreFolder holds the canonical files of deduplicated tracks, one per
+// video and profile. Task folders link to them, see storage.DedupMode.
+const TrackStoreFolder = ".beatbump-tracks"
+
+// ProfileOf returns the profile of a finished track, its output format.
+func ProfileOf(localPath string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(localPath)), ".")
+}
+
+// TrackKey returns where the canonical file of a video is stored.
+func TrackKey(videoID, profile, ext string) string {
+	return path.Join(TrackStoreFolder, profile, videoID+ext)
+}
+
+// LookupTrack returns the canonical file of a video when it is still in the
+// storage.
+func LookupTrack(ctx context.Context, store storage.Storage, videoID, profile string) (*db.StoredTrack, bool) {
+	track, err := db.GetStoredTrack(videoID, profile)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := store.Stat(ctx, track.Key); err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			// Removed by hand, the next download stores it again
+			db.DeleteStoredTrack(track.Key)
+		}
+		return nil, false
+	}
+	return track, true
+}
+
+// StoreTrack moves a finished local file of profile into the track store.
+func StoreTrack(ctx context.Context, store storage.Storage, videoID, profile, localPath, hash string) (*db.StoredTrack, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	track := &db.StoredTrack{
+		VideoID:  videoID,
+		Profile:  profile,
+		Key:      TrackKey(videoID, profile, filepath.Ext(localPath)),
+		FileHash: hash,
+		Size:     info.Size(),
+	}
+	if err := storage.PutFile(ctx, store, track.Key, localPath); err != nil {
+		return nil, err
+	}
+	return track, db.SaveStoredTrack(track)
+}
+
+// LinkTrack makes a stored track part of a task folder. key is where the
+// file of the task would be without deduplication; the returned key is the
+// one the song task points at.
+func LinkTrack(ctx context.Context, store storage.Storage, track *db.StoredTrack, key string) (string, error) {
+	switch mode := storage.DedupMode(); mode {
+	case storage.DedupHardlink, storage.DedupSymlink:
+		if err := storage.Link(ctx, store, track.Key, key, mode == storage.DedupSymlink); err != nil {
+			return "", err
+		}
+		return key, nil
+	case storage.DedupM3U:
+		// The folder playlist references the shared file
+		return track.Key, nil
+	default:
+		return "", fmt.Errorf("deduplication is %s", mode)
+	}
+}
+
+// ReleaseTracks deletes the canonical files that no song task links to
+// anymore. Call it after the song tasks were deleted.
+func ReleaseTracks(ctx context.Context, store storage.Storage, keys ...string) error {
+	var errs []error
+	released := make(map[string]bool)
+	for _, key := range keys {
+		if key == "" || released[key] {
+			continue
+		}
+		released[key] = true
+
+		refs, err := db.CountTrackReferences(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := db.DeleteStoredTrack(key); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package library
+
+import (
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useDedup(t *testing.T, mode string) {
+	t.Helper()
+	require.NoError(t, storage.Configure(config.StorageConfig{Backend: "local", Dedup: mode}))
+	t.Cleanup(func() { storage.Configure(config.StorageConfig{}) })
+}
+
+func storeTestTrack(t *testing.T, store storage.Storage, videoID string) *db.StoredTrack {
+	t.Helper()
+	local := filepath.Join(t.TempDir(), "track.mp3")
+	require.NoError(t, os.WriteFile(local, []byte("audio of "+videoID), 0644))
+	stored, err := StoreTrack(context.Background(), store, videoID, ProfileOf(local), local, "hash")
+	require.NoError(t, err)
+	return stored
+}
+
+func TestStoreAndLinkTrack(t *testing.T) {
+	root := setupTestDB(t)
+	store := storage.Resolve(root)
+	ctx := context.Background()
+
+	stored := storeTestTrack(t, store, "abc")
+	assert.Equal(t, ".beatbump-tracks/mp3/abc.mp3", stored.Key)
+	found, ok := LookupTrack(ctx, store, "abc", "mp3")
+	require.True(t, ok)
+	assert.Equal(t, stored.Key, found.Key)
+	_, ok = LookupTrack(ctx, store, "abc", "m4a")
+	assert.False(t, ok)
+
+	canonical, err := os.Stat(filepath.Join(root, filepath.FromSlash(stored.Key)))
+	require.NoError(t, err)
+
+	useDedup(t, storage.DedupHardlink)
+	key, err := LinkTrack(ctx, store, stored, "Playlist/A - abc.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, "Playlist/A - abc.mp3", key)
+	linked, err := os.Stat(filepath.Join(root, "Playlist", "A - abc.mp3"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(canonical, linked))
+
+	useDedup(t, storage.DedupSymlink)
+	_, err = LinkTrack(ctx, store, stored, "Mix/A - abc.mp3")
+	require.NoError(t, err)
+	target, err := os.Readlink(filepath.Join(root, "Mix", "A - abc.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("../.beatbump-tracks/mp3/abc.mp3"), target)
+
+	useDedup(t, storage.DedupM3U)
+	key, err = LinkTrack(ctx, store, stored, "Other/A - abc.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, stored.Key, key)
+
+	// Removed by hand
+	require.NoError(t, os.Remove(filepath.Join(root, filepath.FromSlash(stored.Key))))
+	_, ok = LookupTrack(ctx, store, "abc", "mp3")
+	assert.False(t, ok)
+	_, err = db.GetStoredTrack("abc", "mp3")
+	assert.Error(t, err)
+}
+
+func TestReleaseTracksCountsReferences(t *testing.T) {
+	root := setupTestDB(t)
+	store := storage.Resolve(root)
+	ctx := context.Background()
+	stored := storeTestTrack(t, store, "abc")
+
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	require.NoError(t, db.AddGroupTask(db.TaskTypeSongMixDownload, "MIX1", "Mix", db.TaskSourceUser, 5))
+	for _, groupID := range []int{1, 2} {
+		require.NoError(t, db.AddSongTask(groupID, "abc", "abc", "A", "", ""))
+		require.NoError(t, db.MarkSongTaskLinked(groupID, "abc", stored.Key, stored.FileHash, stored.Key))
+	}
+
+	require.NoError(t, db.DeleteSongTask(1, "abc"))
+	require.NoError(t, ReleaseTracks(ctx, store, stored.Key))
+	_, err := store.Stat(ctx, stored.Key)
+	assert.NoError(t, err, "still used by the mix")
+
+	require.NoError(t, db.DeleteSongTask(2, "abc"))
+	require.NoError(t, ReleaseTracks(ctx, store, stored.Key, stored.Key, ""))
+	_, err = store.Stat(ctx, stored.Key)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = db.GetStoredTrack("abc", "mp3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/tags"
+	"context"
+	"errors"
+	"math"
+)
+
+// ErrLoudnessUnknown is returned for albums with a track whose loudness was
+// not measured.
+var ErrLoudnessUnknown = errors.New("loudness of a track is unknown")
+
+// AlbumLoudness combines the integrated loudness of the completed tracks of
+// an album into the loudness of the whole album, the energy mean weighted
+// by the track durations, and returns the largest peak. It approximates a
+// measurement over the concatenated tracks.
+func AlbumLoudness(songs []db.SongTask) (loudness, peak float64, err error) {
+	var energy, weight float64
+	for _, song := range songs {
+		if song.Status != db.TaskStatusCompleted {
+			continue
+		}
+		if song.Loudness == nil {
+			return 0, 0, ErrLoudnessUnknown
+		}
+		// Tracks of unknown length count as one second
+		w := float64(max(song.DurationMs, 1000))
+		energy += w * math.Pow(10, *song.Loudness/10)
+		weight += w
+		peak = max(peak, song.Peak)
+	}
+	if weight == 0 {
+		return 0, 0, ErrNotStored
+	}
+	return 10 * math.Log10(energy/weight), peak, nil
+}
+
+// TagAlbumGain writes the ReplayGain album gain into every completed track
+// of a group task. A deduplicated file shared with tracks of other groups
+// is skipped, it cannot hold the album gain of each of them. A failed track
+// does not stop the others.
+func TagAlbumGain(ctx context.Context, groupTaskID int) error {
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
+		return err
+	}
+	loudness, peak, err := AlbumLoudness(songs)
+	if err != nil {
+		return err
+	}
+	gain := tags.GainFor(loudness, peak)
+
+	var errs []error
+	for i := range songs {
+		song := &songs[i]
+		if song.Status != db.TaskStatusCompleted {
+			continue
+		}
+		if song.TrackKey != "" {
+			references, err := db.CountTrackReferences(song.TrackKey)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if references > 1 {
+				continue
+			}
+		}
+		_, err := updateTags(ctx, song, func(t *tags.Tags) { t.AlbumGain = gain })
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
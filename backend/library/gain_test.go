@@ -0,0 +1,43 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/tags"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagAlbumGain(t *testing.T) {
+	root := setupTestDB(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	for _, id := range []string{"a", "b"} {
+		writeFile(t, root, "Playlist/"+id+".mp3", mp3WithVideoID(id, "Title "+id))
+		addStoredTrack(t, groupID, id, filepath.FromSlash("Playlist/"+id+".mp3"), "")
+	}
+	require.NoError(t, db.AddSongTask(groupID, "pending", "Pending", "Artist", "", ""))
+
+	ctx := context.Background()
+	assert.ErrorIs(t, TagAlbumGain(ctx, groupID), ErrLoudnessUnknown)
+
+	loud, quiet := -10.0, -20.0
+	require.NoError(t, db.SetSongTaskLoudness(groupID, "a", 180000, &loud, 0.9))
+	require.NoError(t, db.SetSongTaskLoudness(groupID, "b", 180000, &quiet, 0.5))
+	require.NoError(t, TagAlbumGain(ctx, groupID))
+
+	for _, id := range []string{"a", "b"} {
+		song, err := db.GetSongTask(groupID, id)
+		require.NoError(t, err)
+		got, err := ReadTags(ctx, song)
+		require.NoError(t, err)
+		// The energy mean of -10 and -20 LUFS is -12.6 LUFS
+		assert.Equal(t, &tags.Gain{Gain: -5.4, Peak: 0.9}, got.AlbumGain)
+		assert.Equal(t, "Title "+id, got.Title)
+	}
+}
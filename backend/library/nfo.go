@@ -0,0 +1,177 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/nfo"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"bytes"
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AlbumNFO builds the album.nfo of a group task from the tags of its
+// completed tracks. Tracks whose tags cannot be read use the metadata of
+// their song task.
+func AlbumNFO(ctx context.Context, groupTaskID int) (*nfo.Album, error) {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	groupTask, err := db.GetGroupTask(groupTaskID)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
+		return nil, err
+	}
+	_, _, title := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+
+	var (
+		albums, artists, albumArtists, years []string
+		albumIDs, groupIDs, albumArtistIDs   []string
+		genres                               []string
+	)
+	album := &nfo.Album{}
+	for i := range songs {
+		song := &songs[i]
+		if song.Status != db.TaskStatusCompleted {
+			continue
+		}
+		t, err := ReadTags(ctx, song)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			t = &tags.Tags{Title: song.Title, Artist: song.Artist, Album: song.Album}
+		}
+
+		position := t.Track
+		if position <= 0 {
+			position = len(album.Tracks) + 1
+		}
+		album.Tracks = append(album.Tracks, nfo.Track{
+			Position:           position,
+			Title:              t.Title,
+			Duration:           nfo.Duration(song.DurationMs),
+			MusicBrainzTrackID: t.MusicBrainz.TrackID,
+		})
+		albums = append(albums, t.Album)
+		artists = append(artists, t.Artist)
+		albumArtists = append(albumArtists, t.AlbumArtist)
+		if len(t.Year) >= 4 {
+			years = append(years, t.Year[:4])
+		}
+		albumIDs = append(albumIDs, t.MusicBrainz.AlbumID)
+		groupIDs = append(groupIDs, t.MusicBrainz.ReleaseGroupID)
+		albumArtistIDs = append(albumArtistIDs, t.MusicBrainz.AlbumArtistID)
+		if t.Genre != "" && !containsFold(genres, t.Genre) {
+			genres = append(genres, t.Genre)
+		}
+	}
+	sort.SliceStable(album.Tracks, func(i, j int) bool {
+		return album.Tracks[i].Position < album.Tracks[j].Position
+	})
+
+	// A playlist of one album is named after it
+	album.Title = shared(albums)
+	if album.Title == "" {
+		album.Title = title
+	}
+	album.AlbumArtist = shared(albumArtists)
+	if album.AlbumArtist == "" {
+		album.AlbumArtist = shared(artists)
+	}
+	album.AlbumArtistCredits = &nfo.AlbumArtistCredits{MusicBrainzArtistID: shared(albumArtistIDs)}
+	if album.AlbumArtist == "" {
+		album.AlbumArtist = nfo.VariousArtists
+		album.Compilation = true
+		// The IDs name the artist of a single track
+		album.AlbumArtistCredits.MusicBrainzArtistID = ""
+	}
+	album.Artist = album.AlbumArtist
+	album.AlbumArtistCredits.Artist = album.AlbumArtist
+	album.Year = mostCommon(years)
+	album.Genres = genres
+	album.MusicBrainzAlbumID = shared(albumIDs)
+	album.MusicBrainzReleaseGroupID = shared(groupIDs)
+	return album, nil
+}
+
+// shared returns the value every non-empty value equals, empty when they
+// differ or there is none.
+func shared(values []string) string {
+	common := ""
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if common != "" && value != common {
+			return ""
+		}
+		common = value
+	}
+	return common
+}
+
+// mostCommon returns the most frequent value, the first one on a tie.
+func mostCommon(values []string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, value := range values {
+		counts[value]++
+		if counts[value] > counts[best] {
+			best = value
+		}
+	}
+	return best
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteAlbumNFO writes the album.nfo of a group task into folder and
+// returns its content.
+func WriteAlbumNFO(ctx context.Context, store storage.Storage, groupTaskID int, folder string) (*nfo.Album, error) {
+	album, err := AlbumNFO(ctx, groupTaskID)
+	if err != nil {
+		return nil, err
+	}
+	return album, WriteNFO(ctx, store, filepath.Join(folder, nfo.AlbumFile), album)
+}
+
+// WriteNFO writes an nfo.Album or nfo.Artist to the file at name.
+func WriteNFO(ctx context.Context, store storage.Storage, name string, v interface{}) error {
+	data, err := nfo.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, storage.Key(name), bytes.NewReader(data), int64(len(data)))
+}
+
+// UpdateAlbumNFO writes the album.nfo of a completed group task again,
+// after the tags of its tracks changed. Unfinished tasks get theirs when
+// they complete.
+func UpdateAlbumNFO(ctx context.Context, groupTaskID int) error {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return ErrNoDownloadPath
+	}
+	groupTask, err := db.GetGroupTask(groupTaskID)
+	if err != nil {
+		return err
+	}
+	if groupTask.Status != db.TaskStatusCompleted {
+		return nil
+	}
+	_, folder, _ := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	_, err = WriteAlbumNFO(ctx, storage.Resolve(downloadPath), groupTaskID, folder)
+	return err
+}
@@ -0,0 +1,70 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/nfo"
+	"beatbump-server/backend/tags"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlbumNFO(t *testing.T) {
+	root := setupTestDB(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	for i, id := range []string{"a", "b"} {
+		path := filepath.Join(root, "Playlist", id+".mp3")
+		writeFile(t, root, "Playlist/"+id+".mp3", mp3WithVideoID(id, "Title "+id))
+		require.NoError(t, tags.UpdateFile(path, func(t *tags.Tags) {
+			t.Artist, t.Album, t.Year, t.Genre = "Daft Punk", "Homework", "1997-01-17", "House"
+			t.Track = 2 - i
+			t.MusicBrainz = tags.MusicBrainzIDs{TrackID: "rec-" + id, AlbumID: "release-1", AlbumArtistID: "artist-1"}
+		}))
+		addStoredTrack(t, groupID, id, filepath.FromSlash("Playlist/"+id+".mp3"), "")
+	}
+	require.NoError(t, db.SetSongTaskDuration(groupID, "a", 245000))
+	require.NoError(t, db.AddSongTask(groupID, "pending", "Pending", "Other", "", ""))
+
+	ctx := context.Background()
+	album, err := AlbumNFO(ctx, groupID)
+	require.NoError(t, err)
+	assert.Equal(t, &nfo.Album{
+		Title:              "Homework",
+		MusicBrainzAlbumID: "release-1",
+		Artist:             "Daft Punk",
+		AlbumArtist:        "Daft Punk",
+		AlbumArtistCredits: &nfo.AlbumArtistCredits{Artist: "Daft Punk", MusicBrainzArtistID: "artist-1"},
+		Genres:             []string{"House"},
+		Year:               "1997",
+		Tracks: []nfo.Track{
+			{Position: 1, Title: "Title b", MusicBrainzTrackID: "rec-b"},
+			{Position: 2, Title: "Title a", Duration: "4:05", MusicBrainzTrackID: "rec-a"},
+		},
+	}, album)
+
+	// Written once the task completes, then on every edit
+	artist := "Guest"
+	song, err := db.GetSongTask(groupID, "b")
+	require.NoError(t, err)
+	_, err = EditTags(ctx, song, &TagEdit{Artist: &artist})
+	require.NoError(t, err)
+	nfoPath := filepath.Join(root, "Playlist", nfo.AlbumFile)
+	assert.NoFileExists(t, nfoPath)
+
+	require.NoError(t, db.UpdateGroupTaskStatus(groupID, db.TaskStatusCompleted))
+	artist = "Guest & Friends"
+	_, err = EditTags(ctx, song, &TagEdit{Artist: &artist})
+	require.NoError(t, err)
+	content, err := os.ReadFile(nfoPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "<albumartist>Various Artists</albumartist>")
+	assert.Contains(t, string(content), "<compilation>true</compilation>")
+	assert.NotContains(t, string(content), "musicBrainzArtistID")
+}
@@ -0,0 +1,187 @@
+package library
+
+import (
+	"beatbump-server/backend/artwork"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// ErrNotStored is returned for a track that has no file in the library.
+var ErrNotStored = errors.New("track has no stored file")
+
+// RetagTrack rewrites the tags of the stored file of song with meta and
+// embeds the artwork at meta.ArtworkURL when it is set. The album.nfo of
+// the tasks sharing the file follows.
+func RetagTrack(ctx context.Context, song *db.SongTask, meta utils.AudioMetadata) error {
+	groups, err := linkedGroups(song)
+	if err != nil {
+		return err
+	}
+	var cover *tags.Picture
+	if meta.ArtworkURL != "" {
+		// Without it the embedded cover is kept
+		if data, err := fetchCover(meta.ArtworkURL); err == nil {
+			cover = &tags.Picture{MIMEType: tags.DetectImageType(data), Data: data}
+		}
+	}
+	_, err = updateTags(ctx, song, func(t *tags.Tags) {
+		t.Title, t.Artist, t.Album = meta.Title, meta.Artist, meta.Album
+		if meta.Year != "" {
+			t.Year = meta.Year
+		}
+		if meta.Genre != "" {
+			t.Genre = meta.Genre
+		}
+		// IDs of an earlier match would describe another release
+		t.MusicBrainz = meta.MusicBrainz
+		if cover != nil {
+			t.Cover = cover
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if cover != nil {
+		if err := db.SetSongTaskArtwork(int(song.GroupTaskID), song.VideoID, song.TrackKey, meta.ArtworkURL, artwork.Hash(cover.Data)); err != nil {
+			return err
+		}
+	}
+	return updateFolders(ctx, groups, cover != nil)
+}
+
+// linkedGroups returns the group tasks sharing the stored file of song, the
+// task of song first.
+func linkedGroups(song *db.SongTask) ([]int, error) {
+	groups := []int{int(song.GroupTaskID)}
+	if song.TrackKey == "" {
+		return groups, nil
+	}
+	songs, err := db.GetLinkedSongTasks(song.TrackKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, linked := range songs {
+		if id := int(linked.GroupTaskID); !slices.Contains(groups, id) {
+			groups = append(groups, id)
+		}
+	}
+	return groups, nil
+}
+
+// updateFolders writes the album.nfo and, when the cover changed, the
+// folder cover of group tasks again.
+func updateFolders(ctx context.Context, groups []int, coverChanged bool) error {
+	for _, id := range groups {
+		if coverChanged {
+			if err := UpdateFolderCover(ctx, id); err != nil {
+				return err
+			}
+		}
+		if err := UpdateAlbumNFO(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteTrack replaces the stored file of song with the file rewrite makes
+// from a local copy of it. A deduplicated track is rewritten once and
+// linked into every task folder again.
+func rewriteTrack(ctx context.Context, song *db.SongTask, rewrite func(input, output string) error) error {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return ErrNoDownloadPath
+	}
+	if song.Status != db.TaskStatusCompleted || song.FilePath == "" {
+		return ErrNotStored
+	}
+	store := storage.Resolve(downloadPath)
+	key := trackKey(song)
+
+	stagingDir := storage.StagingDir(downloadPath)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(stagingDir, "retag-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	input := filepath.Join(workDir, "original"+path.Ext(key))
+	if err := fetch(ctx, store, key, input); err != nil {
+		return err
+	}
+	output := filepath.Join(workDir, "retagged"+path.Ext(key))
+	if err := rewrite(input, output); err != nil {
+		return err
+	}
+	hash, err := HashFile(output)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.PutFile(ctx, store, key, output); err != nil {
+		return err
+	}
+	if song.TrackKey != "" && storage.DedupMode() == storage.DedupHardlink {
+		// The hard links still point at the old file
+		if err := relink(ctx, store, song.TrackKey); err != nil {
+			return err
+		}
+	}
+	return db.UpdateFileHash(int(song.GroupTaskID), song.VideoID, song.TrackKey, hash)
+}
+
+// trackKey returns the key of the file holding the audio of song, the
+// canonical file of a deduplicated track.
+func trackKey(song *db.SongTask) string {
+	if song.TrackKey != "" {
+		return song.TrackKey
+	}
+	return storage.Key(song.FilePath)
+}
+
+// fetch copies the object at key to the local file at localPath.
+func fetch(ctx context.Context, store storage.Storage, key, localPath string) error {
+	r, err := store.Get(ctx, key, 0, -1)
+	if errors.Is(err, storage.ErrNotExist) {
+		return ErrNotStored
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func relink(ctx context.Context, store storage.Storage, trackKey string) error {
+	songs, err := db.GetLinkedSongTasks(trackKey)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, song := range songs {
+		if key := storage.Key(song.FilePath); key != trackKey {
+			errs = append(errs, storage.Link(ctx, store, trackKey, key, false))
+		}
+	}
+	return errors.Join(errs...)
+}
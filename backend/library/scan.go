@@ -0,0 +1,455 @@
+// Package library keeps the stored tracks and the song tasks in sync.
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What happens to tracks whose file is gone
+const (
+	MissingMark    = "mark"
+	MissingRequeue = "requeue"
+)
+
+// ImportedIDPrefix marks the video ID of an imported file without a
+// YOUTUBE_VIDEO_ID tag, such tracks cannot be downloaded again.
+const ImportedIDPrefix = "file:"
+
+var (
+	ErrScanRunning    = errors.New("a library scan is already running")
+	ErrNoDownloadPath = errors.New("download path not configured")
+)
+
+// audioExtensions are the files the scanner looks at.
+var audioExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".mp4": true, ".aac": true,
+	".opus": true, ".ogg": true, ".flac": true,
+}
+
+type ScanOptions struct {
+	// MissingAction is MissingMark (default) or MissingRequeue
+	MissingAction string `json:"missingAction"`
+	// DryRun only reports what would change
+	DryRun bool `json:"dryRun"`
+}
+
+// ScanEntry is one reconciled track or file.
+type ScanEntry struct {
+	GroupTaskID uint   `json:"groupTaskId,omitempty"`
+	VideoID     string `json:"videoId,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	Path        string `json:"path"`
+	OldPath     string `json:"oldPath,omitempty"`
+	MatchedBy   string `json:"matchedBy,omitempty"` // video_id or hash
+	Action      string `json:"action,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ScanReport lists what a scan found and did.
+type ScanReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DryRun     bool      `json:"dryRun"`
+	Backend    string    `json:"backend"`
+	Files      int       `json:"files"`
+	Matched    int       `json:"matched"`
+	// Tracks marked missing by an earlier scan whose file is back
+	Restored []ScanEntry `json:"restored"`
+	Moved    []ScanEntry `json:"moved"`
+	Missing  []ScanEntry `json:"missing"`
+	Imported []ScanEntry `json:"imported"`
+	Errors   []ScanEntry `json:"errors"`
+	Error    string      `json:"error,omitempty"`
+}
+
+var (
+	scanMu     sync.Mutex
+	scanning   bool
+	lastReport *ScanReport
+)
+
+// StartScan runs Scan in the background, one scan at a time.
+func StartScan(opts ScanOptions) error {
+	if err := beginScan(); err != nil {
+		return err
+	}
+	go func() {
+		finishScan(Scan(context.Background(), opts))
+	}()
+	return nil
+}
+
+// RunScan runs Scan in the caller, one scan at a time.
+func RunScan(ctx context.Context, opts ScanOptions) (*ScanReport, error) {
+	if err := beginScan(); err != nil {
+		return nil, err
+	}
+	report, err := Scan(ctx, opts)
+	finishScan(report, err)
+	return report, err
+}
+
+func beginScan() error {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+	if scanning {
+		return ErrScanRunning
+	}
+	scanning = true
+	return nil
+}
+
+func finishScan(report *ScanReport, err error) {
+	if err != nil {
+		logging.Log.Error().Err(err).Msg("Library scan failed")
+		report.Error = err.Error()
+	}
+	scanMu.Lock()
+	defer scanMu.Unlock()
+	scanning, lastReport = false, report
+}
+
+// ScanStatus reports whether a scan is running and the result of the last
+// finished one, nil before the first scan.
+func ScanStatus() (bool, *ScanReport) {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+	return scanning, lastReport
+}
+
+// Scan walks the library and reconciles it with the song tasks:
+//   - tracks whose file is gone are marked missing or queued again
+//   - files no track points at are matched to missing tracks by their
+//     YOUTUBE_VIDEO_ID tag or hash (moved), or else imported from their tags
+func Scan(ctx context.Context, opts ScanOptions) (*ScanReport, error) {
+	report := &ScanReport{
+		StartedAt: time.Now(),
+		DryRun:    opts.DryRun,
+		Backend:   storage.Backend(),
+		Restored:  []ScanEntry{},
+		Moved:     []ScanEntry{},
+		Missing:   []ScanEntry{},
+		Imported:  []ScanEntry{},
+		Errors:    []ScanEntry{},
+	}
+	defer func() { report.FinishedAt = time.Now() }()
+
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if storage.IsLocal() && downloadPath == "" {
+		return report, ErrNoDownloadPath
+	}
+	store := storage.Resolve(downloadPath)
+
+	objects, err := store.List(ctx, "")
+	if err != nil {
+		return report, fmt.Errorf("listing library: %w", err)
+	}
+	files := make(map[string]storage.Object)
+	for _, object := range objects {
+		if audioExtensions[strings.ToLower(path.Ext(object.Key))] {
+			files[object.Key] = object
+		}
+	}
+	report.Files = len(files)
+
+	tasks, err := db.GetStoredSongTasks()
+	if err != nil {
+		return report, err
+	}
+
+	// 1. Tracks whose file is where the DB says
+	referenced := make(map[string]bool)
+	stored := make(map[string]string) // group/video -> key
+	var missing []*db.SongTask
+	for i := range tasks {
+		task := &tasks[i]
+		key := storage.Key(task.FilePath)
+		if _, ok := files[key]; !ok {
+			missing = append(missing, task)
+			continue
+		}
+		referenced[key] = true
+		stored[trackID(task.GroupTaskID, task.VideoID)] = key
+		report.Matched++
+		if task.Status == db.TaskStatusMissing {
+			entry := newEntry(task, key)
+			entry.Action = "restored"
+			if !opts.DryRun {
+				if err := db.MarkSongTaskCompleted(int(task.GroupTaskID), task.VideoID, task.FilePath, task.FileHash); err != nil {
+					entry.Error = err.Error()
+				}
+			}
+			report.Restored = append(report.Restored, entry)
+		}
+	}
+
+	byVideoID := make(map[string][]*db.SongTask)
+	byHash := make(map[string][]*db.SongTask)
+	for _, task := range missing {
+		byVideoID[task.VideoID] = append(byVideoID[task.VideoID], task)
+		if task.FileHash != "" {
+			byHash[task.FileHash] = append(byHash[task.FileHash], task)
+		}
+	}
+	relinked := make(map[*db.SongTask]bool)
+	claim := func(candidates []*db.SongTask) *db.SongTask {
+		for _, task := range candidates {
+			if !relinked[task] {
+				relinked[task] = true
+				return task
+			}
+		}
+		return nil
+	}
+
+	folders, err := folderGroups()
+	if err != nil {
+		return report, err
+	}
+
+	// 2. Files no track points at, moved or new
+	var orphans []string
+	for key := range files {
+		// Shared tracks are owned by the track store, see ReleaseTracks
+		if !referenced[key] && !strings.HasPrefix(key, TrackStoreFolder+"/") {
+			orphans = append(orphans, key)
+		}
+	}
+	sort.Strings(orphans)
+
+	for _, key := range orphans {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		fileTags, hash, err := identify(ctx, store, files[key])
+		if err != nil {
+			report.Errors = append(report.Errors, ScanEntry{Path: key, Error: err.Error()})
+			continue
+		}
+
+		matchedBy := "video_id"
+		task := claim(byVideoID[fileTags.VideoID])
+		if task == nil {
+			matchedBy = "hash"
+			task = claim(byHash[hash])
+		}
+		if task != nil {
+			entry := newEntry(task, key)
+			entry.OldPath = storage.Key(task.FilePath)
+			entry.MatchedBy = matchedBy
+			entry.Action = "relinked"
+			if !opts.DryRun {
+				if err := db.MarkSongTaskCompleted(int(task.GroupTaskID), task.VideoID, filepath.FromSlash(key), hash); err != nil {
+					entry.Error = err.Error()
+				}
+			}
+			report.Moved = append(report.Moved, entry)
+			continue
+		}
+
+		entry := importEntry(key, fileTags, hash)
+		folder := path.Dir(key)
+		if folder == "." {
+			folder = ""
+		}
+		if groupID, ok := folders[folder]; ok {
+			entry.GroupTaskID = groupID
+			if existing, ok := stored[trackID(groupID, entry.VideoID)]; ok {
+				entry.Error = "duplicate of " + existing
+				report.Errors = append(report.Errors, entry)
+				continue
+			}
+		}
+		entry.Action = "imported"
+		if !opts.DryRun {
+			if err := importFile(&entry, folder, hash); err != nil {
+				entry.Error = err.Error()
+			} else {
+				folders[folder] = entry.GroupTaskID
+				stored[trackID(entry.GroupTaskID, entry.VideoID)] = key
+			}
+		}
+		report.Imported = append(report.Imported, entry)
+	}
+
+	// 3. Tracks whose file is gone for good
+	for _, task := range missing {
+		if relinked[task] {
+			continue
+		}
+		entry := newEntry(task, storage.Key(task.FilePath))
+		requeue := opts.MissingAction == MissingRequeue && !strings.HasPrefix(task.VideoID, ImportedIDPrefix)
+		switch {
+		case requeue:
+			entry.Action = "requeued"
+			if !opts.DryRun {
+				err = db.RequeueMissingSongTask(int(task.GroupTaskID), task.VideoID)
+			}
+		case task.Status == db.TaskStatusMissing:
+			entry.Action = "already_missing"
+		default:
+			entry.Action = "marked"
+			if !opts.DryRun {
+				err = db.UpdateSongTaskStatus(int(task.GroupTaskID), task.VideoID, db.TaskStatusMissing)
+			}
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			err = nil
+		}
+		report.Missing = append(report.Missing, entry)
+	}
+
+	logging.Log.Info().
+		Bool("dry_run", opts.DryRun).
+		Int("files", report.Files).
+		Int("matched", report.Matched).
+		Int("moved", len(report.Moved)).
+		Int("missing", len(report.Missing)).
+		Int("imported", len(report.Imported)).
+		Int("errors", len(report.Errors)).
+		Msg("Library scan finished")
+	return report, nil
+}
+
+// HashFile returns the hex SHA-256 of a local file.
+func HashFile(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// identify reads the tags and hash of a stored file.
+func identify(ctx context.Context, store storage.Storage, object storage.Object) (*tags.Tags, string, error) {
+	r := storage.NewReadSeeker(ctx, store, object)
+	defer r.Close()
+
+	// Broken tags do not stop the hash from matching the file
+	fileTags, err := tags.Read(r, object.Key)
+	if err != nil {
+		if !errors.Is(err, tags.ErrUnsupported) {
+			logging.Log.Debug().Err(err).Str("key", object.Key).Msg("Failed to read tags")
+		}
+		fileTags = &tags.Tags{}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	hash, err := hashReader(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("hashing: %w", err)
+	}
+	return fileTags, hash, nil
+}
+
+// folderGroups maps the folders of the group tasks to their IDs, files
+// found in a group's folder are imported into that group.
+func folderGroups() (map[string]uint, error) {
+	groups, err := db.GetAllGroupTasks()
+	if err != nil {
+		return nil, err
+	}
+	folders := make(map[string]uint)
+	for i := range groups {
+		_, folder, _ := utils.ResolveDownloadDirectory(&groups[i], "")
+		key := storage.Key(folder)
+		if key == "" && groups[i].Type != db.TaskTypeLibraryImport {
+			// Only the import group owns the library root
+			continue
+		}
+		if _, ok := folders[key]; !ok {
+			folders[key] = groups[i].ID
+		}
+	}
+	return folders, nil
+}
+
+func importEntry(key string, fileTags *tags.Tags, hash string) ScanEntry {
+	entry := ScanEntry{
+		VideoID: fileTags.VideoID,
+		Title:   fileTags.Title,
+		Artist:  fileTags.Artist,
+		Album:   fileTags.Album,
+		Path:    key,
+	}
+	if entry.VideoID == "" {
+		entry.VideoID = ImportedIDPrefix + hash[:16]
+	}
+	if entry.Title == "" {
+		// Downloads are named "Artist - Title.ext"
+		name := strings.TrimSuffix(path.Base(key), path.Ext(key))
+		if artist, title, ok := strings.Cut(name, " - "); ok {
+			entry.Title = title
+			if entry.Artist == "" {
+				entry.Artist = artist
+			}
+		} else {
+			entry.Title = name
+		}
+	}
+	return entry
+}
+
+func importFile(entry *ScanEntry, folder, hash string) error {
+	if entry.GroupTaskID == 0 {
+		group, err := db.GetOrCreateImportGroupTask(folder)
+		if err != nil {
+			return err
+		}
+		entry.GroupTaskID = group.ID
+	}
+	return db.ImportSongTask(db.SongTask{
+		GroupTaskID: entry.GroupTaskID,
+		VideoID:     entry.VideoID,
+		Title:       entry.Title,
+		Artist:      entry.Artist,
+		Album:       entry.Album,
+		FilePath:    filepath.FromSlash(entry.Path),
+		FileHash:    hash,
+	})
+}
+
+func newEntry(task *db.SongTask, key string) ScanEntry {
+	return ScanEntry{
+		GroupTaskID: task.GroupTaskID,
+		VideoID:     task.VideoID,
+		Title:       task.Title,
+		Artist:      task.Artist,
+		Path:        key,
+	}
+}
+
+func trackID(groupTaskID uint, videoID string) string {
+	return fmt.Sprintf("%d/%s", groupTaskID, videoID)
+}
@@ -0,0 +1,188 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) string {
+	t.Helper()
+	dbtest.Open(t)
+
+	root := t.TempDir()
+	require.NoError(t, db.SetSetting(db.DownloadPathSetting, root))
+	return root
+}
+
+// mp3WithVideoID is an MP3 file with an ID3v2.3 YOUTUBE_VIDEO_ID tag.
+func mp3WithVideoID(videoID, title string) []byte {
+	frame := func(id string, body string) []byte {
+		out := append([]byte(id), 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(out[4:8], uint32(len(body)))
+		return append(out, body...)
+	}
+	body := append(frame("TIT2", "\x00"+title), frame("TXXX", "\x00YOUTUBE_VIDEO_ID\x00"+videoID)...)
+	header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, byte(len(body) >> 7), byte(len(body) & 0x7f)}
+	return append(append(header, body...), "mpeg frames"...)
+}
+
+func writeFile(t *testing.T, root, rel string, data []byte) {
+	t.Helper()
+	full := filepath.Join(root, filepath.FromSlash(rel))
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+	require.NoError(t, os.WriteFile(full, data, 0644))
+}
+
+func addStoredTrack(t *testing.T, groupID int, videoID, filePath, hash string) {
+	t.Helper()
+	require.NoError(t, db.AddSongTask(groupID, videoID, videoID, "Artist", "", ""))
+	require.NoError(t, db.MarkSongTaskCompleted(groupID, videoID, filePath, hash))
+}
+
+// setupLibrary creates a playlist whose files were rearranged by hand.
+func setupLibrary(t *testing.T) (string, int) {
+	root := setupTestDB(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	require.NoError(t, db.UpdateGroupTaskStatus(groupID, db.TaskStatusCompleted))
+
+	// In place
+	writeFile(t, root, "Playlist/Artist - a.mp3", []byte("a"))
+	addStoredTrack(t, groupID, "a", filepath.FromSlash("Playlist/Artist - a.mp3"), "")
+	// Moved, found by its tag
+	writeFile(t, root, "Moved/b.mp3", mp3WithVideoID("b", "b"))
+	addStoredTrack(t, groupID, "b", filepath.FromSlash("Playlist/Artist - b.mp3"), "")
+	// Moved, found by its hash
+	writeFile(t, root, "Other/renamed.m4a", []byte("c"))
+	hash, err := HashFile(filepath.Join(root, "Other", "renamed.m4a"))
+	require.NoError(t, err)
+	addStoredTrack(t, groupID, "c", filepath.FromSlash("Playlist/Artist - c.m4a"), hash)
+	// Gone
+	addStoredTrack(t, groupID, "d", filepath.FromSlash("Playlist/Artist - d.mp3"), "x")
+	// New files, tagged in the playlist folder and untagged in another one
+	writeFile(t, root, "Playlist/e.mp3", mp3WithVideoID("e", "Song E"))
+	writeFile(t, root, "Loose/Someone - Song F.m4a", []byte("f"))
+	// Not audio
+	writeFile(t, root, "Playlist/playlist.m3u8", []byte("#EXTM3U\n"))
+	return root, groupID
+}
+
+func TestScanReconcilesLibrary(t *testing.T) {
+	_, groupID := setupLibrary(t)
+
+	report, err := Scan(context.Background(), ScanOptions{MissingAction: MissingMark})
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Files)
+	assert.Equal(t, 1, report.Matched)
+	require.Len(t, report.Moved, 2)
+	assert.Equal(t, "b", report.Moved[0].VideoID)
+	assert.Equal(t, "video_id", report.Moved[0].MatchedBy)
+	assert.Equal(t, "c", report.Moved[1].VideoID)
+	assert.Equal(t, "hash", report.Moved[1].MatchedBy)
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "marked", report.Missing[0].Action)
+	require.Len(t, report.Imported, 2)
+	assert.Empty(t, report.Errors)
+
+	song, err := db.GetSongTask(groupID, "b")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("Moved/b.mp3"), song.FilePath)
+	song, err = db.GetSongTask(groupID, "d")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusMissing, song.Status)
+
+	// Tagged file joins the group of its folder
+	song, err = db.GetSongTask(groupID, "e")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusCompleted, song.Status)
+	assert.Equal(t, "Song E", song.Title)
+
+	// Untagged file gets an import group and a title from its name
+	imported, err := db.GetGroupTaskByReferenceID(db.ImportReferencePrefix + "Loose")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskTypeLibraryImport, imported.Type)
+	songs, err := db.GetSongTasks(int(imported.ID))
+	require.NoError(t, err)
+	require.Len(t, songs, 1)
+	assert.Equal(t, "Song F", songs[0].Title)
+	assert.Equal(t, "Someone", songs[0].Artist)
+	assert.Contains(t, songs[0].VideoID, ImportedIDPrefix)
+
+	// A second scan finds everything in place
+	report, err = Scan(context.Background(), ScanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Matched)
+	assert.Empty(t, report.Moved)
+	assert.Empty(t, report.Imported)
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "already_missing", report.Missing[0].Action)
+}
+
+func TestScanRestoresAndRequeues(t *testing.T) {
+	root, groupID := setupLibrary(t)
+	_, err := Scan(context.Background(), ScanOptions{})
+	require.NoError(t, err)
+
+	// d comes back, b vanishes again
+	writeFile(t, root, "Playlist/Artist - d.mp3", []byte("d"))
+	require.NoError(t, os.Remove(filepath.Join(root, "Moved", "b.mp3")))
+
+	report, err := Scan(context.Background(), ScanOptions{MissingAction: MissingRequeue})
+	require.NoError(t, err)
+	require.Len(t, report.Restored, 1)
+	assert.Equal(t, "d", report.Restored[0].VideoID)
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "requeued", report.Missing[0].Action)
+
+	song, err := db.GetSongTask(groupID, "b")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusNotStarted, song.Status)
+	assert.Empty(t, song.FilePath)
+	group, err := db.GetGroupTask(groupID)
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusProcessing, group.Status)
+}
+
+func TestScanDryRun(t *testing.T) {
+	_, groupID := setupLibrary(t)
+
+	report, err := Scan(context.Background(), ScanOptions{DryRun: true, MissingAction: MissingRequeue})
+	require.NoError(t, err)
+	assert.Len(t, report.Moved, 2)
+	assert.Len(t, report.Imported, 2)
+	assert.Equal(t, "requeued", report.Missing[0].Action)
+
+	song, err := db.GetSongTask(groupID, "b")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("Playlist/Artist - b.mp3"), song.FilePath)
+	song, err = db.GetSongTask(groupID, "d")
+	require.NoError(t, err)
+	assert.Equal(t, db.TaskStatusCompleted, song.Status)
+	_, err = db.GetGroupTaskByReferenceID(db.ImportReferencePrefix + "Loose")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestRunScanOneAtATime(t *testing.T) {
+	setupTestDB(t)
+	require.NoError(t, beginScan())
+	_, err := RunScan(context.Background(), ScanOptions{})
+	assert.ErrorIs(t, err, ErrScanRunning)
+	finishScan(&ScanReport{}, nil)
+
+	_, err = RunScan(context.Background(), ScanOptions{})
+	require.NoError(t, err)
+	running, last := ScanStatus()
+	assert.False(t, running)
+	assert.NotNil(t, last)
+}
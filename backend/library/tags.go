@@ -0,0 +1,271 @@
+package library
+
+import (
+	"beatbump-server/backend/artwork"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// ErrInvalidCover is returned for cover data that is not a JPEG or PNG
+// image.
+var ErrInvalidCover = errors.New("cover must be a JPEG or PNG image")
+
+// ErrSharedTrackNumber is returned for a track number edit of a
+// deduplicated file that tasks of other playlists link to, the number
+// belongs to one playlist.
+var ErrSharedTrackNumber = errors.New("track is shared with other playlists, its track number cannot be changed")
+
+// TagEdit changes the tags of a track. Nil fields are kept, empty ones
+// remove the tag.
+type TagEdit struct {
+	Title       *string `json:"title"`
+	Artist      *string `json:"artist"`
+	Album       *string `json:"album"`
+	AlbumArtist *string `json:"albumArtist"`
+	Year        *string `json:"year"`
+	Genre       *string `json:"genre"`
+	Track       *int    `json:"track"`
+	TrackTotal  *int    `json:"trackTotal"`
+	Lyrics      *string `json:"lyrics"`
+	VideoID     *string `json:"videoId"`
+	// Base64 encoded image, empty removes the cover
+	Cover *string `json:"cover"`
+	// Image to download as the cover
+	ArtworkURL string `json:"artworkUrl"`
+
+	picture *tags.Picture
+}
+
+// PerTrack reports whether the edit sets fields that differ between the
+// tracks of a group.
+func (e *TagEdit) PerTrack() bool {
+	return e.Title != nil || e.Track != nil || e.Lyrics != nil || e.VideoID != nil
+}
+
+// prepare decodes or downloads the new cover once for every track.
+func (e *TagEdit) prepare() error {
+	var data []byte
+	switch {
+	case e.ArtworkURL != "":
+		var err error
+		if data, err = fetchCover(e.ArtworkURL); err != nil {
+			return err
+		}
+	case e.Cover != nil && *e.Cover != "":
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(*e.Cover); err != nil {
+			return ErrInvalidCover
+		}
+	default:
+		return nil
+	}
+	if len(data) < 4 || (string(data[:2]) != "\xff\xd8" && string(data[:4]) != "\x89PNG") {
+		return ErrInvalidCover
+	}
+	e.picture = &tags.Picture{MIMEType: tags.DetectImageType(data), Data: data}
+	return nil
+}
+
+// fetchCover downloads the artwork at url with the current artwork
+// options.
+func fetchCover(url string) ([]byte, error) {
+	f, err := os.CreateTemp("", "beatbump_cover_*")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := artwork.Fetch(url, f.Name(), artwork.CurrentOptions()); err != nil {
+		return nil, fmt.Errorf("downloading artwork: %w", err)
+	}
+	return os.ReadFile(f.Name())
+}
+
+// coverChanged reports whether the edit sets or removes the cover.
+func (e *TagEdit) coverChanged() bool {
+	return e.picture != nil || (e.Cover != nil && *e.Cover == "")
+}
+
+func (e *TagEdit) apply(t *tags.Tags) {
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	set(&t.Title, e.Title)
+	set(&t.Artist, e.Artist)
+	set(&t.Album, e.Album)
+	set(&t.AlbumArtist, e.AlbumArtist)
+	set(&t.Year, e.Year)
+	set(&t.Genre, e.Genre)
+	set(&t.Lyrics, e.Lyrics)
+	set(&t.VideoID, e.VideoID)
+	if e.Track != nil {
+		t.Track = *e.Track
+	}
+	if e.TrackTotal != nil {
+		t.TrackTotal = *e.TrackTotal
+	}
+	if e.picture != nil {
+		t.Cover = e.picture
+	} else if e.Cover != nil && *e.Cover == "" {
+		t.Cover = nil
+	}
+}
+
+// ReadTags returns the tags of the stored file of song.
+func ReadTags(ctx context.Context, song *db.SongTask) (*tags.Tags, error) {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return nil, ErrNoDownloadPath
+	}
+	if song.Status != db.TaskStatusCompleted || song.FilePath == "" {
+		return nil, ErrNotStored
+	}
+	store := storage.Resolve(downloadPath)
+	object, err := store.Stat(ctx, trackKey(song))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, ErrNotStored
+	} else if err != nil {
+		return nil, err
+	}
+	r := storage.NewReadSeeker(ctx, store, object)
+	defer r.Close()
+	return tags.Read(r, object.Key)
+}
+
+// EditTags applies edit to the stored file of song and returns its new
+// tags. The tasks linked to a deduplicated file share the change, the
+// album.nfo of their tasks follows.
+func EditTags(ctx context.Context, song *db.SongTask, edit *TagEdit) (*tags.Tags, error) {
+	if err := edit.prepare(); err != nil {
+		return nil, err
+	}
+	updated, groups, err := editTags(ctx, song, edit)
+	if err != nil {
+		return nil, err
+	}
+	if err := updateFolders(ctx, groups, edit.coverChanged()); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// GroupTagResult is the outcome of a group edit for one track.
+type GroupTagResult struct {
+	VideoID string `json:"videoId"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EditGroupTags applies edit to every completed track of a group task. A
+// failed track does not stop the others.
+func EditGroupTags(ctx context.Context, groupTaskID int, edit *TagEdit) ([]GroupTagResult, error) {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return nil, ErrNoDownloadPath
+	}
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := edit.prepare(); err != nil {
+		return nil, err
+	}
+
+	results := []GroupTagResult{}
+	groups := []int{groupTaskID}
+	for i := range songs {
+		song := &songs[i]
+		if song.Status != db.TaskStatusCompleted {
+			continue
+		}
+		result := GroupTagResult{VideoID: song.VideoID}
+		_, linked, err := editTags(ctx, song, edit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			result.Error = err.Error()
+		}
+		for _, id := range linked {
+			if !slices.Contains(groups, id) {
+				groups = append(groups, id)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, updateFolders(ctx, groups, edit.coverChanged())
+}
+
+// editTags applies edit to the stored file of song and returns the group
+// tasks sharing the file.
+func editTags(ctx context.Context, song *db.SongTask, edit *TagEdit) (*tags.Tags, []int, error) {
+	groups, err := linkedGroups(song)
+	if err != nil {
+		return nil, nil, err
+	}
+	if (edit.Track != nil || edit.TrackTotal != nil) && len(groups) > 1 {
+		return nil, nil, ErrSharedTrackNumber
+	}
+	updated, err := updateTags(ctx, song, edit.apply)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := db.UpdateSongTaskTags(int(song.GroupTaskID), song.VideoID, song.TrackKey, updated.Title, updated.Artist, updated.Album); err != nil {
+		return nil, nil, err
+	}
+	if edit.Lyrics != nil {
+		if err := db.UpdateSongTaskLyrics(int(song.GroupTaskID), song.VideoID, song.TrackKey, updated.Lyrics); err != nil {
+			return nil, nil, err
+		}
+	}
+	if edit.coverChanged() {
+		url, hash := edit.ArtworkURL, ""
+		if edit.picture != nil {
+			hash = artwork.Hash(edit.picture.Data)
+		}
+		if err := db.SetSongTaskArtwork(int(song.GroupTaskID), song.VideoID, song.TrackKey, url, hash); err != nil {
+			return nil, nil, err
+		}
+	}
+	return updated, groups, nil
+}
+
+// updateTags rewrites the stored file of song with the tags update makes
+// from its current ones.
+func updateTags(ctx context.Context, song *db.SongTask, update func(t *tags.Tags)) (*tags.Tags, error) {
+	var updated *tags.Tags
+	err := rewriteTrack(ctx, song, func(input, output string) error {
+		in, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if updated, err = tags.Read(in, input); err != nil {
+			return err
+		}
+		update(updated)
+
+		out, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		if err := tags.Write(out, in, input, updated); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
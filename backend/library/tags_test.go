@@ -0,0 +1,117 @@
+package library
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditTags(t *testing.T) {
+	root := setupTestDB(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	for _, id := range []string{"a", "b"} {
+		writeFile(t, root, "Playlist/"+id+".mp3", mp3WithVideoID(id, "Title "+id))
+		addStoredTrack(t, groupID, id, filepath.FromSlash("Playlist/"+id+".mp3"), "")
+	}
+	require.NoError(t, db.AddSongTask(groupID, "pending", "Pending", "Artist", "", ""))
+
+	ctx := context.Background()
+	song, err := db.GetSongTask(groupID, "a")
+	require.NoError(t, err)
+	got, err := ReadTags(ctx, song)
+	require.NoError(t, err)
+	assert.Equal(t, "Title a", got.Title)
+	assert.Equal(t, "a", got.VideoID)
+
+	title, lyrics, track := "New title", "La la la", 2
+	got, err = EditTags(ctx, song, &TagEdit{Title: &title, Lyrics: &lyrics, Track: &track})
+	require.NoError(t, err)
+	assert.Equal(t, "New title", got.Title)
+	assert.Equal(t, "a", got.VideoID, "fields missing from the edit are kept")
+
+	got, err = ReadTags(ctx, song)
+	require.NoError(t, err)
+	assert.Equal(t, "La la la", got.Lyrics)
+	assert.Equal(t, 2, got.Track)
+	song, err = db.GetSongTask(groupID, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "New title", song.Title)
+	assert.Equal(t, "La la la", song.Lyrics)
+	hash, err := HashFile(filepath.Join(root, "Playlist", "a.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, hash, song.FileHash)
+
+	// The same album and cover for the whole group
+	album := "Album"
+	cover := base64.StdEncoding.EncodeToString([]byte("\x89PNG image"))
+	edit := &TagEdit{Album: &album, Cover: &cover}
+	assert.False(t, edit.PerTrack())
+	results, err := EditGroupTags(ctx, groupID, edit)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupTagResult{{VideoID: "a"}, {VideoID: "b"}}, results)
+	for _, id := range []string{"a", "b"} {
+		song, err := db.GetSongTask(groupID, id)
+		require.NoError(t, err)
+		got, err := ReadTags(ctx, song)
+		require.NoError(t, err)
+		assert.Equal(t, "Album", got.Album)
+		require.NotNil(t, got.Cover)
+		assert.Equal(t, "image/png", got.Cover.MIMEType)
+	}
+
+	invalid := base64.StdEncoding.EncodeToString([]byte("not an image"))
+	_, err = EditTags(ctx, song, &TagEdit{Cover: &invalid})
+	assert.ErrorIs(t, err, ErrInvalidCover)
+
+	pending, err := db.GetSongTask(groupID, "pending")
+	require.NoError(t, err)
+	_, err = ReadTags(ctx, pending)
+	assert.ErrorIs(t, err, ErrNotStored)
+}
+
+func TestEditSharedTrack(t *testing.T) {
+	root := setupTestDB(t)
+	useDedup(t, storage.DedupM3U)
+	key := TrackKey("a", "mp3", ".mp3")
+	writeFile(t, root, key, mp3WithVideoID("a", "Title"))
+	for i, ref := range []string{"PL1", "PL2"} {
+		require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, ref, ref, db.TaskSourceUser, -1))
+		require.NoError(t, db.AddSongTask(i+1, "a", "Title", "Artist", "", ""))
+		require.NoError(t, db.MarkSongTaskLinked(i+1, "a", key, "", key))
+	}
+
+	ctx := context.Background()
+	song, err := db.GetSongTask(1, "a")
+	require.NoError(t, err)
+	title := "New title"
+	_, err = EditTags(ctx, song, &TagEdit{Title: &title})
+	require.NoError(t, err)
+	other, err := db.GetSongTask(2, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "New title", other.Title, "the linked task shares the file")
+
+	// The position differs per playlist
+	track := 3
+	_, err = EditTags(ctx, song, &TagEdit{Track: &track})
+	assert.ErrorIs(t, err, ErrSharedTrackNumber)
+
+	// Re-tagged natively, without ffmpeg
+	ids := tags.MusicBrainzIDs{TrackID: "recording", AlbumID: "release"}
+	require.NoError(t, RetagTrack(ctx, song, utils.AudioMetadata{Title: "Matched", Artist: "Artist", Album: "Album", MusicBrainz: ids}))
+	got, err := ReadTags(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, "Matched", got.Title)
+	assert.Equal(t, ids, got.MusicBrainz)
+	assert.Equal(t, "a", got.VideoID)
+}
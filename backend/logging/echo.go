@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/rs/zerolog"
+)
+
+// EchoLogger adapts a zerolog logger to echo.Logger so that e.Logger and
+// c.Logger() write through the same output as the rest of the server.
+type EchoLogger struct {
+	logger zerolog.Logger
+	prefix string
+}
+
+func NewEchoLogger(logger zerolog.Logger) *EchoLogger {
+	return &EchoLogger{logger: logger}
+}
+
+var echoLevels = map[log.Lvl]zerolog.Level{
+	log.DEBUG: zerolog.DebugLevel,
+	log.INFO:  zerolog.InfoLevel,
+	log.WARN:  zerolog.WarnLevel,
+	log.ERROR: zerolog.ErrorLevel,
+	log.OFF:   zerolog.Disabled,
+}
+
+// Output is used by echo for its start-up messages, which are logged at info.
+func (l *EchoLogger) Output() io.Writer {
+	return infoWriter{l.logger}
+}
+
+func (l *EchoLogger) SetOutput(w io.Writer) {
+	l.logger = l.logger.Output(w)
+}
+
+func (l *EchoLogger) Prefix() string {
+	return l.prefix
+}
+
+func (l *EchoLogger) SetPrefix(p string) {
+	l.prefix = p
+	l.logger = l.logger.With().Str("prefix", p).Logger()
+}
+
+func (l *EchoLogger) Level() log.Lvl {
+	for echoLevel, level := range echoLevels {
+		if level == l.logger.GetLevel() {
+			return echoLevel
+		}
+	}
+	return log.INFO
+}
+
+func (l *EchoLogger) SetLevel(v log.Lvl) {
+	if level, ok := echoLevels[v]; ok {
+		l.logger = l.logger.Level(level)
+	}
+}
+
+// SetHeader is a no-op, the format is defined by the zerolog output.
+func (l *EchoLogger) SetHeader(h string) {}
+
+func (l *EchoLogger) Print(i ...interface{}) { l.logger.Info().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Printf(format string, args ...interface{}) {
+	l.logger.Info().Msgf(format, args...)
+}
+func (l *EchoLogger) Printj(j log.JSON)      { logJSON(l.logger.Info(), j) }
+func (l *EchoLogger) Debug(i ...interface{}) { l.logger.Debug().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debug().Msgf(format, args...)
+}
+func (l *EchoLogger) Debugj(j log.JSON)                        { logJSON(l.logger.Debug(), j) }
+func (l *EchoLogger) Info(i ...interface{})                    { l.logger.Info().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Infof(format string, args ...interface{}) { l.logger.Info().Msgf(format, args...) }
+func (l *EchoLogger) Infoj(j log.JSON)                         { logJSON(l.logger.Info(), j) }
+func (l *EchoLogger) Warn(i ...interface{})                    { l.logger.Warn().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Warnf(format string, args ...interface{}) { l.logger.Warn().Msgf(format, args...) }
+func (l *EchoLogger) Warnj(j log.JSON)                         { logJSON(l.logger.Warn(), j) }
+func (l *EchoLogger) Error(i ...interface{})                   { l.logger.Error().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error().Msgf(format, args...)
+}
+func (l *EchoLogger) Errorj(j log.JSON)      { logJSON(l.logger.Error(), j) }
+func (l *EchoLogger) Fatal(i ...interface{}) { l.logger.Fatal().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Fatalj(j log.JSON)      { logJSON(l.logger.Fatal(), j) }
+func (l *EchoLogger) Fatalf(format string, args ...interface{}) {
+	l.logger.Fatal().Msgf(format, args...)
+}
+func (l *EchoLogger) Panic(i ...interface{}) { l.logger.Panic().Msg(fmt.Sprint(i...)) }
+func (l *EchoLogger) Panicj(j log.JSON)      { logJSON(l.logger.Panic(), j) }
+func (l *EchoLogger) Panicf(format string, args ...interface{}) {
+	l.logger.Panic().Msgf(format, args...)
+}
+
+// infoWriter logs every write as an info message
+type infoWriter struct {
+	logger zerolog.Logger
+}
+
+func (w infoWriter) Write(p []byte) (int, error) {
+	if msg := strings.TrimSpace(string(p)); msg != "" {
+		w.logger.Info().Msg(msg)
+	}
+	return len(p), nil
+}
+
+func logJSON(event *zerolog.Event, j log.JSON) {
+	b, err := json.Marshal(j)
+	if err != nil {
+		event.Interface("fields", j).Send()
+		return
+	}
+	event.RawJSON("fields", b).Send()
+}
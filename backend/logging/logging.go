@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Log is the process wide logger. It starts with console output at info
+// level and is replaced by Configure once settings are known.
+var Log = New(FormatConsole, os.Stdout)
+
+func init() {
+	zerolog.DefaultContextLogger = &Log
+}
+
+// Configure sets the output format (console or json) and the minimum level.
+func Configure(format, level string) error {
+	if format == "" {
+		format = FormatConsole
+	}
+	if level == "" {
+		level = zerolog.InfoLevel.String()
+	}
+
+	if format != FormatConsole && format != FormatJSON {
+		return fmt.Errorf("unknown log format %q, expected console or json", format)
+	}
+	lvl, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil {
+		return fmt.Errorf("unknown log level %q: %w", level, err)
+	}
+
+	zerolog.SetGlobalLevel(lvl)
+	Log = New(format, os.Stdout)
+	return nil
+}
+
+// New creates a logger writing either human readable or JSON lines to out.
+func New(format string, out io.Writer) zerolog.Logger {
+	if format == FormatJSON {
+		return zerolog.New(out).With().Timestamp().Logger()
+	}
+
+	// create output configuration
+	output := zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
+
+	// Format level: fatal, error, debug, info, warn
+	output.FormatLevel = func(i interface{}) string {
+		return strings.ToUpper(fmt.Sprintf("| %-6s|", i))
+	}
+	output.FormatFieldName = func(i interface{}) string {
+		return fmt.Sprintf("%s:", i)
+	}
+	output.FormatFieldValue = func(i interface{}) string {
+		return fmt.Sprintf("%s", i)
+	}
+
+	// format error
+	output.FormatErrFieldName = func(i interface{}) string {
+		return fmt.Sprintf("%s: ", i)
+	}
+
+	return zerolog.New(output).With().Caller().Timestamp().Logger()
+}
+
+// Ctx returns the request scoped logger stored in ctx, or Log.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l != nil && l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &Log
+}
+
+// ForTrack returns a logger tagged with the group task and video IDs, so the
+// lifecycle of a single track can be followed across log lines.
+func ForTrack(taskID uint, videoID string) zerolog.Logger {
+	return Log.With().Uint("task_id", taskID).Str("video_id", videoID).Logger()
+}
+
+// ForTask returns a logger tagged with the group task ID.
+func ForTask(taskID int) zerolog.Logger {
+	return Log.With().Int("task_id", taskID).Logger()
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureRejectsUnknownValues(t *testing.T) {
+	assert.Error(t, Configure("xml", "info"))
+	assert.Error(t, Configure("json", "loud"))
+	assert.NoError(t, Configure("json", "debug"))
+	assert.NoError(t, Configure("console", "info"))
+}
+
+func TestRequestLoggerCarriesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	previous := Log
+	Log = New(FormatJSON, &buf)
+	defer func() { Log = previous }()
+
+	e := echo.New()
+	e.Use(middleware.RequestID())
+	e.Use(RequestLogger)
+	e.GET("/test", func(c echo.Context) error {
+		Ctx(c.Request().Context()).Info().Msg("from context")
+		c.Logger().Infof("from %s", "echo")
+		return c.String(http.StatusOK, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set(echo.HeaderXRequestID, "req-123")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &entry))
+		assert.Equal(t, "req-123", entry["request_id"])
+	}
+
+	var access map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[2], &access))
+	assert.Equal(t, "/test", access["path"])
+	assert.Equal(t, float64(http.StatusOK), access["status"])
+}
+
+func TestForTrackFields(t *testing.T) {
+	var buf bytes.Buffer
+	previous := Log
+	Log = New(FormatJSON, &buf)
+	defer func() { Log = previous }()
+
+	logger := ForTrack(7, "abc")
+	logger.Info().Msg("downloading")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, float64(7), entry["task_id"])
+	assert.Equal(t, "abc", entry["video_id"])
+}
+
+func TestEchoPrintLogsAtInfo(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewEchoLogger(New(FormatJSON, &buf))
+
+	logger.Print("print")
+	logger.Printf("printf %d", 1)
+	logger.Printj(map[string]interface{}{"key": "value"})
+	// Echo writes its start-up banner and address here
+	_, err := logger.Output().Write([]byte("http server started on [::]:8080\n"))
+	require.NoError(t, err)
+	_, err = logger.Output().Write([]byte("\n"))
+	require.NoError(t, err)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 4)
+	messages := make([]interface{}, 0, len(lines))
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &entry))
+		assert.Equal(t, "info", entry["level"])
+		messages = append(messages, entry["message"])
+	}
+	assert.Equal(t, []interface{}{"print", "printf 1", nil, "http server started on [::]:8080"}, messages)
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+)
+
+// RequestLogger must run after echo's RequestID middleware. It attaches a
+// logger carrying the request ID to the request context and to c.Logger(),
+// then logs one line per request.
+func RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		req := c.Request()
+
+		requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+		logger := Log.With().Str("request_id", requestID).Logger()
+		c.SetRequest(req.WithContext(logger.WithContext(req.Context())))
+		c.SetLogger(NewEchoLogger(logger))
+
+		// call the next middleware/handler
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		var event *zerolog.Event
+		status := c.Response().Status
+		switch {
+		case err != nil || status >= 500:
+			event = logger.Error().Err(err)
+		case status >= 400:
+			event = logger.Warn()
+		default:
+			event = logger.Info()
+		}
+
+		event.
+			Str("method", req.Method).
+			Str("path", req.URL.Path).
+			Str("query", req.URL.RawQuery).
+			Int("status", status).
+			Int64("bytes", c.Response().Size).
+			Dur("latency", time.Since(start)).
+			Str("remote_ip", c.RealIP()).
+			Str("user_agent", req.UserAgent()).
+			Msg("request")
+
+		// The error was already handled above
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"beatbump-server/backend/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultDeezerURL = "https://api.deezer.com"
+
+// Deezer searches the public Deezer API, which needs no key.
+// See https://developers.deezer.com/api/search
+type Deezer struct {
+	baseURL string
+}
+
+func NewDeezer(baseURL string) *Deezer {
+	if baseURL == "" {
+		baseURL = DefaultDeezerURL
+	}
+	return &Deezer{baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (d *Deezer) Name() string {
+	return "deezer"
+}
+
+type deezerResponse struct {
+	Data []struct {
+		Title    string `json:"title"`
+		Duration int    `json:"duration"` // seconds
+		Artist   struct {
+			Name string `json:"name"`
+		} `json:"artist"`
+		Album struct {
+			Title   string `json:"title"`
+			CoverXL string `json:"cover_xl"`
+		} `json:"album"`
+	} `json:"data"`
+	// Deezer reports errors with status 200
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (d *Deezer) Search(ctx context.Context, q Query) ([]Candidate, error) {
+	if err := deezerRateLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	query := "track:" + luceneQuote(utils.CleanString(q.Title))
+	if artist := utils.CleanString(q.Artist); artist != "" {
+		query = "artist:" + luceneQuote(artist) + " " + query
+	}
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("limit", "5")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result deezerResponse
+	if err := getJSON(req, &result); err != nil {
+		return nil, fmt.Errorf("Deezer API %w", err)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("Deezer API returned %s: %s", result.Error.Type, result.Error.Message)
+	}
+
+	candidates := make([]Candidate, 0, len(result.Data))
+	for _, track := range result.Data {
+		candidates = append(candidates, Candidate{
+			Provider:   d.Name(),
+			Title:      track.Title,
+			Artist:     track.Artist.Name,
+			Album:      track.Album.Title,
+			ArtworkURL: track.Album.CoverXL,
+			DurationMs: track.Duration * 1000,
+		})
+	}
+	return candidates, nil
+}
@@ -0,0 +1,120 @@
+package metadata
+
+import (
+	"beatbump-server/backend/metrics"
+	"beatbump-server/backend/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultITunesURL = "https://itunes.apple.com/search"
+
+// ITunes searches the iTunes Search API.
+// See https://performance-partners.apple.com/search-api
+type ITunes struct {
+	searchURL string
+}
+
+func NewITunes(searchURL string) *ITunes {
+	if searchURL == "" {
+		searchURL = DefaultITunesURL
+	}
+	return &ITunes{searchURL: searchURL}
+}
+
+func (i *ITunes) Name() string {
+	return "itunes"
+}
+
+type iTunesResponse struct {
+	ResultCount int          `json:"resultCount"`
+	Results     []iTunesItem `json:"results"`
+}
+
+type iTunesItem struct {
+	ArtistName       string `json:"artistName"`
+	TrackName        string `json:"trackName"`
+	CollectionName   string `json:"collectionName"`
+	ArtworkUrl100    string `json:"artworkUrl100"`
+	PrimaryGenreName string `json:"primaryGenreName"`
+	ReleaseDate      string `json:"releaseDate"` // ISO 8601 format: 2005-03-01T08:00:00Z
+	TrackTimeMillis  int    `json:"trackTimeMillis"`
+}
+
+func (i *ITunes) Search(ctx context.Context, q Query) ([]Candidate, error) {
+	// Wait for rate limiter
+	waitStart := time.Now()
+	err := itunesRateLimiter.Wait(ctx)
+	metrics.ObserveSince(metrics.ItunesRateLimitWait, waitStart)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("%s %s", utils.CleanString(q.Artist), utils.CleanString(q.Title))
+	params := url.Values{}
+	params.Set("term", query)
+	params.Set("entity", "song")
+	params.Set("limit", "5")
+	// Detect country based on script to improve search results
+	if countryCode := detectStoreCountry(query); countryCode != "" {
+		params.Set("country", countryCode)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, i.searchURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result iTunesResponse
+	if err := getJSON(req, &result); err != nil {
+		return nil, fmt.Errorf("iTunes API %w", err)
+	}
+
+	candidates := make([]Candidate, 0, len(result.Results))
+	for _, item := range result.Results {
+		// Parse Year from ReleaseDate
+		year := ""
+		if len(item.ReleaseDate) >= 4 {
+			year = item.ReleaseDate[:4]
+		}
+		candidates = append(candidates, Candidate{
+			Provider: i.Name(),
+			Title:    item.TrackName,
+			Artist:   item.ArtistName,
+			Album:    item.CollectionName,
+			Year:     year,
+			Genre:    item.PrimaryGenreName,
+			// Get high-res artwork (replace 100x100 with 600x600)
+			ArtworkURL: strings.Replace(item.ArtworkUrl100, "100x100bb", "600x600bb", 1),
+			DurationMs: item.TrackTimeMillis,
+		})
+	}
+	return candidates, nil
+}
+
+// detectStoreCountry returns an iTunes country code based on the script detected in the string.
+// Returns empty string if no specific script is detected (defaults to US/Global).
+func detectStoreCountry(s string) string {
+	for _, r := range s {
+		switch {
+		case r >= 0x0590 && r <= 0x05FF: // Hebrew
+			return "IL"
+		case r >= 0x0400 && r <= 0x04FF: // Cyrillic
+			return "RU"
+		case r >= 0x0600 && r <= 0x06FF: // Arabic
+			return "EG"
+		case r >= 0x3040 && r <= 0x309F: // Hiragana
+			return "JP"
+		case r >= 0x30A0 && r <= 0x30FF: // Katakana
+			return "JP"
+		case r >= 0x4E00 && r <= 0x9FFF: // CJK Unified Ideographs (Kanji)
+			// CJK is shared, but JP is a good default for music metadata in this context.
+			// Could be refined if needed.
+			return "JP"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,226 @@
+package metadata
+
+import (
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/tags"
+	"beatbump-server/backend/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Query is the track whose metadata is looked up.
+type Query struct {
+	Title  string
+	Artist string
+	Album  string
+	// Length of the stream, 0 when unknown
+	DurationMs int
+}
+
+// Candidate is a track a provider found for a query.
+type Candidate struct {
+	Provider   string
+	Title      string
+	Artist     string
+	Album      string
+	Year       string
+	Genre      string
+	ArtworkURL string
+	DurationMs int
+	// Only MusicBrainz knows them
+	MusicBrainz tags.MusicBrainzIDs
+}
+
+// Match is a candidate and how well it fits the query, see Score.
+type Match struct {
+	Candidate
+	Score float64
+}
+
+// Provider searches a metadata service for tracks.
+type Provider interface {
+	Name() string
+	Search(ctx context.Context, q Query) ([]Candidate, error)
+}
+
+var (
+	ErrNoProviders = errors.New("no metadata providers enabled")
+	ErrNoMatch     = errors.New("no matching track found")
+)
+
+// DisabledSetting as the provider setting turns enrichment off.
+const DisabledSetting = "none"
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+var (
+	mu               sync.RWMutex
+	defaultProviders = []string{"itunes"}
+	baseURLs         = map[string]string{}
+)
+
+// Per service limits, shared by every instance of a provider
+var (
+	itunesRateLimiter      = rate.NewLimiter(rate.Every(3*time.Second), 1)
+	musicBrainzRateLimiter = rate.NewLimiter(rate.Every(time.Second), 1)
+	deezerRateLimiter      = rate.NewLimiter(rate.Every(100*time.Millisecond), 1)
+)
+
+// Configure sets the default provider chain, the endpoint of each provider
+// and the minimum interval between iTunes requests.
+func Configure(cfg config.MetadataConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultProviders = cfg.Providers
+	baseURLs = map[string]string{
+		"itunes":      cfg.ITunesURL,
+		"musicbrainz": cfg.MusicBrainzURL,
+		"deezer":      cfg.DeezerURL,
+	}
+	itunesRateLimiter.SetLimit(rate.Every(cfg.ITunesRateLimit.Duration()))
+}
+
+// NewProvider builds a provider by its config name, with the configured
+// endpoint.
+func NewProvider(name string) (Provider, error) {
+	mu.RLock()
+	baseURL := baseURLs[name]
+	mu.RUnlock()
+
+	switch name {
+	case "itunes":
+		return NewITunes(baseURL), nil
+	case "musicbrainz":
+		return NewMusicBrainz(baseURL), nil
+	case "deezer":
+		return NewDeezer(baseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown metadata provider %q", name)
+	}
+}
+
+// ProviderNames returns the provider chain in order, from the settings and
+// otherwise from the configuration.
+func ProviderNames() []string {
+	if value, _ := db.GetSetting(db.MetadataProvidersSetting); value != "" {
+		if value == DisabledSetting {
+			return nil
+		}
+		return ParseProviders(value)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultProviders
+}
+
+// ParseProviders splits a comma separated provider list.
+func ParseProviders(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Chain builds the providers of the chain, skipping unknown names.
+func Chain() []Provider {
+	var providers []Provider
+	for _, name := range ProviderNames() {
+		if provider, err := NewProvider(name); err == nil {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// Result is the outcome of a lookup.
+type Result struct {
+	Query Query
+	// Best candidate of the first provider that found one scoring at least
+	// MinScore, nil when there is none
+	Match *Match
+	// Every candidate of the providers that were asked, best first
+	Candidates []Match
+}
+
+// Lookup asks the providers of the chain in order until one finds a match
+// scoring at least MinScore. Without a match it returns ErrNoMatch and the
+// candidates that scored too low. The result is never nil.
+func Lookup(ctx context.Context, q Query) (*Result, error) {
+	return LookupWith(ctx, Chain(), q)
+}
+
+func LookupWith(ctx context.Context, providers []Provider, q Query) (*Result, error) {
+	result := &Result{Query: q}
+	if len(providers) == 0 {
+		return result, ErrNoProviders
+	}
+
+	errs := []error{ErrNoMatch}
+	for _, provider := range providers {
+		candidates, err := provider.Search(ctx, q)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+			continue
+		}
+		for _, candidate := range candidates {
+			result.Candidates = append(result.Candidates, Match{Candidate: candidate, Score: Score(q, candidate)})
+		}
+		if best := Best(q, candidates); best != nil && best.Score >= MinScore {
+			result.Match = best
+			break
+		}
+	}
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Score > result.Candidates[j].Score
+	})
+
+	if result.Match == nil {
+		return result, errors.Join(errs...)
+	}
+	return result, nil
+}
+
+// AudioMetadata returns the tags written for the match.
+func (m *Match) AudioMetadata() utils.AudioMetadata {
+	return utils.AudioMetadata{
+		Title:       m.Title,
+		Artist:      m.Artist,
+		Album:       m.Album,
+		Year:        m.Year,
+		Genre:       m.Genre,
+		ArtworkURL:  m.ArtworkURL,
+		MusicBrainz: m.MusicBrainz,
+	}
+}
+
+// getJSON sends req and decodes the JSON response into v.
+func getJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Beatbump (https://github.com/giwty/Beatbump)")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("returned status: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
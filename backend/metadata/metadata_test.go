@@ -0,0 +1,172 @@
+package metadata
+
+import (
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"beatbump-server/backend/tags"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stub(t *testing.T, path, body string, check func(r *http.Request)) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, path, r.URL.Path)
+		if check != nil {
+			check(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+var query = Query{Title: "Around the World (Official Video)", Artist: "Daft Punk", DurationMs: 429000}
+
+func TestITunesSearch(t *testing.T) {
+	url := stub(t, "/search", `{"resultCount":2,"results":[
+		{"artistName":"Daft Punk","trackName":"Around the World","collectionName":"Homework","artworkUrl100":"http://art/100x100bb.jpg","primaryGenreName":"Electronic","releaseDate":"1997-01-20T08:00:00Z","trackTimeMillis":429533}]}`,
+		func(r *http.Request) {
+			assert.Equal(t, "Daft Punk Around the World", r.URL.Query().Get("term"))
+			assert.Equal(t, "5", r.URL.Query().Get("limit"))
+		})
+
+	candidates, err := NewITunes(url+"/search").Search(context.Background(), query)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, Candidate{
+		Provider: "itunes", Title: "Around the World", Artist: "Daft Punk", Album: "Homework",
+		Year: "1997", Genre: "Electronic", ArtworkURL: "http://art/600x600bb.jpg", DurationMs: 429533,
+	}, candidates[0])
+}
+
+func TestMusicBrainzSearch(t *testing.T) {
+	url := stub(t, "/ws/2/recording", `{"recordings":[{"id":"rec-1","title":"Around the World","length":429000,
+		"artist-credit":[{"name":"Daft Punk","joinphrase":" & ","artist":{"id":"artist-1"}},{"name":"Guest"}],
+		"releases":[{"id":"release-1","title":"Homework","date":"1997-01-17","release-group":{"id":"group-1"}}],
+		"tags":[{"name":"house","count":1},{"name":"french house","count":3}]}]}`,
+		func(r *http.Request) {
+			assert.Equal(t, `recording:"Around the World" AND artist:"Daft Punk"`, r.URL.Query().Get("query"))
+			assert.Contains(t, r.Header.Get("User-Agent"), "Beatbump")
+		})
+
+	candidates, err := NewMusicBrainz(url+"/ws/2/").Search(context.Background(), query)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, Candidate{
+		Provider: "musicbrainz", Title: "Around the World", Artist: "Daft Punk & Guest", Album: "Homework",
+		Year: "1997", Genre: "french house", DurationMs: 429000,
+		MusicBrainz: tags.MusicBrainzIDs{
+			TrackID: "rec-1", AlbumID: "release-1", ReleaseGroupID: "group-1", AlbumArtistID: "artist-1",
+		},
+	}, candidates[0])
+}
+
+func TestDeezerSearch(t *testing.T) {
+	url := stub(t, "/search", `{"data":[{"title":"Around the World","duration":429,
+		"artist":{"name":"Daft Punk"},"album":{"title":"Homework","cover_xl":"http://art/xl.jpg"}}]}`,
+		func(r *http.Request) {
+			assert.Equal(t, `artist:"Daft Punk" track:"Around the World"`, r.URL.Query().Get("q"))
+		})
+
+	candidates, err := NewDeezer(url).Search(context.Background(), query)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, 429000, candidates[0].DurationMs)
+	assert.Equal(t, "http://art/xl.jpg", candidates[0].ArtworkURL)
+
+	url = stub(t, "/search", `{"error":{"type":"Exception","message":"Quota limit exceeded"}}`, nil)
+	_, err = NewDeezer(url).Search(context.Background(), query)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Quota limit exceeded")
+}
+
+func TestScore(t *testing.T) {
+	exact := Candidate{Title: "Around the World", Artist: "Daft Punk", DurationMs: 429533}
+	assert.InDelta(t, 1, Score(query, exact), 0.001)
+
+	// The radio edit has the same name but not the same length
+	edit := Candidate{Title: "Around the World", Artist: "Daft Punk", DurationMs: 240000}
+	assert.Less(t, Score(query, edit), MinScore)
+
+	// A cover by someone else
+	cover := Candidate{Title: "Around the World", Artist: "Red Hot Chili Peppers", DurationMs: 429000}
+	assert.Less(t, Score(query, cover), MinScore)
+
+	// Without durations only the names count
+	assert.InDelta(t, 1, Score(Query{Title: "Song", Artist: "Artist"}, Candidate{Title: "song", Artist: "ARTIST"}), 0.001)
+
+	assert.InDelta(t, 1, Similarity("Daft Punk", "Punk, Daft"), 0.001)
+	assert.Greater(t, Similarity("Beyonce", "Beyoncé"), 0.8)
+	assert.Zero(t, Similarity("", "Song"))
+
+	best := Best(query, []Candidate{edit, exact, cover})
+	require.NotNil(t, best)
+	assert.Equal(t, 429533, best.DurationMs)
+	assert.Nil(t, Best(query, nil))
+}
+
+type fakeProvider struct {
+	name       string
+	candidates []Candidate
+	err        error
+	calls      int
+}
+
+func (f *fakeProvider) Name() string { return f.name }
+
+func (f *fakeProvider) Search(ctx context.Context, q Query) ([]Candidate, error) {
+	f.calls++
+	return f.candidates, f.err
+}
+
+func TestLookupWithFallsThroughTheChain(t *testing.T) {
+	failing := &fakeProvider{name: "down", err: errors.New("unavailable")}
+	wrong := &fakeProvider{name: "wrong", candidates: []Candidate{{Title: "Other Song", Artist: "Other"}}}
+	right := &fakeProvider{name: "right", candidates: []Candidate{{Provider: "right", Title: "Around the World", Artist: "Daft Punk", DurationMs: 430000}}}
+	unused := &fakeProvider{name: "unused"}
+
+	result, err := LookupWith(context.Background(), []Provider{failing, wrong, right, unused}, query)
+	require.NoError(t, err)
+	require.NotNil(t, result.Match)
+	assert.Equal(t, "right", result.Match.Provider)
+	assert.Greater(t, result.Match.Score, 0.9)
+	assert.Zero(t, unused.calls)
+	// Candidates of every asked provider, best first
+	require.Len(t, result.Candidates, 2)
+	assert.Equal(t, "right", result.Candidates[0].Provider)
+
+	result, err = LookupWith(context.Background(), []Provider{failing, wrong}, query)
+	assert.ErrorIs(t, err, ErrNoMatch)
+	assert.Contains(t, err.Error(), "down: unavailable")
+	assert.Nil(t, result.Match)
+	require.Len(t, result.Candidates, 1)
+	assert.Less(t, result.Candidates[0].Score, MinScore)
+
+	_, err = LookupWith(context.Background(), nil, query)
+	assert.ErrorIs(t, err, ErrNoProviders)
+}
+
+func TestProviderChainFromSettings(t *testing.T) {
+	dbtest.Open(t)
+	Configure(config.Default().Metadata)
+	assert.Equal(t, []string{"itunes"}, ProviderNames())
+
+	require.NoError(t, db.SetSetting(db.MetadataProvidersSetting, "deezer, musicbrainz,spotify"))
+	assert.Equal(t, []string{"deezer", "musicbrainz", "spotify"}, ProviderNames())
+	chain := Chain()
+	require.Len(t, chain, 2)
+	assert.Equal(t, "deezer", chain[0].Name())
+	assert.Equal(t, "musicbrainz", chain[1].Name())
+
+	require.NoError(t, db.SetSetting(db.MetadataProvidersSetting, DisabledSetting))
+	assert.Empty(t, Chain())
+}
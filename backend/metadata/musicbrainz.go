@@ -0,0 +1,131 @@
+package metadata
+
+import (
+	"beatbump-server/backend/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultMusicBrainzURL = "https://musicbrainz.org/ws/2"
+
+// MusicBrainz searches recordings. The service allows one request per
+// second and asks clients to identify themselves.
+// See https://musicbrainz.org/doc/MusicBrainz_API/Search
+type MusicBrainz struct {
+	baseURL string
+}
+
+func NewMusicBrainz(baseURL string) *MusicBrainz {
+	if baseURL == "" {
+		baseURL = DefaultMusicBrainzURL
+	}
+	return &MusicBrainz{baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (m *MusicBrainz) Name() string {
+	return "musicbrainz"
+}
+
+type musicBrainzArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     struct {
+		ID string `json:"id"`
+	} `json:"artist"`
+}
+
+type musicBrainzResponse struct {
+	Recordings []struct {
+		ID           string                    `json:"id"`
+		Title        string                    `json:"title"`
+		Length       int                       `json:"length"`
+		ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
+		Releases     []struct {
+			ID           string                    `json:"id"`
+			Title        string                    `json:"title"`
+			Date         string                    `json:"date"`
+			ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
+			ReleaseGroup struct {
+				ID string `json:"id"`
+			} `json:"release-group"`
+		} `json:"releases"`
+		Tags []struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		} `json:"tags"`
+	} `json:"recordings"`
+}
+
+// luceneQuote quotes s as a phrase of a Lucene query.
+func luceneQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func (m *MusicBrainz) Search(ctx context.Context, q Query) ([]Candidate, error) {
+	if err := musicBrainzRateLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	query := "recording:" + luceneQuote(utils.CleanString(q.Title))
+	if artist := utils.CleanString(q.Artist); artist != "" {
+		query += " AND artist:" + luceneQuote(artist)
+	}
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("fmt", "json")
+	params.Set("limit", "5")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+"/recording?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result musicBrainzResponse
+	if err := getJSON(req, &result); err != nil {
+		return nil, fmt.Errorf("MusicBrainz API %w", err)
+	}
+
+	candidates := make([]Candidate, 0, len(result.Recordings))
+	for _, recording := range result.Recordings {
+		var artist strings.Builder
+		for _, credit := range recording.ArtistCredit {
+			artist.WriteString(credit.Name + credit.JoinPhrase)
+		}
+		candidate := Candidate{
+			Provider:   m.Name(),
+			Title:      recording.Title,
+			Artist:     artist.String(),
+			DurationMs: recording.Length,
+		}
+		candidate.MusicBrainz.TrackID = recording.ID
+		if len(recording.Releases) > 0 {
+			release := recording.Releases[0]
+			candidate.Album = release.Title
+			if len(release.Date) >= 4 {
+				candidate.Year = release.Date[:4]
+			}
+			candidate.MusicBrainz.AlbumID = release.ID
+			candidate.MusicBrainz.ReleaseGroupID = release.ReleaseGroup.ID
+			// Search results only credit the release when it differs
+			credits := release.ArtistCredit
+			if len(credits) == 0 {
+				credits = recording.ArtistCredit
+			}
+			if len(credits) > 0 {
+				candidate.MusicBrainz.AlbumArtistID = credits[0].Artist.ID
+			}
+		}
+		// The most voted tag is the closest MusicBrainz has to a genre
+		best := 0
+		for _, tag := range recording.Tags {
+			if tag.Count > best {
+				best, candidate.Genre = tag.Count, tag.Name
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
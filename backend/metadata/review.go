@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/utils"
+	"context"
+	"errors"
+)
+
+// maxReviewCandidates is how many candidates a review offers.
+const maxReviewCandidates = 5
+
+// ErrInvalidChoice is returned when a review has no candidate at the chosen
+// index.
+var ErrInvalidChoice = errors.New("no such candidate")
+
+// Record stores the match score of an enriched track. Without a confident
+// match the track keeps its YouTube metadata and the candidates are queued
+// for review.
+func Record(groupTaskID int, videoID string, result *Result) error {
+	if result.Match != nil {
+		return db.SetSongTaskMatch(groupTaskID, videoID, result.Match.Provider, result.Match.Score)
+	}
+	if len(result.Candidates) == 0 {
+		return nil
+	}
+	if err := db.SetSongTaskMatch(groupTaskID, videoID, "", result.Candidates[0].Score); err != nil {
+		return err
+	}
+
+	q := result.Query
+	candidates := result.Candidates
+	if len(candidates) > maxReviewCandidates {
+		candidates = candidates[:maxReviewCandidates]
+	}
+	review := &db.MetadataReview{
+		GroupTaskID: uint(groupTaskID),
+		VideoID:     videoID,
+		Title:       q.Title,
+		Artist:      q.Artist,
+		Album:       q.Album,
+		DurationMs:  q.DurationMs,
+	}
+	for _, c := range candidates {
+		review.Candidates = append(review.Candidates, db.ReviewCandidate{
+			Provider:    c.Provider,
+			Title:       c.Title,
+			Artist:      c.Artist,
+			Album:       c.Album,
+			Year:        c.Year,
+			Genre:       c.Genre,
+			ArtworkURL:  c.ArtworkURL,
+			DurationMs:  c.DurationMs,
+			Score:       c.Score,
+			MusicBrainz: c.MusicBrainz,
+		})
+	}
+	return db.AddMetadataReview(review)
+}
+
+// Accept applies the candidate at index choice of a pending review: the
+// stored file is re-tagged and the track takes the metadata of the
+// candidate.
+func Accept(ctx context.Context, reviewID, choice int) (*db.MetadataReview, error) {
+	review, err := db.GetMetadataReview(reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.Status != db.ReviewStatusPending {
+		return nil, db.ErrReviewResolved
+	}
+	if choice < 0 || choice >= len(review.Candidates) {
+		return nil, ErrInvalidChoice
+	}
+	song, err := db.GetSongTask(int(review.GroupTaskID), review.VideoID)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate := review.Candidates[choice]
+	meta := utils.AudioMetadata{
+		Title:       candidate.Title,
+		Artist:      candidate.Artist,
+		Album:       candidate.Album,
+		Year:        candidate.Year,
+		Genre:       candidate.Genre,
+		ArtworkURL:  candidate.ArtworkURL,
+		MusicBrainz: candidate.MusicBrainz,
+	}
+	if err := library.RetagTrack(ctx, song, meta); err != nil {
+		return nil, err
+	}
+	if err := db.UpdateSongTaskTags(int(song.GroupTaskID), song.VideoID, song.TrackKey, meta.Title, meta.Artist, meta.Album); err != nil {
+		return nil, err
+	}
+	if err := db.SetSongTaskMatch(int(song.GroupTaskID), song.VideoID, candidate.Provider, candidate.Score); err != nil {
+		return nil, err
+	}
+	if err := db.ResolveMetadataReview(reviewID, db.ReviewStatusAccepted, &choice); err != nil {
+		return nil, err
+	}
+	return db.GetMetadataReview(reviewID)
+}
+
+// Reject closes a pending review, the track keeps its YouTube metadata.
+func Reject(reviewID int) (*db.MetadataReview, error) {
+	if _, err := db.GetMetadataReview(reviewID); err != nil {
+		return nil, err
+	}
+	if err := db.ResolveMetadataReview(reviewID, db.ReviewStatusRejected, nil); err != nil {
+		return nil, err
+	}
+	return db.GetMetadataReview(reviewID)
+}
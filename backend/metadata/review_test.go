@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"beatbump-server/backend/library"
+	"beatbump-server/backend/tags"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupReviewDB(t *testing.T) {
+	t.Helper()
+	dbtest.Open(t)
+	require.NoError(t, db.DB.Create(&db.GroupTask{ID: 1, ReferenceID: "PL1", PlaylistName: "Mix"}).Error)
+	require.NoError(t, db.DB.Create(&db.SongTask{GroupTaskID: 1, VideoID: "vid", Title: "Around the World", Artist: "Daft Punk"}).Error)
+}
+
+func lowConfidence(n int) *Result {
+	result := &Result{Query: query}
+	for i := 0; i < n; i++ {
+		result.Candidates = append(result.Candidates, Match{
+			Candidate: Candidate{Provider: "itunes", Title: "Around the World", Artist: "Other"},
+			Score:     0.6 - float64(i)/100,
+		})
+	}
+	return result
+}
+
+func TestRecordMatch(t *testing.T) {
+	setupReviewDB(t)
+
+	result := &Result{Query: query, Match: &Match{Candidate: Candidate{Provider: "deezer"}, Score: 0.95}}
+	require.NoError(t, Record(1, "vid", result))
+	song, err := db.GetSongTask(1, "vid")
+	require.NoError(t, err)
+	assert.Equal(t, "deezer", song.MatchProvider)
+	assert.InDelta(t, 0.95, song.MatchScore, 0.001)
+
+	reviews, err := db.GetMetadataReviews("")
+	require.NoError(t, err)
+	assert.Empty(t, reviews)
+}
+
+func TestRecordQueuesReview(t *testing.T) {
+	setupReviewDB(t)
+
+	require.NoError(t, Record(1, "vid", lowConfidence(7)))
+	song, err := db.GetSongTask(1, "vid")
+	require.NoError(t, err)
+	assert.Empty(t, song.MatchProvider)
+	assert.InDelta(t, 0.6, song.MatchScore, 0.001)
+
+	reviews, err := db.GetMetadataReviews(db.ReviewStatusPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	assert.Len(t, reviews[0].Candidates, maxReviewCandidates)
+	assert.Equal(t, query.Title, reviews[0].Title)
+
+	// Downloading the track again replaces the pending review
+	require.NoError(t, Record(1, "vid", lowConfidence(2)))
+	reviews, err = db.GetMetadataReviews("")
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	assert.Len(t, reviews[0].Candidates, 2)
+
+	// Nothing to review without candidates
+	require.NoError(t, Record(1, "other", &Result{Query: query}))
+	reviews, err = db.GetMetadataReviews("")
+	require.NoError(t, err)
+	assert.Len(t, reviews, 1)
+}
+
+func TestResolveReview(t *testing.T) {
+	setupReviewDB(t)
+	require.NoError(t, Record(1, "vid", lowConfidence(2)))
+	reviews, err := db.GetMetadataReviews(db.ReviewStatusPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	id := int(reviews[0].ID)
+
+	_, err = Accept(context.Background(), id, 2)
+	assert.ErrorIs(t, err, ErrInvalidChoice)
+	// The song was never stored
+	_, err = Accept(context.Background(), id, 0)
+	assert.ErrorIs(t, err, library.ErrNoDownloadPath)
+
+	review, err := Reject(id)
+	require.NoError(t, err)
+	assert.Equal(t, db.ReviewStatusRejected, review.Status)
+	assert.Nil(t, review.Choice)
+
+	_, err = Reject(id)
+	assert.ErrorIs(t, err, db.ErrReviewResolved)
+	_, err = Accept(context.Background(), id, 0)
+	assert.ErrorIs(t, err, db.ErrReviewResolved)
+}
+
+func TestAcceptKeepsMusicBrainzIDs(t *testing.T) {
+	setupReviewDB(t)
+	root := t.TempDir()
+	require.NoError(t, db.SetSetting(db.DownloadPathSetting, root))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "Mix"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "Mix", "vid.mp3"), []byte("mpeg frames"), 0644))
+	require.NoError(t, db.MarkSongTaskCompleted(1, "vid", filepath.Join("Mix", "vid.mp3"), ""))
+
+	ids := tags.MusicBrainzIDs{TrackID: "recording", AlbumID: "release", ReleaseGroupID: "group", AlbumArtistID: "artist"}
+	result := lowConfidence(1)
+	result.Candidates[0].Provider = "musicbrainz"
+	result.Candidates[0].MusicBrainz = ids
+	require.NoError(t, Record(1, "vid", result))
+	reviews, err := db.GetMetadataReviews(db.ReviewStatusPending)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	assert.Equal(t, ids, reviews[0].Candidates[0].MusicBrainz)
+
+	_, err = Accept(context.Background(), int(reviews[0].ID), 0)
+	require.NoError(t, err)
+	song, err := db.GetSongTask(1, "vid")
+	require.NoError(t, err)
+	got, err := library.ReadTags(context.Background(), song)
+	require.NoError(t, err)
+	assert.Equal(t, ids, got.MusicBrainz)
+	assert.Equal(t, "Other", got.Artist)
+}
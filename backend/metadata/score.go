@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"beatbump-server/backend/utils"
+	"strings"
+	"unicode"
+)
+
+// MinScore is the lowest score Lookup accepts as a match.
+const MinScore = 0.75
+
+const (
+	titleWeight  = 0.55
+	artistWeight = 0.45
+
+	// Durations this close count as equal, this far apart as another
+	// version of the track, which halves the score
+	durationToleranceMs = 2000
+	durationLimitMs     = 20000
+)
+
+// Score rates from 0 to 1 how well c matches q. The title and artist
+// similarity is scaled down by up to half as the durations differ, so
+// neither a cover nor a radio edit of the right song passes MinScore.
+func Score(q Query, c Candidate) float64 {
+	score := Similarity(q.Title, c.Title)*titleWeight + Similarity(q.Artist, c.Artist)*artistWeight
+	if q.DurationMs > 0 && c.DurationMs > 0 {
+		score *= 0.5 + durationScore(q.DurationMs, c.DurationMs)/2
+	}
+	return score
+}
+
+// Best returns the highest scoring candidate, the earlier one on a tie.
+func Best(q Query, candidates []Candidate) *Match {
+	var best *Match
+	for _, candidate := range candidates {
+		score := Score(q, candidate)
+		if best == nil || score > best.Score {
+			best = &Match{Candidate: candidate, Score: score}
+		}
+	}
+	return best
+}
+
+func durationScore(a, b int) float64 {
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	switch {
+	case diff <= durationToleranceMs:
+		return 1
+	case diff >= durationLimitMs:
+		return 0
+	default:
+		return 1 - float64(diff-durationToleranceMs)/float64(durationLimitMs-durationToleranceMs)
+	}
+}
+
+// Similarity compares two names after removing YouTube noise, case and
+// punctuation. It is the better of the word overlap, which ignores the
+// order of words, and the edit distance, which tolerates typos.
+func Similarity(a, b string) float64 {
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	joinedA, joinedB := strings.Join(wordsA, " "), strings.Join(wordsB, " ")
+	if joinedA == joinedB {
+		return 1
+	}
+
+	counts := make(map[string]int, len(wordsA))
+	for _, w := range wordsA {
+		counts[w]++
+	}
+	shared := 0
+	for _, w := range wordsB {
+		if counts[w] > 0 {
+			counts[w]--
+			shared++
+		}
+	}
+	overlap := 2 * float64(shared) / float64(len(wordsA)+len(wordsB))
+
+	ra, rb := []rune(joinedA), []rune(joinedB)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	edit := 1 - float64(levenshtein(ra, rb))/float64(longest)
+
+	if edit > overlap {
+		return edit
+	}
+	return overlap
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(utils.CleanString(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "beatbump"
+
+var (
+	InnertubeRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "innertube_requests_total",
+		Help:      "Innertube API requests by endpoint, client and HTTP status.",
+	}, []string{"endpoint", "client", "status"})
+
+	InnertubeRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "innertube_request_duration_seconds",
+		Help:      "Innertube API request latency by endpoint and client.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "client"})
+
+	OngoingPlaylistLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ongoing_playlist_lookups_total",
+		Help:      "Ongoing listening plays from a playlist by whether its download task existed (found) or had to be created (created).",
+	}, []string{"result"})
+
+	DownloadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Audio bytes written by the downloader.",
+	})
+
+	SongTasksProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "song_tasks_processed_total",
+		Help:      "Song tasks handled by the worker by result.",
+	}, []string{"result"})
+
+	SongTaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "song_task_duration_seconds",
+		Help:      "Time to download and convert a single track.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"result"})
+
+	ConversionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_conversion_duration_seconds",
+		Help:      "ffmpeg conversion time by result.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"result"})
+
+	ItunesRateLimitWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "itunes_rate_limiter_wait_seconds",
+		Help:      "Time spent waiting for the iTunes rate limiter.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 2, 3, 5, 10, 30, 60},
+	})
+
+	Failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failures_total",
+		Help:      "Downloader failures by stage and error class.",
+	}, []string{"stage", "class"})
+
+	WorkerTicks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_ticks_total",
+		Help:      "Download worker polling iterations.",
+	})
+
+	WorkerLastTick = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_last_tick_timestamp_seconds",
+		Help:      "Unix time of the last download worker iteration.",
+	})
+)
+
+// QueueDepthFunc returns the number of song tasks per status.
+type QueueDepthFunc func() (map[string]int64, error)
+
+type queueCollector struct {
+	desc *prometheus.Desc
+
+	mu     sync.RWMutex
+	source QueueDepthFunc
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	source := c.source
+	c.mu.RUnlock()
+	if source == nil {
+		return
+	}
+
+	counts, err := source()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), status)
+	}
+}
+
+var (
+	queueDepth = &queueCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "download_queue_depth"),
+			"Song tasks by status.",
+			[]string{"status"}, nil,
+		),
+	}
+	registerQueueDepth sync.Once
+)
+
+// RegisterQueueDepth exposes the download queue depth, queried at scrape time.
+// The collector is registered once, later calls only replace its source.
+func RegisterQueueDepth(source QueueDepthFunc) {
+	queueDepth.mu.Lock()
+	queueDepth.source = source
+	queueDepth.mu.Unlock()
+	registerQueueDepth.Do(func() {
+		prometheus.MustRegister(queueDepth)
+	})
+}
+
+// ObserveSince records the seconds elapsed since start.
+func ObserveSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// RecordFailure counts a failure in the given stage, classified by error.
+func RecordFailure(stage string, err error) {
+	Failures.WithLabelValues(stage, ErrorClass(err)).Inc()
+}
+
+// ErrorClass buckets errors into a small set of label values.
+func ErrorClass(err error) string {
+	if err == nil {
+		return "none"
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, os.ErrPermission):
+		return "filesystem"
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "not playable"):
+		return "not_playable"
+	case strings.Contains(msg, "ffmpeg"):
+		return "ffmpeg"
+	case strings.Contains(msg, "status"):
+		return "http_status"
+	case strings.Contains(msg, "disk space"):
+		return "disk_space"
+	case strings.Contains(msg, "file"):
+		return "filesystem"
+	}
+	return "other"
+}
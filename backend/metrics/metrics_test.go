@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "none", ErrorClass(nil))
+	assert.Equal(t, "timeout", ErrorClass(fmt.Errorf("get: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "filesystem", ErrorClass(fmt.Errorf("open: %w", os.ErrPermission)))
+	assert.Equal(t, "not_playable", ErrorClass(errors.New("not playable: LOGIN_REQUIRED")))
+	assert.Equal(t, "http_status", ErrorClass(errors.New("unexpected status code: 403")))
+	assert.Equal(t, "ffmpeg", ErrorClass(errors.New("ffmpeg failed: exit status 1")))
+	assert.Equal(t, "other", ErrorClass(errors.New("boom")))
+}
+
+func TestQueueCollector(t *testing.T) {
+	collector := &queueCollector{
+		desc: prometheus.NewDesc("test_queue_depth", "test", []string{"status"}, nil),
+		source: func() (map[string]int64, error) {
+			return map[string]int64{"pending": 3, "completed": 7}, nil
+		},
+	}
+	assert.Equal(t, 2, testutil.CollectAndCount(collector))
+}
+
+func TestRegisterQueueDepthTwice(t *testing.T) {
+	RegisterQueueDepth(func() (map[string]int64, error) {
+		return map[string]int64{"pending": 1}, nil
+	})
+	assert.NotPanics(t, func() {
+		RegisterQueueDepth(func() (map[string]int64, error) {
+			return map[string]int64{"pending": 1, "failed": 2}, nil
+		})
+	})
+	assert.Equal(t, 2, testutil.CollectAndCount(queueDepth))
+}
@@ -0,0 +1,87 @@
+// Package nfo writes the album.nfo and artist.nfo files Kodi and Jellyfin
+// read the metadata of a music folder from.
+package nfo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// File names inside a task folder
+const (
+	AlbumFile  = "album.nfo"
+	ArtistFile = "artist.nfo"
+)
+
+// VariousArtists is the album artist of albums by several artists.
+const VariousArtists = "Various Artists"
+
+// Album is the content of album.nfo.
+type Album struct {
+	XMLName                   xml.Name `xml:"album"`
+	Title                     string   `xml:"title"`
+	MusicBrainzAlbumID        string   `xml:"musicbrainzalbumid,omitempty"`
+	MusicBrainzReleaseGroupID string   `xml:"musicbrainzreleasegroupid,omitempty"`
+	// Kodi reads the credits, Jellyfin the album artist
+	Artist             string              `xml:"artist"`
+	AlbumArtist        string              `xml:"albumartist"`
+	AlbumArtistCredits *AlbumArtistCredits `xml:"albumArtistCredits,omitempty"`
+	Genres             []string            `xml:"genre"`
+	Year               string              `xml:"year,omitempty"`
+	Compilation        bool                `xml:"compilation"`
+	Tracks             []Track             `xml:"track"`
+}
+
+// AlbumArtistCredits links the album artist to MusicBrainz.
+type AlbumArtistCredits struct {
+	Artist              string `xml:"artist"`
+	MusicBrainzArtistID string `xml:"musicBrainzArtistID,omitempty"`
+}
+
+// Track is a track of an album.
+type Track struct {
+	Position           int    `xml:"position"`
+	Title              string `xml:"title"`
+	Duration           string `xml:"duration,omitempty"`
+	MusicBrainzTrackID string `xml:"musicbrainztrackid,omitempty"`
+}
+
+// Duration formats a length in milliseconds as Kodi writes it, "4:05".
+// It returns an empty string for unknown lengths.
+func Duration(ms int) string {
+	if ms <= 0 {
+		return ""
+	}
+	seconds := (ms + 500) / 1000
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Artist is the content of artist.nfo.
+type Artist struct {
+	XMLName             xml.Name `xml:"artist"`
+	Name                string   `xml:"name"`
+	MusicBrainzArtistID string   `xml:"musicBrainzArtistID,omitempty"`
+	Biography           string   `xml:"biography,omitempty"`
+	Thumbs              []Thumb  `xml:"thumb"`
+}
+
+// Thumb is an image of an artist, by URL.
+type Thumb struct {
+	Aspect string `xml:"aspect,attr,omitempty"`
+	URL    string `xml:",chardata"`
+}
+
+// Marshal encodes an Album or an Artist as an NFO file, every value is
+// escaped.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>` + "\n")
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
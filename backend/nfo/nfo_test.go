@@ -0,0 +1,60 @@
+package nfo
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalAlbum(t *testing.T) {
+	album := &Album{
+		Title:              `Rock & Roll <Live> "2001"`,
+		MusicBrainzAlbumID: "release-1",
+		Artist:             "AC/DC",
+		AlbumArtist:        "AC/DC",
+		AlbumArtistCredits: &AlbumArtistCredits{Artist: "AC/DC", MusicBrainzArtistID: "artist-1"},
+		Genres:             []string{"Rock", "Hard Rock"},
+		Year:               "2001",
+		Tracks: []Track{
+			{Position: 1, Title: "Intro & Outro", Duration: Duration(245000)},
+			{Position: 2, Title: "Encore"},
+		},
+	}
+	data, err := Marshal(album)
+	require.NoError(t, err)
+	content := string(data)
+
+	assert.True(t, strings.HasPrefix(content, `<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>`))
+	assert.Contains(t, content, "<title>Rock &amp; Roll &lt;Live&gt; &#34;2001&#34;</title>")
+	assert.Contains(t, content, "<genre>Rock</genre>\n  <genre>Hard Rock</genre>")
+	assert.Contains(t, content, "<compilation>false</compilation>")
+	assert.Contains(t, content, "<duration>4:05</duration>")
+	assert.NotContains(t, content, "musicbrainzreleasegroupid")
+
+	var decoded Album
+	require.NoError(t, xml.Unmarshal(data, &decoded))
+	decoded.XMLName = xml.Name{}
+	assert.Equal(t, album, &decoded)
+}
+
+func TestMarshalArtist(t *testing.T) {
+	data, err := Marshal(&Artist{
+		Name:      "Simon & Garfunkel",
+		Biography: "Duo <folk>",
+		Thumbs:    []Thumb{{Aspect: "thumb", URL: "https://example.com/a.jpg?w=1&h=1"}},
+	})
+	require.NoError(t, err)
+	content := string(data)
+	assert.Contains(t, content, "<name>Simon &amp; Garfunkel</name>")
+	assert.Contains(t, content, "<biography>Duo &lt;folk&gt;</biography>")
+	assert.Contains(t, content, `<thumb aspect="thumb">https://example.com/a.jpg?w=1&amp;h=1</thumb>`)
+}
+
+func TestDuration(t *testing.T) {
+	assert.Equal(t, "", Duration(0))
+	assert.Equal(t, "0:01", Duration(600))
+	assert.Equal(t, "62:03", Duration(3723000))
+}
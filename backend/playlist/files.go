@@ -0,0 +1,138 @@
+package playlist
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/storage"
+	"beatbump-server/backend/utils"
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Styles of the track paths, see the playlist paths setting
+const (
+	PathsRelative = "relative"
+	PathsAbsolute = "absolute"
+)
+
+// FileName is the name of the playlist file of format in a task folder.
+func FileName(format string) string {
+	return "playlist." + format
+}
+
+// ParseFormats splits a comma separated format list, it returns
+// ErrUnknownFormat for a format missing from Formats.
+func ParseFormats(list string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(list, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" || slices.Contains(formats, format) {
+			continue
+		}
+		if !slices.Contains(Formats, format) {
+			return nil, ErrUnknownFormat
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// ConfiguredFormats returns the formats written into task folders, M3U8
+// when the setting is unset. M3U8 is always written while deduplication
+// relies on it.
+func ConfiguredFormats() []string {
+	value, _ := db.GetSetting(db.PlaylistFormatsSetting)
+	formats, err := ParseFormats(value)
+	if err != nil || len(formats) == 0 {
+		formats = []string{FormatM3U8}
+	}
+	if storage.DedupMode() == storage.DedupM3U && !slices.Contains(formats, FormatM3U8) {
+		formats = append(formats, FormatM3U8)
+	}
+	return formats
+}
+
+// ConfiguredPaths returns the path style of the playlist paths setting.
+func ConfiguredPaths() string {
+	if value, _ := db.GetSetting(db.PlaylistPathsSetting); value == PathsAbsolute {
+		return PathsAbsolute
+	}
+	return PathsRelative
+}
+
+// Build returns the playlist of the completed tracks in songs. Relative
+// paths start at folder, absolute ones at downloadPath.
+func Build(title string, songs []db.SongTask, folder, downloadPath, paths string) *Playlist {
+	p := &Playlist{Title: title}
+	for _, song := range songs {
+		if song.Status != db.TaskStatusCompleted || song.FilePath == "" {
+			continue
+		}
+		var entryPath string
+		if paths == PathsAbsolute {
+			entryPath = filepath.Join(downloadPath, song.FilePath)
+		} else {
+			// Shared tracks live outside the folder
+			var err error
+			if entryPath, err = filepath.Rel(folder, song.FilePath); err != nil {
+				entryPath = filepath.Base(song.FilePath)
+			}
+		}
+		p.Entries = append(p.Entries, Entry{
+			Path:       entryPath,
+			Title:      song.Title,
+			Artist:     song.Artist,
+			Album:      song.Album,
+			DurationMs: song.DurationMs,
+		})
+	}
+	return p
+}
+
+// ForTask returns the playlist of a group task and the folder of its files.
+func ForTask(groupTaskID int, paths string) (*Playlist, string, error) {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	groupTask, err := db.GetGroupTask(groupTaskID)
+	if err != nil {
+		return nil, "", err
+	}
+	songs, err := db.GetSongTasks(groupTaskID)
+	if err != nil {
+		return nil, "", err
+	}
+	_, folder, title := utils.ResolveDownloadDirectory(groupTask, downloadPath)
+	return Build(title, songs, folder, downloadPath, paths), folder, nil
+}
+
+// Update writes the playlist files of a group task into its folder in the
+// configured formats and removes those of the other formats.
+func Update(ctx context.Context, groupTaskID int) error {
+	downloadPath, _ := db.GetSetting(db.DownloadPathSetting)
+	if downloadPath == "" {
+		return nil
+	}
+	p, folder, err := ForTask(groupTaskID, ConfiguredPaths())
+	if err != nil {
+		return err
+	}
+	store := storage.Resolve(downloadPath)
+
+	formats := ConfiguredFormats()
+	var errs []error
+	for _, format := range Formats {
+		key := storage.Key(filepath.Join(folder, FileName(format)))
+		if !slices.Contains(formats, format) {
+			errs = append(errs, store.Delete(ctx, key))
+			continue
+		}
+		var buf bytes.Buffer
+		if err := Encode(&buf, p, format); err != nil {
+			return err
+		}
+		errs = append(errs, store.Put(ctx, key, &buf, int64(buf.Len())))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package playlist
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) string {
+	t.Helper()
+	dbtest.Open(t)
+
+	root := t.TempDir()
+	require.NoError(t, db.SetSetting(db.DownloadPathSetting, root))
+	return root
+}
+
+func TestUpdate(t *testing.T) {
+	root := setupTestDB(t)
+	require.NoError(t, db.AddGroupTask(db.TaskTypePlaylistDownload, "PL1", "Playlist", db.TaskSourceUser, -1))
+	group, err := db.GetGroupTaskByReferenceID("PL1")
+	require.NoError(t, err)
+	groupID := int(group.ID)
+	require.NoError(t, db.AddSongTask(groupID, "a", "One", "A", "", ""))
+	require.NoError(t, db.AddSongTask(groupID, "b", "Two", "B", "", ""))
+	require.NoError(t, db.MarkSongTaskCompleted(groupID, "a", filepath.Join("Playlist", "A - One.mp3"), ""))
+	require.NoError(t, db.SetSongTaskDuration(groupID, "a", 180000))
+
+	ctx := context.Background()
+	require.NoError(t, Update(ctx, groupID))
+	data, err := os.ReadFile(filepath.Join(root, "Playlist", "playlist.m3u8"))
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXTINF:180,A - One\nA - One.mp3\n", string(data))
+
+	// Other formats replace the M3U8
+	require.NoError(t, db.SetSetting(db.PlaylistFormatsSetting, "xspf,pls"))
+	require.NoError(t, db.SetSetting(db.PlaylistPathsSetting, PathsAbsolute))
+	require.NoError(t, Update(ctx, groupID))
+	assert.NoFileExists(t, filepath.Join(root, "Playlist", "playlist.m3u8"))
+	data, err = os.ReadFile(filepath.Join(root, "Playlist", "playlist.pls"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "File1="+filepath.ToSlash(filepath.Join(root, "Playlist", "A - One.mp3")))
+	assert.FileExists(t, filepath.Join(root, "Playlist", "playlist.xspf"))
+}
@@ -0,0 +1,200 @@
+// Package playlist exports the tracks of a download task as playlist files:
+// M3U8, XSPF, PLS and JSPF.
+package playlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Playlist formats
+const (
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+	FormatPLS  = "pls"
+	FormatJSPF = "jspf"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{FormatM3U8, FormatXSPF, FormatPLS, FormatJSPF}
+
+// ErrUnknownFormat is returned for formats missing from Formats.
+var ErrUnknownFormat = errors.New("unknown playlist format")
+
+var contentTypes = map[string]string{
+	FormatM3U8: "audio/x-mpegurl",
+	FormatXSPF: "application/xspf+xml",
+	FormatPLS:  "audio/x-scpls",
+	FormatJSPF: "application/json",
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Playlist is an ordered list of tracks.
+type Playlist struct {
+	Title   string
+	Entries []Entry
+}
+
+// Entry is a track of a playlist.
+type Entry struct {
+	// Path of the file, relative to the playlist or absolute
+	Path       string
+	Title      string
+	Artist     string
+	Album      string
+	DurationMs int // 0 when unknown
+}
+
+func (e *Entry) displayTitle() string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// seconds is the duration in whole seconds, -1 when unknown as M3U and PLS
+// expect.
+func (e *Entry) seconds() int {
+	if e.DurationMs <= 0 {
+		return -1
+	}
+	return (e.DurationMs + 500) / 1000
+}
+
+// location is the path as a URI, as XSPF and JSPF expect.
+func (e *Entry) location() string {
+	p := filepath.ToSlash(e.Path)
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	escaped := strings.Join(segments, "/")
+	if filepath.IsAbs(e.Path) {
+		if !strings.HasPrefix(escaped, "/") {
+			// Windows drive letters
+			escaped = "/" + escaped
+		}
+		return "file://" + escaped
+	}
+	return escaped
+}
+
+// Encode writes p to w in format.
+func Encode(w io.Writer, p *Playlist, format string) error {
+	switch format {
+	case FormatM3U8:
+		return encodeM3U8(w, p)
+	case FormatXSPF:
+		return encodeXSPF(w, p)
+	case FormatPLS:
+		return encodePLS(w, p)
+	case FormatJSPF:
+		return encodeJSPF(w, p)
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+func encodeM3U8(w io.Writer, p *Playlist) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("#EXTM3U\n")
+	for _, e := range p.Entries {
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", e.seconds(), e.displayTitle())
+		b.WriteString(filepath.ToSlash(e.Path) + "\n")
+	}
+	return b.Flush()
+}
+
+func encodePLS(w io.Writer, p *Playlist) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("[playlist]\n")
+	for i, e := range p.Entries {
+		n := i + 1
+		fmt.Fprintf(b, "File%d=%s\n", n, filepath.ToSlash(e.Path))
+		fmt.Fprintf(b, "Title%d=%s\n", n, e.displayTitle())
+		fmt.Fprintf(b, "Length%d=%d\n", n, e.seconds())
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\nVersion=2\n", len(p.Entries))
+	return b.Flush()
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version int         `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int    `xml:"duration,omitempty"`
+}
+
+func encodeXSPF(w io.Writer, p *Playlist) error {
+	playlist := xspfPlaylist{Version: 1, Title: p.Title, Tracks: []xspfTrack{}}
+	for _, e := range p.Entries {
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{
+			Location: e.location(),
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: max(e.DurationMs, 0),
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type jspfPlaylist struct {
+	Playlist struct {
+		Title string      `json:"title,omitempty"`
+		Track []jspfTrack `json:"track"`
+	} `json:"playlist"`
+}
+
+type jspfTrack struct {
+	Location []string `json:"location"`
+	Title    string   `json:"title,omitempty"`
+	Creator  string   `json:"creator,omitempty"`
+	Album    string   `json:"album,omitempty"`
+	Duration int      `json:"duration,omitempty"`
+}
+
+func encodeJSPF(w io.Writer, p *Playlist) error {
+	var playlist jspfPlaylist
+	playlist.Playlist.Title = p.Title
+	playlist.Playlist.Track = []jspfTrack{}
+	for _, e := range p.Entries {
+		playlist.Playlist.Track = append(playlist.Playlist.Track, jspfTrack{
+			Location: []string{e.location()},
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: max(e.DurationMs, 0),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(playlist)
+}
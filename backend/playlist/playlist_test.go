@@ -0,0 +1,85 @@
+package playlist
+
+import (
+	"beatbump-server/backend/db"
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(t *testing.T, p *Playlist, format string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, p, format))
+	return buf.String()
+}
+
+func TestBuildReferencesSharedTracks(t *testing.T) {
+	songs := []db.SongTask{
+		{Status: db.TaskStatusCompleted, Title: "One", Artist: "A", FilePath: filepath.Join("Playlist", "A - One.mp3"), DurationMs: 225400},
+		{Status: db.TaskStatusCompleted, Title: "Two", Artist: "B", FilePath: filepath.FromSlash(".beatbump-tracks/mp3/two.mp3")},
+		{Status: db.TaskStatusFailed, Title: "Three", Artist: "C"},
+	}
+	p := Build("Playlist", songs, "Playlist", "/music", PathsRelative)
+	assert.Equal(t, "#EXTM3U\n#EXTINF:225,A - One\nA - One.mp3\n#EXTINF:-1,B - Two\n../.beatbump-tracks/mp3/two.mp3\n", encode(t, p, FormatM3U8))
+
+	p = Build("Playlist", songs, "Playlist", "/music", PathsAbsolute)
+	assert.Equal(t, filepath.FromSlash("/music/Playlist/A - One.mp3"), p.Entries[0].Path)
+}
+
+func TestEncode(t *testing.T) {
+	p := &Playlist{Title: "Rock & Roll", Entries: []Entry{
+		{Path: "A - <One>.mp3", Title: "<One>", Artist: "A", Album: "X", DurationMs: 61000},
+		{Path: "../.beatbump-tracks/mp3/two.mp3", Title: "Two"},
+	}}
+
+	assert.Equal(t, `[playlist]
+File1=A - <One>.mp3
+Title1=A - <One>
+Length1=61
+File2=../.beatbump-tracks/mp3/two.mp3
+Title2=Two
+Length2=-1
+NumberOfEntries=2
+Version=2
+`, encode(t, p, FormatPLS))
+
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<playlist xmlns="http://xspf.org/ns/0/" version="1">
+  <title>Rock &amp; Roll</title>
+  <trackList>
+    <track>
+      <location>A%20-%20%3COne%3E.mp3</location>
+      <title>&lt;One&gt;</title>
+      <creator>A</creator>
+      <album>X</album>
+      <duration>61000</duration>
+    </track>
+    <track>
+      <location>../.beatbump-tracks/mp3/two.mp3</location>
+      <title>Two</title>
+    </track>
+  </trackList>
+</playlist>
+`, encode(t, p, FormatXSPF))
+
+	assert.JSONEq(t, `{"playlist": {"title": "Rock & Roll", "track": [
+		{"location": ["A%20-%20%3COne%3E.mp3"], "title": "<One>", "creator": "A", "album": "X", "duration": 61000},
+		{"location": ["../.beatbump-tracks/mp3/two.mp3"], "title": "Two"}
+	]}}`, encode(t, p, FormatJSPF))
+
+	assert.Equal(t, "file:///music/A%20-%20One.mp3", (&Entry{Path: filepath.FromSlash("/music/A - One.mp3")}).location())
+	assert.ErrorIs(t, Encode(&bytes.Buffer{}, p, "wpl"), ErrUnknownFormat)
+}
+
+func TestParseFormats(t *testing.T) {
+	formats, err := ParseFormats(" XSPF,m3u8,,xspf")
+	require.NoError(t, err)
+	assert.Equal(t, []string{FormatXSPF, FormatM3U8}, formats)
+
+	_, err = ParseFormats("m3u8,wpl")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
@@ -0,0 +1,238 @@
+// Package schedule decides when the download worker runs: quiet hours in
+// which it pauses or slows down, and the recurring refresh of subscribed
+// playlists. Times are read in the schedule timezone setting.
+package schedule
+
+import (
+	"beatbump-server/backend/db"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Timezones without the system database, as in minimal containers
+	_ "time/tzdata"
+)
+
+// What the worker does in quiet hours
+const (
+	// No new downloads start
+	ModePause = "pause"
+	// One download at a time
+	ModeThrottle = "throttle"
+)
+
+// Modes lists the quiet hour modes.
+var Modes = []string{ModePause, ModeThrottle}
+
+// Disabled is the quiet hours setting without quiet hours.
+const Disabled = "none"
+
+var ErrInvalidWindow = errors.New(`quiet hours must look like "23:00-07:00" or "mon-fri 09:00-17:00"`)
+
+var ErrInvalidRecurrence = errors.New(`recurrence must look like "daily 03:00", "weekly sun 03:00" or "every 6h"`)
+
+// MinInterval is the shortest interval of an "every" recurrence.
+const MinInterval = 15 * time.Minute
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	i := slices.Index(weekdays, strings.ToLower(s))
+	return time.Weekday(i), i >= 0
+}
+
+// parseClock reads "HH:MM" as minutes after midnight, "24:00" included.
+func parseClock(s string) (int, bool) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	if !ok || len(minutes) != 2 {
+		return 0, false
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, false
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// Window is a daily span of quiet hours, on some weekdays only.
+type Window struct {
+	// By time.Weekday, the day the window starts on
+	days [7]bool
+	// Minutes after midnight, a window ending before it starts crosses
+	// midnight
+	start, end int
+}
+
+// ParseWindows reads a comma separated list of windows such as
+// "23:00-07:00" or "mon-fri 09:00-17:00". Days are single weekdays or
+// ranges, the first three letters of their English name.
+func ParseWindows(list string) ([]Window, error) {
+	if strings.TrimSpace(list) == Disabled {
+		return nil, nil
+	}
+	var windows []Window
+	for _, spec := range strings.Split(list, ",") {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, ErrInvalidWindow
+		}
+
+		var w Window
+		if len(fields) == 1 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		} else {
+			first, last, isRange := strings.Cut(fields[0], "-")
+			if !isRange {
+				last = first
+			}
+			from, validFrom := parseWeekday(first)
+			to, validTo := parseWeekday(last)
+			if !validFrom || !validTo {
+				return nil, ErrInvalidWindow
+			}
+			for day := from; ; day = (day + 1) % 7 {
+				w.days[day] = true
+				if day == to {
+					break
+				}
+			}
+		}
+
+		start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+		if !ok {
+			return nil, ErrInvalidWindow
+		}
+		var validStart, validEnd bool
+		w.start, validStart = parseClock(start)
+		w.end, validEnd = parseClock(end)
+		if !validStart || !validEnd || w.start == w.end || w.start == 24*60 {
+			return nil, ErrInvalidWindow
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// Contains reports whether t, in the location of t, falls in the window.
+func (w Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// The part after midnight belongs to the window of the day before
+	return (w.days[day] && minute >= w.start) || (w.days[(day+6)%7] && minute < w.end)
+}
+
+// Recurrence is how often a subscription is refreshed.
+type Recurrence struct {
+	every   time.Duration
+	weekday time.Weekday
+	weekly  bool
+	minute  int
+}
+
+// ParseRecurrence reads "daily HH:MM", "weekly <day> HH:MM" or
+// "every <duration>", such as "every 6h".
+func ParseRecurrence(spec string) (*Recurrence, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) < 2 {
+		return nil, ErrInvalidRecurrence
+	}
+	r := &Recurrence{}
+	var ok bool
+	switch {
+	case fields[0] == "every" && len(fields) == 2:
+		every, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, ErrInvalidRecurrence
+		}
+		if every < MinInterval {
+			return nil, fmt.Errorf("recurrence must be at least %s apart", MinInterval)
+		}
+		r.every = every
+		return r, nil
+	case fields[0] == "daily" && len(fields) == 2:
+		r.minute, ok = parseClock(fields[1])
+	case fields[0] == "weekly" && len(fields) == 3:
+		r.weekly = true
+		if r.weekday, ok = parseWeekday(fields[1]); ok {
+			r.minute, ok = parseClock(fields[2])
+		}
+	}
+	if !ok || r.minute == 24*60 {
+		return nil, ErrInvalidRecurrence
+	}
+	return r, nil
+}
+
+// Next returns the first time after t the recurrence comes up, daily and
+// weekly times in the location of t.
+func (r *Recurrence) Next(t time.Time) time.Time {
+	if r.every > 0 {
+		return t.Add(r.every)
+	}
+	year, month, day := t.Date()
+	next := time.Date(year, month, day, r.minute/60, r.minute%60, 0, 0, t.Location())
+	for !next.After(t) || (r.weekly && next.Weekday() != r.weekday) {
+		next = time.Date(next.Year(), next.Month(), next.Day()+1, r.minute/60, r.minute%60, 0, 0, t.Location())
+	}
+	return next
+}
+
+// Location returns the timezone of the schedule timezone setting, the
+// local timezone of the server when it is unset or unknown.
+func Location() *time.Location {
+	name, _ := db.GetSetting(db.ScheduleTimezoneSetting)
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// QuietMode returns the quiet hour mode when t falls in the quiet hours
+// setting, an empty string otherwise.
+func QuietMode(t time.Time) string {
+	list, _ := db.GetSetting(db.QuietHoursSetting)
+	windows, err := ParseWindows(list)
+	if err != nil {
+		return ""
+	}
+	t = t.In(Location())
+	for _, w := range windows {
+		if w.Contains(t) {
+			if mode, _ := db.GetSetting(db.QuietHoursModeSetting); mode == ModeThrottle {
+				return ModeThrottle
+			}
+			return ModePause
+		}
+	}
+	return ""
+}
+
+// ParseTime reads a start time, RFC 3339 or "2006-01-02 15:04" in the
+// schedule timezone.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf(`invalid time %q, use RFC 3339 or "2006-01-02 15:04"`, s)
+}
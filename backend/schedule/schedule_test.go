@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindows(t *testing.T) {
+	windows, err := ParseWindows("mon-fri 09:00-17:00, sat 23:00-07:00")
+	require.NoError(t, err)
+	require.Len(t, windows, 2)
+
+	at := func(day, clock string) time.Time {
+		// 2026-10-19 is a Monday
+		base, err := time.Parse("2006-01-02 15:04", "2026-10-19 "+clock)
+		require.NoError(t, err)
+		weekday, ok := parseWeekday(day)
+		require.True(t, ok)
+		return base.AddDate(0, 0, (int(weekday)+6)%7)
+	}
+	tests := []struct {
+		day, clock string
+		want       bool
+	}{
+		{"mon", "09:00", true},
+		{"fri", "16:59", true},
+		{"fri", "17:00", false},
+		{"sat", "12:00", false},
+		{"sat", "23:30", true},
+		// After midnight the Saturday window goes on
+		{"sun", "06:00", true},
+		{"mon", "06:00", false},
+	}
+	for _, tt := range tests {
+		got := false
+		for _, w := range windows {
+			got = got || w.Contains(at(tt.day, tt.clock))
+		}
+		assert.Equal(t, tt.want, got, "%s %s", tt.day, tt.clock)
+	}
+
+	windows, err = ParseWindows("fri-mon 00:00-24:00")
+	require.NoError(t, err)
+	assert.True(t, windows[0].Contains(at("sun", "12:00")))
+	assert.False(t, windows[0].Contains(at("wed", "12:00")))
+
+	windows, err = ParseWindows(Disabled)
+	require.NoError(t, err)
+	assert.Empty(t, windows)
+
+	for _, invalid := range []string{"9-17", "09:00-09:00", "funday 09:00-17:00", "mon-xyz 09:00-17:00", "mon 09:00-17:00 extra", "25:00-26:00"} {
+		_, err := ParseWindows(invalid)
+		assert.ErrorIs(t, err, ErrInvalidWindow, invalid)
+	}
+}
+
+func TestRecurrence(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// A Monday
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, loc)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"daily 03:00", time.Date(2026, 10, 20, 3, 0, 0, 0, loc)},
+		{"daily 18:30", time.Date(2026, 10, 19, 18, 30, 0, 0, loc)},
+		{"weekly sun 03:00", time.Date(2026, 10, 25, 3, 0, 0, 0, loc)},
+		{"weekly mon 12:00", time.Date(2026, 10, 26, 12, 0, 0, 0, loc)},
+		{"Every 6h", now.Add(6 * time.Hour)},
+	}
+	for _, tt := range tests {
+		r, err := ParseRecurrence(tt.spec)
+		require.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.want, r.Next(now), tt.spec)
+	}
+
+	// Wall clock time across the end of daylight saving time
+	r, err := ParseRecurrence("daily 03:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 26, 3, 0, 0, 0, loc), r.Next(time.Date(2026, 10, 25, 4, 0, 0, 0, loc)))
+
+	for _, invalid := range []string{"", "daily", "hourly 03:00", "weekly 03:00", "daily 24:00", "every soon"} {
+		_, err := ParseRecurrence(invalid)
+		assert.ErrorIs(t, err, ErrInvalidRecurrence, invalid)
+	}
+	_, err = ParseRecurrence("every 1m")
+	assert.Error(t, err)
+}
+
+func TestQuietMode(t *testing.T) {
+	dbtest.Open(t)
+
+	now := time.Date(2026, 10, 19, 22, 30, 0, 0, time.UTC)
+	assert.Empty(t, QuietMode(now))
+
+	require.NoError(t, db.SetSetting(db.QuietHoursSetting, "23:00-07:00"))
+	require.NoError(t, db.SetSetting(db.ScheduleTimezoneSetting, "Europe/Berlin"))
+	assert.Equal(t, ModePause, QuietMode(now), "00:30 in Berlin")
+	assert.Empty(t, QuietMode(now.Add(-2*time.Hour)))
+
+	require.NoError(t, db.SetSetting(db.QuietHoursModeSetting, ModeThrottle))
+	assert.Equal(t, ModeThrottle, QuietMode(now))
+
+	start, err := ParseTime("2026-10-20 01:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC), start.UTC())
+	_, err = ParseTime("tomorrow")
+	assert.Error(t, err)
+}
@@ -0,0 +1,162 @@
+package scrobbler
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLastFmURL = "https://ws.audioscrobbler.com/2.0/"
+
+// LastFm submits scrobbles with a signed session key.
+// See https://www.last.fm/api/scrobbling
+type LastFm struct {
+	baseURL    string
+	apiKey     string
+	secret     string
+	sessionKey string
+}
+
+func NewLastFm(baseURL, apiKey, secret, sessionKey string) *LastFm {
+	if baseURL == "" {
+		baseURL = DefaultLastFmURL
+	}
+	return &LastFm{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		secret:     secret,
+		sessionKey: sessionKey,
+	}
+}
+
+func (l *LastFm) Name() string {
+	return "lastfm"
+}
+
+type lastFmResponse struct {
+	Error   int    `json:"error"`
+	Message string `json:"message"`
+	Session *struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	} `json:"session"`
+}
+
+func (l *LastFm) NowPlaying(ctx context.Context, track Track) error {
+	params := l.trackParams(track)
+	params.Set("method", "track.updateNowPlaying")
+	_, err := l.call(ctx, params)
+	return err
+}
+
+func (l *LastFm) Scrobble(ctx context.Context, track Track) error {
+	params := l.trackParams(track)
+	params.Set("method", "track.scrobble")
+	params.Set("timestamp", strconv.FormatInt(track.PlayedAt.Unix(), 10))
+	_, err := l.call(ctx, params)
+	return err
+}
+
+func (l *LastFm) trackParams(track Track) url.Values {
+	params := url.Values{}
+	params.Set("artist", track.Artist)
+	params.Set("track", track.Title)
+	if track.Album != "" {
+		params.Set("album", track.Album)
+	}
+	if track.DurationSeconds > 0 {
+		params.Set("duration", strconv.Itoa(track.DurationSeconds))
+	}
+	params.Set("sk", l.sessionKey)
+	return params
+}
+
+func (l *LastFm) call(ctx context.Context, params url.Values) (*lastFmResponse, error) {
+	return lastFmCall(ctx, l.baseURL, l.apiKey, l.secret, params)
+}
+
+// LastFmSession exchanges an authorized web auth token for a session key.
+func LastFmSession(ctx context.Context, baseURL, apiKey, secret, token string) (string, error) {
+	if baseURL == "" {
+		baseURL = DefaultLastFmURL
+	}
+	params := url.Values{}
+	params.Set("method", "auth.getSession")
+	params.Set("token", token)
+
+	resp, err := lastFmCall(ctx, baseURL, apiKey, secret, params)
+	if err != nil {
+		return "", err
+	}
+	if resp.Session == nil || resp.Session.Key == "" {
+		return "", fmt.Errorf("lastfm returned no session")
+	}
+	return resp.Session.Key, nil
+}
+
+func lastFmCall(ctx context.Context, baseURL, apiKey, secret string, params url.Values) (*lastFmResponse, error) {
+	params.Set("api_key", apiKey)
+	params.Set("api_sig", lastFmSignature(params, secret))
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result lastFmResponse
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("lastfm: invalid response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != 0 {
+		message := result.Message
+		if message == "" {
+			message = string(body)
+		}
+		return nil, &providerError{provider: "lastfm", status: resp.StatusCode, body: fmt.Sprintf("error %d: %s", result.Error, message)}
+	}
+	return &result, nil
+}
+
+// lastFmSignature builds api_sig: the md5 of all parameters except format and
+// callback, sorted by name and concatenated as <name><value>, followed by the secret.
+func lastFmSignature(params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k == "format" || k == "callback" || k == "api_sig" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString(params.Get(k))
+	}
+	sb.WriteString(secret)
+
+	sum := md5.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
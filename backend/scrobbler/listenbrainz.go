@@ -0,0 +1,108 @@
+package scrobbler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const DefaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// ListenBrainz submits listens with a user token.
+// See https://listenbrainz.readthedocs.io/en/latest/users/api/core.html
+type ListenBrainz struct {
+	baseURL string
+	token   string
+}
+
+func NewListenBrainz(baseURL, token string) *ListenBrainz {
+	if baseURL == "" {
+		baseURL = DefaultListenBrainzURL
+	}
+	return &ListenBrainz{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+	}
+}
+
+func (l *ListenBrainz) Name() string {
+	return "listenbrainz"
+}
+
+type listenBrainzPayload struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                 `json:"listened_at,omitempty"`
+	TrackMetadata listenBrainzTrackMeta `json:"track_metadata"`
+}
+
+type listenBrainzTrackMeta struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo map[string]interface{} `json:"additional_info,omitempty"`
+}
+
+func (l *ListenBrainz) NowPlaying(ctx context.Context, track Track) error {
+	listen := l.listen(track)
+	listen.ListenedAt = 0
+	return l.submit(ctx, listenBrainzPayload{ListenType: "playing_now", Payload: []listenBrainzListen{listen}})
+}
+
+func (l *ListenBrainz) Scrobble(ctx context.Context, track Track) error {
+	return l.submit(ctx, listenBrainzPayload{ListenType: "single", Payload: []listenBrainzListen{l.listen(track)}})
+}
+
+func (l *ListenBrainz) listen(track Track) listenBrainzListen {
+	info := map[string]interface{}{
+		"media_player":      "Beatbump",
+		"submission_client": "Beatbump",
+		"music_service":     "music.youtube.com",
+		"origin_url":        "https://music.youtube.com/watch?v=" + track.VideoID,
+		"youtube_id":        track.VideoID,
+	}
+	if track.DurationSeconds > 0 {
+		info["duration"] = track.DurationSeconds
+	}
+	return listenBrainzListen{
+		ListenedAt: track.PlayedAt.Unix(),
+		TrackMetadata: listenBrainzTrackMeta{
+			ArtistName:     track.Artist,
+			TrackName:      track.Title,
+			ReleaseName:    track.Album,
+			AdditionalInfo: info,
+		},
+	}
+}
+
+func (l *ListenBrainz) submit(ctx context.Context, payload listenBrainzPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+l.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &providerError{provider: l.Name(), status: resp.StatusCode, body: string(respBody)}
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package scrobbler
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Track is a play submitted to the scrobbling providers.
+type Track struct {
+	VideoID         string
+	Artist          string
+	Title           string
+	Album           string
+	DurationSeconds int
+	PlayedAt        time.Time
+}
+
+// Provider submits plays to a scrobbling service.
+type Provider interface {
+	Name() string
+	NowPlaying(ctx context.Context, track Track) error
+	Scrobble(ctx context.Context, track Track) error
+}
+
+const (
+	// Tracks shorter than this are never scrobbled (Last.fm and ListenBrainz rule)
+	minScrobbleDuration = 30 * time.Second
+	// A track counts as listened after half its length or four minutes
+	maxScrobbleThreshold = 4 * time.Minute
+
+	retryInterval = time.Minute
+	maxRetryDelay = 6 * time.Hour
+	retryBatch    = 50
+	// Queued scrobbles are dropped after this many attempts, about a week
+	// with the backoff
+	maxAttempts = 35
+)
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+type nowPlaying struct {
+	track     Track
+	startedAt time.Time
+}
+
+var (
+	mu      sync.Mutex
+	current = map[string]nowPlaying{}
+)
+
+// ConfiguredProviders builds the providers that have credentials in the settings.
+func ConfiguredProviders() []Provider {
+	var providers []Provider
+
+	if token, _ := db.GetSetting(db.ScrobbleListenBrainzTokenSetting); token != "" {
+		baseURL, _ := db.GetSetting(db.ScrobbleListenBrainzURLSetting)
+		providers = append(providers, NewListenBrainz(baseURL, token))
+	}
+
+	apiKey, _ := db.GetSetting(db.ScrobbleLastFmAPIKeySetting)
+	secret, _ := db.GetSetting(db.ScrobbleLastFmSecretSetting)
+	sessionKey, _ := db.GetSetting(db.ScrobbleLastFmSessionKeySetting)
+	if apiKey != "" && secret != "" && sessionKey != "" {
+		baseURL, _ := db.GetSetting(db.ScrobbleLastFmURLSetting)
+		providers = append(providers, NewLastFm(baseURL, apiKey, secret, sessionKey))
+	}
+
+	return providers
+}
+
+func providerByName(name string) Provider {
+	for _, p := range ConfiguredProviders() {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// CleanTrack strips YouTube noise such as "(Official Video)" from the metadata.
+func CleanTrack(track Track) Track {
+	track.Artist = utils.CleanString(track.Artist)
+	track.Title = utils.CleanString(track.Title)
+	track.Album = utils.CleanString(track.Album)
+	return track
+}
+
+// HandlePlay is called when a user starts a track. It sends a now-playing
+// notification and scrobbles the user's previous track if it was listened
+// to long enough, so plays are scrobbled even when the client never reports
+// completion.
+func HandlePlay(userID string, track Track) {
+	if track.PlayedAt.IsZero() {
+		track.PlayedAt = time.Now()
+	}
+
+	mu.Lock()
+	previous, ok := current[userID]
+	current[userID] = nowPlaying{track: track, startedAt: track.PlayedAt}
+	mu.Unlock()
+
+	if ok && previous.track.VideoID != track.VideoID &&
+		listenedEnough(previous.track.DurationSeconds, track.PlayedAt.Sub(previous.startedAt)) {
+		Scrobble(previous.track)
+	}
+
+	NowPlaying(track)
+}
+
+// Complete scrobbles a track the client reports as finished. listened is the
+// time actually played; zero means the whole track.
+func Complete(userID string, track Track, listened time.Duration) bool {
+	mu.Lock()
+	if p, ok := current[userID]; ok && p.track.VideoID == track.VideoID {
+		if track.PlayedAt.IsZero() {
+			track.PlayedAt = p.startedAt
+		}
+		delete(current, userID)
+	}
+	mu.Unlock()
+
+	if track.PlayedAt.IsZero() {
+		track.PlayedAt = time.Now()
+	}
+	if listened == 0 {
+		listened = time.Duration(track.DurationSeconds) * time.Second
+	}
+	if !listenedEnough(track.DurationSeconds, listened) {
+		return false
+	}
+
+	Scrobble(track)
+	return true
+}
+
+func listenedEnough(durationSeconds int, listened time.Duration) bool {
+	duration := time.Duration(durationSeconds) * time.Second
+	if duration > 0 && duration < minScrobbleDuration {
+		return false
+	}
+	threshold := duration / 2
+	if duration == 0 || threshold > maxScrobbleThreshold {
+		threshold = maxScrobbleThreshold
+	}
+	return listened >= threshold
+}
+
+// NowPlaying notifies all providers. Failures are only logged since a stale
+// now-playing status is not worth retrying.
+func NowPlaying(track Track) {
+	track = CleanTrack(track)
+	for _, p := range ConfiguredProviders() {
+		go func(p Provider) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			if err := p.NowPlaying(ctx, track); err != nil {
+				logging.Log.Warn().Err(err).Str("provider", p.Name()).Str("video_id", track.VideoID).Msg("Scrobbler: now playing failed")
+			}
+		}(p)
+	}
+}
+
+// Scrobble submits the track to all providers, queueing failed submissions for retry.
+func Scrobble(track Track) {
+	track = CleanTrack(track)
+	for _, p := range ConfiguredProviders() {
+		go func(p Provider) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			submitScrobble(ctx, p, track)
+		}(p)
+	}
+}
+
+func submitScrobble(ctx context.Context, p Provider, track Track) {
+	logger := logging.Log.With().Str("provider", p.Name()).Str("video_id", track.VideoID).Logger()
+	err := p.Scrobble(ctx, track)
+	if err == nil {
+		logger.Info().Msgf("Scrobbler: scrobbled %s - %s", track.Artist, track.Title)
+		return
+	}
+
+	if permanent(err) {
+		logger.Error().Err(err).Msg("Scrobbler: scrobble rejected")
+		return
+	}
+	logger.Warn().Err(err).Msg("Scrobbler: scrobble failed, queueing")
+	item := &db.ScrobbleQueueItem{
+		Provider:        p.Name(),
+		VideoID:         track.VideoID,
+		Artist:          track.Artist,
+		Title:           track.Title,
+		Album:           track.Album,
+		DurationSeconds: track.DurationSeconds,
+		PlayedAt:        track.PlayedAt,
+		Attempts:        1,
+		LastError:       err.Error(),
+		NextAttemptAt:   time.Now().Add(retryDelay(1)),
+	}
+	if err := db.EnqueueScrobble(item); err != nil {
+		logger.Error().Err(err).Msg("Scrobbler: failed to queue scrobble")
+	}
+}
+
+// StartRetryWorker periodically resubmits queued scrobbles until ctx is done.
+func StartRetryWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(retryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := ProcessQueue(ctx); err != nil {
+				logging.Log.Error().Err(err).Msg("Scrobbler: failed to process queue")
+			}
+		}
+	}()
+}
+
+// ProcessQueue retries the queued scrobbles that are due.
+func ProcessQueue(ctx context.Context) error {
+	// Items of unconfigured providers stay queued until they come back
+	providers := map[string]Provider{}
+	names := []string{}
+	for _, p := range ConfiguredProviders() {
+		providers[p.Name()] = p
+		names = append(names, p.Name())
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	items, err := db.GetDueScrobbles(time.Now(), names, retryBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		provider := providers[item.Provider]
+
+		track := Track{
+			VideoID:         item.VideoID,
+			Artist:          item.Artist,
+			Title:           item.Title,
+			Album:           item.Album,
+			DurationSeconds: item.DurationSeconds,
+			PlayedAt:        item.PlayedAt,
+		}
+		if err := provider.Scrobble(ctx, track); err != nil {
+			attempts := item.Attempts + 1
+			logger := logging.Log.With().Str("provider", item.Provider).Str("video_id", item.VideoID).Int("attempt", attempts).Logger()
+			if permanent(err) || attempts >= maxAttempts {
+				logger.Error().Err(err).Msg("Scrobbler: giving up on scrobble")
+				if err := db.DeleteScrobble(item.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			logger.Warn().Err(err).Msg("Scrobbler: retry failed")
+			if err := db.RescheduleScrobble(item.ID, attempts, err.Error(), time.Now().Add(retryDelay(attempts))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := db.DeleteScrobble(item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryDelay backs off exponentially from one minute up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := retryInterval
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// providerError is returned for non-successful provider responses.
+type providerError struct {
+	provider string
+	status   int
+	body     string
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.provider, e.status, e.body)
+}
+
+// permanent reports whether a retry cannot succeed: the provider rejected
+// the scrobble or the credentials with a client error other than a timeout
+// or rate limit.
+func permanent(err error) bool {
+	var perr *providerError
+	if !errors.As(err, &perr) {
+		return false
+	}
+	return perr.status >= 400 && perr.status < 500 &&
+		perr.status != http.StatusRequestTimeout && perr.status != http.StatusTooManyRequests
+}
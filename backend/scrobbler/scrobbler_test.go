@@ -0,0 +1,154 @@
+package scrobbler
+
+import (
+	"beatbump-server/backend/db"
+	"beatbump-server/backend/internal/dbtest"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenBrainzSubmit(t *testing.T) {
+	var got listenBrainzPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/1/submit-listens", r.URL.Path)
+		assert.Equal(t, "Token secret-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	provider := NewListenBrainz(server.URL+"/", "secret-token")
+	playedAt := time.Unix(1700000000, 0)
+	err := provider.Scrobble(context.Background(), Track{VideoID: "abc", Artist: "Artist", Title: "Song", PlayedAt: playedAt})
+	require.NoError(t, err)
+
+	assert.Equal(t, "single", got.ListenType)
+	require.Len(t, got.Payload, 1)
+	assert.Equal(t, int64(1700000000), got.Payload[0].ListenedAt)
+	assert.Equal(t, "Song", got.Payload[0].TrackMetadata.TrackName)
+}
+
+func TestLastFmSignedScrobble(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "track.scrobble", r.PostForm.Get("method"))
+		assert.Equal(t, "json", r.PostForm.Get("format"))
+
+		sig := r.PostForm.Get("api_sig")
+		r.PostForm.Del("api_sig")
+		assert.Equal(t, lastFmSignature(r.PostForm, "shh"), sig)
+		w.Write([]byte(`{"scrobbles":{"@attr":{"accepted":1,"ignored":0}}}`))
+	}))
+	defer server.Close()
+
+	provider := NewLastFm(server.URL, "key", "shh", "session")
+	err := provider.Scrobble(context.Background(), Track{Artist: "Artist", Title: "Song", PlayedAt: time.Now()})
+	require.NoError(t, err)
+}
+
+func TestLastFmSignature(t *testing.T) {
+	params := map[string][]string{
+		"api_key": {"xxxxxxxx"},
+		"method":  {"auth.getSession"},
+		"token":   {"yyyyyy"},
+		"format":  {"json"},
+	}
+	// md5("api_keyxxxxxxxxmethodauth.getSessiontokenyyyyyyilovecher")
+	assert.Equal(t, "1333ebf6f7dec747486b6ce965cca66b", lastFmSignature(params, "ilovecher"))
+}
+
+func TestFailedScrobbleIsQueuedAndRetried(t *testing.T) {
+	dbtest.Open(t)
+
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	require.NoError(t, db.SetSetting(db.ScrobbleListenBrainzTokenSetting, "token"))
+	require.NoError(t, db.SetSetting(db.ScrobbleListenBrainzURLSetting, server.URL))
+
+	provider := providerByName("listenbrainz")
+	require.NotNil(t, provider)
+	submitScrobble(context.Background(), provider, CleanTrack(Track{
+		VideoID: "abc", Artist: "Artist", Title: "Song (Official Video)", PlayedAt: time.Now(),
+	}))
+
+	queue, err := db.GetScrobbleQueue()
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "Song", queue[0].Title)
+
+	// Not due yet
+	require.NoError(t, ProcessQueue(context.Background()))
+	queue, _ = db.GetScrobbleQueue()
+	require.Len(t, queue, 1)
+
+	fail = false
+	require.NoError(t, db.RescheduleScrobble(queue[0].ID, queue[0].Attempts, "", time.Now().Add(-time.Second)))
+	require.NoError(t, ProcessQueue(context.Background()))
+	queue, _ = db.GetScrobbleQueue()
+	assert.Empty(t, queue)
+}
+
+func TestQueueSkipsUnconfiguredAndRejected(t *testing.T) {
+	dbtest.Open(t)
+
+	status := http.StatusBadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	require.NoError(t, db.SetSetting(db.ScrobbleListenBrainzTokenSetting, "token"))
+	require.NoError(t, db.SetSetting(db.ScrobbleListenBrainzURLSetting, server.URL))
+
+	// Older plays of an unconfigured provider fill more than a batch
+	past := time.Now().Add(-time.Hour)
+	for i := 0; i < retryBatch; i++ {
+		require.NoError(t, db.EnqueueScrobble(&db.ScrobbleQueueItem{Provider: "lastfm", VideoID: "old", PlayedAt: past, NextAttemptAt: past}))
+	}
+	require.NoError(t, db.EnqueueScrobble(&db.ScrobbleQueueItem{Provider: "listenbrainz", VideoID: "rejected", PlayedAt: time.Now(), NextAttemptAt: past}))
+	require.NoError(t, db.EnqueueScrobble(&db.ScrobbleQueueItem{Provider: "listenbrainz", VideoID: "exhausted", PlayedAt: time.Now(), NextAttemptAt: past, Attempts: maxAttempts - 1}))
+
+	require.NoError(t, ProcessQueue(context.Background()))
+	queue, err := db.GetScrobbleQueue()
+	require.NoError(t, err)
+	assert.Len(t, queue, retryBatch, "only the lastfm items are left")
+	for _, item := range queue {
+		assert.Equal(t, "lastfm", item.Provider)
+	}
+
+	// Rate limits are retried
+	status = http.StatusTooManyRequests
+	require.NoError(t, db.EnqueueScrobble(&db.ScrobbleQueueItem{Provider: "listenbrainz", VideoID: "limited", PlayedAt: time.Now(), NextAttemptAt: past}))
+	require.NoError(t, ProcessQueue(context.Background()))
+	queue, err = db.GetScrobbleQueue()
+	require.NoError(t, err)
+	assert.Len(t, queue, retryBatch+1)
+}
+
+func TestListenedEnough(t *testing.T) {
+	assert.False(t, listenedEnough(20, time.Hour), "tracks under 30s are never scrobbled")
+	assert.False(t, listenedEnough(200, 90*time.Second))
+	assert.True(t, listenedEnough(200, 100*time.Second))
+	assert.True(t, listenedEnough(3600, 4*time.Minute))
+	assert.False(t, listenedEnough(0, time.Minute))
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Minute, retryDelay(1))
+	assert.Equal(t, 4*time.Minute, retryDelay(3))
+	assert.Equal(t, maxRetryDelay, retryDelay(30))
+}
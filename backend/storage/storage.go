@@ -0,0 +1,241 @@
+// Package storage abstracts where downloaded tracks live. Keys are slash
+// separated paths relative to the library root, the same paths that are
+// stored in SongTask.FilePath.
+package storage
+
+import (
+	"beatbump-server/backend/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotExist is returned for keys that are not in the storage.
+	ErrNotExist = errors.New("storage: object does not exist")
+	// ErrLinkUnsupported is returned by Link for backends without links.
+	ErrLinkUnsupported = errors.New("storage: backend does not support links")
+)
+
+// Deduplication modes, see config.StorageConfig.Dedup
+const (
+	DedupOff      = "off"
+	DedupHardlink = "hardlink"
+	DedupSymlink  = "symlink"
+	DedupM3U      = "m3u"
+)
+
+// Object describes a stored file.
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Storage is a flat key/value view of the library.
+type Storage interface {
+	// Put stores r under key, replacing an existing object. size is -1 when
+	// unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get reads length bytes starting at offset, length -1 reads to the end.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns every object below prefix, recursively.
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// dirRemover is implemented by backends with real folders that Delete does
+// not clean up.
+type dirRemover interface {
+	removeDir(ctx context.Context, prefix string) error
+}
+
+// linker is implemented by backends that can expose one object under
+// several keys.
+type linker interface {
+	Link(ctx context.Context, target, key string, symbolic bool) error
+}
+
+// filePutter is implemented by backends that can take over a local file
+// without copying it.
+type filePutter interface {
+	PutFile(ctx context.Context, key, localPath string) error
+}
+
+var (
+	mu      sync.RWMutex
+	backend = "local"
+	remote  Storage
+	staging string
+	dedup   = DedupOff
+)
+
+// Configure selects the backend. The local backend stores into the
+// download path setting, which is resolved on every use, see Resolve.
+func Configure(cfg config.StorageConfig) error {
+	var (
+		s   Storage
+		err error
+	)
+	switch cfg.Backend {
+	case "", "local":
+	case "s3":
+		s, err = NewS3(cfg.S3)
+	case "webdav":
+		s, err = NewWebDAV(cfg.WebDAV)
+	default:
+		err = fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	backend, remote, staging, dedup = cfg.Backend, s, cfg.StagingDir, cfg.Dedup
+	if backend == "" {
+		backend = "local"
+	}
+	if dedup == "" {
+		dedup = DedupOff
+	}
+	return nil
+}
+
+// Backend returns the name of the configured backend.
+func Backend() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return backend
+}
+
+// DedupMode returns how tasks share the files of the same track.
+func DedupMode() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return dedup
+}
+
+// IsLocal reports whether tracks are stored in the download path.
+func IsLocal() bool {
+	return Backend() == "local"
+}
+
+// Resolve returns the configured storage. downloadPath is the root of the
+// local backend and ignored by the others.
+func Resolve(downloadPath string) Storage {
+	mu.RLock()
+	defer mu.RUnlock()
+	if remote != nil {
+		return remote
+	}
+	return &Local{Root: downloadPath}
+}
+
+// StagingDir returns the local directory in which tracks are downloaded and
+// converted before they are put into the storage.
+func StagingDir(downloadPath string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if staging != "" {
+		return staging
+	}
+	if remote == nil && downloadPath != "" {
+		// Same file system as the library, so tracks are moved, not copied
+		return filepath.Join(downloadPath, stagingFolder)
+	}
+	return os.TempDir()
+}
+
+// PutFile moves the local file at localPath into s under key.
+func PutFile(ctx context.Context, s Storage, key, localPath string) error {
+	if fp, ok := s.(filePutter); ok {
+		return fp.PutFile(ctx, key, localPath)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, key, f, info.Size()); err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(localPath)
+}
+
+// Link makes the object at target also available at key, replacing what
+// is stored at key.
+func Link(ctx context.Context, s Storage, target, key string, symbolic bool) error {
+	if l, ok := s.(linker); ok {
+		return l.Link(ctx, target, key, symbolic)
+	}
+	return ErrLinkUnsupported
+}
+
+// DeleteAll removes every object below prefix. An empty prefix is refused,
+// it would wipe the whole library.
+func DeleteAll(ctx context.Context, s Storage, prefix string) error {
+	if Key(prefix) == "" {
+		return errors.New("storage: refusing to delete the library root")
+	}
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, object := range objects {
+		if err := s.Delete(ctx, object.Key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if dr, ok := s.(dirRemover); ok && len(errs) == 0 && strings.HasSuffix(prefix, "/") {
+		errs = append(errs, dr.removeDir(ctx, prefix))
+	}
+	return errors.Join(errs...)
+}
+
+// Key turns a relative file path into a storage key.
+func Key(relativePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(relativePath)), "/")
+}
+
+// Dir returns the prefix that lists the objects of a folder.
+func Dir(relativePath string) string {
+	key := Key(relativePath)
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+// ContentType guesses the MIME type from the key's extension.
+func ContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m4a":
+		return "audio/mp4"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	}
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"beatbump-server/backend/config"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func readAll(t *testing.T, s Storage, key string, offset, length int64) string {
+	t.Helper()
+	rc, err := s.Get(context.Background(), key, offset, length)
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func keys(objects []Object) []string {
+	var out []string
+	for _, object := range objects {
+		out = append(out, object.Key)
+	}
+	return out
+}
+
+// testStorage runs the behaviour every backend has to share.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Put(ctx, "Playlist/a.mp3", strings.NewReader("0123456789"), 10))
+	require.NoError(t, s.Put(ctx, "Playlist/b.mp3", strings.NewReader("abc"), 3))
+	require.NoError(t, s.Put(ctx, "Other/c.mp3", strings.NewReader("c"), 1))
+
+	assert.Equal(t, "0123456789", readAll(t, s, "Playlist/a.mp3", 0, -1))
+	assert.Equal(t, "3456", readAll(t, s, "Playlist/a.mp3", 3, 4))
+	assert.Equal(t, "789", readAll(t, s, "Playlist/a.mp3", 7, -1))
+
+	object, err := s.Stat(ctx, "Playlist/a.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), object.Size)
+
+	_, err = s.Stat(ctx, "Playlist/missing.mp3")
+	assert.ErrorIs(t, err, ErrNotExist)
+	_, err = s.Get(ctx, "Playlist/missing.mp3", 0, -1)
+	assert.ErrorIs(t, err, ErrNotExist)
+
+	objects, err := s.List(ctx, "Playlist/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Playlist/a.mp3", "Playlist/b.mp3"}, keys(objects))
+
+	require.NoError(t, s.Delete(ctx, "Playlist/b.mp3"))
+	require.NoError(t, s.Delete(ctx, "Playlist/b.mp3"), "deleting a missing key")
+	_, err = s.Stat(ctx, "Playlist/b.mp3")
+	assert.ErrorIs(t, err, ErrNotExist)
+
+	require.NoError(t, DeleteAll(ctx, s, "Playlist/"))
+	objects, err = s.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Other/c.mp3"}, keys(objects))
+}
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	testStorage(t, &Local{Root: root})
+
+	// Folders emptied by deletes are removed
+	_, err := os.Stat(filepath.Join(root, "Playlist"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocalPutFileAndStaging(t *testing.T) {
+	root := t.TempDir()
+	s := &Local{Root: root}
+	staged := filepath.Join(root, stagingFolder, "track.mp3")
+	require.NoError(t, os.MkdirAll(filepath.Dir(staged), 0755))
+	require.NoError(t, os.WriteFile(staged, []byte("data"), 0644))
+
+	require.NoError(t, PutFile(context.Background(), s, "Playlist/track.mp3", staged))
+	_, err := os.Stat(staged)
+	assert.True(t, os.IsNotExist(err), "staged file is moved")
+
+	// The staging folder is not part of the library
+	require.NoError(t, os.WriteFile(filepath.Join(root, stagingFolder, "partial.m4a"), nil, 0644))
+	objects, err := s.List(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Playlist/track.mp3"}, keys(objects))
+}
+
+func TestWebDAV(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+
+	s, err := NewWebDAV(config.WebDAVConfig{URL: server.URL})
+	require.NoError(t, err)
+	testStorage(t, s)
+}
+
+func TestDeleteAllRefusesRoot(t *testing.T) {
+	root := t.TempDir()
+	s := &Local{Root: root}
+	require.NoError(t, s.Put(context.Background(), "a.mp3", strings.NewReader("a"), 1))
+
+	assert.Error(t, DeleteAll(context.Background(), s, ""))
+	assert.Error(t, DeleteAll(context.Background(), s, "/"))
+	_, err := s.Stat(context.Background(), "a.mp3")
+	assert.NoError(t, err)
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "Playlist/a.mp3", Key("Playlist/a.mp3"))
+	assert.Equal(t, "a.mp3", Key("/../../a.mp3"))
+	assert.Equal(t, "Playlist/a.mp3", Key("Playlist/x/../a.mp3"))
+	assert.Equal(t, "", Key(""))
+	assert.Equal(t, "Playlist/", Dir("Playlist"))
+	assert.Equal(t, "", Dir(""))
+}
+
+func TestReadSeekerServesRanges(t *testing.T) {
+	s := &Local{Root: t.TempDir()}
+	require.NoError(t, s.Put(context.Background(), "a.mp3", strings.NewReader("0123456789"), 10))
+	object, err := s.Stat(context.Background(), "a.mp3")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/a.mp3", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	rec := httptest.NewRecorder()
+	rs := NewReadSeeker(context.Background(), s, object)
+	defer rs.Close()
+	http.ServeContent(rec, req, "a.mp3", object.ModTime, rs)
+
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "2345", rec.Body.String())
+	assert.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"beatbump-server/backend/config"
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebDAV stores objects below a WebDAV collection, e.g. Nextcloud or a
+// plain Apache/nginx share. The client does not support contexts, requests
+// are not cancelled with ctx.
+type WebDAV struct {
+	client *gowebdav.Client
+}
+
+func NewWebDAV(cfg config.WebDAVConfig) (*WebDAV, error) {
+	return &WebDAV{client: gowebdav.NewClient(cfg.URL, cfg.Username, cfg.Password)}, nil
+}
+
+func (w *WebDAV) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	// WriteStream creates the parent collections
+	return w.client.WriteStream("/"+Key(key), r, 0644)
+}
+
+func (w *WebDAV) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	var (
+		rc  io.ReadCloser
+		err error
+	)
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case offset == 0 && length < 0:
+		rc, err = w.client.ReadStream("/" + Key(key))
+	default:
+		rc, err = w.client.ReadStreamRange("/"+Key(key), offset, max(length, 0))
+	}
+	if err != nil {
+		return nil, w.mapError(err)
+	}
+	return rc, nil
+}
+
+func (w *WebDAV) Stat(ctx context.Context, key string) (Object, error) {
+	info, err := w.client.Stat("/" + Key(key))
+	if err != nil {
+		return Object{}, w.mapError(err)
+	}
+	if info.IsDir() {
+		return Object{}, ErrNotExist
+	}
+	return Object{Key: Key(key), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (w *WebDAV) Delete(ctx context.Context, key string) error {
+	if err := w.client.Remove("/" + Key(key)); err != nil && !gowebdav.IsErrNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (w *WebDAV) removeDir(ctx context.Context, prefix string) error {
+	if err := w.client.RemoveAll("/" + Key(prefix)); err != nil && !gowebdav.IsErrNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (w *WebDAV) List(ctx context.Context, prefix string) ([]Object, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	start := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = prefix[:i]
+	}
+
+	var objects []Object
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := w.client.ReadDir("/" + dir)
+		if gowebdav.IsErrNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key := path.Join(dir, entry.Name())
+			if entry.IsDir() {
+				if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+					if err := walk(key); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(key, prefix) {
+				objects = append(objects, Object{Key: key, Size: entry.Size(), ModTime: entry.ModTime()})
+			}
+		}
+		return nil
+	}
+	return objects, walk(start)
+}
+
+func (w *WebDAV) mapError(err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		return ErrNotExist
+	}
+	return err
+}
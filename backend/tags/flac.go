@@ -0,0 +1,153 @@
+package tags
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FLAC metadata block types
+const (
+	flacPaddingBlock = 1
+	flacCommentBlock = 4
+	flacPictureBlock = 6
+)
+
+// vendor is the Vorbis comment vendor of files without comments.
+const vendor = "Beatbump"
+
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// parseFLAC reads the metadata blocks of a FLAC file. It returns the offset
+// of the stream marker, which some taggers precede with an ID3v2 tag, and
+// the offset of the audio frames.
+func parseFLAC(r io.ReadSeeker) ([]flacBlock, int64, int64, error) {
+	start := int64(0)
+	tag, err := parseID3(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if tag != nil {
+		start = tag.size
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || string(marker[:]) != "fLaC" {
+		return nil, 0, 0, errors.New("tags: not a FLAC file")
+	}
+
+	var blocks []flacBlock
+	pos := start + 4
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, 0, 0, err
+		}
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, 0, 0, err
+		}
+		blocks = append(blocks, flacBlock{typ: header[0] & 0x7f, data: data})
+		pos += 4 + int64(size)
+		if header[0]&0x80 != 0 {
+			return blocks, start, pos, nil
+		}
+	}
+}
+
+func readFLAC(r io.ReadSeeker) (*Tags, error) {
+	blocks, _, _, err := parseFLAC(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tags{}
+	for _, block := range blocks {
+		if block.typ == flacCommentBlock {
+			comments, err := parseVorbisComments(block.data)
+			if err != nil {
+				return nil, err
+			}
+			t = comments.tags()
+			break
+		}
+	}
+	for _, block := range blocks {
+		if block.typ != flacPictureBlock {
+			continue
+		}
+		if picture, front := parseFLACPicture(block.data); picture != nil && (front || t.Cover == nil) {
+			t.Cover = picture
+			if front {
+				break
+			}
+		}
+	}
+	return t, nil
+}
+
+// writeFLAC writes r to w with its Vorbis comment and picture blocks
+// rebuilt from t. Padding is dropped, other blocks are kept.
+func writeFLAC(w io.Writer, r io.ReadSeeker, t *Tags) error {
+	blocks, start, audioStart, err := parseFLAC(r)
+	if err != nil {
+		return err
+	}
+
+	comments := &vorbisComments{vendor: vendor}
+	var kept []flacBlock
+	for _, block := range blocks {
+		switch block.typ {
+		case flacCommentBlock:
+			if parsed, err := parseVorbisComments(block.data); err == nil {
+				comments = parsed
+			}
+		case flacPictureBlock, flacPaddingBlock:
+		default:
+			kept = append(kept, block)
+		}
+	}
+	comments.set(t, false)
+	kept = append(kept, flacBlock{typ: flacCommentBlock, data: comments.bytes()})
+	if t.Cover != nil && len(t.Cover.Data) > 0 {
+		kept = append(kept, flacBlock{typ: flacPictureBlock, data: flacPicture(t.Cover)})
+	}
+
+	// Anything before the stream marker is kept as it is
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, r, start); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "fLaC"); err != nil {
+		return err
+	}
+	for i, block := range kept {
+		if len(block.data) >= 1<<24 {
+			return errors.New("tags: FLAC metadata block too large")
+		}
+		header := binary.BigEndian.AppendUint32(nil, uint32(len(block.data)))
+		header[0] = block.typ
+		if i == len(kept)-1 {
+			header[0] |= 0x80
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(block.data); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.Seek(audioStart, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
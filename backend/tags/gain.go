@@ -0,0 +1,114 @@
+package tags
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ReplayGain tag names, TXXX frames in ID3v2, freeform atoms in MP4 and
+// comment fields in Vorbis comments
+const (
+	TrackGainKey = "REPLAYGAIN_TRACK_GAIN"
+	TrackPeakKey = "REPLAYGAIN_TRACK_PEAK"
+	AlbumGainKey = "REPLAYGAIN_ALBUM_GAIN"
+	AlbumPeakKey = "REPLAYGAIN_ALBUM_PEAK"
+)
+
+// ReferenceLoudness is the loudness in LUFS that ReplayGain 2.0 gains bring
+// the audio to.
+const ReferenceLoudness = -18.0
+
+// Gain is a ReplayGain adjustment: the gain in dB that brings the audio to
+// the reference loudness and the largest sample amplitude, 1 being full
+// scale.
+type Gain struct {
+	Gain float64 `json:"gain"`
+	Peak float64 `json:"peak"`
+}
+
+// GainFor returns the gain of audio with the integrated loudness in LUFS
+// and the peak amplitude.
+func GainFor(loudness, peak float64) *Gain {
+	return &Gain{Gain: math.Round((ReferenceLoudness-loudness)*100) / 100, Peak: peak}
+}
+
+// customFields are the fields of Tags stored under a name of their own
+var customFields = []struct {
+	name string
+	get  func(t *Tags) string
+	set  func(t *Tags, value string)
+}{
+	{VideoIDKey, func(t *Tags) string { return t.VideoID }, func(t *Tags, v string) { t.VideoID = v }},
+	{TrackGainKey, func(t *Tags) string { return formatGain(t.TrackGain) }, func(t *Tags, v string) { setGain(&t.TrackGain, v) }},
+	{TrackPeakKey, func(t *Tags) string { return formatPeak(t.TrackGain) }, func(t *Tags, v string) { setPeak(&t.TrackGain, v) }},
+	{AlbumGainKey, func(t *Tags) string { return formatGain(t.AlbumGain) }, func(t *Tags, v string) { setGain(&t.AlbumGain, v) }},
+	{AlbumPeakKey, func(t *Tags) string { return formatPeak(t.AlbumGain) }, func(t *Tags, v string) { setPeak(&t.AlbumGain, v) }},
+	{MusicBrainzTrackIDKey, func(t *Tags) string { return t.MusicBrainz.TrackID }, func(t *Tags, v string) { t.MusicBrainz.TrackID = v }},
+	{MusicBrainzAlbumIDKey, func(t *Tags) string { return t.MusicBrainz.AlbumID }, func(t *Tags, v string) { t.MusicBrainz.AlbumID = v }},
+	{MusicBrainzReleaseGroupIDKey, func(t *Tags) string { return t.MusicBrainz.ReleaseGroupID }, func(t *Tags, v string) { t.MusicBrainz.ReleaseGroupID = v }},
+	{MusicBrainzAlbumArtistIDKey, func(t *Tags) string { return t.MusicBrainz.AlbumArtistID }, func(t *Tags, v string) { t.MusicBrainz.AlbumArtistID = v }},
+}
+
+// setCustom stores value in the custom field name and reports whether the
+// name is known.
+func setCustom(t *Tags, name, value string) bool {
+	for _, f := range customFields {
+		if strings.EqualFold(f.name, name) {
+			f.set(t, value)
+			return true
+		}
+	}
+	return false
+}
+
+func isCustom(name string) bool {
+	for _, f := range customFields {
+		if strings.EqualFold(f.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatGain(g *Gain) string {
+	if g == nil {
+		return ""
+	}
+	return strconv.FormatFloat(g.Gain, 'f', 2, 64) + " dB"
+}
+
+func formatPeak(g *Gain) string {
+	if g == nil || g.Peak <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(g.Peak, 'f', 6, 64)
+}
+
+// parseGainValue reads values such as "-6.52 dB" and "0.988553".
+func parseGainValue(v string) (float64, bool) {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(fields[0], 64)
+	return f, err == nil
+}
+
+func setGain(g **Gain, v string) {
+	if f, ok := parseGainValue(v); ok {
+		if *g == nil {
+			*g = &Gain{}
+		}
+		(*g).Gain = f
+	}
+}
+
+func setPeak(g **Gain, v string) {
+	if f, ok := parseGainValue(v); ok {
+		if *g == nil {
+			*g = &Gain{}
+		}
+		(*g).Peak = f
+	}
+}
@@ -0,0 +1,404 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxTagSize caps the ID3v2 tag that is read into memory, embedded covers
+// are usually well below it.
+const maxTagSize = 16 << 20
+
+// id3Tag is a parsed ID3v2.3 or ID3v2.4 tag.
+type id3Tag struct {
+	version byte
+	frames  []rawFrame
+	// Offset of the audio after the tag
+	size int64
+}
+
+// rawFrame holds a frame body with unsynchronisation and the data length
+// indicator removed. flags are the ID3v2.4 format flags of frames kept as
+// they are, grouped, compressed or encrypted ones.
+type rawFrame struct {
+	id    string
+	flags byte
+	body  []byte
+}
+
+// Frames ID3v2.3 has and ID3v2.4 replaced, dropped on rewrite
+var id3v23Frames = map[string]bool{
+	"TYER": true, "TDAT": true, "TIME": true, "TORY": true, "TRDA": true,
+	"TSIZ": true, "IPLS": true, "RVAD": true, "EQUA": true,
+}
+
+// parseID3 reads the ID3v2 tag at the start of r. It returns nil when the
+// file has no tag in a supported version.
+func parseID3(r io.ReadSeeker) (*id3Tag, error) {
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, nil
+	}
+	version, flags := header[3], header[5]
+	size := syncsafe(header[6:10])
+	tag := &id3Tag{version: version, size: 10 + int64(size)}
+	if version == 4 && flags&0x10 != 0 {
+		tag.size += 10 // footer
+	}
+	if version != 3 && version != 4 {
+		// ID3v2.2 uses three letter frames, not written by ffmpeg
+		return tag, nil
+	}
+	if size > maxTagSize {
+		return nil, fmt.Errorf("tags: ID3v2 tag of %d bytes", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("tags: truncated ID3v2 tag: %w", err)
+	}
+	if version == 3 && flags&0x80 != 0 {
+		data = resync(data)
+	}
+	if flags&0x40 != 0 && len(data) >= 4 {
+		// Skip the extended header
+		extSize := int(binary.BigEndian.Uint32(data[:4]))
+		if version == 4 {
+			extSize = syncsafe(data[:4])
+		} else {
+			extSize += 4
+		}
+		if extSize > len(data) {
+			return tag, nil
+		}
+		data = data[extSize:]
+	}
+
+	for len(data) >= 10 && data[0] != 0 {
+		id := string(data[:4])
+		frameSize := int(binary.BigEndian.Uint32(data[4:8]))
+		if version == 4 {
+			frameSize = syncsafe(data[4:8])
+		}
+		if frameSize > len(data)-10 {
+			break
+		}
+		format := data[9]
+		body := data[10 : 10+frameSize]
+		data = data[10+frameSize:]
+
+		frame := rawFrame{id: id, body: body}
+		if version == 3 {
+			if format&0xe0 != 0 {
+				// Compressed, encrypted or grouped, not worth converting
+				continue
+			}
+		} else if format&0x4c != 0 {
+			frame.flags = format
+		} else {
+			if format&0x02 != 0 {
+				frame.body = resync(frame.body)
+			}
+			if format&0x01 != 0 && len(frame.body) >= 4 {
+				frame.body = frame.body[4:]
+			}
+		}
+		tag.frames = append(tag.frames, frame)
+	}
+	return tag, nil
+}
+
+func readID3(r io.ReadSeeker) (*Tags, error) {
+	tag, err := parseID3(r)
+	if err != nil || tag == nil {
+		return &Tags{}, err
+	}
+
+	t := &Tags{}
+	var pictures []*Picture
+	for _, frame := range tag.frames {
+		body := frame.body
+		if frame.flags != 0 || len(body) == 0 {
+			continue
+		}
+		switch frame.id {
+		case "TIT2":
+			t.Title = id3Text(body)
+		case "TPE1":
+			t.Artist = id3Text(body)
+		case "TALB":
+			t.Album = id3Text(body)
+		case "TPE2":
+			t.AlbumArtist = id3Text(body)
+		case "TDRC":
+			t.Year = id3Text(body)
+		case "TYER":
+			if t.Year == "" {
+				t.Year = id3Text(body)
+			}
+		case "TCON":
+			t.Genre = id3Genre(id3Text(body))
+		case "TRCK":
+			t.Track, t.TrackTotal = parseTrack(id3Text(body))
+		case "TXXX":
+			fields := id3Strings(body[0], body[1:])
+			if len(fields) >= 2 {
+				setCustom(t, fields[0], fields[1])
+			}
+		case "USLT":
+			// encoding, language, description, text
+			if len(body) >= 4 {
+				if fields := id3Strings(body[0], body[4:]); len(fields) >= 2 {
+					t.Lyrics = fields[1]
+				}
+			}
+		case "APIC":
+			if picture, front := id3Picture(body); picture != nil {
+				if front {
+					pictures = append([]*Picture{picture}, pictures...)
+				} else {
+					pictures = append(pictures, picture)
+				}
+			}
+		}
+	}
+	if len(pictures) > 0 {
+		t.Cover = pictures[0]
+	}
+	return t, nil
+}
+
+// writeID3 writes r to w with an ID3v2.4 tag in place of its ID3v2 tag.
+// Frames for the fields of t are replaced, other frames are kept.
+func writeID3(w io.Writer, r io.ReadSeeker, t *Tags) error {
+	tag, err := parseID3(r)
+	if err != nil {
+		return err
+	}
+
+	var frames []rawFrame
+	audioStart := int64(0)
+	if tag != nil {
+		audioStart = tag.size
+		for _, frame := range tag.frames {
+			if !id3Replaced(frame) && !(tag.version == 3 && id3v23Frames[frame.id]) {
+				frames = append(frames, frame)
+			}
+		}
+	}
+	frames = append(frames, tagFrames(t)...)
+
+	var body bytes.Buffer
+	for _, frame := range frames {
+		var header [10]byte
+		copy(header[:4], frame.id)
+		putSyncsafe(header[4:8], len(frame.body))
+		header[9] = frame.flags
+		body.Write(header[:])
+		body.Write(frame.body)
+	}
+	header := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(header[6:10], body.Len())
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := body.WriteTo(w); err != nil {
+		return err
+	}
+
+	if _, err := r.Seek(audioStart, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// id3Replaced reports whether frame holds one of the fields of Tags.
+func id3Replaced(frame rawFrame) bool {
+	switch frame.id {
+	case "TIT2", "TPE1", "TALB", "TPE2", "TDRC", "TYER", "TCON", "TRCK", "USLT", "APIC":
+		return true
+	case "TXXX":
+		if frame.flags == 0 && len(frame.body) > 0 {
+			fields := id3Strings(frame.body[0], frame.body[1:])
+			return len(fields) > 0 && isCustom(fields[0])
+		}
+	}
+	return false
+}
+
+// tagFrames encodes the set fields of t, text in UTF-8.
+func tagFrames(t *Tags) []rawFrame {
+	var frames []rawFrame
+	text := func(id, value string) {
+		if value != "" {
+			frames = append(frames, rawFrame{id: id, body: append([]byte{3}, value...)})
+		}
+	}
+	text("TIT2", t.Title)
+	text("TPE1", t.Artist)
+	text("TALB", t.Album)
+	text("TPE2", t.AlbumArtist)
+	text("TDRC", t.Year)
+	text("TCON", t.Genre)
+	text("TRCK", formatTrack(t.Track, t.TrackTotal))
+	for _, f := range customFields {
+		if value := f.get(t); value != "" {
+			text("TXXX", f.name+"\x00"+value)
+		}
+	}
+	if t.Lyrics != "" {
+		// No language and an empty description
+		frames = append(frames, rawFrame{id: "USLT", body: append([]byte("\x03XXX\x00"), t.Lyrics...)})
+	}
+	if t.Cover != nil && len(t.Cover.Data) > 0 {
+		// MIME type, front cover, empty description
+		body := append([]byte{3}, t.Cover.MIMEType...)
+		body = append(body, 0, 3, 0)
+		frames = append(frames, rawFrame{id: "APIC", body: append(body, t.Cover.Data...)})
+	}
+	return frames
+}
+
+// id3Picture decodes an APIC frame and reports whether it is the front
+// cover.
+func id3Picture(body []byte) (*Picture, bool) {
+	encoding := body[0]
+	body = body[1:]
+	end := bytes.IndexByte(body, 0)
+	if end < 0 || end+2 > len(body) {
+		return nil, false
+	}
+	mimeType := string(body[:end])
+	pictureType := body[end+1]
+	body = body[end+2:]
+
+	// Skip the description
+	if encoding == 1 || encoding == 2 {
+		end = -1
+		for i := 0; i+1 < len(body); i += 2 {
+			if body[i] == 0 && body[i+1] == 0 {
+				end = i + 2
+				break
+			}
+		}
+	} else if end = bytes.IndexByte(body, 0); end >= 0 {
+		end++
+	}
+	if end < 0 {
+		return nil, false
+	}
+	data := body[end:]
+	if mimeType == "" || !strings.Contains(mimeType, "/") {
+		mimeType = DetectImageType(data)
+	}
+	return &Picture{MIMEType: mimeType, Data: data}, pictureType == 3
+}
+
+// id3Genre turns the ID3v1 genre references of old taggers, "(17)" or
+// "(17)Rock", into the text that follows them.
+func id3Genre(genre string) string {
+	for strings.HasPrefix(genre, "(") {
+		end := strings.IndexByte(genre, ')')
+		if end < 0 || end == len(genre)-1 {
+			break
+		}
+		genre = genre[end+1:]
+	}
+	return genre
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0], b[1], b[2], b[3] = byte(n>>21&0x7f), byte(n>>14&0x7f), byte(n>>7&0x7f), byte(n&0x7f)
+}
+
+// resync removes the zero bytes unsynchronisation inserts after 0xff.
+func resync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0}, []byte{0xff})
+}
+
+// id3Text returns the first value of a text frame.
+func id3Text(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	fields := id3Strings(body[0], body[1:])
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// id3Strings splits null terminated strings in the given text encoding.
+func id3Strings(encoding byte, data []byte) []string {
+	var fields []string
+	switch encoding {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		for len(data) >= 2 {
+			end := len(data)
+			for i := 0; i+1 < len(data); i += 2 {
+				if data[i] == 0 && data[i+1] == 0 {
+					end = i
+					break
+				}
+			}
+			fields = append(fields, decodeUTF16(data[:end], encoding == 2))
+			if end+2 > len(data) {
+				break
+			}
+			data = data[end+2:]
+		}
+	default: // ISO-8859-1, UTF-8
+		for _, field := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+			if encoding == 0 {
+				fields = append(fields, latin1(field))
+			} else {
+				fields = append(fields, string(field))
+			}
+		}
+	}
+	return fields
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xfe && b[1] == 0xff:
+			order, b = binary.BigEndian, b[2:]
+		case b[0] == 0xff && b[1] == 0xfe:
+			order, b = binary.LittleEndian, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
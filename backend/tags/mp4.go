@@ -0,0 +1,510 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxItemSize caps a metadata item read into memory, covers included.
+const maxItemSize = 16 << 20
+
+// maxMoovSize caps the moov box that is rewritten in memory. It holds the
+// sample tables and the metadata, not the audio.
+const maxMoovSize = 64 << 20
+
+// Data types of the data box of a metadata item
+const (
+	mp4Implicit = 0
+	mp4UTF8     = 1
+	mp4JPEG     = 13
+	mp4PNG      = 14
+)
+
+type mp4Box struct {
+	typ        string
+	pos        int64 // header
+	start, end int64 // payload
+}
+
+// mp4Boxes lists the boxes in [start, end) of r; end -1 reads to EOF.
+func mp4Boxes(r io.ReadSeeker, start, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for pos := start; end < 0 || pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if end < 0 && errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0: // extends to the end of the file
+			if end < 0 {
+				last, err := r.Seek(0, io.SeekEnd)
+				if err != nil {
+					return nil, err
+				}
+				size = last - pos
+			} else {
+				size = end - pos
+			}
+		case 1: // 64-bit size follows
+			var large [8]byte
+			if _, err := io.ReadFull(r, large[:]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(large[:]))
+			headerSize = 16
+		}
+		if size < headerSize || (end >= 0 && pos+size > end) {
+			return nil, fmt.Errorf("tags: invalid %q box", header[4:8])
+		}
+		boxes = append(boxes, mp4Box{typ: string(header[4:8]), pos: pos, start: pos + headerSize, end: pos + size})
+		pos += size
+	}
+	return boxes, nil
+}
+
+// mp4Child returns the first box of type typ in [start, end).
+func mp4Child(r io.ReadSeeker, start, end int64, typ string) (mp4Box, bool, error) {
+	boxes, err := mp4Boxes(r, start, end)
+	if err != nil {
+		return mp4Box{}, false, err
+	}
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box, true, nil
+		}
+	}
+	return mp4Box{}, false, nil
+}
+
+func readMP4(r io.ReadSeeker) (*Tags, error) {
+	t := &Tags{}
+
+	// moov/udta/meta/ilst, meta is a full box with 4 bytes version and flags
+	box := mp4Box{start: 0, end: -1}
+	for _, typ := range []string{"moov", "udta", "meta", "ilst"} {
+		child, ok, err := mp4Child(r, box.start, box.end, typ)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return t, nil
+		}
+		if typ == "meta" {
+			child.start += 4
+		}
+		box = child
+	}
+
+	items, err := mp4Boxes(r, box.start, box.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		switch item.typ {
+		case "\xa9nam":
+			t.Title, err = mp4Text(r, item)
+		case "\xa9ART":
+			t.Artist, err = mp4Text(r, item)
+		case "\xa9alb":
+			t.Album, err = mp4Text(r, item)
+		case "aART":
+			t.AlbumArtist, err = mp4Text(r, item)
+		case "\xa9day":
+			t.Year, err = mp4Text(r, item)
+		case "\xa9gen":
+			t.Genre, err = mp4Text(r, item)
+		case "\xa9lyr":
+			t.Lyrics, err = mp4Text(r, item)
+		case "trkn":
+			var value []byte
+			if _, value, err = mp4Value(r, item); err == nil && len(value) >= 6 {
+				t.Track = int(binary.BigEndian.Uint16(value[2:4]))
+				t.TrackTotal = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		case "covr":
+			var typ int
+			var value []byte
+			if typ, value, err = mp4Value(r, item); err == nil && len(value) > 0 {
+				mimeType := "image/jpeg"
+				if typ == mp4PNG {
+					mimeType = "image/png"
+				}
+				t.Cover = &Picture{MIMEType: mimeType, Data: value}
+			}
+		case "----":
+			var name, value string
+			name, value, err = mp4Freeform(r, item)
+			if err == nil {
+				setCustom(t, name, value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func readBox(r io.ReadSeeker, box mp4Box) ([]byte, error) {
+	if box.end-box.start > maxItemSize {
+		return nil, fmt.Errorf("tags: %q box of %d bytes", box.typ, box.end-box.start)
+	}
+	if _, err := r.Seek(box.start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, box.end-box.start)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// mp4Value returns the data type and value of the data box of a metadata
+// item.
+func mp4Value(r io.ReadSeeker, item mp4Box) (int, []byte, error) {
+	data, ok, err := mp4Child(r, item.start, item.end, "data")
+	if err != nil || !ok {
+		return 0, nil, err
+	}
+	value, err := readBox(r, data)
+	if err != nil || len(value) < 8 {
+		return 0, nil, err
+	}
+	// 4 bytes type indicator, 4 bytes locale
+	return int(binary.BigEndian.Uint32(value[:4]) & 0xffffff), value[8:], nil
+}
+
+// mp4Text returns the value of the data box of a metadata item.
+func mp4Text(r io.ReadSeeker, item mp4Box) (string, error) {
+	_, value, err := mp4Value(r, item)
+	return string(value), err
+}
+
+// mp4Freeform returns the name and value of a "----" item.
+func mp4Freeform(r io.ReadSeeker, item mp4Box) (string, string, error) {
+	name, ok, err := mp4Child(r, item.start, item.end, "name")
+	if err != nil || !ok {
+		return "", "", err
+	}
+	raw, err := readBox(r, name)
+	if err != nil || len(raw) < 4 {
+		return "", "", err
+	}
+	value, err := mp4Text(r, item)
+	// name is a full box, skip version and flags
+	return string(raw[4:]), value, err
+}
+
+// atom is a box of the moov tree held in memory. Containers have
+// children, other boxes keep their payload. prefix holds the version and
+// flags of full box containers.
+type atom struct {
+	typ      string
+	prefix   []byte
+	payload  []byte
+	children []*atom
+}
+
+// Boxes on the way to the sample tables and the metadata
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "udta": true, "ilst": true,
+}
+
+func parseAtoms(data []byte) ([]*atom, error) {
+	var atoms []*atom
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("tags: truncated box")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("tags: truncated box")
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("tags: invalid %q box", typ)
+		}
+		a := &atom{typ: typ, payload: data[headerSize:size]}
+		if mp4Containers[typ] {
+			children, err := parseAtoms(a.payload)
+			if err != nil {
+				return nil, err
+			}
+			a.payload, a.children = nil, children
+		}
+		atoms = append(atoms, a)
+		data = data[size:]
+	}
+	return atoms, nil
+}
+
+func (a *atom) size() int {
+	n := 8 + len(a.prefix) + len(a.payload)
+	for _, child := range a.children {
+		n += child.size()
+	}
+	return n
+}
+
+func (a *atom) appendTo(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(a.size()))
+	b = append(b, a.typ...)
+	b = append(b, a.prefix...)
+	b = append(b, a.payload...)
+	for _, child := range a.children {
+		b = child.appendTo(b)
+	}
+	return b
+}
+
+// child returns the first child of type typ, nil on a nil atom.
+func (a *atom) child(typ string) *atom {
+	if a == nil {
+		return nil
+	}
+	for _, child := range a.children {
+		if child.typ == typ {
+			return child
+		}
+	}
+	return nil
+}
+
+// writeMP4 writes r to w with a rebuilt moov box. Items for the fields of t
+// are replaced in moov/udta/meta/ilst, the boxes on the way are created when
+// missing. Chunk offsets into data after moov are moved by the change of
+// its size.
+func writeMP4(w io.Writer, r io.ReadSeeker, t *Tags) error {
+	boxes, err := mp4Boxes(r, 0, -1)
+	if err != nil {
+		return err
+	}
+	moovIndex := -1
+	for i, box := range boxes {
+		if box.typ == "moov" {
+			moovIndex = i
+			break
+		}
+	}
+	if moovIndex < 0 {
+		return errors.New("tags: no moov box")
+	}
+	moovBox := boxes[moovIndex]
+	if moovBox.end-moovBox.pos > maxMoovSize {
+		return fmt.Errorf("tags: moov box of %d bytes", moovBox.end-moovBox.pos)
+	}
+	if _, err := r.Seek(moovBox.pos, io.SeekStart); err != nil {
+		return err
+	}
+	raw := make([]byte, moovBox.end-moovBox.pos)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return err
+	}
+	atoms, err := parseAtoms(raw)
+	if err != nil {
+		return err
+	}
+	moov := atoms[0]
+
+	if err := setIlst(moov, t); err != nil {
+		return err
+	}
+	delta := int64(moov.size()) - (moovBox.end - moovBox.pos)
+	if delta != 0 {
+		if err := shiftChunkOffsets(moov, moovBox.end, delta); err != nil {
+			return err
+		}
+	}
+
+	for i, box := range boxes {
+		if i == moovIndex {
+			if _, err := w.Write(moov.appendTo(nil)); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := r.Seek(box.pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, box.end-box.pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setIlst replaces the items of the fields of t in the metadata of moov.
+func setIlst(moov *atom, t *Tags) error {
+	udta := moov.child("udta")
+	if udta == nil {
+		udta = &atom{typ: "udta"}
+		moov.children = append(moov.children, udta)
+	}
+	var meta *atom
+	for i, child := range udta.children {
+		if child.typ != "meta" {
+			continue
+		}
+		if len(child.payload) < 4 {
+			return errors.New("tags: invalid meta box")
+		}
+		children, err := parseAtoms(child.payload[4:])
+		if err != nil {
+			return err
+		}
+		meta = &atom{typ: "meta", prefix: child.payload[:4], children: children}
+		udta.children[i] = meta
+		break
+	}
+	if meta == nil {
+		// hdlr: version and flags, predefined, handler type, reserved, name
+		hdlr := &atom{typ: "hdlr", payload: append(make([]byte, 8), "mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)}
+		meta = &atom{typ: "meta", prefix: make([]byte, 4), children: []*atom{hdlr}}
+		udta.children = append(udta.children, meta)
+	}
+	ilst := meta.child("ilst")
+	if ilst == nil {
+		ilst = &atom{typ: "ilst"}
+		meta.children = append(meta.children, ilst)
+	}
+
+	var items []*atom
+	for _, item := range ilst.children {
+		if !mp4Replaced(item) {
+			items = append(items, item)
+		}
+	}
+	ilst.children = append(items, mp4Items(t)...)
+	return nil
+}
+
+// mp4Replaced reports whether item holds one of the fields of Tags.
+func mp4Replaced(item *atom) bool {
+	switch item.typ {
+	case "\xa9nam", "\xa9ART", "\xa9alb", "aART", "\xa9day", "\xa9gen", "gnre", "\xa9lyr", "trkn", "covr":
+		return true
+	case "----":
+		children, err := parseAtoms(item.payload)
+		if err != nil {
+			return false
+		}
+		for _, child := range children {
+			if child.typ == "name" && len(child.payload) >= 4 && isCustom(string(child.payload[4:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func mp4Items(t *Tags) []*atom {
+	var items []*atom
+	item := func(typ string, dataType int, value []byte) {
+		data := binary.BigEndian.AppendUint32(nil, uint32(dataType))
+		data = append(data, 0, 0, 0, 0) // locale
+		data = append(data, value...)
+		items = append(items, &atom{typ: typ, children: []*atom{{typ: "data", payload: data}}})
+	}
+	text := func(typ, value string) {
+		if value != "" {
+			item(typ, mp4UTF8, []byte(value))
+		}
+	}
+	text("\xa9nam", t.Title)
+	text("\xa9ART", t.Artist)
+	text("\xa9alb", t.Album)
+	text("aART", t.AlbumArtist)
+	text("\xa9day", t.Year)
+	text("\xa9gen", t.Genre)
+	if t.Track > 0 {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint16(value[2:4], uint16(t.Track))
+		binary.BigEndian.PutUint16(value[4:6], uint16(max(t.TrackTotal, 0)))
+		item("trkn", mp4Implicit, value)
+	}
+	text("\xa9lyr", t.Lyrics)
+	if t.Cover != nil && len(t.Cover.Data) > 0 {
+		dataType := mp4JPEG
+		if t.Cover.MIMEType == "image/png" {
+			dataType = mp4PNG
+		}
+		item("covr", dataType, t.Cover.Data)
+	}
+	for _, f := range customFields {
+		value := f.get(t)
+		if value == "" {
+			continue
+		}
+		item("----", mp4UTF8, []byte(value))
+		freeform := items[len(items)-1]
+		freeform.children = append([]*atom{
+			{typ: "mean", payload: append(make([]byte, 4), "com.apple.iTunes"...)},
+			{typ: "name", payload: append(make([]byte, 4), f.name...)},
+		}, freeform.children...)
+	}
+	return items
+}
+
+// shiftChunkOffsets moves the chunk offsets of every track that point at or
+// after from by delta.
+func shiftChunkOffsets(moov *atom, from, delta int64) error {
+	for _, trak := range moov.children {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl := trak.child("mdia").child("minf").child("stbl")
+		if stbl == nil {
+			continue
+		}
+		for _, table := range stbl.children {
+			if table.typ != "stco" && table.typ != "co64" {
+				continue
+			}
+			if len(table.payload) < 8 {
+				return fmt.Errorf("tags: invalid %s box", table.typ)
+			}
+			entries := bytes.Clone(table.payload)
+			count := int(binary.BigEndian.Uint32(entries[4:8]))
+			width := 4
+			if table.typ == "co64" {
+				width = 8
+			}
+			if len(entries) < 8+count*width {
+				return fmt.Errorf("tags: truncated %s box", table.typ)
+			}
+			for i := 0; i < count; i++ {
+				entry := entries[8+i*width:]
+				if width == 4 {
+					offset := int64(binary.BigEndian.Uint32(entry))
+					if offset < from {
+						continue
+					}
+					if offset+delta > 0xffffffff {
+						return errors.New("tags: chunk offset overflows stco")
+					}
+					binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+				} else if offset := int64(binary.BigEndian.Uint64(entry)); offset >= from {
+					binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+				}
+			}
+			table.payload = entries
+		}
+	}
+	return nil
+}
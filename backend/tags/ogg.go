@@ -0,0 +1,268 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxPacketSize caps a header packet read into memory, embedded covers
+// included.
+const maxPacketSize = 16 << 20
+
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	sequence   uint32
+	segments   []byte
+	data       []byte
+}
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+	var header [27]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" {
+		return nil, errors.New("tags: invalid Ogg page")
+	}
+	page := &oggPage{
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:14]),
+		serial:     binary.LittleEndian.Uint32(header[14:18]),
+		sequence:   binary.LittleEndian.Uint32(header[18:22]),
+		segments:   make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, page.segments); err != nil {
+		return nil, err
+	}
+	size := 0
+	for _, lacing := range page.segments {
+		size += int(lacing)
+	}
+	page.data = make([]byte, size)
+	if _, err := io.ReadFull(r, page.data); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (p *oggPage) bytes() []byte {
+	b := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(b, "OggS")
+	b[5] = p.headerType
+	binary.LittleEndian.PutUint64(b[6:14], p.granule)
+	binary.LittleEndian.PutUint32(b[14:18], p.serial)
+	binary.LittleEndian.PutUint32(b[18:22], p.sequence)
+	b[26] = byte(len(p.segments))
+	b = append(b, p.segments...)
+	b = append(b, p.data...)
+	binary.LittleEndian.PutUint32(b[22:26], oggCRC(b))
+	return b
+}
+
+func (p *oggPage) size() int64 {
+	return int64(27 + len(p.segments) + len(p.data))
+}
+
+// oggStream holds the header packets of an Ogg Vorbis or Opus stream.
+type oggStream struct {
+	opus    bool
+	serial  uint32
+	packets [][]byte
+	// Header pages and the offset of the first audio page
+	pages      int
+	audioStart int64
+}
+
+// parseOgg reads the header packets of the first logical stream: the
+// identification and comment headers, and the setup header of Vorbis.
+func parseOgg(r io.Reader) (*oggStream, error) {
+	var s oggStream
+	var packet []byte
+	want := 0
+	for want == 0 || len(s.packets) < want {
+		page, err := readOggPage(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, errors.New("tags: truncated Ogg headers")
+			}
+			return nil, err
+		}
+		if s.pages == 0 {
+			s.serial = page.serial
+		} else if page.serial != s.serial {
+			return nil, errors.New("tags: multiplexed Ogg streams are not supported")
+		}
+		s.pages++
+		s.audioStart += page.size()
+
+		data := page.data
+		for _, lacing := range page.segments {
+			if want > 0 && len(s.packets) == want {
+				return nil, errors.New("tags: Ogg headers do not end a page")
+			}
+			packet = append(packet, data[:lacing]...)
+			data = data[lacing:]
+			if len(packet) > maxPacketSize {
+				return nil, fmt.Errorf("tags: Ogg header packet of %d bytes", len(packet))
+			}
+			if lacing == 255 {
+				continue
+			}
+			s.packets = append(s.packets, packet)
+			packet = nil
+			if len(s.packets) == 1 {
+				switch {
+				case bytes.HasPrefix(s.packets[0], []byte("\x01vorbis")):
+					want = 3
+				case bytes.HasPrefix(s.packets[0], []byte("OpusHead")):
+					s.opus, want = true, 2
+				default:
+					return nil, ErrUnsupported
+				}
+			}
+		}
+	}
+	return &s, nil
+}
+
+// commentPrefix is the start of the comment header packet.
+func (s *oggStream) commentPrefix() []byte {
+	if s.opus {
+		return []byte("OpusTags")
+	}
+	return []byte("\x03vorbis")
+}
+
+func (s *oggStream) comments() (*vorbisComments, error) {
+	packet := s.packets[1]
+	prefix := s.commentPrefix()
+	if !bytes.HasPrefix(packet, prefix) {
+		return nil, errInvalidComments
+	}
+	return parseVorbisComments(packet[len(prefix):])
+}
+
+func readOgg(r io.ReadSeeker) (*Tags, error) {
+	s, err := parseOgg(r)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := s.comments()
+	if err != nil {
+		return nil, err
+	}
+	return comments.tags(), nil
+}
+
+// writeOgg writes r to w with the comment header rebuilt from t. The header
+// packets are paginated again, later pages of the stream are renumbered
+// when the number of header pages changed.
+func writeOgg(w io.Writer, r io.ReadSeeker, t *Tags) error {
+	s, err := parseOgg(r)
+	if err != nil {
+		return err
+	}
+	comments, err := s.comments()
+	if err != nil {
+		comments = &vorbisComments{vendor: vendor}
+	}
+	comments.set(t, true)
+	packet := append(s.commentPrefix(), comments.bytes()...)
+	if !s.opus {
+		packet = append(packet, 1) // framing bit
+	}
+
+	// The identification header has a page of its own
+	pages := paginate(s.serial, 0, [][]byte{s.packets[0]})
+	pages[0].headerType |= 0x02
+	pages = append(pages, paginate(s.serial, uint32(len(pages)), append([][]byte{packet}, s.packets[2:]...))...)
+	for _, page := range pages {
+		if _, err := w.Write(page.bytes()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.Seek(s.audioStart, io.SeekStart); err != nil {
+		return err
+	}
+	shift := uint32(len(pages) - s.pages)
+	if shift == 0 {
+		_, err = io.Copy(w, r)
+		return err
+	}
+	for {
+		page, err := readOggPage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if page.serial == s.serial {
+			page.sequence += shift
+		}
+		if _, err := w.Write(page.bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// paginate lays packets out on pages numbered from sequence. Pages on which
+// no packet ends have no granule position.
+func paginate(serial, sequence uint32, packets [][]byte) []*oggPage {
+	var pages []*oggPage
+	page := &oggPage{serial: serial, sequence: sequence, granule: ^uint64(0)}
+	flush := func() {
+		pages = append(pages, page)
+		continued := page.segments[len(page.segments)-1] == 255
+		page = &oggPage{serial: serial, sequence: page.sequence + 1, granule: ^uint64(0)}
+		if continued {
+			page.headerType = 0x01
+		}
+	}
+	for _, packet := range packets {
+		for n := len(packet); ; n -= 255 {
+			if len(page.segments) == 255 {
+				flush()
+			}
+			lacing := min(n, 255)
+			page.segments = append(page.segments, byte(lacing))
+			page.data = append(page.data, packet[len(packet)-n:len(packet)-n+lacing]...)
+			if lacing < 255 {
+				page.granule = 0
+				break
+			}
+		}
+	}
+	if len(page.segments) > 0 {
+		flush()
+	}
+	return pages
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
@@ -0,0 +1,171 @@
+// Package tags reads and writes the metadata embedded in downloaded tracks:
+// ID3v2 in MP3 files, iTunes style metadata in M4A files and Vorbis
+// comments in FLAC, Ogg Vorbis and Opus files.
+package tags
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// VideoIDKey names the custom tag that holds the YouTube video ID of a
+// track, a TXXX frame in ID3v2, a freeform atom in MP4 and a comment field
+// in Vorbis comments.
+const VideoIDKey = "YOUTUBE_VIDEO_ID"
+
+// MusicBrainz identifier tag names, stored like VideoIDKey
+const (
+	MusicBrainzTrackIDKey        = "MUSICBRAINZ_TRACKID"
+	MusicBrainzAlbumIDKey        = "MUSICBRAINZ_ALBUMID"
+	MusicBrainzReleaseGroupIDKey = "MUSICBRAINZ_RELEASEGROUPID"
+	MusicBrainzAlbumArtistIDKey  = "MUSICBRAINZ_ALBUMARTISTID"
+)
+
+// ErrUnsupported is returned for files without a known tag format.
+var ErrUnsupported = errors.New("tags: unsupported file format")
+
+// Tags holds the fields the library reads and edits. Write replaces exactly
+// these fields and keeps every other tag of the file.
+type Tags struct {
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	AlbumArtist string `json:"albumArtist"`
+	Year        string `json:"year"`
+	Genre       string `json:"genre"`
+	Track       int    `json:"track"`
+	TrackTotal  int    `json:"trackTotal"`
+	Lyrics      string `json:"lyrics"`
+	VideoID     string `json:"videoId"`
+	// ReplayGain of the track and of its album
+	TrackGain *Gain `json:"trackGain,omitempty"`
+	AlbumGain *Gain `json:"albumGain,omitempty"`
+	// Set when the metadata was matched on MusicBrainz
+	MusicBrainz MusicBrainzIDs `json:"musicBrainz"`
+	// The front cover, it replaces every embedded picture on Write
+	Cover *Picture `json:"-"`
+}
+
+// MusicBrainzIDs identify a track, its release and the release artist on
+// MusicBrainz. Empty IDs are unknown.
+type MusicBrainzIDs struct {
+	TrackID        string `json:"trackId,omitempty"` // The recording
+	AlbumID        string `json:"albumId,omitempty"` // The release
+	ReleaseGroupID string `json:"releaseGroupId,omitempty"`
+	AlbumArtistID  string `json:"albumArtistId,omitempty"`
+}
+
+// Picture is an embedded image.
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+// Read parses the tags of a file, the format is picked by the extension of
+// name. A file without tags returns empty Tags.
+func Read(r io.ReadSeeker, name string) (*Tags, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp3":
+		return readID3(r)
+	case ".m4a", ".mp4", ".aac":
+		return readMP4(r)
+	case ".flac":
+		return readFLAC(r)
+	case ".ogg", ".opus":
+		return readOgg(r)
+	}
+	return nil, ErrUnsupported
+}
+
+// Write copies the file read from r to w with its tags replaced by t. The
+// format is picked by the extension of name, r is read from the start.
+func Write(w io.Writer, r io.ReadSeeker, name string, t *Tags) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp3":
+		return writeID3(w, r, t)
+	case ".m4a", ".mp4", ".aac":
+		return writeMP4(w, r, t)
+	case ".flac":
+		return writeFLAC(w, r, t)
+	case ".ogg", ".opus":
+		return writeOgg(w, r, t)
+	}
+	return ErrUnsupported
+}
+
+// WriteFile replaces the tags of the local file at filePath. The file is
+// rewritten next to itself and renamed over the original.
+func WriteFile(filePath string, t *Tags) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(filePath), ".tags-*"+filepath.Ext(filePath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := Write(out, in, filePath, t); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Rename(out.Name(), filePath)
+}
+
+// UpdateFile reads the tags of the local file at filePath, lets update
+// change them and writes them back.
+func UpdateFile(filePath string, update func(t *Tags)) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	t, err := Read(f, filePath)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	update(t)
+	return WriteFile(filePath, t)
+}
+
+// DetectImageType returns the MIME type of JPEG and PNG data, JPEG for
+// anything else.
+func DetectImageType(data []byte) string {
+	if strings.HasPrefix(string(data), "\x89PNG") {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// parseTrack reads a track number such as "3" or "3/12".
+func parseTrack(s string) (track, total int) {
+	number, of, _ := strings.Cut(strings.TrimSpace(s), "/")
+	track, _ = strconv.Atoi(strings.TrimSpace(number))
+	total, _ = strconv.Atoi(strings.TrimSpace(of))
+	return track, total
+}
+
+func formatTrack(track, total int) string {
+	switch {
+	case track <= 0:
+		return ""
+	case total <= 0:
+		return strconv.Itoa(track)
+	default:
+		return strconv.Itoa(track) + "/" + strconv.Itoa(total)
+	}
+}
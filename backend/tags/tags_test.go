@@ -0,0 +1,106 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func id3Frame(id string, body []byte) []byte {
+	frame := make([]byte, 10, 10+len(body))
+	copy(frame, id)
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(body)))
+	return append(frame, body...)
+}
+
+func id3v23(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	size := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(append(header, body...), "audio"...)
+}
+
+func utf16Text(values ...string) []byte {
+	out := []byte{1}
+	for _, value := range values {
+		out = append(out, 0xff, 0xfe)
+		for _, unit := range utf16.Encode([]rune(value)) {
+			out = binary.LittleEndian.AppendUint16(out, unit)
+		}
+		out = append(out, 0, 0)
+	}
+	return out
+}
+
+func mp4Atom(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	atom := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(atom, typ...), body...)
+}
+
+func mp4Data(value string) []byte {
+	return mp4Atom("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(value))
+}
+
+func TestReadID3(t *testing.T) {
+	file := id3v23(
+		id3Frame("TIT2", append([]byte{0}, "Caf\xe9"...)),
+		id3Frame("TPE1", utf16Text("Artíst")),
+		id3Frame("TALB", append([]byte{3}, "Album\x00"...)),
+		id3Frame("TXXX", utf16Text("OTHER", "x")),
+		id3Frame("TXXX", append([]byte{0}, "YOUTUBE_VIDEO_ID\x00dQw4w9WgXcQ"...)),
+	)
+
+	got, err := Read(bytes.NewReader(file), "Song.MP3")
+	require.NoError(t, err)
+	assert.Equal(t, &Tags{Title: "Café", Artist: "Artíst", Album: "Album", VideoID: "dQw4w9WgXcQ"}, got)
+}
+
+func TestReadID3WithoutTag(t *testing.T) {
+	got, err := Read(bytes.NewReader([]byte("\xff\xfbplain mpeg frames")), "a.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, &Tags{}, got)
+}
+
+func TestReadMP4(t *testing.T) {
+	ilst := mp4Atom("ilst",
+		mp4Atom("\xa9nam", mp4Data("Title")),
+		mp4Atom("\xa9ART", mp4Data("Artist")),
+		mp4Atom("\xa9alb", mp4Data("Album")),
+		mp4Atom("----",
+			mp4Atom("mean", []byte{0, 0, 0, 0}, []byte("com.apple.iTunes")),
+			mp4Atom("name", []byte{0, 0, 0, 0}, []byte(VideoIDKey)),
+			mp4Data("dQw4w9WgXcQ"),
+		),
+	)
+	file := bytes.Join([][]byte{
+		mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		mp4Atom("mdat", []byte("audio")),
+		mp4Atom("moov",
+			mp4Atom("mvhd", make([]byte, 100)),
+			mp4Atom("udta", mp4Atom("meta", []byte{0, 0, 0, 0}, mp4Atom("hdlr", make([]byte, 25)), ilst)),
+		),
+	}, nil)
+
+	got, err := Read(bytes.NewReader(file), "a.m4a")
+	require.NoError(t, err)
+	assert.Equal(t, &Tags{Title: "Title", Artist: "Artist", Album: "Album", VideoID: "dQw4w9WgXcQ"}, got)
+}
+
+func TestReadMP4WithoutMetadata(t *testing.T) {
+	file := append(mp4Atom("ftyp", []byte("M4A ")), mp4Atom("moov", mp4Atom("mvhd", make([]byte, 8)))...)
+	got, err := Read(bytes.NewReader(file), "a.m4a")
+	require.NoError(t, err)
+	assert.Equal(t, &Tags{}, got)
+
+	_, err = Read(bytes.NewReader(mp4Atom("moov")[:6]), "a.m4a")
+	assert.Error(t, err, "truncated box header")
+
+	_, err = Read(bytes.NewReader(nil), "a.wav")
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
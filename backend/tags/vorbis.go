@@ -0,0 +1,240 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strconv"
+	"strings"
+)
+
+var errInvalidComments = errors.New("tags: invalid Vorbis comments")
+
+type vorbisField struct {
+	names []string
+	get   func(t *Tags) string
+	set   func(t *Tags, value string)
+}
+
+// Vorbis comment fields of Tags, the first name is written and every name
+// is read and replaced
+var vorbisFields = []vorbisField{
+	{[]string{"TITLE"}, func(t *Tags) string { return t.Title }, func(t *Tags, v string) { t.Title = v }},
+	{[]string{"ARTIST"}, func(t *Tags) string { return t.Artist }, func(t *Tags, v string) { t.Artist = v }},
+	{[]string{"ALBUM"}, func(t *Tags) string { return t.Album }, func(t *Tags, v string) { t.Album = v }},
+	{[]string{"ALBUMARTIST", "ALBUM ARTIST"}, func(t *Tags) string { return t.AlbumArtist }, func(t *Tags, v string) { t.AlbumArtist = v }},
+	{[]string{"DATE", "YEAR"}, func(t *Tags) string { return t.Year }, func(t *Tags, v string) { t.Year = v }},
+	{[]string{"GENRE"}, func(t *Tags) string { return t.Genre }, func(t *Tags, v string) { t.Genre = v }},
+	{[]string{"TRACKNUMBER"},
+		func(t *Tags) string { return formatTrack(t.Track, 0) },
+		func(t *Tags, v string) {
+			// Some taggers write "3/12"
+			track, total := parseTrack(v)
+			t.Track = track
+			if total > 0 {
+				t.TrackTotal = total
+			}
+		}},
+	{[]string{"TRACKTOTAL", "TOTALTRACKS"},
+		func(t *Tags) string {
+			if t.Track <= 0 || t.TrackTotal <= 0 {
+				return ""
+			}
+			return strconv.Itoa(t.TrackTotal)
+		},
+		func(t *Tags, v string) { t.TrackTotal, _ = strconv.Atoi(strings.TrimSpace(v)) }},
+	{[]string{"LYRICS", "UNSYNCEDLYRICS"}, func(t *Tags) string { return t.Lyrics }, func(t *Tags, v string) { t.Lyrics = v }},
+}
+
+func init() {
+	for _, f := range customFields {
+		vorbisFields = append(vorbisFields, vorbisField{[]string{f.name}, f.get, f.set})
+	}
+}
+
+// Fields holding pictures in Ogg files, the old COVERART is only removed
+const (
+	pictureField    = "METADATA_BLOCK_PICTURE"
+	oldPictureField = "COVERART"
+)
+
+// vorbisComments is a Vorbis comment block: the vendor string and the
+// NAME=value fields in file order.
+type vorbisComments struct {
+	vendor string
+	fields []string
+}
+
+func parseVorbisComments(data []byte) (*vorbisComments, error) {
+	next := func() (string, error) {
+		if len(data) < 4 {
+			return "", errInvalidComments
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", errInvalidComments
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, nil
+	}
+
+	vendor, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, errInvalidComments
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	c := &vorbisComments{vendor: vendor}
+	for i := uint32(0); i < count; i++ {
+		field, err := next()
+		if err != nil {
+			return nil, err
+		}
+		c.fields = append(c.fields, field)
+	}
+	return c, nil
+}
+
+func (c *vorbisComments) bytes() []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(c.vendor)))
+	b = append(b, c.vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(c.fields)))
+	for _, field := range c.fields {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+	return b
+}
+
+// tags returns the fields of Tags, the first value of each. Pictures are
+// read from METADATA_BLOCK_PICTURE fields.
+func (c *vorbisComments) tags() *Tags {
+	t := &Tags{}
+	seen := make(map[int]bool)
+	for _, field := range c.fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		name = strings.ToUpper(name)
+		if name == pictureField && t.Cover == nil {
+			if raw, err := base64.StdEncoding.DecodeString(value); err == nil {
+				t.Cover, _ = parseFLACPicture(raw)
+			}
+			continue
+		}
+		for i, f := range vorbisFields {
+			if !seen[i] && containsName(f.names, name) {
+				f.set(t, value)
+				seen[i] = true
+			}
+		}
+	}
+	return t
+}
+
+// set replaces the fields of Tags with the values of t. With pictures the
+// cover goes into a METADATA_BLOCK_PICTURE field, FLAC files have picture
+// blocks instead.
+func (c *vorbisComments) set(t *Tags, pictures bool) {
+	var fields []string
+	for _, field := range c.fields {
+		name, _, _ := strings.Cut(field, "=")
+		if !vorbisReplaced(strings.ToUpper(name), pictures) {
+			fields = append(fields, field)
+		}
+	}
+	for _, f := range vorbisFields {
+		if value := f.get(t); value != "" {
+			fields = append(fields, f.names[0]+"="+value)
+		}
+	}
+	if pictures && t.Cover != nil && len(t.Cover.Data) > 0 {
+		fields = append(fields, pictureField+"="+base64.StdEncoding.EncodeToString(flacPicture(t.Cover)))
+	}
+	c.fields = fields
+}
+
+func vorbisReplaced(name string, pictures bool) bool {
+	if name == pictureField || name == oldPictureField {
+		return pictures
+	}
+	for _, f := range vorbisFields {
+		if containsName(f.names, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFLACPicture decodes a FLAC picture block, also used base64 encoded
+// in Ogg comments. It reports whether the picture is the front cover.
+func parseFLACPicture(data []byte) (*Picture, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	pictureType := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	next := func() []byte {
+		if len(data) < 4 {
+			return nil
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			data = nil
+			return nil
+		}
+		value := data[4 : 4+n]
+		data = data[4+n:]
+		return value
+	}
+	mimeType := string(next())
+	next() // description
+	if len(data) < 16 {
+		return nil, false
+	}
+	data = data[16:] // width, height, depth, colors
+	picture := next()
+	if len(picture) == 0 {
+		return nil, false
+	}
+	if !strings.Contains(mimeType, "/") {
+		mimeType = DetectImageType(picture)
+	}
+	return &Picture{MIMEType: mimeType, Data: picture}, pictureType == 3
+}
+
+// flacPicture encodes p as a front cover picture block.
+func flacPicture(p *Picture) []byte {
+	width, height := 0, 0
+	if config, _, err := image.DecodeConfig(bytes.NewReader(p.Data)); err == nil {
+		width, height = config.Width, config.Height
+	}
+	b := binary.BigEndian.AppendUint32(nil, 3)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p.MIMEType)))
+	b = append(b, p.MIMEType...)
+	b = binary.BigEndian.AppendUint32(b, 0) // description
+	b = binary.BigEndian.AppendUint32(b, uint32(width))
+	b = binary.BigEndian.AppendUint32(b, uint32(height))
+	b = binary.BigEndian.AppendUint32(b, 24) // color depth
+	b = binary.BigEndian.AppendUint32(b, 0)  // indexed colors
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p.Data)))
+	return append(b, p.Data...)
+}
@@ -0,0 +1,257 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var edited = &Tags{
+	Title:       "Title ✓",
+	Artist:      "Artist",
+	Album:       "Album",
+	AlbumArtist: "Various Artists",
+	Year:        "2001",
+	Genre:       "House",
+	Track:       3,
+	TrackTotal:  12,
+	Lyrics:      "First line\nSecond line",
+	VideoID:     "dQw4w9WgXcQ",
+	TrackGain:   &Gain{Gain: -6.52, Peak: 0.988553},
+	AlbumGain:   &Gain{Gain: -7.1},
+	MusicBrainz: MusicBrainzIDs{
+		TrackID:        "b1a9c0e9-d987-4042-ae91-78d6a3267d69",
+		AlbumID:        "9e1e4ab4-2e3c-4d6a-8e5b-8f3bfa5c1a2b",
+		ReleaseGroupID: "0e8d5f0a-5f0e-3f7c-9a2c-6d2b7f1c8a11",
+	},
+	Cover: &Picture{MIMEType: "image/png", Data: []byte("\x89PNG fake image")},
+}
+
+func rewrite(t *testing.T, file []byte, name string, tags *Tags) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	r := bytes.NewReader(file)
+	r.Seek(0, io.SeekEnd)
+	require.NoError(t, Write(&out, r, name, tags))
+	got, err := Read(bytes.NewReader(out.Bytes()), name)
+	require.NoError(t, err)
+	assert.Equal(t, tags, got)
+	return out.Bytes()
+}
+
+func TestWriteID3(t *testing.T) {
+	file := id3v23(
+		id3Frame("TIT2", append([]byte{0}, "Old"...)),
+		id3Frame("TYER", append([]byte{0}, "1999"...)),
+		id3Frame("COMM", append([]byte{0}, "eng\x00kept"...)),
+	)
+	out := rewrite(t, file, "a.mp3", edited)
+	assert.True(t, bytes.HasSuffix(out, []byte("audio")))
+	assert.Contains(t, string(out), "eng\x00kept")
+	assert.NotContains(t, string(out), "TYER")
+	assert.Equal(t, byte(4), out[3])
+
+	// Empty fields remove their frames
+	out = rewrite(t, out, "a.mp3", &Tags{Title: "Only"})
+	assert.NotContains(t, string(out), "APIC")
+	assert.NotContains(t, string(out), VideoIDKey)
+
+	// Files without a tag get one
+	rewrite(t, []byte("\xff\xfbplain mpeg frames"), "a.mp3", edited)
+}
+
+// mp4File builds an M4A whose sample table points at the audio in mdat,
+// which follows moov when moovFirst is set.
+func mp4File(moovFirst bool, ilst []byte) ([]byte, func([]byte) string) {
+	stco := func(offset uint32) []byte {
+		return mp4Atom("trak", mp4Atom("mdia", mp4Atom("minf", mp4Atom("stbl",
+			mp4Atom("stco", []byte{0, 0, 0, 0, 0, 0, 0, 1}, binary.BigEndian.AppendUint32(nil, offset))))))
+	}
+	ftyp := mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	mdat := mp4Atom("mdat", []byte("audio"))
+	udta := []byte{}
+	if ilst != nil {
+		udta = mp4Atom("udta", mp4Atom("meta", []byte{0, 0, 0, 0}, mp4Atom("hdlr", make([]byte, 25)), ilst))
+	}
+	moov := func(offset uint32) []byte {
+		return mp4Atom("moov", mp4Atom("mvhd", make([]byte, 100)), stco(offset), udta)
+	}
+
+	var file []byte
+	if moovFirst {
+		size := len(ftyp) + len(moov(0))
+		file = bytes.Join([][]byte{ftyp, moov(uint32(size + 8)), mdat}, nil)
+	} else {
+		file = bytes.Join([][]byte{ftyp, mdat, moov(uint32(len(ftyp) + 8))}, nil)
+	}
+
+	// audio reads the first chunk through the sample table
+	audio := func(file []byte) string {
+		r := bytes.NewReader(file)
+		box := mp4Box{start: 0, end: -1}
+		for _, typ := range []string{"moov", "trak", "mdia", "minf", "stbl", "stco"} {
+			child, ok, err := mp4Child(r, box.start, box.end, typ)
+			if err != nil || !ok {
+				return ""
+			}
+			box = child
+		}
+		offset := binary.BigEndian.Uint32(file[box.start+8:])
+		return string(file[offset : offset+5])
+	}
+	return file, audio
+}
+
+func TestWriteMP4(t *testing.T) {
+	for _, moovFirst := range []bool{true, false} {
+		file, audio := mp4File(moovFirst, nil)
+		require.Equal(t, "audio", audio(file))
+
+		out := rewrite(t, file, "a.m4a", edited)
+		assert.Equal(t, "audio", audio(out), "chunk offsets follow the moved audio")
+
+		out = rewrite(t, out, "a.m4a", &Tags{Title: "Shorter"})
+		assert.Equal(t, "audio", audio(out))
+	}
+
+	// Unknown items are kept
+	file, _ := mp4File(true, mp4Atom("ilst",
+		mp4Atom("\xa9nam", mp4Data("Old")),
+		mp4Atom("\xa9too", mp4Data("Lavf")),
+	))
+	out := rewrite(t, file, "a.m4a", &Tags{Title: "New"})
+	assert.Contains(t, string(out), "Lavf")
+	assert.NotContains(t, string(out), "Old")
+
+	var buf bytes.Buffer
+	assert.Error(t, Write(&buf, bytes.NewReader(mp4Atom("ftyp", []byte("M4A "))), "a.m4a", edited))
+}
+
+func flacBlockBytes(typ byte, last bool, data []byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	header[0] = typ
+	if last {
+		header[0] |= 0x80
+	}
+	return append(header, data...)
+}
+
+func TestWriteFLAC(t *testing.T) {
+	comments := &vorbisComments{vendor: "reference libFLAC", fields: []string{"TITLE=Old", "ENCODER=kept"}}
+	file := bytes.Join([][]byte{
+		[]byte("fLaC"),
+		flacBlockBytes(0, false, make([]byte, 34)),
+		flacBlockBytes(flacCommentBlock, false, comments.bytes()),
+		flacBlockBytes(flacPaddingBlock, true, make([]byte, 100)),
+		[]byte("frames"),
+	}, nil)
+
+	got, err := Read(bytes.NewReader(file), "a.flac")
+	require.NoError(t, err)
+	assert.Equal(t, "Old", got.Title)
+
+	out := rewrite(t, file, "a.flac", edited)
+	assert.True(t, bytes.HasSuffix(out, []byte("frames")))
+	assert.Contains(t, string(out), "ENCODER=kept")
+	assert.Contains(t, string(out), "reference libFLAC")
+	// STREAMINFO stays first
+	assert.Equal(t, byte(0), out[4])
+}
+
+// oggStreamFile builds an Ogg Vorbis stream with one audio page.
+func oggStreamFile(comments *vorbisComments) []byte {
+	comment := append([]byte("\x03vorbis"), comments.bytes()...)
+	pages := paginate(7, 0, [][]byte{[]byte("\x01vorbis identification")})
+	pages[0].headerType = 0x02
+	pages = append(pages, paginate(7, 1, [][]byte{append(comment, 1), []byte("\x05vorbis setup")})...)
+	audio := paginate(7, 2, [][]byte{[]byte("audio")})[0]
+	audio.granule, audio.headerType = 1024, 0x04
+	pages = append(pages, audio)
+
+	var file []byte
+	for _, page := range pages {
+		file = append(file, page.bytes()...)
+	}
+	return file
+}
+
+func TestWriteOgg(t *testing.T) {
+	file := oggStreamFile(&vorbisComments{vendor: "Xiph", fields: []string{"TITLE=Old", "ENCODER=kept"}})
+	got, err := Read(bytes.NewReader(file), "a.ogg")
+	require.NoError(t, err)
+	assert.Equal(t, "Old", got.Title)
+
+	// A cover larger than a page moves the audio page
+	large := *edited
+	large.Cover = &Picture{MIMEType: "image/jpeg", Data: bytes.Repeat([]byte{0xab}, 100000)}
+	out := rewrite(t, file, "a.ogg", &large)
+	assert.Contains(t, string(out), "ENCODER=kept")
+
+	r := bytes.NewReader(out)
+	var sequences []uint32
+	var last *oggPage
+	for {
+		page, err := readOggPage(r)
+		if err != nil {
+			break
+		}
+		raw := page.bytes()
+		sequences = append(sequences, page.sequence)
+		assert.Equal(t, binary.LittleEndian.Uint32(raw[22:26]), oggCRC(append(raw[:22:22], append([]byte{0, 0, 0, 0}, raw[26:]...)...)))
+		last = page
+	}
+	require.Greater(t, len(sequences), 3)
+	for i, sequence := range sequences {
+		assert.Equal(t, uint32(i), sequence)
+	}
+	assert.Equal(t, "audio", string(last.data))
+	assert.Equal(t, uint64(1024), last.granule)
+
+	// And back to a single comment page
+	out = rewrite(t, out, "a.ogg", &Tags{Title: "Small"})
+	assert.Equal(t, len(file)-len("TITLE=Old")+len("TITLE=Small"), len(out))
+}
+
+func TestGainFor(t *testing.T) {
+	assert.Equal(t, &Gain{Gain: -4.23, Peak: 0.5}, GainFor(-13.77, 0.5))
+
+	got := &Tags{}
+	setCustom(got, "replaygain_track_gain", "+1.50 dB")
+	setCustom(got, "REPLAYGAIN_TRACK_PEAK", "0.75")
+	setCustom(got, "REPLAYGAIN_ALBUM_GAIN", "loud")
+	assert.Equal(t, &Tags{TrackGain: &Gain{Gain: 1.5, Peak: 0.75}}, got)
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mp3")
+	require.NoError(t, os.WriteFile(path, []byte("\xff\xfbframes"), 0644))
+	require.NoError(t, WriteFile(path, &Tags{Title: "Title"}))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	got, err := Read(f, path)
+	require.NoError(t, err)
+	assert.Equal(t, "Title", got.Title)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, UpdateFile(path, func(t *Tags) { t.TrackGain = GainFor(-10, 0.9) }))
+	f, err = os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	got, err = Read(f, path)
+	require.NoError(t, err)
+	assert.Equal(t, "Title", got.Title, "fields missing from the update are kept")
+	assert.Equal(t, &Gain{Gain: -8, Peak: 0.9}, got.TrackGain)
+
+	assert.ErrorIs(t, WriteFile(filepath.Join(t.TempDir(), "a.wav"), &Tags{}), os.ErrNotExist)
+}
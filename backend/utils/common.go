@@ -3,10 +3,17 @@ package utils
 import (
 	"beatbump-server/backend/db"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
+// MinFreeDiskSpace is the free space required before a track is downloaded.
+const MinFreeDiskSpace = 50 * 1024 * 1024 // 50MB
+
 func SanitizeFilename(name string) string {
 	return strings.Map(func(r rune) rune {
 		if strings.ContainsRune(`<>:"/\|?*`, r) {
@@ -34,6 +41,13 @@ func ResolveDownloadDirectory(groupTask *db.GroupTask, downloadPath string) (str
 		return fullDownloadPath, playlistFolder, playlistName
 	}
 
+	if groupTask.Type == db.TaskTypeLibraryImport {
+		// Imported files stay in the folder they were found in
+		playlistFolder := filepath.FromSlash(strings.TrimPrefix(groupTask.ReferenceID, db.ImportReferencePrefix))
+		fullDownloadPath := filepath.Join(downloadPath, playlistFolder)
+		return fullDownloadPath, playlistFolder, playlistName
+	}
+
 	if groupTask.Type == db.TaskTypeSongMixDownload {
 		// Format: {Song Title}-{MaxTracks}
 		// If MaxTracks is 0, maybe just {Song Title}?
@@ -48,3 +62,37 @@ func ResolveDownloadDirectory(groupTask *db.GroupTask, downloadPath string) (str
 	fullDownloadPath := filepath.Join(downloadPath, playlistFolder)
 	return fullDownloadPath, playlistFolder, playlistName
 }
+
+// CleanString removes common noise from YouTube titles
+func CleanString(s string) string {
+	// Remove things in brackets/parentheses like (Official Video), [Lyrics], etc.
+	re := regexp.MustCompile(`(?i)(\(|\[)(official|video|audio|lyrics|hq|hd|4k|music video).*?(\)|\])`)
+	s = re.ReplaceAllString(s, "")
+
+	// Remove "ft.", "feat."
+	reFeat := regexp.MustCompile(`(?i)\s(ft\.|feat\.|featuring)\s.*`)
+	s = reFeat.ReplaceAllString(s, "")
+
+	return strings.TrimSpace(s)
+}
+
+// DownloadFile saves the body of a GET request to url at path.
+func DownloadFile(url, path string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status: %d", url, resp.StatusCode)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
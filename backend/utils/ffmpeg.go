@@ -1,8 +1,15 @@
 package utils
 
 import (
+	"beatbump-server/backend/tags"
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 )
 
 // IsFFmpegAvailable checks if ffmpeg is installed and available in the system PATH.
@@ -18,11 +25,47 @@ type AudioMetadata struct {
 	Year       string
 	Genre      string
 	ArtworkURL string // Used if coverPath is not provided
+	VideoID    string // Lets the library scanner identify moved files
+	Track      int    // Position in the playlist, 0 when unknown
+	TrackTotal int
+	Normalize  bool // Apply EBU R128 loudness normalization while converting
+	// Known when the metadata was matched on MusicBrainz
+	MusicBrainz tags.MusicBrainzIDs
 }
 
+// musicBrainzArgs sets the MusicBrainz ID tags, empty IDs remove a tag.
+func musicBrainzArgs(ids tags.MusicBrainzIDs) []string {
+	var args []string
+	for _, tag := range []struct{ key, id string }{
+		{tags.MusicBrainzTrackIDKey, ids.TrackID},
+		{tags.MusicBrainzAlbumIDKey, ids.AlbumID},
+		{tags.MusicBrainzReleaseGroupIDKey, ids.ReleaseGroupID},
+		{tags.MusicBrainzAlbumArtistIDKey, ids.AlbumArtistID},
+	} {
+		args = append(args, "-metadata", fmt.Sprintf("%s=%s", tag.key, tag.id))
+	}
+	return args
+}
+
+// Output profiles of new downloads, a stored file is only reused for a
+// download in the same profile. Without ffmpeg tracks are kept as M4A.
+const (
+	ProfileMP3         = "mp3"
+	ProfileMP3Loudnorm = "mp3-loudnorm"
+	ProfileM4A         = "m4a"
+)
+
+// OutputProfiles can be picked with the output profile setting.
+var OutputProfiles = []string{ProfileMP3, ProfileMP3Loudnorm}
+
+// LoudnessTarget is the integrated loudness in LUFS that normalized
+// conversions aim for.
+const LoudnessTarget = -14
+
 // ConvertToMp3 converts an audio file to MP3 with ID3 tags and optional cover art.
 // It uses -q:a 0 for best variable bitrate quality (approx 220-260kbps).
-func ConvertToMp3(inputPath, outputPath, coverPath string, meta AudioMetadata) error {
+// ffmpeg is killed when ctx is cancelled and the partial output is removed.
+func ConvertToMp3(ctx context.Context, inputPath, outputPath, coverPath string, meta AudioMetadata) error {
 	// ffmpeg -i input.m4a -i cover.jpg -map 0:a -map 1:0 -c:a libmp3lame -q:a 0 -id3v2_version 3
 	// -metadata title="..." -metadata artist="..." -metadata album="..." output.mp3
 
@@ -44,6 +87,10 @@ func ConvertToMp3(inputPath, outputPath, coverPath string, meta AudioMetadata) e
 		args = append(args, "-map", "0:a")
 	}
 
+	if meta.Normalize {
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%d:TP=-1:LRA=11", LoudnessTarget))
+	}
+
 	args = append(args,
 		"-c:a", "libmp3lame",
 		"-q:a", "0", // Best quality VBR
@@ -59,15 +106,88 @@ func ConvertToMp3(inputPath, outputPath, coverPath string, meta AudioMetadata) e
 	if meta.Genre != "" {
 		args = append(args, "-metadata", fmt.Sprintf("genre=%s", meta.Genre))
 	}
+	if meta.Track > 0 {
+		args = append(args, "-metadata", fmt.Sprintf("track=%d/%d", meta.Track, meta.TrackTotal))
+	}
+	if meta.VideoID != "" {
+		// Written as a TXXX frame
+		args = append(args, "-metadata", fmt.Sprintf("%s=%s", tags.VideoIDKey, meta.VideoID))
+	}
+	if meta.MusicBrainz != (tags.MusicBrainzIDs{}) {
+		args = append(args, musicBrainzArgs(meta.MusicBrainz)...)
+	}
 
 	args = append(args, outputPath)
 
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	// Capture output for debugging if needed, but for now just run it
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		os.Remove(outputPath)
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg interrupted: %w", ctx.Err())
+		}
 		return fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
 	}
 
 	return nil
 }
+
+// Loudness is the EBU R128 measurement of a track.
+type Loudness struct {
+	Integrated float64 // LUFS
+	Peak       float64 // Largest sample amplitude, 1 is full scale
+}
+
+// MeasureLoudness runs the ffmpeg ebur128 filter over the audio of path.
+func MeasureLoudness(ctx context.Context, path string) (*Loudness, error) {
+	args := []string{
+		"-hide_banner", "-nostats",
+		"-i", path,
+		"-map", "0:a",
+		"-af", "ebur128=peak=sample",
+		"-f", "null", "-",
+	}
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ffmpeg interrupted: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+	return parseEBUR128(string(output))
+}
+
+// parseEBUR128 reads the summary the ebur128 filter logs at the end:
+//
+//	Integrated loudness:
+//	  I:         -14.2 LUFS
+//	...
+//	Sample peak:
+//	  Peak:       -0.3 dBFS
+func parseEBUR128(output string) (*Loudness, error) {
+	_, summary, ok := strings.Cut(output, "Summary:")
+	if !ok {
+		return nil, errors.New("ebur128 summary missing from ffmpeg output")
+	}
+	value := func(label string) (float64, bool) {
+		for _, line := range strings.Split(summary, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == label {
+				f, err := strconv.ParseFloat(fields[1], 64)
+				return f, err == nil
+			}
+		}
+		return 0, false
+	}
+
+	integrated, ok := value("I:")
+	if !ok {
+		return nil, errors.New("integrated loudness missing from ebur128 summary")
+	}
+	loudness := &Loudness{Integrated: integrated}
+	if peak, ok := value("Peak:"); ok {
+		loudness.Peak = math.Pow(10, peak/20)
+	}
+	return loudness, nil
+}
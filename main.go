@@ -1,24 +1,77 @@
 package main
 
 import (
+	ytapi "beatbump-server/backend/_youtube/api"
 	"beatbump-server/backend/api"
 	"beatbump-server/backend/api/downloader"
+	"beatbump-server/backend/config"
 	"beatbump-server/backend/db"
+	"beatbump-server/backend/health"
+	"beatbump-server/backend/logging"
+	"beatbump-server/backend/metadata"
+	"beatbump-server/backend/scrobbler"
+	"beatbump-server/backend/storage"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	db.InitDB()
-	downloader.StartWorker()
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		runHealthcheck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	config.Set(cfg)
+
+	if err := logging.Configure(cfg.Logging.Format, cfg.Logging.Level); err != nil {
+		logging.Log.Fatal().Err(err).Msg("Invalid logging configuration")
+	}
+	ytapi.ConfigureCompanion(cfg.Companion.URL, cfg.Companion.SecretKey)
+	metadata.Configure(cfg.Metadata)
+	if err := storage.Configure(cfg.Storage); err != nil {
+		logging.Log.Fatal().Err(err).Msg("Invalid storage configuration")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db.InitDB(cfg.Database)
+	health.RegisterDefaultChecks()
+	downloader.StartWorker(cfg.Worker)
+	scrobbler.StartRetryWorker(ctx)
 
 	e := echo.New()
+	e.Logger = logging.NewEchoLogger(logging.Log)
 
+	e.Use(middleware.RequestID())
+	e.Use(logging.RequestLogger)
 	e.Use(middleware.CORS())
-	e.Use(middleware.Logger())
 	e.Use(middleware.StaticWithConfig(middleware.StaticConfig{
-		Root:       "./build",
+		Root:       cfg.Server.StaticRoot,
 		Browse:     true,
 		IgnoreBase: true,
 		HTML5:      true,
@@ -45,15 +98,116 @@ func main() {
 	e.GET("/api/v1/download/playlist", api.DownloadPlaylistHandler)
 	e.GET("/api/v1/download/song", api.DownloadSongMixHandler)
 	e.GET("/api/v1/downloads", api.GetDownloadsHandler)
+	e.GET("/api/v1/downloads/export", api.ExportDownloadsHandler)
+	e.POST("/api/v1/downloads/import", api.ImportDownloadsHandler)
 	e.GET("/api/v1/downloads/:taskId/tracks", api.GetTaskTracksHandler)
 	e.POST("/api/v1/downloads/:taskId/pause", api.PauseTaskHandler)
 	e.POST("/api/v1/downloads/:taskId/resume", api.ResumeTaskHandler)
 	e.POST("/api/v1/downloads/:taskId/retry", api.RetryTaskHandler)
+	e.PUT("/api/v1/downloads/:taskId/schedule", api.ScheduleTaskHandler)
 	e.DELETE("/api/v1/downloads/:taskId", api.DeleteTaskHandler)
 	e.DELETE("/api/v1/downloads/:taskId/tracks/:videoId", api.DeleteTrackHandler)
+	e.GET("/api/v1/downloads/:taskId/tracks/:videoId/tags", api.GetTrackTagsHandler)
+	e.PUT("/api/v1/downloads/:taskId/tracks/:videoId/tags", api.UpdateTrackTagsHandler)
+	e.PUT("/api/v1/downloads/:taskId/tags", api.UpdateGroupTagsHandler)
+	e.POST("/api/v1/downloads/:taskId/artwork/refresh", api.RefreshArtworkHandler)
+	e.GET("/api/v1/downloads/:taskId/playlist", api.GetTaskPlaylistHandler)
 	e.GET("/api/v1/stream/:taskId/:videoId", api.StreamTrackHandler)
+	// Reconcile and search the downloaded files, not part of the optional server library
+	e.GET("/api/v1/library/scan", api.GetLibraryScanHandler)
+	e.POST("/api/v1/library/scan", api.StartLibraryScanHandler)
+	e.GET("/api/v1/library/search", api.SearchLibraryHandler)
+	e.GET("/api/v1/metadata/review", api.GetMetadataReviewsHandler)
+	e.GET("/api/v1/metadata/review/:id", api.GetMetadataReviewHandler)
+	e.POST("/api/v1/metadata/review/:id/accept", api.AcceptMetadataReviewHandler)
+	e.POST("/api/v1/metadata/review/:id/pick", api.PickMetadataReviewHandler)
+	e.POST("/api/v1/metadata/review/:id/reject", api.RejectMetadataReviewHandler)
 	e.GET("/api/v1/settings", api.GetSettingsHandler)
+	e.GET("/api/v1/settings/schema", api.SettingsSchemaHandler)
 	e.POST("/api/v1/settings", api.UpdateSettingsHandler)
 
-	e.Logger.Fatal(e.Start(":8080"))
+	// Listening history
+	e.GET("/api/v1/history", api.GetHistoryHandler)
+	e.DELETE("/api/v1/history", api.ClearHistoryHandler)
+	e.GET("/api/v1/history/stats", api.GetHistoryStatsHandler)
+	e.GET("/api/v1/history/export", api.ExportHistoryHandler)
+
+	// Scrobbling
+	e.POST("/api/v1/scrobble", api.ScrobbleHandler)
+	e.GET("/api/v1/scrobble/queue", api.GetScrobbleQueueHandler)
+	e.GET("/api/v1/scrobble/settings", api.GetScrobbleSettingsHandler)
+	e.POST("/api/v1/scrobble/settings", api.UpdateScrobbleSettingsHandler)
+	e.POST("/api/v1/scrobble/lastfm/session", api.LastFmSessionHandler)
+	e.DELETE("/api/v1/scrobble/:provider", api.DisconnectScrobblerHandler)
+
+	// Server-side library
+	library := e.Group("/api/v1/library", api.RequireServerLibrary)
+	library.GET("/favorites", api.GetFavoritesHandler)
+	library.POST("/favorites", api.AddFavoriteHandler)
+	library.PUT("/favorites/order", api.ReorderFavoritesHandler)
+	library.DELETE("/favorites/:videoId", api.DeleteFavoriteHandler)
+	library.GET("/playlists", api.GetLibraryPlaylistsHandler)
+	library.POST("/playlists", api.CreateLibraryPlaylistHandler)
+	library.GET("/playlists/:playlistId", api.GetLibraryPlaylistHandler)
+	library.PUT("/playlists/:playlistId", api.UpdateLibraryPlaylistHandler)
+	library.DELETE("/playlists/:playlistId", api.DeleteLibraryPlaylistHandler)
+	library.POST("/playlists/:playlistId/tracks", api.AddLibraryPlaylistTracksHandler)
+	library.DELETE("/playlists/:playlistId/tracks/:entryId", api.DeleteLibraryPlaylistTrackHandler)
+	library.PUT("/playlists/:playlistId/order", api.ReorderLibraryPlaylistHandler)
+	library.POST("/playlists/:playlistId/download", api.DownloadLibraryPlaylistHandler)
+	library.GET("/export", api.ExportLibraryHandler)
+	library.POST("/import", api.ImportLibraryHandler)
+
+	// Monitoring
+	e.GET("/healthz", api.HealthzHandler)
+	e.GET("/readyz", api.ReadyzHandler)
+	e.GET("/api/v1/stats", api.StatsEndpointHandler)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+
+	go func() {
+		var err error
+		if cfg.Server.TLS.CertFile != "" {
+			err = e.StartTLS(cfg.Server.Listen, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		} else {
+			err = e.Start(cfg.Server.Listen)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	// A second signal terminates immediately
+	stop()
+	shutdown(e, cfg.Server.ShutdownTimeout.Duration())
+}
+
+// shutdown stops taking new requests and downloads, then gives in-flight
+// requests and tracks until timeout to finish. Tracks that don't make it are
+// put back in the queue and resume on the next start.
+func shutdown(e *echo.Echo, timeout time.Duration) {
+	logging.Log.Info().Dur("timeout", timeout).Msg("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := e.Shutdown(ctx); err != nil {
+			logging.Log.Warn().Err(err).Msg("HTTP server did not shut down cleanly")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := downloader.StopWorker(ctx); err != nil {
+			logging.Log.Warn().Err(err).Msg("Interrupted in-flight downloads, they will resume on next start")
+		}
+	}()
+	wg.Wait()
+
+	if err := db.Close(); err != nil {
+		logging.Log.Error().Err(err).Msg("Failed to close database")
+	}
+	logging.Log.Info().Msg("Shutdown complete")
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"beatbump-server/backend/config"
+	"beatbump-server/backend/db"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: beat-server migrate [up | down [steps] | status] [config flags]"
+
+// runMigrate manages the database schema without starting the server:
+// `beat-server migrate [up | down [steps] | status] [config flags]`.
+// Both directions back up beatbump.db first.
+func runMigrate(args []string) {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	if action != "up" && action != "down" && action != "status" {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	steps := 1
+	if action == "down" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			migrateFailed(fmt.Errorf("invalid number of steps %q", args[0]))
+		}
+		steps, args = n, args[1:]
+	}
+
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return
+	} else if err != nil {
+		migrateFailed(err)
+	}
+	if err := db.Open(cfg.Database); err != nil {
+		migrateFailed(err)
+	}
+	defer db.Close()
+
+	switch action {
+	case "up":
+		applied, backup, err := db.MigrateWithBackup()
+		printBackup(backup)
+		for _, m := range applied {
+			fmt.Printf("Applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			migrateFailed(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		backup, err := db.BackupDatabase()
+		if err != nil {
+			migrateFailed(err)
+		}
+		printBackup(backup)
+		reverted, err := db.Rollback(steps)
+		for _, m := range reverted {
+			fmt.Printf("Rolled back %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			migrateFailed(err)
+		}
+	case "status":
+		states, err := db.MigrationStatus()
+		if err != nil {
+			migrateFailed(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.AppliedAt != nil {
+				appliedAt = state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, state.Name, appliedAt)
+		}
+		w.Flush()
+	}
+}
+
+func printBackup(path string) {
+	if path != "" {
+		fmt.Printf("Backed up database to %s\n", path)
+	}
+}
+
+func migrateFailed(err error) {
+	db.Close()
+	fmt.Fprintf(os.Stderr, "migrate failed: %v\n", err)
+	os.Exit(1)
+}